@@ -0,0 +1,44 @@
+// Package cache holds the service's cache backends: an in-process one, and
+// one in Redis that instances can share.
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+// Cache stores values by key. Get returns values until the backend drops
+// them, which may be after they expire, so callers can tell stale from
+// absent.
+type Cache interface {
+	Get(key string) (interface{}, bool)
+
+	// Set stores value under key, to expire at expiresAt
+	Set(key string, value interface{}, expiresAt time.Time)
+
+	Delete(key string)
+
+	// Ping checks the backend can be reached, for readiness probes
+	Ping(ctx context.Context) error
+
+	// Keys lists the cached keys, expired or not, for the admin API
+	Keys(ctx context.Context) ([]string, error)
+
+	// Flush drops every entry
+	Flush(ctx context.Context) error
+
+	// Close stops any background work and releases the backend's resources.
+	// The cache mustn't be used afterwards.
+	Close()
+}
+
+// Codec turns values into bytes and back, for backends that store bytes
+type Codec interface {
+	Marshal(value interface{}) ([]byte, error)
+	Unmarshal(data []byte) (interface{}, error)
+}
+
+var (
+	_ Cache = memoryCache{}
+	_ Cache = redisCache{}
+)
@@ -0,0 +1,103 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	gocache "github.com/patrickmn/go-cache"
+)
+
+// memoryCache is an in-process Cache
+type memoryCache struct {
+	c    *gocache.Cache
+	stop chan struct{}
+	done chan struct{}
+}
+
+// memoryItem is a value as memoryCache holds it, with its expiry
+type memoryItem struct {
+	value     interface{}
+	expiresAt time.Time
+}
+
+// NewMemory is an in-process cache that deletes expired entries every
+// cleanupInterval. onEvict, if set, is told of every entry that leaves it,
+// and whether that's because it expired.
+func NewMemory(cleanupInterval time.Duration, onEvict func(key string, expired bool)) Cache {
+	// entries carry their own expiry, so go-cache never expires them itself
+	// and has no janitor; expired entries are cleaned up by a goroutine Close
+	// can stop instead
+	c := gocache.New(gocache.NoExpiration, 0)
+	if onEvict != nil {
+		c.OnEvicted(func(key string, value interface{}) {
+			item, _ := value.(memoryItem)
+			onEvict(key, !time.Now().Before(item.expiresAt))
+		})
+	}
+
+	m := memoryCache{c: c, stop: make(chan struct{}), done: make(chan struct{})}
+	go m.cleanup(cleanupInterval)
+	return m
+}
+
+// cleanup deletes expired entries every interval until the cache is closed
+func (m memoryCache) cleanup(interval time.Duration) {
+	defer close(m.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			now := time.Now()
+			for key, item := range m.c.Items() {
+				if item, ok := item.Object.(memoryItem); ok && !now.Before(item.expiresAt) {
+					m.c.Delete(key)
+				}
+			}
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+func (m memoryCache) Get(key string) (interface{}, bool) {
+	cached, found := m.c.Get(key)
+	if !found {
+		return nil, false
+	}
+
+	return cached.(memoryItem).value, true
+}
+
+func (m memoryCache) Set(key string, value interface{}, expiresAt time.Time) {
+	m.c.Set(key, memoryItem{value: value, expiresAt: expiresAt}, gocache.NoExpiration)
+}
+
+func (m memoryCache) Delete(key string) {
+	m.c.Delete(key)
+}
+
+func (m memoryCache) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (m memoryCache) Keys(ctx context.Context) ([]string, error) {
+	var keys []string
+	for key := range m.c.Items() {
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+func (m memoryCache) Flush(ctx context.Context) error {
+	m.c.Flush()
+	return nil
+}
+
+func (m memoryCache) Close() {
+	close(m.stop)
+	<-m.done
+}
@@ -0,0 +1,98 @@
+package cache
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMemory(t *testing.T) {
+	c := NewMemory(time.Hour, nil)
+	defer c.Close()
+
+	testCache(t, c)
+}
+
+func TestMemoryCleansUpExpiredEntries(t *testing.T) {
+	var mu sync.Mutex
+	evicted := map[string]bool{}
+	c := NewMemory(5*time.Millisecond, func(key string, expired bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		evicted[key] = expired
+	})
+	defer c.Close()
+
+	c.Set("expired", "a", time.Now().Add(-time.Second))
+	c.Set("expiring", "b", time.Now().Add(10*time.Millisecond))
+	c.Set("fresh", "c", time.Now().Add(time.Hour))
+	c.Set("deleted", "d", time.Now().Add(time.Hour))
+
+	// expired entries are still got until they're cleaned up
+	if value, found := c.Get("expired"); !found || value != "a" {
+		t.Errorf("got %v, %v before cleanup, want the expired entry", value, found)
+	}
+
+	c.Delete("deleted")
+	time.Sleep(50 * time.Millisecond)
+
+	for _, key := range []string{"expired", "expiring", "deleted"} {
+		if _, found := c.Get(key); found {
+			t.Errorf("%s is still cached", key)
+		}
+	}
+	if _, found := c.Get("fresh"); !found {
+		t.Error("fresh entry was cleaned up")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if want := map[string]bool{"expired": true, "expiring": true, "deleted": false}; !reflect.DeepEqual(evicted, want) {
+		t.Errorf("evicted %v, want %v", evicted, want)
+	}
+}
+
+// testCache checks the behaviour every backend shares
+func testCache(t *testing.T, c Cache) {
+	t.Helper()
+	ctx := context.Background()
+	expiry := time.Now().Add(time.Hour)
+
+	if err := c.Ping(ctx); err != nil {
+		t.Fatalf("Ping failed, %s", err)
+	}
+
+	if _, found := c.Get("uk"); found {
+		t.Error("got an entry from an empty cache")
+	}
+
+	c.Set("uk", "list", expiry)
+	c.Set("us", "other list", expiry)
+	if value, found := c.Get("uk"); !found || value != "list" {
+		t.Errorf("got %v, %v, want the value set", value, found)
+	}
+
+	keys, err := c.Keys(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(keys)
+	if want := []string{"uk", "us"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("got keys %v, want %v", keys, want)
+	}
+
+	c.Delete("uk")
+	if _, found := c.Get("uk"); found {
+		t.Error("got a deleted entry")
+	}
+
+	if err := c.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if keys, err := c.Keys(ctx); err != nil || len(keys) != 0 {
+		t.Errorf("got keys %v, %v after a flush, want none", keys, err)
+	}
+}
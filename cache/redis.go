@@ -0,0 +1,127 @@
+package cache
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+)
+
+// redisCache is a Cache shared between instances through Redis. Redis
+// failures are logged and treated as misses, so the service falls back to
+// fetching from CAPI.
+type redisCache struct {
+	client  *redis.Client
+	keepFor time.Duration
+	codec   Codec
+}
+
+const redisKeyPrefix = "onward:"
+
+// NewRedis is a cache in the Redis at url, storing values as codec encodes
+// them. Redis drops each value keepFor after it expires, so until then it can
+// still be served stale.
+func NewRedis(url string, keepFor time.Duration, codec Codec) (Cache, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, errors.Wrap(err, "Invalid Redis URL")
+	}
+
+	return redisCache{client: redis.NewClient(opts), keepFor: keepFor, codec: codec}, nil
+}
+
+func (rc redisCache) Get(key string) (interface{}, bool) {
+	data, err := rc.client.Get(context.Background(), redisKeyPrefix+key).Bytes()
+	if err != nil {
+		if err != redis.Nil {
+			log.Printf("Redis GET failed, %s", err)
+		}
+		return nil, false
+	}
+
+	value, err := rc.codec.Unmarshal(data)
+	if err != nil {
+		log.Printf("Unable to unmarshal cached entry %s, %s", key, err)
+		return nil, false
+	}
+
+	return value, true
+}
+
+func (rc redisCache) Set(key string, value interface{}, expiresAt time.Time) {
+	data, err := rc.codec.Marshal(value)
+	if err != nil {
+		log.Printf("Unable to marshal cache entry %s, %s", key, err)
+		return
+	}
+
+	// Redis takes a TTL, where zero is forever, so a value already past
+	// keeping is kept for as short a time as it can be
+	ttl := time.Until(expiresAt) + rc.keepFor
+	if ttl < time.Millisecond {
+		ttl = time.Millisecond
+	}
+
+	if err := rc.client.Set(context.Background(), redisKeyPrefix+key, data, ttl).Err(); err != nil {
+		log.Printf("Redis SET failed, %s", err)
+	}
+}
+
+func (rc redisCache) Delete(key string) {
+	if err := rc.client.Del(context.Background(), redisKeyPrefix+key).Err(); err != nil {
+		log.Printf("Redis DEL failed, %s", err)
+	}
+}
+
+func (rc redisCache) Ping(ctx context.Context) error {
+	return rc.client.Ping(ctx).Err()
+}
+
+// Keys scans for the service's keys, leaving anything else in the database
+// alone
+func (rc redisCache) Keys(ctx context.Context) ([]string, error) {
+	var keys []string
+
+	iter := rc.client.Scan(ctx, 0, redisKeyPrefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, strings.TrimPrefix(iter.Val(), redisKeyPrefix))
+	}
+
+	return keys, errors.Wrap(iter.Err(), "Redis SCAN failed")
+}
+
+// Flush deletes the service's keys, not the whole database, which may be
+// shared
+func (rc redisCache) Flush(ctx context.Context) error {
+	keys, err := rc.Keys(ctx)
+	if err != nil {
+		return err
+	}
+
+	for len(keys) > 0 {
+		batch := keys
+		if len(batch) > 100 {
+			batch = batch[:100]
+		}
+		keys = keys[len(batch):]
+
+		for i, key := range batch {
+			batch[i] = redisKeyPrefix + key
+		}
+
+		if err := rc.client.Del(ctx, batch...).Err(); err != nil {
+			return errors.Wrap(err, "Redis DEL failed")
+		}
+	}
+
+	return nil
+}
+
+func (rc redisCache) Close() {
+	if err := rc.client.Close(); err != nil {
+		log.Printf("Unable to close Redis client, %s", err)
+	}
+}
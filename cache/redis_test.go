@@ -0,0 +1,93 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// stringCodec stores values as JSON strings
+type stringCodec struct{}
+
+func (stringCodec) Marshal(value interface{}) ([]byte, error) {
+	return json.Marshal(value)
+}
+
+func (stringCodec) Unmarshal(data []byte) (interface{}, error) {
+	var value string
+	err := json.Unmarshal(data, &value)
+	return value, err
+}
+
+func newTestRedis(t *testing.T, ttl time.Duration) (*miniredis.Miniredis, Cache) {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	c, err := NewRedis("redis://"+server.Addr(), ttl, stringCodec{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+
+	return server, c
+}
+
+func TestRedis(t *testing.T) {
+	_, c := newTestRedis(t, time.Hour)
+
+	testCache(t, c)
+}
+
+func TestRedisKeepsToItsOwnKeys(t *testing.T) {
+	server, c := newTestRedis(t, time.Hour)
+	server.Set("someone-else", "theirs")
+
+	c.Set("uk", "list", time.Now().Add(time.Hour))
+	if got, err := server.Get("onward:uk"); err != nil || got != `"list"` {
+		t.Errorf("stored %q, %v, want the encoded value under the service's prefix", got, err)
+	}
+
+	if err := c.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := server.Get("someone-else"); err != nil || got != "theirs" {
+		t.Errorf("flush deleted another service's key")
+	}
+}
+
+func TestRedisKeepsEntriesPastExpiry(t *testing.T) {
+	server, c := newTestRedis(t, time.Minute)
+
+	c.Set("uk", "list", time.Now().Add(time.Second))
+	c.Set("us", "list", time.Now().Add(time.Hour))
+	server.FastForward(30 * time.Second)
+	if _, found := c.Get("uk"); !found {
+		t.Error("entry gone before it was kept for long enough past its expiry")
+	}
+
+	server.FastForward(time.Minute)
+	if _, found := c.Get("uk"); found {
+		t.Error("entry kept for too long past its expiry")
+	}
+	if _, found := c.Get("us"); !found {
+		t.Error("entry gone before it expired")
+	}
+}
+
+func TestRedisTreatsBadEntriesAsMisses(t *testing.T) {
+	server, c := newTestRedis(t, time.Hour)
+	server.Set("onward:uk", "not JSON")
+
+	if _, found := c.Get("uk"); found {
+		t.Error("got an entry that doesn't decode")
+	}
+}
+
+func TestNewRedisRejectsBadURLs(t *testing.T) {
+	if _, err := NewRedis("not a URL", time.Hour, stringCodec{}); err == nil {
+		t.Error("got a cache for a bad URL")
+	}
+}
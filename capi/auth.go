@@ -0,0 +1,78 @@
+package capi
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	v4 "github.com/aws/aws-sdk-go/aws/signer/v4"
+	"github.com/guardian/onward/config"
+	"github.com/pkg/errors"
+)
+
+// newAuthTransport wraps next so CAPI requests carry the authentication
+// cfg.CAPIAuth asks for
+func newAuthTransport(cfg config.Config, next http.RoundTripper) (http.RoundTripper, error) {
+	switch cfg.CAPIAuth {
+	case config.CAPIAuthBasic:
+		return basicAuthTransport{next: next, user: cfg.CAPIUser, password: cfg.CAPIPassword}, nil
+	case config.CAPIAuthAWS:
+		// credentials come from the standard AWS environment, shared config
+		// or instance role, and are refreshed by the session as they expire
+		sess, err := session.NewSession()
+		if err != nil {
+			return nil, errors.Wrap(err, "Unable to create AWS session")
+		}
+
+		return sigV4Transport{next: next, signer: v4.NewSigner(sess.Config.Credentials), region: cfg.CAPIAWSRegion, service: cfg.CAPIAWSService}, nil
+	default:
+		return next, nil
+	}
+}
+
+// basicAuthTransport sends every request with HTTP basic auth
+type basicAuthTransport struct {
+	next     http.RoundTripper
+	user     string
+	password string
+}
+
+func (t basicAuthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.SetBasicAuth(t.user, t.password)
+
+	return t.next.RoundTrip(req)
+}
+
+// sigV4Transport signs every request with AWS Signature Version 4. Nothing
+// beneath it may change the request, or the signature won't match.
+type sigV4Transport struct {
+	next    http.RoundTripper
+	signer  *v4.Signer
+	region  string
+	service string
+}
+
+func (t sigV4Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+
+	// the signature covers the body, so it's read and put back
+	var body io.ReadSeeker
+	if req.Body != nil && req.Body != http.NoBody {
+		b, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, errors.Wrap(err, "Unable to read request body to sign")
+		}
+		body = bytes.NewReader(b)
+	}
+
+	if _, err := t.signer.Sign(req, body, t.service, t.region, time.Now()); err != nil {
+		return nil, errors.Wrap(err, "Unable to sign CAPI request")
+	}
+
+	return t.next.RoundTrip(req)
+}
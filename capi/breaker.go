@@ -0,0 +1,149 @@
+package capi
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// breakerState is the state of a circuit breaker
+type breakerState int
+
+const (
+	// breakerClosed lets every call through
+	breakerClosed breakerState = iota
+
+	// breakerHalfOpen lets one probe call through to see if CAPI is back
+	breakerHalfOpen
+
+	// breakerOpen fails calls straight away
+	breakerOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerHalfOpen:
+		return "half-open"
+	case breakerOpen:
+		return "open"
+	}
+
+	return "closed"
+}
+
+// errCircuitOpen is the cause of calls refused by an open breaker
+var errCircuitOpen = errors.New("CAPI circuit breaker open")
+
+// breaker stops calling CAPI after threshold transient failures in a row.
+// Once cooldown has passed it lets a single probe through, closing again if
+// that succeeds. A zero threshold never opens.
+type breaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	state     breakerState
+	failures  int
+	openedAt  time.Time
+	probing   bool
+}
+
+func newBreaker(threshold int, cooldown time.Duration) *breaker {
+	breakerGauge.Set(float64(breakerClosed))
+	return &breaker{threshold: threshold, cooldown: cooldown}
+}
+
+// set moves the breaker to state. It's called with the lock held.
+func (b *breaker) set(state breakerState) {
+	b.state = state
+	breakerGauge.Set(float64(state))
+}
+
+// allow reports whether a call may go ahead, returning the error to fail it
+// with if not
+func (b *breaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return b.refusal()
+		}
+		b.set(breakerHalfOpen)
+		log.Printf("CAPI circuit breaker half-open, probing")
+		fallthrough
+	case breakerHalfOpen:
+		if b.probing {
+			return b.refusal()
+		}
+		b.probing = true
+	}
+
+	return nil
+}
+
+func (b *breaker) refusal() error {
+	return &Error{
+		Status:     http.StatusServiceUnavailable,
+		Message:    "CAPI unavailable",
+		Err:        errCircuitOpen,
+		Code:       CodeUpstreamUnavailable,
+		RetryAfter: b.cooldown - time.Since(b.openedAt),
+	}
+}
+
+// record notes the outcome of an allowed call. Only transient failures count
+// against CAPI; anything else shows it's answering.
+func (b *breaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.threshold == 0 {
+		return
+	}
+
+	b.probing = false
+
+	if !IsTransient(err) {
+		if b.state != breakerClosed {
+			log.Printf("CAPI circuit breaker closed")
+		}
+		b.set(breakerClosed)
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.threshold {
+		if b.state != breakerOpen {
+			log.Printf("CAPI circuit breaker open after %d failures", b.failures)
+		}
+		b.set(breakerOpen)
+		b.openedAt = time.Now()
+	}
+}
+
+// release gives up an allowed call that was cancelled before CAPI answered.
+// It counts neither way, but a half-open breaker may probe again.
+func (b *breaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+}
+
+func (b *breaker) current() breakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.state
+}
+
+// IsCircuitOpen reports whether err is a call refused by the breaker
+func IsCircuitOpen(err error) bool {
+	capiErr, ok := errors.Cause(err).(*Error)
+	return ok && capiErr.Err == errCircuitOpen
+}
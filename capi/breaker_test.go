@@ -0,0 +1,65 @@
+package capi
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestCancelledCallsDontTripTheBreaker(t *testing.T) {
+	started := make(chan struct{}, 10)
+	stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-r.Context().Done()
+	})
+	cfg := testConfig(t, stub.URL)
+	cfg.BreakerThreshold, cfg.BreakerCooldown = 1, time.Minute
+	c := testClient(cfg)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+
+	_, err := Fetch(ctx, c, "uk", nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want a cancelled error", err)
+	}
+	if IsTransient(err) {
+		t.Error("a cancelled call is transient, so it would be retried")
+	}
+	if calls := stub.requests(); calls != 1 {
+		t.Errorf("CAPI got %d requests, want 1", calls)
+	}
+	if state := c.breaker.current(); state != breakerClosed {
+		t.Errorf("breaker is %s after a cancelled call, want closed", state)
+	}
+}
+
+func TestBreakerOpensOnTransientFailures(t *testing.T) {
+	stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	cfg := testConfig(t, stub.URL)
+	cfg.CAPIRetries = 0
+	cfg.BreakerThreshold, cfg.BreakerCooldown = 1, time.Minute
+	c := testClient(cfg)
+
+	if _, err := Fetch(context.Background(), c, "uk", nil); !IsTransient(err) {
+		t.Fatalf("got %v, want a transient error", err)
+	}
+	if state := c.breaker.current(); state != breakerOpen {
+		t.Fatalf("breaker is %s after a failure, want open", state)
+	}
+
+	if _, err := Fetch(context.Background(), c, "uk", nil); !IsCircuitOpen(err) {
+		t.Errorf("got %v, want the breaker to refuse the call", err)
+	}
+	if calls := stub.requests(); calls != 1 {
+		t.Errorf("CAPI got %d requests, want 1", calls)
+	}
+}
@@ -0,0 +1,223 @@
+// Package capi is the service's Content API (CAPI) client: the response
+// model, and a Client that sends every request through the API key pool, the
+// concurrency limit and the circuit breaker, retrying what's worth retrying.
+package capi
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Item is the CAPI iten model
+type Item struct {
+	ID                 string    `json:"id"`
+	Type               string    `json:"type"`
+	WebTitle           string    `json:"webTitle"`
+	WebPublicationDate time.Time `json:"webPublicationDate"`
+	SectionID          string    `json:"sectionId"`
+	SectionName        string    `json:"sectionName"`
+	Fields             *Fields   `json:"fields"`
+	Tags               []Tag     `json:"tags"`
+
+	// Elements are the item's image elements, for its crops
+	Elements []Element `json:"elements,omitempty"`
+
+	// Backfilled marks an item added to make up a short list
+	Backfilled bool `json:"-"`
+}
+
+// Fields are the optional fields CAPI sends under "fields", if any were
+// asked for and the item has them
+type Fields struct {
+	Headline        string   `json:"headline"`
+	Byline          string   `json:"byline"`
+	ShowByline      *boolean `json:"showByline"`
+	Thumbnail       string   `json:"thumbnail"`
+	LiveBloggingNow *boolean `json:"liveBloggingNow"`
+}
+
+// Tag is a tag on an item. Only tone tags are asked for.
+type Tag struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+}
+
+// Element is one of an item's elements. Only image elements are asked for,
+// with show-elements=image.
+type Element struct {
+	Relation string  `json:"relation"`
+	Type     string  `json:"type"`
+	Assets   []Asset `json:"assets"`
+}
+
+// Asset is one rendition of an element, e.g. an image crop at one width
+type Asset struct {
+	Type     string `json:"type"`
+	File     string `json:"file"`
+	TypeData struct {
+		Width       number   `json:"width"`
+		Height      number   `json:"height"`
+		AspectRatio string   `json:"aspectRatio"`
+		IsMaster    *boolean `json:"isMaster"`
+	} `json:"typeData"`
+}
+
+// boolean is a boolean field, which CAPI sends as either a JSON boolean or
+// the string "true" or "false"
+type boolean bool
+
+func (b *boolean) UnmarshalJSON(data []byte) error {
+	switch string(data) {
+	case "true", `"true"`:
+		*b = true
+	case "false", `"false"`:
+		*b = false
+	default:
+		return fmt.Errorf("expected a boolean, got %s", data)
+	}
+
+	return nil
+}
+
+// number is a number CAPI sends as either a JSON number or a string
+type number int
+
+func (n *number) UnmarshalJSON(data []byte) error {
+	raw := strings.Trim(string(data), `"`)
+	if raw == "" || raw == "null" {
+		*n = 0
+		return nil
+	}
+
+	i, err := strconv.Atoi(raw)
+	if err != nil {
+		return fmt.Errorf("expected a number, got %s", data)
+	}
+
+	*n = number(i)
+	return nil
+}
+
+// Response is the main CAPI response model
+type Response struct {
+	Response struct {
+		Results []Item `json:"mostViewed"`
+		Latest  []Item `json:"results"`
+		Related []Item `json:"relatedContent"`
+	} `json:"response"`
+
+	// Validators are CAPI's ETag and Last-Modified for the response, so it
+	// can be revalidated once it's cached
+	Validators http.Header `json:"-"`
+
+	// NotModified marks a cached response CAPI said was unchanged when it
+	// was revalidated
+	NotModified bool `json:"-"`
+}
+
+// ShowByline is CAPI's showByline field, or whether there's a byline to show
+// when CAPI doesn't say
+func (item Item) ShowByline() bool {
+	if item.Fields != nil && item.Fields.ShowByline != nil {
+		return bool(*item.Fields.ShowByline)
+	}
+
+	return item.Byline() != ""
+}
+
+// Headline is the item's headline, or its web title if CAPI doesn't send one
+func (item Item) Headline() string {
+	if item.Fields != nil && item.Fields.Headline != "" {
+		return item.Fields.Headline
+	}
+
+	return item.WebTitle
+}
+
+func (item Item) Thumbnail() string {
+	if item.Fields == nil {
+		return ""
+	}
+
+	return item.Fields.Thumbnail
+}
+
+// Tones are the IDs of the item's tone tags
+func (item Item) Tones() []string {
+	var tones []string
+	for _, tag := range item.Tags {
+		if tag.Type == "tone" || strings.HasPrefix(tag.ID, "tone/") {
+			tones = append(tones, tag.ID)
+		}
+	}
+
+	return tones
+}
+
+// IsLiveblog reports whether the item is a liveblog: by its type, CAPI
+// saying it's being live blogged, or the minute-by-minute tone
+func (item Item) IsLiveblog() bool {
+	if item.Type == "liveblog" {
+		return true
+	}
+
+	if item.Fields != nil && item.Fields.LiveBloggingNow != nil && bool(*item.Fields.LiveBloggingNow) {
+		return true
+	}
+
+	for _, tag := range item.Tags {
+		if tag.ID == "tone/minutebyminute" {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (item Item) Byline() string {
+	if item.Fields == nil {
+		return ""
+	}
+
+	return item.Fields.Byline
+}
+
+// IsMaster reports whether the asset is its element's original, uncropped
+// image
+func (a Asset) IsMaster() bool {
+	return a.TypeData.IsMaster != nil && bool(*a.TypeData.IsMaster)
+}
+
+// WithBackfill tops a most-viewed list that's shorter than min up from the
+// path's regular results, which CAPI sends alongside, skipping anything
+// already in the list. Backfilled items are marked as such.
+func (resp Response) WithBackfill(min int) Response {
+	if len(resp.Response.Results) >= min {
+		return resp
+	}
+
+	seen := map[string]bool{}
+	results := make([]Item, 0, min)
+	for _, item := range resp.Response.Results {
+		seen[item.ID] = true
+		results = append(results, item)
+	}
+
+	for _, item := range resp.Response.Latest {
+		if len(results) >= min {
+			break
+		}
+
+		if !seen[item.ID] {
+			seen[item.ID] = true
+			item.Backfilled = true
+			results = append(results, item)
+		}
+	}
+
+	resp.Response.Results = results
+	return resp
+}
@@ -0,0 +1,51 @@
+package capi
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestItemFields(t *testing.T) {
+	tests := []struct {
+		name       string
+		item       string
+		headline   string
+		byline     string
+		showByline bool
+		thumbnail  string
+		liveblog   bool
+	}{
+		{"all fields", `{"id":"a","webTitle":"Title","fields":{"headline":"Headline","byline":"A Writer","showByline":"false","thumbnail":"https://media.guim.co.uk/1.jpg","liveBloggingNow":"true"}}`,
+			"Headline", "A Writer", false, "https://media.guim.co.uk/1.jpg", true},
+		{"no fields", `{"id":"a","webTitle":"Title"}`, "Title", "", false, "", false},
+		{"null fields", `{"id":"a","webTitle":"Title","fields":null}`, "Title", "", false, "", false},
+		{"empty fields", `{"id":"a","webTitle":"Title","fields":{}}`, "Title", "", false, "", false},
+		{"some fields", `{"id":"a","webTitle":"Title","fields":{"byline":"A Writer"}}`, "Title", "A Writer", true, "", false},
+		{"booleans as JSON", `{"id":"a","webTitle":"Title","fields":{"byline":"A Writer","showByline":true,"liveBloggingNow":false}}`, "Title", "A Writer", true, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var item Item
+			if err := json.Unmarshal([]byte(tt.item), &item); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := item.Headline(); got != tt.headline {
+				t.Errorf("headline is %q, want %q", got, tt.headline)
+			}
+			if got := item.Byline(); got != tt.byline {
+				t.Errorf("byline is %q, want %q", got, tt.byline)
+			}
+			if got := item.ShowByline(); got != tt.showByline {
+				t.Errorf("showByline is %t, want %t", got, tt.showByline)
+			}
+			if got := item.Thumbnail(); got != tt.thumbnail {
+				t.Errorf("thumbnail is %q, want %q", got, tt.thumbnail)
+			}
+			if got := item.IsLiveblog(); got != tt.liveblog {
+				t.Errorf("liveblog is %t, want %t", got, tt.liveblog)
+			}
+		})
+	}
+}
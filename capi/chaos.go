@@ -0,0 +1,40 @@
+//go:build debug
+// +build debug
+
+package capi
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/guardian/onward/config"
+	"github.com/pkg/errors"
+)
+
+// errChaos is a failure injected by -chaos-error-rate
+var errChaos = errors.New("injected failure")
+
+// injectFault delays or fails a CAPI request at the configured rates, for
+// exercising timeouts, stale serving and the like. It does nothing without
+// -debug.
+func injectFault(ctx context.Context, cfg config.Config) error {
+	if !cfg.Debug {
+		return nil
+	}
+
+	if rand.Float64() < cfg.ChaosLatencyRate {
+		select {
+		case <-time.After(cfg.ChaosLatency):
+		case <-ctx.Done():
+			return &Error{Status: http.StatusGatewayTimeout, Message: "CAPI timed out", Err: ctx.Err()}
+		}
+	}
+
+	if rand.Float64() < cfg.ChaosErrorRate {
+		return TransientError(errChaos, "GET failed")
+	}
+
+	return nil
+}
@@ -0,0 +1,63 @@
+//go:build debug
+
+package capi
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestInjectFaultRates(t *testing.T) {
+	const attempts = 10000
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name        string
+		debug       bool
+		errorRate   float64
+		latencyRate float64
+		status      int
+		want        float64
+	}{
+		{name: "errors", debug: true, errorRate: 0.3, status: http.StatusBadGateway, want: 0.3},
+		{name: "latency", debug: true, latencyRate: 0.2, status: http.StatusGatewayTimeout, want: 0.2},
+		{name: "every request", debug: true, errorRate: 1, status: http.StatusBadGateway, want: 1},
+		{name: "without -debug", errorRate: 1, latencyRate: 1, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig(t, "http://capi.invalid")
+			cfg.Debug = tt.debug
+			cfg.ChaosErrorRate = tt.errorRate
+			cfg.ChaosLatencyRate = tt.latencyRate
+			// delayed requests give up at once, as their context is done
+			cfg.ChaosLatency = time.Hour
+
+			faults := 0
+			for i := 0; i < attempts; i++ {
+				err := injectFault(cancelled, cfg)
+				if err == nil {
+					continue
+				}
+
+				capiErr, ok := errors.Cause(err).(*Error)
+				if !ok || capiErr.Status != tt.status {
+					t.Fatalf("injected %v, want a %d", err, tt.status)
+				}
+				faults++
+			}
+
+			// well over four standard deviations either side
+			if rate := float64(faults) / attempts; rate < tt.want-0.03 || rate > tt.want+0.03 {
+				t.Errorf("injected faults into %.3f of requests, want about %.2f", rate, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,458 @@
+package capi
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/guardian/onward/config"
+	"github.com/pkg/errors"
+	"golang.org/x/net/http/httpproxy"
+)
+
+// UserAgent is sent with every request to CAPI and the other upstreams
+const UserAgent = "guardian-onward"
+
+var errRedirectRefused = errors.New("redirect refused")
+
+var errMalformedCAPIURL = errors.New("malformed CAPI URL")
+
+// Doer sends HTTP requests, as an *http.Client does
+type Doer interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Client makes requests to CAPI
+type Client interface {
+	// Stream GETs a CAPI path and hands a successful response's body to
+	// read, so it can be decoded without holding all of it in memory.
+	// Failures come back as Errors; read should return one too.
+	Stream(ctx context.Context, path string, params url.Values, read func(io.Reader) error) error
+
+	// Health is how the client's recent requests have gone
+	Health() Health
+}
+
+// Health is how a Client's recent requests have gone
+type Health struct {
+	// SuccessRate is the fraction of the last cfg.HealthWindow requests
+	// that succeeded, 1 before any have been made
+	SuccessRate float64
+
+	// LastSucceeded is when a request last succeeded, zero if none has
+	LastSucceeded time.Time
+}
+
+// client is the Client that sends requests over HTTP with doer
+type client struct {
+	cfg     config.Config
+	doer    Doer
+	keys    *keyPool
+	limiter *limiter
+	breaker *breaker
+	health  *successWindow
+}
+
+// New is a Client for the CAPI at cfg.CAPIURL, sending its requests with
+// doer, usually NewHTTPClient's. Its keys, timeouts, retries, concurrency
+// limit and circuit breaker are all set by cfg.
+func New(cfg config.Config, doer Doer) Client {
+	return &client{
+		cfg:     cfg,
+		doer:    doer,
+		keys:    newKeyPool(),
+		limiter: newLimiter(cfg.CAPIMaxInFlight, cfg.CAPIQueue, cfg.CAPIQueueTimeout, cfg.ShedRetryAfter),
+		breaker: newBreaker(cfg.BreakerThreshold, cfg.BreakerCooldown),
+		health:  newSuccessWindow(cfg.HealthWindow),
+	}
+}
+
+// NewTransport is the transport CAPI requests are sent over. It reaches CAPI
+// through cfg.CAPIProxy, except for hosts in cfg.NoProxy, and without a
+// proxy configured uses the standard HTTP_PROXY/HTTPS_PROXY/NO_PROXY
+// environment. Requests carry the authentication cfg.CAPIAuth asks for, and
+// each gets a client span.
+func NewTransport(cfg config.Config) (http.RoundTripper, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if cfg.CAPIProxy != "" {
+		proxyFunc := (&httpproxy.Config{
+			HTTPProxy:  cfg.CAPIProxy,
+			HTTPSProxy: cfg.CAPIProxy,
+			NoProxy:    strings.Join(cfg.NoProxy, ","),
+		}).ProxyFunc()
+
+		transport.Proxy = func(req *http.Request) (*url.URL, error) {
+			return proxyFunc(req.URL)
+		}
+	}
+
+	authenticated, err := newAuthTransport(cfg, transport)
+	if err != nil {
+		return nil, err
+	}
+
+	return tracedTransport{next: authenticated}, nil
+}
+
+// NewHTTPClient is the HTTP client for CAPI requests, sent over transport.
+// It follows at most cfg.MaxRedirects redirects, and none that leave the
+// CAPI host.
+func NewHTTPClient(cfg config.Config, transport http.RoundTripper) *http.Client {
+	base, _ := url.Parse(cfg.CAPIURL)
+
+	return &http.Client{
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > cfg.MaxRedirects {
+				return errors.Wrapf(errRedirectRefused, "more than %d redirects", cfg.MaxRedirects)
+			}
+
+			if req.URL.Host != base.Host {
+				return errors.Wrapf(errRedirectRefused, "redirect to %s is off the CAPI host", req.URL.Host)
+			}
+
+			// the query carries the API key, so it's left out
+			log.Printf("Following CAPI redirect to %s%s", req.URL.Host, req.URL.Path)
+			return nil
+		},
+	}
+}
+
+// Fetch GETs a CAPI path and returns the response body
+func Fetch(ctx context.Context, c Client, path string, params url.Values) ([]byte, error) {
+	var body []byte
+
+	err := c.Stream(ctx, path, params, func(r io.Reader) error {
+		var err error
+		if body, err = ioutil.ReadAll(r); err != nil {
+			return UpstreamError(err, "Unable to read response body")
+		}
+		return nil
+	})
+
+	return body, err
+}
+
+// Get GETs a CAPI path and decodes the response as it streams in, an item
+// at a time, rather than reading it in full and then unmarshalling it
+func Get(ctx context.Context, c Client, path string, params url.Values) (Response, error) {
+	var response Response
+
+	err := c.Stream(ctx, path, params, func(r io.Reader) error {
+		if err := decodeResponse(r, &response); err != nil {
+			return UpstreamError(err, "Unable to decode response body")
+		}
+		return nil
+	})
+
+	return response, err
+}
+
+func (c *client) Health() Health {
+	return Health{SuccessRate: c.health.rate(), LastSucceeded: c.health.lastSucceeded()}
+}
+
+type noRetriesKey struct{}
+
+// WithoutRetries makes the CAPI requests made with ctx once only, for probes
+// that shouldn't sit out a rate limit or retries
+func WithoutRetries(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noRetriesKey{}, true)
+}
+
+// Stream is where every call to CAPI is made.
+//
+// When CAPI rate limits us it's retried, up to cfg.RateLimitRetries times,
+// after waiting as long as its Retry-After asks. Transient failures are
+// retried up to cfg.CAPIRetries times, backing off exponentially from
+// cfg.RetryBackoff with jitter, unless ctx is WithoutRetries. Either wait
+// has to fit in what's left of the request's deadline. Every attempt has to
+// get past the breaker first.
+//
+// Each attempt picks its key from the key pool, so a rate-limited request
+// is retried straight away with another key if one isn't throttled.
+func (c *client) Stream(ctx context.Context, path string, params url.Values, read func(io.Reader) error) error {
+	timeout := upstreamTimeout(c.cfg, path)
+
+	retries := c.cfg
+	if once, _ := ctx.Value(noRetriesKey{}).(bool); once {
+		retries.RateLimitRetries = 0
+		retries.CAPIRetries = 0
+	}
+
+	return WithRetries(ctx, retries, func() error {
+		key, err := c.keys.pick(path, c.cfg)
+		if err != nil {
+			return err
+		}
+
+		target, err := requestURL(c.cfg.CAPIURL, path, params, key.value)
+		if err != nil {
+			return err
+		}
+
+		// a slot is taken before the breaker's asked, so a shed call can't
+		// leave a half-open breaker waiting on a probe that never ran
+		release, err := c.limiter.acquire(ctx)
+		if err != nil {
+			return err
+		}
+		defer release()
+
+		if err := c.breaker.allow(); err != nil {
+			return err
+		}
+
+		start := time.Now()
+		err = c.attemptWithin(ctx, timeout, target, key, read)
+		requestDuration.Observe(time.Since(start).Seconds())
+		if errors.Is(err, context.Canceled) {
+			c.breaker.release()
+			return err
+		}
+		c.recordOutcome(err)
+		c.breaker.record(err)
+
+		if capiErr, ok := err.(*Error); ok && capiErr.Status == http.StatusTooManyRequests {
+			capiErr.RetryAfter = c.keys.wait(path, c.cfg)
+		}
+
+		return err
+	})
+}
+
+// requestURL builds the URL for a CAPI path under base. Each path segment is
+// escaped, and relative segments are rejected so a path can't climb out of
+// the API root.
+func requestURL(base, path string, params url.Values, apiKey string) (string, error) {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if segment == "." || segment == ".." {
+			return "", &Error{Status: http.StatusBadRequest, Message: "Invalid path"}
+		}
+
+		segments[i] = url.PathEscape(segment)
+	}
+
+	withKey := url.Values{}
+	for name, values := range params {
+		withKey[name] = values
+	}
+	withKey.Set("api-key", apiKey)
+
+	target := fmt.Sprintf("%s/%s?%s", strings.TrimSuffix(base, "/"), strings.Join(segments, "/"), withKey.Encode())
+
+	// a path can't break the URL once escaped, so this only fails if the base
+	// URL itself is wrong, and then shouldn't be passed off as a CAPI failure
+	if u, err := url.Parse(target); err != nil || u.Scheme == "" || u.Host == "" {
+		if urlErr, ok := err.(*url.Error); ok {
+			urlErr.URL = RedactedURL(urlErr.URL)
+		} else if err == nil {
+			err = fmt.Errorf("%s has no scheme or host", RedactedURL(target))
+		}
+
+		urlErrorsTotal.Inc()
+		return "", &Error{
+			Status:  http.StatusInternalServerError,
+			Message: "CAPI URL is misconfigured",
+			Err:     errors.Wrap(errMalformedCAPIURL, err.Error()),
+		}
+	}
+
+	return target, nil
+}
+
+// RedactedURL is rawURL with any api-key parameter masked, for logging
+func RedactedURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "(unparseable URL)"
+	}
+
+	values := u.Query()
+	if values.Get("api-key") != "" {
+		values.Set("api-key", "REDACTED")
+		u.RawQuery = values.Encode()
+	}
+
+	return u.String()
+}
+
+// upstreamTimeout is the per-attempt timeout for a path: its first segment's
+// from cfg.EditionTimeouts, or the default
+func upstreamTimeout(cfg config.Config, path string) time.Duration {
+	if timeout, ok := cfg.EditionTimeouts[strings.SplitN(path, "/", 2)[0]]; ok {
+		return timeout
+	}
+
+	return cfg.UpstreamTimeout
+}
+
+// WithRetries makes attempt until it succeeds or fails for good, retrying
+// rate limiting after the upstream's Retry-After and transient failures with
+// backoff. Retries stop once the wait wouldn't fit ctx's deadline.
+func WithRetries(ctx context.Context, cfg config.Config, attempt func() error) error {
+	rateLimited, failed := 0, 0
+	for {
+		err := attempt()
+
+		capiErr, ok := errors.Cause(err).(*Error)
+		if !ok {
+			return err
+		}
+
+		var wait time.Duration
+		switch {
+		case capiErr.Status == http.StatusTooManyRequests && rateLimited < cfg.RateLimitRetries:
+			rateLimited++
+			wait = capiErr.RetryAfter
+		case capiErr.Transient && failed < cfg.CAPIRetries:
+			wait = backoff(cfg.RetryBackoff, cfg.RetryMaxBackoff, failed)
+			failed++
+		default:
+			return err
+		}
+
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			return err
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
+
+// backoff is how long to wait before retry n (from 0): a random duration up
+// to base doubled n times, capped at max ("full jitter"), so instances don't
+// retry in step
+func backoff(base, max time.Duration, n int) time.Duration {
+	ceiling := base
+	for i := 0; i < n && ceiling < max; i++ {
+		ceiling *= 2
+	}
+
+	if ceiling > max {
+		ceiling = max
+	}
+
+	if ceiling <= 0 {
+		return 0
+	}
+
+	return time.Duration(rand.Int63n(int64(ceiling)) + 1)
+}
+
+// attemptWithin is attempt bounded by timeout, if it's non-zero
+func (c *client) attemptWithin(ctx context.Context, timeout time.Duration, target string, key apiKey, read func(io.Reader) error) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	return c.attempt(ctx, target, key, read)
+}
+
+// attempt makes a single request to CAPI for target, which carries key
+func (c *client) attempt(ctx context.Context, target string, key apiKey, read func(io.Reader) error) error {
+	if err := injectFault(ctx, c.cfg); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return errors.Wrap(err, "Unable to build CAPI request")
+	}
+
+	req.Header.Set("User-Agent", UserAgent)
+	for name, values := range c.cfg.CAPIHeaders {
+		req.Header[name] = values
+	}
+
+	cond := ConditionalFrom(ctx)
+	if cond != nil {
+		for name, values := range cond.Request {
+			req.Header[name] = values
+		}
+	}
+
+	resp, err := c.doer.Do(req)
+	if err != nil {
+		// transport errors quote the URL, key and all, and end up in logs
+		if urlErr, ok := err.(*url.Error); ok {
+			urlErr.URL = RedactedURL(urlErr.URL)
+		}
+
+		switch {
+		case ctx.Err() == context.Canceled:
+			// the caller went away, which says nothing about CAPI
+			return errors.Wrap(err, "CAPI request cancelled")
+		case ctx.Err() == context.DeadlineExceeded:
+			return &Error{Status: http.StatusGatewayTimeout, Message: "CAPI timed out", Err: err, Transient: true}
+		case errors.Is(err, errRedirectRefused):
+			return UpstreamError(err, "CAPI redirect refused")
+		}
+		return TransientError(err, "GET failed")
+	}
+	defer resp.Body.Close()
+
+	c.keys.observe(key, resp)
+	if cond != nil {
+		cond.observe(resp)
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && cond != nil:
+		return nil
+	case resp.StatusCode == http.StatusNotFound:
+		return &Error{Status: http.StatusNotFound, Message: "Not found"}
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return UpstreamError(fmt.Errorf("status %d", resp.StatusCode), "CAPI rejected the API key")
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return &Error{
+			Status:     http.StatusTooManyRequests,
+			Message:    "CAPI rate limit exceeded",
+			Code:       CodeUpstreamRateLimited,
+			RetryAfter: ParseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+		}
+	case resp.StatusCode >= 500:
+		return TransientError(fmt.Errorf("status %d", resp.StatusCode), "CAPI error")
+	case resp.StatusCode != http.StatusOK:
+		return UpstreamError(fmt.Errorf("status %d", resp.StatusCode), "Unexpected CAPI response")
+	}
+
+	return read(resp.Body)
+}
+
+// defaultRetryAfter is how long to back off from a 429 with no usable
+// Retry-After
+const defaultRetryAfter = time.Second
+
+// ParseRetryAfter reads a Retry-After header, given either in seconds or as
+// an HTTP date
+func ParseRetryAfter(header string, now time.Time) time.Duration {
+	if seconds, err := strconv.Atoi(header); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if at, err := http.ParseTime(header); err == nil {
+		if wait := at.Sub(now); wait > 0 {
+			return wait
+		}
+		return 0
+	}
+
+	return defaultRetryAfter
+}
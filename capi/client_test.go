@@ -0,0 +1,414 @@
+package capi
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func FuzzRequestURL(f *testing.F) {
+	for _, seed := range []string{
+		"uk",
+		"uk/sport",
+		"",
+		"../",
+		"uk/../../admin",
+		"uk/%2e%2e/admin",
+		"uk/..%2f..%2fadmin",
+		"uk/?api-key=stolen",
+		"uk/#fragment",
+		"@evil.example.com",
+		"//evil.example.com/uk",
+		"uk/culture/über",
+		"uk/カルチャー",
+		"uk/\x00\n\r",
+		strings.Repeat("uk/", 2000),
+		strings.Repeat("ü", 10000),
+	} {
+		f.Add(seed)
+	}
+
+	const base = "https://content.guardianapis.com"
+	params := url.Values{"show-most-viewed": {"true"}, "show-tags": {"tone"}}
+
+	f.Fuzz(func(t *testing.T, path string) {
+		target, err := requestURL(base, path, params, "secret")
+		if err != nil {
+			capiErr, ok := errors.Cause(err).(*Error)
+			if !ok || capiErr.Status != http.StatusBadRequest {
+				t.Fatalf("requestURL(%q) failed with %v, want a 400", path, err)
+			}
+			return
+		}
+
+		u, err := url.Parse(target)
+		if err != nil {
+			t.Fatalf("requestURL(%q) built %q, which doesn't parse: %s", path, target, err)
+		}
+		if u.Scheme != "https" || u.Host != "content.guardianapis.com" || u.User != nil || u.Fragment != "" {
+			t.Fatalf("requestURL(%q) built %q, off the CAPI host", path, RedactedURL(target))
+		}
+		if u.Path != "/"+path {
+			t.Fatalf("requestURL(%q) built a URL for the path %q", path, u.Path)
+		}
+		query := u.Query()
+		if got := query.Get("api-key"); got != "secret" || len(query["api-key"]) != 1 {
+			t.Fatalf("requestURL(%q) sent api-key %q", path, query["api-key"])
+		}
+		query.Del("api-key")
+		if !reflect.DeepEqual(query, params) {
+			t.Fatalf("requestURL(%q) sent parameters %v, want %v", path, query, params)
+		}
+	})
+}
+
+func TestRedirects(t *testing.T) {
+	offHost := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(mostViewedBody("elsewhere")))
+	})
+
+	var stub *stubCAPI
+	stub = newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/moved":
+			http.Redirect(w, r, stub.URL+"/uk?"+r.URL.RawQuery, http.StatusMovedPermanently)
+		case "/off-host":
+			http.Redirect(w, r, offHost.URL+"/uk?"+r.URL.RawQuery, http.StatusFound)
+		case "/loop":
+			http.Redirect(w, r, "/loop?"+r.URL.RawQuery, http.StatusFound)
+		default:
+			w.Write([]byte(mostViewedBody("a")))
+		}
+	})
+
+	tests := []struct {
+		path  string
+		want  int
+		calls int64
+	}{
+		{"moved", http.StatusOK, 2},
+		{"off-host", http.StatusBadGateway, 1},
+		{"loop", http.StatusBadGateway, 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			cfg := testConfig(t, stub.URL)
+			cfg.MaxRedirects = 3
+			before := stub.requests()
+
+			_, err := Fetch(context.Background(), testClient(cfg), tt.path, nil)
+			status := http.StatusOK
+			if err != nil {
+				capiErr, ok := errors.Cause(err).(*Error)
+				if !ok {
+					t.Fatalf("got %v, want a CAPI error", err)
+				}
+				status = capiErr.Status
+			}
+			if status != tt.want {
+				t.Errorf("got %d (%v), want %d", status, err, tt.want)
+			}
+			if calls := stub.requests() - before; calls != tt.calls {
+				t.Errorf("CAPI got %d requests, want %d", calls, tt.calls)
+			}
+		})
+	}
+
+	if calls := offHost.requests(); calls != 0 {
+		t.Errorf("the off-host server got %d requests, want none", calls)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		header string
+		want   time.Duration
+	}{
+		{"120", 2 * time.Minute},
+		{"0", 0},
+		{now.Add(90 * time.Second).Format(http.TimeFormat), 90 * time.Second},
+		{now.Add(-time.Minute).Format(http.TimeFormat), 0},
+		{"", defaultRetryAfter},
+		{"-5", defaultRetryAfter},
+		{"soon", defaultRetryAfter},
+	}
+
+	for _, tt := range tests {
+		if got := ParseRetryAfter(tt.header, now); got != tt.want {
+			t.Errorf("ParseRetryAfter(%q) is %s, want %s", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestWithRetriesHonoursRetryAfter(t *testing.T) {
+	rateLimited := func(wait time.Duration) error {
+		return &Error{Status: http.StatusTooManyRequests, Message: "CAPI rate limit exceeded", RetryAfter: wait}
+	}
+
+	tests := []struct {
+		name      string
+		wait      time.Duration
+		retries   int
+		deadline  time.Duration
+		succeedOn int // the attempt that succeeds, if any
+		attempts  int
+		waited    time.Duration
+	}{
+		{name: "waits, then retries", wait: 50 * time.Millisecond, retries: 1, succeedOn: 2, attempts: 2, waited: 50 * time.Millisecond},
+		{name: "retries used up", wait: time.Millisecond, retries: 2, attempts: 3},
+		{name: "no retries", wait: time.Millisecond, succeedOn: 2, attempts: 1},
+		{name: "wait past the deadline", wait: time.Hour, retries: 1, deadline: time.Second, succeedOn: 2, attempts: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig(t, "http://capi.invalid")
+			cfg.RateLimitRetries = tt.retries
+
+			ctx := context.Background()
+			if tt.deadline > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.deadline)
+				defer cancel()
+			}
+
+			attempts := 0
+			start := time.Now()
+			err := WithRetries(ctx, cfg, func() error {
+				attempts++
+				if attempts == tt.succeedOn {
+					return nil
+				}
+				return rateLimited(tt.wait)
+			})
+			elapsed := time.Since(start)
+
+			if wantErr := attempts != tt.succeedOn; (err != nil) != wantErr {
+				t.Errorf("got %v, want an error: %t", err, wantErr)
+			}
+			if attempts != tt.attempts {
+				t.Errorf("made %d attempts, want %d", attempts, tt.attempts)
+			}
+			if elapsed < tt.waited {
+				t.Errorf("retried after %s, before Retry-After's %s", elapsed, tt.waited)
+			}
+			if tt.deadline > 0 && elapsed >= tt.deadline {
+				t.Errorf("waited %s, past the %s deadline", elapsed, tt.deadline)
+			}
+		})
+	}
+}
+
+func TestRateLimitedRequestsAreRetried(t *testing.T) {
+	var stub *stubCAPI
+	stub = newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if stub.requests() == 1 {
+			// an HTTP date that's already passed, so the retry is immediate
+			w.Header().Set("Retry-After", time.Now().Add(-time.Minute).Format(http.TimeFormat))
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte(mostViewedBody("a")))
+	})
+	cfg := testConfig(t, stub.URL)
+	cfg.RateLimitRetries = 1
+
+	if _, err := Fetch(context.Background(), testClient(cfg), "uk", nil); err != nil {
+		t.Fatal(err)
+	}
+	if calls := stub.requests(); calls != 2 {
+		t.Errorf("CAPI got %d requests, want 2", calls)
+	}
+}
+
+func TestRateLimitedErrorsCarryRetryAfter(t *testing.T) {
+	stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+	cfg := testConfig(t, stub.URL)
+	cfg.RateLimitRetries = 0
+
+	_, err := Fetch(context.Background(), testClient(cfg), "uk", nil)
+	capiErr, ok := errors.Cause(err).(*Error)
+	if !ok || capiErr.Status != http.StatusTooManyRequests {
+		t.Fatalf("got %v, want a 429", err)
+	}
+	// it's how long until the throttled key can be used again, so a little
+	// under the 30s CAPI asked for by now
+	if capiErr.RetryAfter <= 25*time.Second || capiErr.RetryAfter > 30*time.Second {
+		t.Errorf("RetryAfter is %s, want CAPI's 30s", capiErr.RetryAfter)
+	}
+	if capiErr.Code != CodeUpstreamRateLimited {
+		t.Errorf("Code is %q, want %q", capiErr.Code, CodeUpstreamRateLimited)
+	}
+}
+
+func TestCAPIProxy(t *testing.T) {
+	var proxied []string
+	proxy := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		// a proxy is sent the whole URL
+		proxied = append(proxied, r.URL.Host+r.URL.Path)
+		w.Write([]byte(mostViewedBody("a")))
+	})
+
+	tests := []struct {
+		name    string
+		capiURL string
+		noProxy []string
+		want    []string
+	}{
+		{"proxied", "http://content.guardianapis.test", nil, []string{"content.guardianapis.test/uk"}},
+		{"no-proxy domain", "http://capi.internal.test", []string{".internal.test"}, nil},
+		{"no-proxy host", "http://content.guardianapis.test", []string{"content.guardianapis.test"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxied = nil
+			cfg := testConfig(t, tt.capiURL)
+			cfg.CAPIProxy = proxy.URL
+			cfg.NoProxy = tt.noProxy
+			cfg.CAPIRetries = 0
+
+			transport, err := NewTransport(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = Fetch(context.Background(), New(cfg, NewHTTPClient(cfg, transport)), "uk", nil)
+			if tt.want != nil && err != nil {
+				t.Fatalf("got %v through the proxy", err)
+			}
+			if tt.want == nil && err == nil {
+				t.Fatal("reached CAPI directly, at a host that doesn't exist")
+			}
+			if !reflect.DeepEqual(proxied, tt.want) {
+				t.Errorf("the proxy was sent %v, want %v", proxied, tt.want)
+			}
+		})
+	}
+}
+
+func TestCAPIHeaders(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers http.Header
+		want    http.Header
+	}{
+		{"none configured", nil, http.Header{"User-Agent": {UserAgent}}},
+		{"added", http.Header{"X-Capi-Tier": {"internal"}, "Authorization": {"Bearer t0ken"}},
+			http.Header{"User-Agent": {UserAgent}, "X-Capi-Tier": {"internal"}, "Authorization": {"Bearer t0ken"}}},
+		{"repeated", http.Header{"X-Capi-Tier": {"internal", "preview"}},
+			http.Header{"User-Agent": {UserAgent}, "X-Capi-Tier": {"internal", "preview"}}},
+		{"overriding a default", http.Header{"User-Agent": {"onward-canary"}}, http.Header{"User-Agent": {"onward-canary"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got http.Header
+			stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+				got = r.Header
+				w.Write([]byte(mostViewedBody("a")))
+			})
+			cfg := testConfig(t, stub.URL)
+			for name, values := range tt.headers {
+				cfg.CAPIHeaders[name] = values
+			}
+
+			if _, err := Fetch(context.Background(), testClient(cfg), "uk", nil); err != nil {
+				t.Fatal(err)
+			}
+			for name, values := range tt.want {
+				if !reflect.DeepEqual(got[name], values) {
+					t.Errorf("%s was %q, want %q", name, got[name], values)
+				}
+			}
+		})
+	}
+}
+
+func TestEditionTimeouts(t *testing.T) {
+	cfg := testConfig(t, "")
+	cfg.UpstreamTimeout = 50 * time.Millisecond
+	cfg.EditionTimeouts = map[string]time.Duration{"uk": 2 * time.Second, "football": 5 * time.Second}
+
+	tests := []struct {
+		path string
+		want time.Duration
+	}{
+		{"uk", 2 * time.Second},
+		{"us", 50 * time.Millisecond},
+		{"football", 5 * time.Second},
+		{"football/premierleague", 5 * time.Second},
+		{"uk-news", 50 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := upstreamTimeout(cfg, tt.path); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEditionTimeoutsAreApplied(t *testing.T) {
+	stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(200 * time.Millisecond):
+			w.Write([]byte(mostViewedBody("a")))
+		case <-r.Context().Done():
+		}
+	})
+	cfg := testConfig(t, stub.URL)
+	cfg.UpstreamTimeout = 50 * time.Millisecond
+	cfg.EditionTimeouts = map[string]time.Duration{"uk": 2 * time.Second}
+	api := testClient(cfg)
+
+	// the slow section has the headroom to answer
+	if _, err := Fetch(WithoutRetries(context.Background()), api, "uk", nil); err != nil {
+		t.Errorf("uk, with its own timeout: %s", err)
+	}
+	// everything else has the default
+	if _, err := Fetch(WithoutRetries(context.Background()), api, "us", nil); err == nil {
+		t.Error("us, with the default timeout: got a response, want it to time out")
+	}
+}
+
+func TestMalformedCAPIURL(t *testing.T) {
+	for _, base := range []string{"http://[::1", "content.guardianapis.com", "://content.guardianapis.com", "http://%zz"} {
+		t.Run(base, func(t *testing.T) {
+			cfg := testConfig(t, base)
+			cfg.APIKey = "s3cret"
+			cfg.CAPIRetries = 3
+			before := testutil.ToFloat64(urlErrorsTotal)
+
+			_, err := Fetch(context.Background(), testClient(cfg), "uk", nil)
+			capiErr, ok := errors.Cause(err).(*Error)
+			if !ok || capiErr.Status != http.StatusInternalServerError {
+				t.Fatalf("got %v, want a 500", err)
+			}
+			if errors.Cause(capiErr.Err) != errMalformedCAPIURL {
+				t.Errorf("got %v, want it to be a malformed CAPI URL", capiErr.Err)
+			}
+			if strings.Contains(err.Error(), "s3cret") {
+				t.Errorf("the error gives away the API key: %s", err)
+			}
+			// a misconfiguration isn't retried
+			if n := testutil.ToFloat64(urlErrorsTotal) - before; n != 1 {
+				t.Errorf("counted %v malformed URLs, want 1", n)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package capi
+
+import (
+	"context"
+	"net/http"
+)
+
+// validatorHeaders are the response headers CAPI's validators come in
+var validatorHeaders = []string{"ETag", "Last-Modified"}
+
+// Conditional is a conditional GET: the headers it's sent with, and what CAPI
+// said about it
+type Conditional struct {
+	Request     http.Header
+	Validators  http.Header
+	NotModified bool
+}
+
+type conditionalKey struct{}
+
+// WithConditional makes the CAPI requests made with ctx conditional on cond
+func WithConditional(ctx context.Context, cond *Conditional) context.Context {
+	return context.WithValue(ctx, conditionalKey{}, cond)
+}
+
+// ConditionalFrom returns the conditional GET ctx's requests are made with,
+// or nil if there isn't one
+func ConditionalFrom(ctx context.Context) *Conditional {
+	cond, _ := ctx.Value(conditionalKey{}).(*Conditional)
+	return cond
+}
+
+// Revalidation asks CAPI for a response again only if it's changed since it
+// came with validators. Without any it's unconditional, but still records
+// the new response's validators.
+func Revalidation(validators http.Header) *Conditional {
+	cond := &Conditional{Request: http.Header{}, Validators: http.Header{}}
+
+	if etag := validators.Get("ETag"); etag != "" {
+		cond.Request.Set("If-None-Match", etag)
+	}
+	if lastModified := validators.Get("Last-Modified"); lastModified != "" {
+		cond.Request.Set("If-Modified-Since", lastModified)
+	}
+
+	return cond
+}
+
+// observe records CAPI's validators from resp, and whether it answered 304
+func (cond *Conditional) observe(resp *http.Response) {
+	for _, name := range validatorHeaders {
+		if value := resp.Header.Get(name); value != "" {
+			cond.Validators.Set(name, value)
+		}
+	}
+
+	cond.NotModified = resp.StatusCode == http.StatusNotModified
+}
@@ -0,0 +1,127 @@
+package capi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// decodeResponse decodes a CAPI response as it streams in. The lists are
+// read an item at a time, so a large response is never held whole alongside
+// its items, as it is by json.Decoder.Decode, which buffers the value first.
+// It reads like json.Unmarshal: keys match whatever their case, other keys
+// are skipped, and anything after the response is an error.
+func decodeResponse(r io.Reader, response *Response) error {
+	dec := json.NewDecoder(r)
+
+	err := decodeObject(dec, "response", func(key string) error {
+		if !strings.EqualFold(key, "response") {
+			return skipValue(dec)
+		}
+
+		return decodeObject(dec, "response.response", func(key string) error {
+			switch {
+			case strings.EqualFold(key, "mostViewed"):
+				return decodeItems(dec, key, &response.Response.Results)
+			case strings.EqualFold(key, "results"):
+				return decodeItems(dec, key, &response.Response.Latest)
+			case strings.EqualFold(key, "relatedContent"):
+				return decodeItems(dec, key, &response.Response.Related)
+			}
+			return skipValue(dec)
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	if _, err := dec.Token(); err != io.EOF {
+		return errors.New("invalid data after the response")
+	}
+
+	return nil
+}
+
+// decodeObject reads a JSON object, calling field with each key for it to
+// read the value. null is an empty object.
+func decodeObject(dec *json.Decoder, what string, field func(key string) error) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok == nil {
+		return nil
+	}
+	if tok != json.Delim('{') {
+		return fmt.Errorf("%s is %s, not an object", what, jsonKind(tok))
+	}
+
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		if err := field(tok.(string)); err != nil {
+			return err
+		}
+	}
+
+	_, err = dec.Token()
+	return err
+}
+
+// decodeItems reads a JSON array of items into items, one at a time. null
+// is no items.
+func decodeItems(dec *json.Decoder, what string, items *[]Item) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok == nil {
+		*items = nil
+		return nil
+	}
+	if tok != json.Delim('[') {
+		return fmt.Errorf("%s is %s, not an array", what, jsonKind(tok))
+	}
+
+	*items = []Item{}
+	for dec.More() {
+		var item Item
+		if err := dec.Decode(&item); err != nil {
+			return errors.Wrapf(err, "%s item %d", what, len(*items))
+		}
+		*items = append(*items, item)
+	}
+
+	_, err = dec.Token()
+	return err
+}
+
+// skipValue reads past the next JSON value
+func skipValue(dec *json.Decoder) error {
+	var skipped json.RawMessage
+	return dec.Decode(&skipped)
+}
+
+// jsonKind names the kind of JSON value tok starts, for errors
+func jsonKind(tok json.Token) string {
+	switch tok := tok.(type) {
+	case json.Delim:
+		if tok == '{' {
+			return "an object"
+		}
+		return "an array"
+	case string:
+		return "a string"
+	case float64:
+		return "a number"
+	case bool:
+		return "a boolean"
+	}
+
+	return "null"
+}
@@ -0,0 +1,112 @@
+package capi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// unmarshalResponse is how responses were decoded before they were
+// streamed
+func unmarshalResponse(body []byte) (Response, error) {
+	var response Response
+	data, err := ioutil.ReadAll(bytes.NewReader(body))
+	if err != nil {
+		return response, err
+	}
+
+	err = json.Unmarshal(data, &response)
+	return response, err
+}
+
+var capiResponseBodies = []string{
+	mostViewedBody("a", "b", "c"),
+	`{"response":{"status":"ok","mostViewed":[],"results":[{"id":"x"}],"relatedContent":null}}`,
+	`{"response":{"status":"ok","total":2,"pages":{"next":1},"results":[{"id":"x","fields":{"headline":"H","thumbnail":"t","showByline":"true"}},{"id":"y","tags":[{"id":"tone/news","type":"tone"}]}]}}`,
+	`{"response":{"status":"ok"}}`,
+	`{"response":null}`,
+	`{}`,
+	`null`,
+	`{"Response":{"MostViewed":[{"id":"cased"}]}}`,
+	`{"response":{"mostViewed":[{"id":"first"}],"mostViewed":[{"id":"second"}]}}`,
+	`{"other":[1,2,{"a":"b"}],"response":{"mostViewed":[{"id":"a","webPublicationDate":"2026-10-14T06:00:00Z"}]}}`,
+	` {"response":{"mostViewed":[]}} `,
+	`[]`,
+	`"response"`,
+	`{"response":[]}`,
+	`{"response":{"mostViewed":{}}}`,
+	`{"response":{"mostViewed":[1]}}`,
+	`{"response":{"mostViewed":[{"id":"a"}]}} trailing`,
+	`{"response":{"mostViewed":[{"id":"a"}]}}{}`,
+	`{"response":{"mostViewed":[{"id":"a"}`,
+	``,
+}
+
+func TestDecodeResponseMatchesUnmarshal(t *testing.T) {
+	for _, body := range capiResponseBodies {
+		checkDecodeMatchesUnmarshal(t, body)
+	}
+}
+
+func FuzzDecodeResponse(f *testing.F) {
+	for _, body := range capiResponseBodies {
+		f.Add(body)
+	}
+
+	f.Fuzz(checkDecodeMatchesUnmarshal)
+}
+
+func checkDecodeMatchesUnmarshal(t *testing.T, body string) {
+	want, wantErr := unmarshalResponse([]byte(body))
+
+	var got Response
+	err := decodeResponse(strings.NewReader(body), &got)
+
+	if (err != nil) != (wantErr != nil) {
+		t.Fatalf("decoding %q got error %v, unmarshalling got %v", body, err, wantErr)
+	}
+	if err == nil && !reflect.DeepEqual(got, want) {
+		t.Fatalf("decoding %q got %+v, unmarshalling got %+v", body, got, want)
+	}
+}
+
+// largeResponse is a most-viewed response of n items
+func largeResponse(n int) []byte {
+	var body bytes.Buffer
+	body.WriteString(`{"response":{"status":"ok","mostViewed":[`)
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			body.WriteString(",")
+		}
+		fmt.Fprintf(&body, `{"id":"world/2026/oct/14/story-%d","type":"article","webTitle":"Story %d","webUrl":"https://www.theguardian.com/world/2026/oct/14/story-%d","fields":{"headline":"Story %d","thumbnail":"https://media.guim.co.uk/%d/500.jpg"}}`, i, i, i, i, i)
+	}
+	body.WriteString(`]}}`)
+	return body.Bytes()
+}
+
+func BenchmarkDecodeResponse(b *testing.B) {
+	body := largeResponse(20000)
+
+	b.Run("unmarshal", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := unmarshalResponse(body); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("stream", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var response Response
+			if err := decodeResponse(bytes.NewReader(body), &response); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
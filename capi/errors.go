@@ -0,0 +1,59 @@
+package capi
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Error is a failed upstream request, with the HTTP status a request that
+// needed it should be served with. Message is safe to show to clients; Err,
+// if set, is the underlying cause and is only logged.
+type Error struct {
+	Status  int
+	Message string
+	Err     error
+
+	// Code tells clients what went wrong without parsing Message. Left
+	// empty, it's the general code for Status.
+	Code string
+
+	// RetryAfter, if set, is how long to wait before trying again
+	RetryAfter time.Duration
+
+	// Transient marks failures that may well succeed if retried: 5xx
+	// responses, network errors and timeouts
+	Transient bool
+}
+
+func (e *Error) Error() string {
+	if e.Err == nil {
+		return e.Message
+	}
+
+	return e.Message + ": " + e.Err.Error()
+}
+
+// Error codes for failures that aren't just their status
+const (
+	CodeOverloaded          = "overloaded"
+	CodeUpstreamRateLimited = "upstream_rate_limited"
+	CodeUpstreamUnavailable = "upstream_unavailable"
+)
+
+// UpstreamError is a bad response from an upstream, served as a 502
+func UpstreamError(err error, message string) error {
+	return &Error{Status: http.StatusBadGateway, Message: message, Err: err}
+}
+
+// TransientError is an UpstreamError worth retrying
+func TransientError(err error, message string) error {
+	return &Error{Status: http.StatusBadGateway, Message: message, Err: err, Transient: true}
+}
+
+// IsTransient reports whether err is a failure worth retrying
+func IsTransient(err error) bool {
+	capiErr, ok := errors.Cause(err).(*Error)
+	return ok && capiErr.Transient
+}
@@ -0,0 +1,93 @@
+package capi
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// successWindow tracks whether each of the last few CAPI calls succeeded
+type successWindow struct {
+	mu       sync.Mutex
+	outcomes []bool
+	next     int
+	filled   bool
+
+	// lastSuccess is when a call last succeeded, zero if none has
+	lastSuccess time.Time
+}
+
+func newSuccessWindow(size int) *successWindow {
+	successRate.Set(1)
+	return &successWindow{outcomes: make([]bool, size)}
+}
+
+func (sw *successWindow) record(ok bool) {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+
+	sw.outcomes[sw.next] = ok
+	if ok {
+		sw.lastSuccess = time.Now()
+	}
+	sw.next = (sw.next + 1) % len(sw.outcomes)
+	if sw.next == 0 {
+		sw.filled = true
+	}
+}
+
+// lastSucceeded is when a call last succeeded, zero if none has
+func (sw *successWindow) lastSucceeded() time.Time {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+
+	return sw.lastSuccess
+}
+
+// rate is the fraction of calls in the window that succeeded. With no calls
+// yet it's 1, so a fresh instance starts out healthy.
+func (sw *successWindow) rate() float64 {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+
+	n := sw.next
+	if sw.filled {
+		n = len(sw.outcomes)
+	}
+
+	if n == 0 {
+		return 1
+	}
+
+	succeeded := 0
+	for _, ok := range sw.outcomes[:n] {
+		if ok {
+			succeeded++
+		}
+	}
+
+	return float64(succeeded) / float64(n)
+}
+
+// recordOutcome notes a CAPI call in the client's health, and counts
+// failures by status. A 404 is CAPI answering correctly, so only other errors
+// count against it.
+func (c *client) recordOutcome(err error) {
+	if capiErr, ok := errors.Cause(err).(*Error); ok && capiErr.Status == http.StatusNotFound {
+		err = nil
+	}
+
+	c.health.record(err == nil)
+	successRate.Set(c.health.rate())
+
+	if err != nil {
+		status := "other"
+		if capiErr, ok := errors.Cause(err).(*Error); ok {
+			status = strconv.Itoa(capiErr.Status)
+		}
+		errorsTotal.WithLabelValues(status).Inc()
+	}
+}
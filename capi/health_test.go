@@ -0,0 +1,70 @@
+package capi
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestSuccessWindow(t *testing.T) {
+	tests := []struct {
+		name     string
+		size     int
+		outcomes []bool
+		want     float64
+	}{
+		{"no calls", 4, nil, 1},
+		{"all succeeded", 4, []bool{true, true, true}, 1},
+		{"all failed", 4, []bool{false, false}, 0},
+		{"mixed", 4, []bool{true, false, true, true}, 0.75},
+		{"not yet full", 10, []bool{true, false}, 0.5},
+		{"old calls drop out", 4, []bool{false, false, false, false, true, true, true}, 0.75},
+		{"recovered", 2, []bool{false, false, true, true}, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sw := newSuccessWindow(tt.size)
+			for _, ok := range tt.outcomes {
+				sw.record(ok)
+			}
+
+			if got := sw.rate(); got != tt.want {
+				t.Errorf("rate is %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientHealth(t *testing.T) {
+	statuses := []int{http.StatusOK, http.StatusInternalServerError, http.StatusOK, http.StatusNotFound, http.StatusBadGateway}
+	var n int
+	stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(statuses[n])
+		n++
+		w.Write([]byte(mostViewedBody("a")))
+	})
+	cfg := testConfig(t, stub.URL)
+	cfg.CAPIRetries = 0
+	cfg.BreakerThreshold = 0
+	cfg.HealthWindow = 10
+	c := testClient(cfg)
+
+	if got := c.Health(); got.SuccessRate != 1 || !got.LastSucceeded.IsZero() {
+		t.Errorf("a new client's health is %+v, want a rate of 1 and no successes", got)
+	}
+
+	for range statuses {
+		Fetch(context.Background(), c, "uk", nil)
+	}
+
+	// a 404 is CAPI answering correctly, so it counts as a success
+	if got := c.Health(); got.SuccessRate != 0.6 || got.LastSucceeded.IsZero() {
+		t.Errorf("health is %+v, want a rate of 0.6", got)
+	}
+	if got := testutil.ToFloat64(successRate); got != 0.6 {
+		t.Errorf("the success rate gauge is %v, want 0.6", got)
+	}
+}
@@ -0,0 +1,69 @@
+package capi
+
+import (
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/guardian/onward/config"
+)
+
+// testConfig is the configuration as it is with no flags given, but with
+// CAPI at capiURL
+func testConfig(t testing.TB, capiURL string) config.Config {
+	t.Helper()
+
+	var cfg config.Config
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	config.Register(fs, &cfg)
+	if err := fs.Parse(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.CAPIURL = capiURL
+	return cfg
+}
+
+// testClient is a client for cfg, sending its requests over HTTP
+func testClient(cfg config.Config) *client {
+	return New(cfg, NewHTTPClient(cfg, http.DefaultTransport)).(*client)
+}
+
+// stubCAPI is a stand-in for CAPI serving h, which counts the requests it
+// gets in calls
+type stubCAPI struct {
+	*httptest.Server
+	calls int64
+}
+
+func newStubCAPI(t testing.TB, h http.HandlerFunc) *stubCAPI {
+	t.Helper()
+
+	stub := &stubCAPI{}
+	stub.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&stub.calls, 1)
+		h(w, r)
+	}))
+	t.Cleanup(stub.Close)
+
+	return stub
+}
+
+func (s *stubCAPI) requests() int64 {
+	return atomic.LoadInt64(&s.calls)
+}
+
+// mostViewedBody is a CAPI response most-viewed ranking items with the given
+// IDs
+func mostViewedBody(ids ...string) string {
+	body := `{"response":{"status":"ok","mostViewed":[`
+	for i, id := range ids {
+		if i > 0 {
+			body += ","
+		}
+		body += `{"id":"` + id + `","type":"article","webTitle":"Title of ` + id + `","webUrl":"https://www.theguardian.com/` + id + `"}`
+	}
+	return body + `]}}`
+}
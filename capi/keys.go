@@ -0,0 +1,161 @@
+package capi
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/guardian/onward/config"
+)
+
+// quotaWindows are the rate-limit windows CAPI reports quota for, in
+// X-RateLimit-Limit-{window} and X-RateLimit-Remaining-{window} headers
+var quotaWindows = []string{"second", "minute", "hour", "day", "month"}
+
+// apiKey is a CAPI key and the name it's known by in logs and metrics, as
+// the key itself is never shown
+type apiKey struct {
+	name  string
+	value string
+}
+
+// apiKeys are the keys a CAPI path may be fetched with: its first segment's
+// own key from cfg.PathAPIKeys, or else the -api-keys pool, or -api-key
+func apiKeys(cfg config.Config, path string) []apiKey {
+	segment := strings.SplitN(path, "/", 2)[0]
+	if key, ok := cfg.PathAPIKeys[segment]; ok {
+		return []apiKey{{name: "path-" + segment, value: key}}
+	}
+
+	if len(cfg.APIKeys) == 0 {
+		return []apiKey{{name: "default", value: cfg.APIKey}}
+	}
+
+	keys := make([]apiKey, len(cfg.APIKeys))
+	for i, key := range cfg.APIKeys {
+		keys[i] = apiKey{name: strconv.Itoa(i + 1), value: key}
+	}
+	return keys
+}
+
+// keyPool spreads CAPI requests across the keys, backing off from each one
+// CAPI throttles until its Retry-After has passed
+type keyPool struct {
+	mu   sync.Mutex
+	next int
+
+	// throttledUntil is when each throttled key may be used again, and
+	// lastThrottled when it was last throttled, by key name
+	throttledUntil map[string]time.Time
+	lastThrottled  map[string]time.Time
+}
+
+func newKeyPool() *keyPool {
+	return &keyPool{
+		throttledUntil: map[string]time.Time{},
+		lastThrottled:  map[string]time.Time{},
+	}
+}
+
+// pick chooses a key for path by cfg.APIKeyStrategy, skipping throttled
+// ones. With every key throttled the request isn't made; it fails as CAPI
+// would have, with a 429 lasting until the first key is free.
+func (p *keyPool) pick(path string, cfg config.Config) (apiKey, error) {
+	keys := apiKeys(cfg, path)
+	now := time.Now()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	start := p.next % len(keys)
+	p.next++
+
+	chosen := -1
+	for n := 0; n < len(keys); n++ {
+		i := (start + n) % len(keys)
+		if now.Before(p.throttledUntil[keys[i].name]) {
+			continue
+		}
+
+		if chosen == -1 {
+			chosen = i
+			if cfg.APIKeyStrategy != config.KeyStrategyLeastThrottled {
+				break
+			}
+			continue
+		}
+
+		// the key throttled longest ago (or never) is furthest from its limit
+		if p.lastThrottled[keys[i].name].Before(p.lastThrottled[keys[chosen].name]) {
+			chosen = i
+		}
+	}
+
+	if chosen == -1 {
+		return apiKey{}, &Error{
+			Status:     http.StatusTooManyRequests,
+			Message:    "CAPI rate limit exceeded",
+			Code:       CodeUpstreamRateLimited,
+			RetryAfter: p.waitLocked(keys, now),
+		}
+	}
+
+	return keys[chosen], nil
+}
+
+// wait is how long until one of path's keys may be used, zero if one can be
+// now
+func (p *keyPool) wait(path string, cfg config.Config) time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.waitLocked(apiKeys(cfg, path), time.Now())
+}
+
+func (p *keyPool) waitLocked(keys []apiKey, now time.Time) time.Duration {
+	var soonest time.Duration
+	for i, key := range keys {
+		wait := p.throttledUntil[key.name].Sub(now)
+		if wait <= 0 {
+			return 0
+		}
+		if i == 0 || wait < soonest {
+			soonest = wait
+		}
+	}
+
+	return soonest
+}
+
+// observe records CAPI's response to a request made with key: the request,
+// the quota CAPI says is left, and whether the key was throttled
+func (p *keyPool) observe(key apiKey, resp *http.Response) {
+	keyRequestsTotal.WithLabelValues(key.name).Inc()
+
+	for _, window := range quotaWindows {
+		if limit, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Limit-" + window)); err == nil {
+			keyQuotaLimit.WithLabelValues(key.name, window).Set(float64(limit))
+		}
+		if remaining, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Remaining-" + window)); err == nil {
+			keyQuotaRemaining.WithLabelValues(key.name, window).Set(float64(remaining))
+		}
+	}
+
+	if resp.StatusCode != http.StatusTooManyRequests {
+		return
+	}
+
+	now := time.Now()
+	backoff := ParseRetryAfter(resp.Header.Get("Retry-After"), now)
+	keyThrottledTotal.WithLabelValues(key.name).Inc()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.throttledUntil[key.name] = now.Add(backoff)
+	p.lastThrottled[key.name] = now
+	log.Printf("CAPI throttled key %s, backing off for %s", key.name, backoff)
+}
@@ -0,0 +1,196 @@
+package capi
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/guardian/onward/config"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestAPIKeys(t *testing.T) {
+	cfg := testConfig(t, "http://capi.invalid")
+	cfg.APIKey = "default-key"
+	cfg.PathAPIKeys = map[string]string{"football": "football-key"}
+
+	names := func(keys []apiKey) []string {
+		var names []string
+		for _, key := range keys {
+			names = append(names, key.name+"="+key.value)
+		}
+		return names
+	}
+
+	if got, want := names(apiKeys(cfg, "uk")), []string{"default=default-key"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("without a pool got %v, want %v", got, want)
+	}
+
+	cfg.APIKeys = []string{"pooled-1", "pooled-2"}
+	if got, want := names(apiKeys(cfg, "uk/sport")), []string{"1=pooled-1", "2=pooled-2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("with a pool got %v, want %v", got, want)
+	}
+	if got, want := names(apiKeys(cfg, "football/premierleague")), []string{"path-football=football-key"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("for a path with its own key got %v, want %v", got, want)
+	}
+}
+
+// keyedCAPI is a stub CAPI that throttles the keys in throttled, and records
+// the key each request was made with
+type keyedCAPI struct {
+	*stubCAPI
+
+	mu        sync.Mutex
+	keys      []string
+	throttled map[string]bool
+}
+
+func newKeyedCAPI(t *testing.T, throttled ...string) *keyedCAPI {
+	k := &keyedCAPI{throttled: map[string]bool{}}
+	for _, key := range throttled {
+		k.throttled[key] = true
+	}
+
+	k.stubCAPI = newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		key := r.URL.Query().Get("api-key")
+
+		k.mu.Lock()
+		k.keys = append(k.keys, key)
+		throttled := k.throttled[key]
+		k.mu.Unlock()
+
+		w.Header().Set("X-RateLimit-Limit-day", "5000")
+		w.Header().Set("X-RateLimit-Remaining-day", "4321")
+		if throttled {
+			w.Header().Set("Retry-After", "60")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte(mostViewedBody("a")))
+	})
+
+	return k
+}
+
+func (k *keyedCAPI) used() []string {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	used := k.keys
+	k.keys = nil
+	return used
+}
+
+func TestKeyPoolRotates(t *testing.T) {
+	stub := newKeyedCAPI(t)
+	cfg := testConfig(t, stub.URL)
+	cfg.APIKeys = []string{"k1", "k2", "k3"}
+	c := testClient(cfg)
+
+	for i := 0; i < 6; i++ {
+		if _, err := Fetch(context.Background(), c, "uk", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got, want := stub.used(), []string{"k1", "k2", "k3", "k1", "k2", "k3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("used keys %v, want %v", got, want)
+	}
+}
+
+func TestThrottledKeysAreBackedOff(t *testing.T) {
+	stub := newKeyedCAPI(t, "k1")
+	cfg := testConfig(t, stub.URL)
+	cfg.APIKeys = []string{"k1", "k2"}
+	cfg.RateLimitRetries = 1
+	c := testClient(cfg)
+
+	throttledBefore := testutil.ToFloat64(keyThrottledTotal.WithLabelValues("1"))
+
+	// k1 is throttled, so the request is retried at once with k2
+	if _, err := Fetch(context.Background(), c, "uk", nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := stub.used(), []string{"k1", "k2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("used keys %v, want %v", got, want)
+	}
+
+	// and k1 is left alone until its Retry-After has passed
+	for i := 0; i < 3; i++ {
+		if _, err := Fetch(context.Background(), c, "uk", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := stub.used(), []string{"k2", "k2", "k2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("used keys %v, want only k2", got)
+	}
+
+	if throttled := testutil.ToFloat64(keyThrottledTotal.WithLabelValues("1")) - throttledBefore; throttled != 1 {
+		t.Errorf("counted k1 throttled %v times, want 1", throttled)
+	}
+	if remaining := testutil.ToFloat64(keyQuotaRemaining.WithLabelValues("2", "day")); remaining != 4321 {
+		t.Errorf("k2's remaining daily quota is %v, want CAPI's 4321", remaining)
+	}
+	if limit := testutil.ToFloat64(keyQuotaLimit.WithLabelValues("2", "day")); limit != 5000 {
+		t.Errorf("k2's daily quota is %v, want CAPI's 5000", limit)
+	}
+}
+
+func TestEveryKeyThrottled(t *testing.T) {
+	stub := newKeyedCAPI(t, "k1", "k2")
+	cfg := testConfig(t, stub.URL)
+	cfg.APIKeys = []string{"k1", "k2"}
+	cfg.RateLimitRetries = 0
+	c := testClient(cfg)
+
+	for i := 0; i < 2; i++ {
+		Fetch(context.Background(), c, "uk", nil)
+	}
+	stub.used()
+
+	// with both keys backing off, CAPI isn't asked at all
+	_, err := Fetch(context.Background(), c, "uk", nil)
+	capiErr, ok := errors.Cause(err).(*Error)
+	if !ok || capiErr.Status != http.StatusTooManyRequests || capiErr.RetryAfter <= 0 {
+		t.Fatalf("got %v, want a 429 saying when to retry", err)
+	}
+	if used := stub.used(); len(used) != 0 {
+		t.Errorf("CAPI was asked with %v, want nothing", used)
+	}
+}
+
+func TestLeastThrottledKeys(t *testing.T) {
+	stub := newKeyedCAPI(t)
+	cfg := testConfig(t, stub.URL)
+	cfg.APIKeys = []string{"k1", "k2", "k3"}
+	cfg.APIKeyStrategy = config.KeyStrategyLeastThrottled
+	c := testClient(cfg)
+
+	// k1 and k3 were throttled a while back, and have both recovered
+	c.keys.lastThrottled["1"] = time.Now().Add(-time.Minute)
+	c.keys.lastThrottled["3"] = time.Now().Add(-time.Hour)
+
+	for i := 0; i < 3; i++ {
+		if _, err := Fetch(context.Background(), c, "uk", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// k2 has never been throttled, so it's always preferred
+	if got, want := stub.used(), []string{"k2", "k2", "k2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("used keys %v, want %v", got, want)
+	}
+
+	// once k2 has been throttled too, k3's throttling was longest ago
+	c.keys.lastThrottled["2"] = time.Now()
+	if _, err := Fetch(context.Background(), c, "uk", nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := stub.used(), []string{"k3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("used keys %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,101 @@
+package capi
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// errShed is the cause of CAPI calls refused because too many were already
+// waiting
+var errShed = errors.New("CAPI request queue full")
+
+// limiter bounds how many CAPI requests are in flight at once. Up to queue
+// more wait, for at most wait each, for one to finish; past that a request
+// is shed at once with a 503, rather than piling up. A zero max doesn't
+// limit.
+type limiter struct {
+	mu         sync.Mutex
+	slots      chan struct{}
+	queue      int
+	queued     int
+	wait       time.Duration
+	retryAfter time.Duration
+}
+
+func newLimiter(max, queue int, wait, retryAfter time.Duration) *limiter {
+	l := &limiter{queue: queue, wait: wait, retryAfter: retryAfter}
+	if max > 0 {
+		l.slots = make(chan struct{}, max)
+	}
+
+	return l
+}
+
+// acquire waits for a slot for a call, returning the function to give it
+// back with once the call is done, or the error to fail the call with
+func (l *limiter) acquire(ctx context.Context) (func(), error) {
+	if l.slots == nil {
+		return func() {}, nil
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+		return l.taken(), nil
+	default:
+	}
+
+	l.mu.Lock()
+	if l.queued >= l.queue {
+		l.mu.Unlock()
+		return nil, l.refusal()
+	}
+	l.queued++
+	queuedGauge.Inc()
+	l.mu.Unlock()
+
+	defer func() {
+		l.mu.Lock()
+		l.queued--
+		queuedGauge.Dec()
+		l.mu.Unlock()
+	}()
+
+	timer := time.NewTimer(l.wait)
+	defer timer.Stop()
+
+	select {
+	case l.slots <- struct{}{}:
+		return l.taken(), nil
+	case <-timer.C:
+		return nil, l.refusal()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (l *limiter) refusal() error {
+	shedTotal.Inc()
+
+	return &Error{
+		Status:     http.StatusServiceUnavailable,
+		Message:    "Service overloaded",
+		Err:        errShed,
+		Code:       CodeOverloaded,
+		RetryAfter: l.retryAfter,
+	}
+}
+
+// taken counts a slot that's just been taken, returning the function to give
+// it back with
+func (l *limiter) taken() func() {
+	inFlightGauge.Inc()
+
+	return func() {
+		<-l.slots
+		inFlightGauge.Dec()
+	}
+}
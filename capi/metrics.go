@@ -0,0 +1,67 @@
+package capi
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var urlErrorsTotal = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "onward_capi_url_errors_total",
+	Help: "CAPI requests not made because their URL was malformed, i.e. misconfigured.",
+})
+
+var successRate = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "onward_capi_success_rate",
+	Help: "Fraction of recent CAPI calls that succeeded, over the health window.",
+})
+
+var breakerGauge = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "onward_capi_breaker_state",
+	Help: "State of the CAPI circuit breaker: 0 closed, 1 half-open, 2 open.",
+})
+
+var inFlightGauge = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "onward_capi_in_flight",
+	Help: "CAPI requests currently being made, bounded by -capi-max-in-flight.",
+})
+
+var queuedGauge = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "onward_capi_queued",
+	Help: "CAPI requests waiting for one in flight to finish.",
+})
+
+var shedTotal = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "onward_capi_shed_total",
+	Help: "CAPI requests refused with a 503 because the queue was full or the wait too long.",
+})
+
+var keyRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "onward_capi_key_requests_total",
+	Help: "CAPI requests answered, by the name of the key they were made with.",
+}, []string{"key"})
+
+var keyThrottledTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "onward_capi_key_throttled_total",
+	Help: "CAPI requests answered with a 429, by key name.",
+}, []string{"key"})
+
+var keyQuotaLimit = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "onward_capi_key_quota_limit",
+	Help: "Requests each key may make in a rate-limit window, as CAPI last reported it.",
+}, []string{"key", "window"})
+
+var keyQuotaRemaining = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "onward_capi_key_quota_remaining",
+	Help: "Requests each key has left in a rate-limit window, as CAPI last reported it.",
+}, []string{"key", "window"})
+
+var requestDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+	Name:    "onward_capi_request_duration_seconds",
+	Help:    "Time taken by each CAPI attempt.",
+	Buckets: prometheus.DefBuckets,
+})
+
+var errorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "onward_capi_errors_total",
+	Help: "Failed CAPI attempts, by the status they're served as.",
+}, []string{"status"})
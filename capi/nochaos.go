@@ -0,0 +1,15 @@
+//go:build !debug
+// +build !debug
+
+package capi
+
+import (
+	"context"
+
+	"github.com/guardian/onward/config"
+)
+
+// injectFault never injects anything outside debug builds
+func injectFault(ctx context.Context, cfg config.Config) error {
+	return nil
+}
@@ -0,0 +1,21 @@
+//go:build !debug
+
+package capi
+
+import (
+	"context"
+	"testing"
+)
+
+func TestNoFaultsOutsideDebugBuilds(t *testing.T) {
+	cfg := testConfig(t, "http://capi.invalid")
+	cfg.Debug = true
+	cfg.ChaosErrorRate = 1
+	cfg.ChaosLatencyRate = 1
+
+	for i := 0; i < 100; i++ {
+		if err := injectFault(context.Background(), cfg); err != nil {
+			t.Fatalf("injected %v without the debug tag", err)
+		}
+	}
+}
@@ -0,0 +1,760 @@
+// Package config holds the service settings: their flags, where else they
+// can come from, and the checks they have to pass before the service starts.
+package config
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Most-viewed sources, for -most-viewed-source: CAPI's show-most-viewed, or
+// Ophan's real-time most read
+const (
+	SourceCAPI  = "capi"
+	SourceOphan = "ophan"
+)
+
+// Ways of choosing among the -api-keys pool, for -api-key-strategy
+const (
+	KeyStrategyRoundRobin     = "round-robin"
+	KeyStrategyLeastThrottled = "least-throttled"
+)
+
+// CAPI authentication schemes, for -capi-auth. The live API wants nothing
+// but its key; preview (CODE) sits behind basic auth in some stacks and IAM
+// in others.
+const (
+	CAPIAuthNone  = ""
+	CAPIAuthBasic = "basic"
+	CAPIAuthAWS   = "aws-sigv4"
+)
+
+// Editions are the paths kept warm in the cache
+var Editions = []string{"uk", "us", "au", "international"}
+
+// IsEdition reports whether path is one of Editions
+func IsEdition(path string) bool {
+	for _, edition := range Editions {
+		if path == edition {
+			return true
+		}
+	}
+
+	return false
+}
+
+// defaultEditionNames are how editions are named in headings unless
+// -edition-names says otherwise
+var defaultEditionNames = map[string]string{
+	"uk": "the UK",
+	"us": "the US",
+	"au": "Australia",
+
+	"international": "the international edition",
+}
+
+// defaultCountryEditions are the editions countries get from
+// /most-viewed/auto unless -country-editions says otherwise
+var defaultCountryEditions = map[string]string{
+	"GB": "uk",
+	"US": "us",
+	"AU": "au",
+}
+
+// Config holds the service settings
+type Config struct {
+	// Addr is the address the service listens on
+	Addr string
+
+	// GRPCAddr, if set, is the address the gRPC API listens on
+	GRPCAddr string
+
+	// ReadHeaderTimeout, ReadTimeout, WriteTimeout and IdleTimeout are the
+	// server's http.Server timeouts. Zero leaves one unbounded.
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+
+	// CAPIURL is the base URL of the Content API, e.g. the preview (CODE)
+	// API's instead of the live one
+	CAPIURL string
+
+	// CAPIAuth is how CAPI requests authenticate beyond the API key: empty
+	// for not at all, CAPIAuthBasic with CAPIUser and CAPIPassword, or
+	// CAPIAuthAWS, signed for CAPIAWSService in CAPIAWSRegion
+	CAPIAuth       string
+	CAPIUser       string
+	CAPIPassword   string
+	CAPIAWSRegion  string
+	CAPIAWSService string
+
+	// APIKey is the CAPI key, used for every path that doesn't have its own
+	// in PathAPIKeys (keyed by the path's first segment, e.g. "uk")
+	APIKey      string
+	PathAPIKeys map[string]string
+
+	// APIKeys is a pool of CAPI keys, each with its own rate limit, used in
+	// place of APIKey. Requests are spread across them by APIKeyStrategy,
+	// KeyStrategyRoundRobin or KeyStrategyLeastThrottled.
+	APIKeys        []string
+	APIKeyStrategy string
+
+	// CacheTTL is how long responses are cached for. Within it, entries older
+	// than CacheSoftTTL are still served but refreshed in the background
+	// (stale-while-revalidate), and so are expired ones for CacheStaleTTL
+	// after, which bounds how stale a response is ever served. Zero
+	// CacheSoftTTL and CacheStaleTTL disable that.
+	CacheTTL      time.Duration
+	CacheSoftTTL  time.Duration
+	CacheStaleTTL time.Duration
+
+	// CacheCleanupInterval is how often the memory cache drops expired
+	// entries
+	CacheCleanupInterval time.Duration
+
+	// CoalesceWindow is how long after a CAPI fetch finishes that requests
+	// for the same data still share its result instead of fetching again.
+	// Requests arriving while it's in flight always share it.
+	CoalesceWindow time.Duration
+
+	// MaxEntryBytes is the largest a cache entry may serialise to; bigger
+	// responses are served but not cached. Zero is no limit.
+	MaxEntryBytes int
+
+	// RefreshAhead refreshes fresh entries in the background once they're
+	// this close to expiring, so the next request doesn't find them gone.
+	// Zero disables it.
+	RefreshAhead time.Duration
+
+	// CDNMaxAge, CDNStaleWhileRevalidate and CDNStaleIfError are the
+	// Cache-Control max-age, stale-while-revalidate and stale-if-error sent
+	// with most-viewed responses. They're for the edge, independent of
+	// CacheTTL. Zero CDNMaxAge sends no Cache-Control.
+	CDNMaxAge               time.Duration
+	CDNStaleWhileRevalidate time.Duration
+	CDNStaleIfError         time.Duration
+
+	// CacheMaxAge is a hard limit on the age of cached data; entries older
+	// than this are never served, however they are cached. Zero disables it.
+	CacheMaxAge time.Duration
+
+	// ShutdownTimeout bounds each stage of shutdown: draining requests in
+	// flight, then stopping background work
+	ShutdownTimeout time.Duration
+
+	// WarmInterval is how often the editions are refreshed in the background.
+	// Zero disables the warmer.
+	WarmInterval time.Duration
+
+	// WarmMaxInFlight skips a warm cycle when at least this many requests are
+	// being served. Zero never skips.
+	WarmMaxInFlight int64
+
+	// WarmTimeout bounds each background fetch
+	WarmTimeout time.Duration
+
+	// FanOutWorkers is how many editions a multi-edition request fetches in
+	// parallel
+	FanOutWorkers int
+
+	// CAPIProxy is an HTTP(S) proxy CAPI requests go through, for hosts not
+	// in NoProxy. Empty falls back to the standard proxy environment.
+	CAPIProxy string
+	NoProxy   []string
+
+	// CAPIHeaders are sent with every CAPI request, overriding the defaults
+	CAPIHeaders http.Header
+
+	// CAPIMaxInFlight bounds the CAPI requests made at once, with up to
+	// CAPIQueue more waiting at most CAPIQueueTimeout for one to finish.
+	// Beyond that requests are shed with a 503 and a Retry-After of
+	// ShedRetryAfter. Zero CAPIMaxInFlight doesn't limit.
+	CAPIMaxInFlight  int
+	CAPIQueue        int
+	CAPIQueueTimeout time.Duration
+	ShedRetryAfter   time.Duration
+
+	// DecayHalfLife is the age at which an item's popularity counts half when
+	// ranking with ?rank=decay
+	DecayHalfLife time.Duration
+
+	// CacheBackend is where responses are cached: "memory" (per instance) or
+	// "redis" (shared, at RedisURL)
+	CacheBackend string
+	RedisURL     string
+
+	// RateLimit is how many requests a minute each client may make to the
+	// public routes, zero for no limit, with bursts of up to RateLimitBurst.
+	// Clients sending a RateLimitHeader value on RateLimitClients are limited
+	// by it, at its own limit, and everyone else by IP. Buckets are
+	// kept by RateLimitBackend: "memory" (per instance) or "redis" (shared,
+	// at RedisURL).
+	RateLimit        int
+	RateLimitBurst   int
+	RateLimitHeader  string
+	RateLimitClients map[string]int
+	RateLimitBackend string
+
+	// LastGoodStore is where each edition's last successfully fetched list
+	// is kept, to serve when CAPI fails after the cache has expired: "disk"
+	// (in LastGoodDir), "s3" (in LastGoodBucket, under LastGoodPrefix) or
+	// empty for nowhere
+	LastGoodStore  string
+	LastGoodDir    string
+	LastGoodBucket string
+	LastGoodPrefix string
+
+	// RequestBudget bounds the total time a request may spend on CAPI, across
+	// every attempt it makes
+	RequestBudget time.Duration
+
+	// ReadinessTimeout bounds the CAPI check made by /readyz. It's kept short
+	// so probes fail fast.
+	ReadinessTimeout time.Duration
+
+	// ReadyMaxCAPIAge lets readiness count a CAPI call that succeeded within
+	// it, rather than making one of its own. Zero always makes one.
+	ReadyMaxCAPIAge time.Duration
+
+	// HealthWindow is how many recent CAPI calls the success rate covers.
+	// /readyz fails while fewer than HealthThreshold of them succeeded.
+	HealthWindow    int
+	HealthThreshold float64
+
+	// SnapshotRetention is how many recently cached lists are kept per
+	// edition for the debug diff and snapshot endpoints
+	SnapshotRetention int
+
+	// StartupCheck makes one request to CAPI at startup to check it's
+	// reachable and accepts the key. RequireUpstream makes a failure fatal;
+	// otherwise it's only logged.
+	StartupCheck    bool
+	RequireUpstream bool
+
+	// UpstreamTimeout bounds each CAPI attempt, within the request budget.
+	// EditionTimeouts overrides it for particular editions or sections,
+	// matched on the path's first segment. Zero leaves only the budget.
+	UpstreamTimeout time.Duration
+	EditionTimeouts map[string]time.Duration
+
+	// ProxyPaths are the top-level CAPI paths (e.g. "search") that /capi/
+	// will pass through. Empty disables the proxy.
+	ProxyPaths []string
+
+	// ConditionalPassthrough sends conditional /capi/ requests straight to
+	// CAPI, bypassing the cache, so clients can revalidate against CAPI's own
+	// ETag and Last-Modified
+	ConditionalPassthrough bool
+
+	// SlowLogThreshold logs requests taking at least this long. Zero disables
+	// slow request logging.
+	SlowLogThreshold time.Duration
+
+	// AccessLog logs every request to stdout as a line of JSON
+	AccessLog bool
+
+	// Compress compresses response bodies of at least CompressMinBytes with
+	// gzip or brotli, as clients accept, keeping up to CompressCacheBytes of
+	// compressed bodies to serve again
+	Compress           bool
+	CompressMinBytes   int
+	CompressCacheBytes int
+
+	// CORSOrigins are the origins browsers may call the API from; empty
+	// disables CORS. Preflight responses are cached for CORSMaxAge.
+	CORSOrigins []string
+	CORSMaxAge  time.Duration
+
+	// TrustedProxies are the networks whose X-Forwarded-For is believed when
+	// working out a request's client IP
+	TrustedProxies []*net.IPNet
+
+	// MinItems is the shortest most-viewed list served; shorter ones are
+	// backfilled with the path's latest content. Zero disables backfilling.
+	MinItems int
+
+	// LatestFallback are the paths that serve their latest content, flagged
+	// as backfilled, when CAPI has no most-viewed data for them
+	LatestFallback []string
+
+	// EmptyNoContent answers with a 204 when CAPI has no items for a list,
+	// rather than a 200 with an empty list
+	EmptyNoContent bool
+
+	// RateLimitRetries is how many times a rate-limited CAPI request is
+	// retried, after waiting for its Retry-After
+	RateLimitRetries int
+
+	// CAPIRetries is how many times a CAPI request that failed transiently
+	// (a 5xx, network error or timeout) is retried. The wait before each
+	// retry is random, up to RetryBackoff doubled per retry and capped at
+	// RetryMaxBackoff.
+	CAPIRetries     int
+	RetryBackoff    time.Duration
+	RetryMaxBackoff time.Duration
+
+	// BreakerThreshold is how many transient CAPI failures in a row open the
+	// circuit breaker, which then refuses CAPI calls for BreakerCooldown
+	// before probing again. Zero disables the breaker.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+
+	// MaxRedirects is how many redirects a CAPI request may follow
+	MaxRedirects int
+
+	// MaxLimit caps ?limit=; larger requests get this many trails
+	MaxLimit int
+
+	// DefaultLimit is the number of trails served when a request has no
+	// ?limit=, unless EditionLimits has one for the edition. Zero is no limit.
+	DefaultLimit  int
+	EditionLimits map[string]int
+
+	// EditionNames are the display names of editions in headings, which are
+	// HeadingTemplate with {edition} replaced. Paths with no name get
+	// DefaultHeading.
+	EditionNames    map[string]string
+	HeadingTemplate string
+	DefaultHeading  string
+
+	// RelatedHeading is the heading for related-content lists
+	RelatedHeading string
+
+	// DiscussionURL is the Discussion API, which most-commented lists come
+	// from. MostCommentedSize discussions are listed, headed
+	// MostCommentedHeading.
+	DiscussionURL        string
+	MostCommentedSize    int
+	MostCommentedHeading string
+
+	// GeoHeaders are edge headers trusted to give the client's country, in
+	// order, for /most-viewed/auto. Without one the country is looked up in
+	// the GeoIPDB MaxMind database. CountryEditions maps countries to
+	// editions; anywhere else gets AutoEdition.
+	GeoHeaders      []string
+	GeoIPDB         string
+	CountryEditions map[string]string
+	AutoEdition     string
+
+	// MostViewedSource is where most-viewed lists come from when a request
+	// doesn't say: CAPI, or Ophan at OphanURL. Ophan lists have OphanCount
+	// trails and are cached for OphanCacheTTL.
+	MostViewedSource string
+	OphanURL         string
+	OphanAPIKey      string
+	OphanCount       int
+	OphanCacheTTL    time.Duration
+
+	// FeatureRefresh is how often feature flags are re-read from their
+	// provider
+	FeatureRefresh time.Duration
+
+	// Overrides is a file, or an s3://bucket/key object, of the editors'
+	// pin and block rules, re-read every OverridesRefresh. Empty leaves the
+	// rules to the admin API.
+	Overrides        string
+	OverridesRefresh time.Duration
+
+	// EnrichURL is a secondary service that trails are augmented from, with
+	// {url} replaced by the trail's URL. EnrichWorkers requests run at once,
+	// each within EnrichTimeout and answering at most EnrichMaxBytes, and all
+	// of a response's within EnrichBudget. Empty disables enrichment.
+	EnrichURL      string
+	EnrichWorkers  int
+	EnrichTimeout  time.Duration
+	EnrichMaxBytes int64
+	EnrichBudget   time.Duration
+
+	// JSONStyle is the field naming of JSON responses, "camel" or "snake"
+	JSONStyle string
+
+	// LiveblogStyle is how v1 JSON sends isLiveBlog: "string", the
+	// "true"/"false" existing clients parse, or "bool" for a JSON boolean
+	LiveblogStyle string
+
+	// JSONWrapper nests JSON lists under this top-level key. Empty leaves
+	// them unwrapped.
+	JSONWrapper string
+
+	// TrailingNewline ends every JSON response with a newline. Requests can
+	// also ask for one with ?newline=true.
+	TrailingNewline bool
+
+	// AdminUser and AdminPassword are the basic auth credentials for /admin/
+	// and /debug/ routes, and AdminToken a bearer token accepted instead.
+	// AdminAllow, if set, limits those routes to clients on its networks.
+	// Admin routes are only served when at least one of them is set.
+	AdminUser     string
+	AdminPassword string
+	AdminToken    string
+	AdminAllow    []*net.IPNet
+
+	// Debug enables debugging endpoints such as /preview/, in builds with the
+	// debug tag
+	Debug bool
+
+	// ChaosErrorRate and ChaosLatencyRate are the fractions of CAPI requests
+	// failed or delayed by ChaosLatency on purpose. They can only be set in
+	// debug builds, and only apply with Debug.
+	ChaosErrorRate   float64
+	ChaosLatencyRate float64
+	ChaosLatency     time.Duration
+
+	// H2C serves HTTP/2 without TLS, for use behind a terminating proxy
+	H2C bool
+
+	// StreamKeepAlive is how often an idle /most-viewed/{edition}/stream is
+	// sent a comment to keep it open
+	StreamKeepAlive time.Duration
+
+	// ImageResizerURL is the image-resizing service v2 image crops are
+	// served through, at each of ImageWidths and ImageQuality, signed with
+	// ImageSalt. Empty serves CAPI's own crops.
+	ImageResizerURL string
+	ImageSalt       string
+	ImageWidths     []int
+	ImageQuality    int
+}
+
+// Register defines a flag for each setting on fs, defaulting cfg
+func Register(fs *flag.FlagSet, cfg *Config) {
+	cfg.CAPIHeaders = http.Header{}
+	cfg.EditionLimits = map[string]int{}
+	cfg.RateLimitClients = map[string]int{}
+	cfg.EditionNames = map[string]string{}
+	cfg.PathAPIKeys = map[string]string{}
+	cfg.EditionTimeouts = map[string]time.Duration{}
+	for edition, name := range defaultEditionNames {
+		cfg.EditionNames[edition] = name
+	}
+	cfg.CountryEditions = map[string]string{}
+	cfg.ImageWidths = []int{140, 300, 460, 620, 1020}
+	for country, edition := range defaultCountryEditions {
+		cfg.CountryEditions[country] = edition
+	}
+
+	fs.StringVar(&cfg.Addr, "addr", ":8080", "address to listen on")
+	fs.StringVar(&cfg.GRPCAddr, "grpc-addr", "", "address to serve the gRPC API on, e.g. :9090 (empty for none)")
+	fs.DurationVar(&cfg.ReadHeaderTimeout, "read-header-timeout", 5*time.Second, "longest to wait for a request's headers (0 for no limit)")
+	fs.DurationVar(&cfg.ReadTimeout, "read-timeout", 10*time.Second, "longest to wait for a whole request (0 for no limit)")
+	fs.DurationVar(&cfg.WriteTimeout, "write-timeout", 30*time.Second, "longest to spend writing a response, from the end of its request's headers (0 for no limit)")
+	fs.DurationVar(&cfg.IdleTimeout, "idle-timeout", 2*time.Minute, "how long an idle keep-alive connection is kept (0 for no limit)")
+	fs.StringVar(&cfg.CAPIURL, "capi-url", "https://content.guardianapis.com", "base URL of the Content API")
+	fs.StringVar(&cfg.APIKey, "api-key", "test", "CAPI key")
+	fs.Var((*listFlag)(&cfg.APIKeys), "api-keys", "comma-separated pool of CAPI keys used instead of -api-key")
+	fs.StringVar(&cfg.APIKeyStrategy, "api-key-strategy", KeyStrategyRoundRobin, "how -api-keys are chosen, round-robin or least-throttled")
+	fs.StringVar(&cfg.CAPIAuth, "capi-auth", CAPIAuthNone, "extra CAPI authentication, basic or aws-sigv4, e.g. for the preview API (empty for none)")
+	fs.StringVar(&cfg.CAPIUser, "capi-user", "", "CAPI basic auth user, with -capi-auth basic")
+	fs.StringVar(&cfg.CAPIPassword, "capi-password", "", "CAPI basic auth password, with -capi-auth basic")
+	fs.StringVar(&cfg.CAPIAWSRegion, "capi-aws-region", "eu-west-1", "AWS region CAPI requests are signed for, with -capi-auth aws-sigv4")
+	fs.StringVar(&cfg.CAPIAWSService, "capi-aws-service", "execute-api", "AWS service CAPI requests are signed for, with -capi-auth aws-sigv4")
+	fs.DurationVar(&cfg.CacheCleanupInterval, "cache-cleanup-interval", 10*time.Minute, "how often the memory cache drops expired entries")
+	fs.Var(stringMapFlag(cfg.PathAPIKeys), "path-api-keys", "CAPI keys for particular editions or sections, e.g. uk=KEY,football=KEY")
+	fs.DurationVar(&cfg.CacheTTL, "cache-ttl", 5*time.Minute, "how long responses are cached (the hard TTL)")
+	fs.DurationVar(&cfg.CacheSoftTTL, "cache-soft-ttl", 0, "age after which cached responses are refreshed in the background while still served (0 disables)")
+	fs.DurationVar(&cfg.CacheStaleTTL, "cache-stale-ttl", 0, "how long after -cache-ttl expired responses are still served while refreshed in the background (0 disables)")
+	fs.DurationVar(&cfg.CoalesceWindow, "coalesce-window", 0, "how long a finished CAPI fetch is shared with requests for the same data (0 for only while in flight)")
+	fs.IntVar(&cfg.MaxEntryBytes, "max-cache-entry-bytes", 0, "largest response cached, serialised (0 for no limit)")
+	fs.DurationVar(&cfg.RefreshAhead, "refresh-ahead", 0, "refresh cached responses in the background once they're this close to expiry (0 disables)")
+	fs.DurationVar(&cfg.CDNMaxAge, "cdn-max-age", time.Minute, "Cache-Control max-age of most-viewed responses (0 sends no Cache-Control)")
+	fs.DurationVar(&cfg.CDNStaleWhileRevalidate, "cdn-stale-while-revalidate", 0, "Cache-Control stale-while-revalidate of most-viewed responses (0 leaves it out)")
+	fs.DurationVar(&cfg.CDNStaleIfError, "cdn-stale-if-error", 0, "Cache-Control stale-if-error of most-viewed responses (0 leaves it out)")
+	fs.DurationVar(&cfg.CacheMaxAge, "cache-max-age", 0, "maximum age of cached data ever served (0 for no limit)")
+	fs.DurationVar(&cfg.ShutdownTimeout, "shutdown-timeout", 10*time.Second, "longest to wait, at shutdown, for requests and then background work to finish")
+	fs.DurationVar(&cfg.WarmInterval, "warm-interval", 0, "how often to refresh editions in the background (0 disables)")
+	fs.Int64Var(&cfg.WarmMaxInFlight, "warm-max-in-flight", 0, "skip background refreshes while this many requests are in flight (0 never skips)")
+	fs.DurationVar(&cfg.WarmTimeout, "warm-timeout", 10*time.Second, "timeout for each background refresh fetch")
+	fs.IntVar(&cfg.FanOutWorkers, "fan-out-workers", 4, "maximum editions fetched in parallel by a multi-edition request")
+	fs.StringVar(&cfg.CAPIProxy, "capi-proxy", "", "HTTP(S) proxy URL for CAPI requests (empty for the proxy environment)")
+	fs.Var((*listFlag)(&cfg.NoProxy), "no-proxy", "comma-separated hosts or domains reached without -capi-proxy, e.g. .internal,localhost")
+	fs.Var(headerFlag(cfg.CAPIHeaders), "capi-header", "extra \"Name: value\" header sent to CAPI (repeatable)")
+	fs.IntVar(&cfg.CAPIMaxInFlight, "capi-max-in-flight", 64, "most CAPI requests made at once (0 for no limit)")
+	fs.IntVar(&cfg.CAPIQueue, "capi-queue", 128, "most CAPI requests waiting for one of -capi-max-in-flight to finish, beyond which they're shed")
+	fs.DurationVar(&cfg.CAPIQueueTimeout, "capi-queue-timeout", time.Second, "longest a CAPI request waits in the queue before it's shed")
+	fs.DurationVar(&cfg.ShedRetryAfter, "shed-retry-after", time.Second, "Retry-After of responses shed because CAPI requests are queued")
+	fs.DurationVar(&cfg.DecayHalfLife, "decay-half-life", 6*time.Hour, "half-life of popularity when ranking by ?rank=decay")
+	fs.StringVar(&cfg.CacheBackend, "cache-backend", "memory", "cache backend, memory or redis")
+	fs.StringVar(&cfg.RedisURL, "redis-url", "redis://localhost:6379/0", "Redis URL for the redis cache backend")
+	fs.IntVar(&cfg.RateLimit, "rate-limit", 0, "requests a minute each client may make to the public routes (0 for no limit)")
+	fs.IntVar(&cfg.RateLimitBurst, "rate-limit-burst", 20, "requests a client may make at once before -rate-limit applies")
+	fs.StringVar(&cfg.RateLimitHeader, "rate-limit-header", "X-Client-Key", "header naming a -rate-limit-clients client to rate limit, instead of its IP")
+	fs.Var(intMapFlag(cfg.RateLimitClients), "rate-limit-clients", "requests a minute for particular clients, by -rate-limit-header value, e.g. apps=6000")
+	fs.StringVar(&cfg.RateLimitBackend, "rate-limit-backend", "memory", "where rate limit buckets are kept, memory or redis")
+	fs.StringVar(&cfg.LastGoodStore, "last-good-store", "", "where to keep last-known-good edition lists, disk or s3 (empty for nowhere)")
+	fs.StringVar(&cfg.LastGoodDir, "last-good-dir", "last-good", "directory of last-known-good lists for the disk store")
+	fs.StringVar(&cfg.LastGoodBucket, "last-good-s3-bucket", "", "S3 bucket of last-known-good lists for the s3 store")
+	fs.StringVar(&cfg.LastGoodPrefix, "last-good-s3-prefix", "onward/last-good/", "S3 key prefix of last-known-good lists")
+	fs.DurationVar(&cfg.RequestBudget, "request-budget", 10*time.Second, "total time a request may spend waiting on CAPI")
+	fs.DurationVar(&cfg.ReadinessTimeout, "readiness-timeout", time.Second, "timeout for the CAPI check made by /readyz")
+	fs.DurationVar(&cfg.ReadyMaxCAPIAge, "ready-max-capi-age", 0, "readiness accepts a CAPI success this recent instead of calling CAPI (0 always calls)")
+	fs.IntVar(&cfg.HealthWindow, "health-window", 100, "number of recent CAPI calls the upstream success rate covers")
+	fs.IntVar(&cfg.SnapshotRetention, "snapshot-retention", 2, "cached lists kept per edition for /diff/ and /snapshots/ in debug builds")
+	fs.Float64Var(&cfg.HealthThreshold, "health-threshold", 0.5, "success rate below which /readyz reports unready")
+	fs.BoolVar(&cfg.StartupCheck, "startup-check", true, "check CAPI connectivity and the API key at startup")
+	fs.BoolVar(&cfg.RequireUpstream, "require-upstream", false, "exit if the startup check fails")
+	fs.DurationVar(&cfg.UpstreamTimeout, "upstream-timeout", 0, "timeout for each CAPI attempt (0 for only the request budget)")
+	fs.Var(durationMapFlag(cfg.EditionTimeouts), "edition-timeouts", "per-edition or section CAPI attempt timeouts, e.g. uk=2s,football=5s")
+	fs.Var((*listFlag)(&cfg.ProxyPaths), "proxy-paths", "comma-separated CAPI paths /capi/ may proxy, e.g. search,tags")
+	fs.BoolVar(&cfg.ConditionalPassthrough, "conditional-passthrough", false, "pass conditional /capi/ requests to CAPI uncached, relaying its 304s")
+	fs.DurationVar(&cfg.SlowLogThreshold, "slow-log-threshold", 0, "log requests slower than this, with a timing breakdown (0 disables)")
+	fs.BoolVar(&cfg.AccessLog, "access-log", false, "log every request to stdout as JSON")
+	fs.BoolVar(&cfg.Compress, "compress", true, "compress responses with gzip or brotli when clients accept it")
+	fs.IntVar(&cfg.CompressMinBytes, "compress-min-bytes", 1024, "smallest response body compressed")
+	fs.IntVar(&cfg.CompressCacheBytes, "compress-cache-bytes", 32<<20, "most bytes of compressed response bodies kept to serve again")
+	fs.Var((*listFlag)(&cfg.CORSOrigins), "cors-origins", "comma-separated origins allowed to call the API, e.g. https://*.theguardian.com (* for any; empty disables CORS)")
+	fs.DurationVar(&cfg.CORSMaxAge, "cors-max-age", 10*time.Minute, "how long browsers may cache CORS preflight responses")
+	fs.Var((*cidrListFlag)(&cfg.TrustedProxies), "trusted-proxies", "comma-separated CIDRs whose X-Forwarded-For is trusted, e.g. 10.0.0.0/8")
+	fs.IntVar(&cfg.MinItems, "min-items", 0, "backfill most-viewed lists shorter than this with latest content (0 disables)")
+	fs.Var((*listFlag)(&cfg.LatestFallback), "latest-fallback", "comma-separated editions that serve latest content when most-viewed is empty, e.g. uk,au")
+	fs.BoolVar(&cfg.EmptyNoContent, "empty-204", false, "answer 204 No Content when CAPI has no items, instead of an empty list")
+	fs.IntVar(&cfg.RateLimitRetries, "rate-limit-retries", 1, "retries of a rate-limited CAPI request, honouring Retry-After")
+	fs.IntVar(&cfg.CAPIRetries, "capi-retries", 2, "retries of a CAPI request after a 5xx, network error or timeout")
+	fs.DurationVar(&cfg.RetryBackoff, "retry-backoff", 100*time.Millisecond, "longest wait before the first retry, doubling for each one after")
+	fs.DurationVar(&cfg.RetryMaxBackoff, "retry-max-backoff", time.Second, "longest wait before any retry")
+	fs.IntVar(&cfg.BreakerThreshold, "breaker-threshold", 10, "transient CAPI failures in a row that open the circuit breaker (0 disables)")
+	fs.DurationVar(&cfg.BreakerCooldown, "breaker-cooldown", 30*time.Second, "how long an open circuit breaker refuses CAPI calls before probing")
+	fs.IntVar(&cfg.MaxRedirects, "max-redirects", 3, "maximum redirects followed by a CAPI request")
+	fs.IntVar(&cfg.MaxLimit, "max-limit", 100, "largest ?limit= honoured; bigger requests are clamped")
+	fs.IntVar(&cfg.DefaultLimit, "default-limit", 0, "trails served when a request has no ?limit= (0 for all)")
+	fs.Var(intMapFlag(cfg.EditionLimits), "edition-limits", "per-edition default limits, e.g. uk=10,au=5")
+	fs.Var(stringMapFlag(cfg.EditionNames), "edition-names", "edition display names for headings, e.g. \"uk=the UK,au=Australia\"")
+	fs.StringVar(&cfg.HeadingTemplate, "heading-template", "Most viewed in {edition}", "heading for edition lists; {edition} is the edition's display name")
+	fs.StringVar(&cfg.DefaultHeading, "default-heading", "Most viewed", "heading for lists without an edition display name")
+	fs.StringVar(&cfg.RelatedHeading, "related-heading", "Related content", "heading for related-content lists")
+	fs.StringVar(&cfg.DiscussionURL, "discussion-url", "https://discussion.theguardian.com/discussion-api", "base URL of the Discussion API")
+	fs.IntVar(&cfg.MostCommentedSize, "most-commented-size", 10, "discussions in most-commented lists")
+	fs.StringVar(&cfg.MostCommentedHeading, "most-commented-heading", "Most commented", "heading for most-commented lists")
+	fs.Var((*listFlag)(&cfg.GeoHeaders), "geo-headers", "comma-separated edge headers trusted for the client's country, e.g. X-GU-GeoLocation,CloudFront-Viewer-Country")
+	fs.StringVar(&cfg.GeoIPDB, "geoip-db", "", "MaxMind country database for clients without a geo header (empty disables)")
+	fs.Var(stringMapFlag(cfg.CountryEditions), "country-editions", "editions for /most-viewed/auto by country code, e.g. GB=uk,NZ=au")
+	fs.StringVar(&cfg.AutoEdition, "auto-default-edition", "uk", "edition for /most-viewed/auto when the client's country has none")
+	fs.StringVar(&cfg.MostViewedSource, "most-viewed-source", SourceCAPI, "default most-viewed source, capi or ophan; requests can pick with ?source=")
+	fs.StringVar(&cfg.OphanURL, "ophan-url", "https://api.ophan.co.uk/api", "base URL of the Ophan API")
+	fs.StringVar(&cfg.OphanAPIKey, "ophan-api-key", "", "Ophan API key (empty disables the ophan source)")
+	fs.IntVar(&cfg.OphanCount, "ophan-count", 10, "trails in Ophan most-read lists")
+	fs.DurationVar(&cfg.OphanCacheTTL, "ophan-cache-ttl", time.Minute, "how long Ophan most-read lists are cached")
+	fs.DurationVar(&cfg.FeatureRefresh, "feature-refresh", 30*time.Second, "how often feature flags are re-read")
+	fs.StringVar(&cfg.Overrides, "overrides", "", "YAML or JSON file, or s3://bucket/key, of pin and block overrides (empty for none)")
+	fs.DurationVar(&cfg.OverridesRefresh, "overrides-refresh", 30*time.Second, "how often -overrides is re-read, and pinned content looked up again")
+	fs.StringVar(&cfg.EnrichURL, "enrich-url", "", "service to enrich trails from, e.g. http://meta/items?url={url} (empty disables)")
+	fs.IntVar(&cfg.EnrichWorkers, "enrich-workers", 4, "maximum enrichment requests in flight per response")
+	fs.DurationVar(&cfg.EnrichTimeout, "enrich-timeout", 500*time.Millisecond, "timeout for each enrichment request")
+	fs.Int64Var(&cfg.EnrichMaxBytes, "enrich-max-bytes", 64<<10, "largest enrichment response read for a trail")
+	fs.DurationVar(&cfg.EnrichBudget, "enrich-budget", time.Second, "total time spent enriching a response")
+	fs.StringVar(&cfg.JSONStyle, "json-style", "camel", "JSON field naming, camel or snake")
+	fs.StringVar(&cfg.LiveblogStyle, "liveblog-style", "string", "v1 JSON isLiveBlog type, string or bool")
+	fs.StringVar(&cfg.JSONWrapper, "json-wrapper", "", "top-level key to nest JSON lists under, e.g. mostViewed (empty for none)")
+	fs.BoolVar(&cfg.TrailingNewline, "json-trailing-newline", false, "end JSON responses with a newline")
+	fs.StringVar(&cfg.AdminUser, "admin-user", "admin", "basic auth user for /admin/ and /debug/ routes")
+	fs.StringVar(&cfg.AdminPassword, "admin-password", "", "basic auth password for /admin/ and /debug/ routes")
+	fs.StringVar(&cfg.AdminToken, "admin-token", "", "bearer token accepted for /admin/ and /debug/ routes instead of basic auth")
+	fs.Var((*cidrListFlag)(&cfg.AdminAllow), "admin-allow", "comma-separated CIDRs allowed /admin/ and /debug/ routes, e.g. 10.0.0.0/8 (empty for any)")
+	fs.BoolVar(&cfg.Debug, "debug", false, "enable debugging endpoints")
+	fs.BoolVar(&cfg.H2C, "h2c", false, "serve HTTP/2 over cleartext (h2c) as well as HTTP/1")
+	fs.DurationVar(&cfg.StreamKeepAlive, "stream-keepalive", 15*time.Second, "how often idle most-viewed streams are sent a keep-alive comment")
+	fs.StringVar(&cfg.ImageResizerURL, "image-resizer-url", "", "image-resizing service for v2 image crops, e.g. https://i.guim.co.uk (empty for CAPI's crops)")
+	fs.StringVar(&cfg.ImageSalt, "image-salt", "", "salt signing image-resizer URLs")
+	fs.Var((*intListFlag)(&cfg.ImageWidths), "image-widths", "comma-separated widths of resized image crops")
+	fs.IntVar(&cfg.ImageQuality, "image-quality", 85, "quality of resized image crops, 1-100")
+	registerDebug(fs, cfg)
+}
+
+// AdminEnabled reports whether admin routes are served: only once they're
+// protected by credentials or an allowlist
+func (cfg Config) AdminEnabled() bool {
+	return cfg.AdminPassword != "" || cfg.AdminToken != "" || len(cfg.AdminAllow) > 0
+}
+
+// Validate checks the settings are usable, reporting every problem found
+func (cfg Config) Validate() error {
+	var problems []string
+	check := func(ok bool, problem string) {
+		if !ok {
+			problems = append(problems, problem)
+		}
+	}
+
+	check(cfg.APIKey != "", "a CAPI key is required (-api-key)")
+	check(!debugBuild || !cfg.Debug || cfg.AdminPassword != "" || cfg.AdminToken != "", "-debug needs -admin-password or -admin-token, so debugging endpoints aren't open to anyone")
+	for path, key := range cfg.PathAPIKeys {
+		check(key != "", fmt.Sprintf("-path-api-keys for %s is empty", path))
+	}
+	for _, key := range cfg.APIKeys {
+		check(key != "", "-api-keys must not contain empty keys")
+	}
+	check(cfg.APIKeyStrategy == KeyStrategyRoundRobin || cfg.APIKeyStrategy == KeyStrategyLeastThrottled,
+		fmt.Sprintf("-api-key-strategy %q is unknown, round-robin or least-throttled", cfg.APIKeyStrategy))
+
+	switch cfg.CacheBackend {
+	case "memory":
+	case "redis":
+		_, err := redis.ParseURL(cfg.RedisURL)
+		check(err == nil, fmt.Sprintf("-redis-url is invalid: %v", err))
+	default:
+		check(false, fmt.Sprintf("-cache-backend %q is unknown", cfg.CacheBackend))
+	}
+
+	check(cfg.RateLimit >= 0, "-rate-limit must not be negative")
+	check(cfg.RateLimitBurst > 0, "-rate-limit-burst must be positive")
+	for client, perMinute := range cfg.RateLimitClients {
+		check(perMinute > 0, fmt.Sprintf("-rate-limit-clients for %s must be positive", client))
+	}
+	switch cfg.RateLimitBackend {
+	case "memory":
+	case "redis":
+		_, err := redis.ParseURL(cfg.RedisURL)
+		check(err == nil, fmt.Sprintf("-redis-url is invalid: %v", err))
+	default:
+		check(false, fmt.Sprintf("-rate-limit-backend %q is unknown", cfg.RateLimitBackend))
+	}
+
+	switch cfg.LastGoodStore {
+	case "":
+	case "disk":
+		check(cfg.LastGoodDir != "", "-last-good-dir must be set for the disk store")
+	case "s3":
+		check(cfg.LastGoodBucket != "", "-last-good-s3-bucket must be set for the s3 store")
+	default:
+		check(false, fmt.Sprintf("-last-good-store %q is unknown", cfg.LastGoodStore))
+	}
+
+	check(cfg.JSONStyle == "camel" || cfg.JSONStyle == "snake", fmt.Sprintf("-json-style %q is unknown", cfg.JSONStyle))
+	check(cfg.LiveblogStyle == "bool" || cfg.LiveblogStyle == "string", fmt.Sprintf("-liveblog-style %q is unknown", cfg.LiveblogStyle))
+	check(cfg.CacheTTL > 0, "-cache-ttl must be positive")
+	check(cfg.CacheSoftTTL >= 0 && cfg.CacheSoftTTL < cfg.CacheTTL, "-cache-soft-ttl must be at least 0 and less than -cache-ttl")
+	check(cfg.CacheStaleTTL >= 0, "-cache-stale-ttl must not be negative")
+	check(cfg.RefreshAhead >= 0 && cfg.RefreshAhead < cfg.CacheTTL, "-refresh-ahead must be at least 0 and less than -cache-ttl")
+	check(cfg.CoalesceWindow >= 0, "-coalesce-window must not be negative")
+	check(cfg.MaxEntryBytes >= 0, "-max-cache-entry-bytes must not be negative")
+	check(cfg.CDNMaxAge >= 0, "-cdn-max-age must not be negative")
+	check(cfg.CDNStaleWhileRevalidate >= 0, "-cdn-stale-while-revalidate must not be negative")
+	check(cfg.CDNStaleIfError >= 0, "-cdn-stale-if-error must not be negative")
+	check(cfg.CacheMaxAge >= 0, "-cache-max-age must not be negative")
+	check(cfg.WarmInterval >= 0, "-warm-interval must not be negative")
+	check(cfg.WarmInterval == 0 || cfg.WarmInterval < cfg.CacheTTL, "-warm-interval must be less than -cache-ttl, so editions are refreshed before they expire")
+	check(cfg.WarmMaxInFlight >= 0, "-warm-max-in-flight must not be negative")
+	check(cfg.WarmTimeout > 0, "-warm-timeout must be positive")
+	check(cfg.Addr != "", "-addr must be set")
+	check(cfg.GRPCAddr == "" || cfg.GRPCAddr != cfg.Addr, "-grpc-addr must differ from -addr")
+	check(cfg.ReadHeaderTimeout >= 0 && cfg.ReadTimeout >= 0 && cfg.IdleTimeout >= 0, "server timeouts must not be negative")
+	check(cfg.WriteTimeout == 0 || cfg.WriteTimeout > cfg.RequestBudget, "-write-timeout must be longer than -request-budget")
+	capiBase, err := url.Parse(cfg.CAPIURL)
+	check(err == nil && (capiBase.Scheme == "http" || capiBase.Scheme == "https") && capiBase.Host != "",
+		fmt.Sprintf("-capi-url %q is not an http(s) URL", cfg.CAPIURL))
+	check(err != nil || capiBase.User == nil, "-capi-url must not contain credentials, use -capi-auth basic")
+	switch cfg.CAPIAuth {
+	case CAPIAuthNone:
+		check(cfg.CAPIUser == "" && cfg.CAPIPassword == "", "-capi-user and -capi-password need -capi-auth basic")
+	case CAPIAuthBasic:
+		check(cfg.CAPIUser != "" && cfg.CAPIPassword != "", "-capi-auth basic needs -capi-user and -capi-password")
+	case CAPIAuthAWS:
+		check(cfg.CAPIAWSRegion != "" && cfg.CAPIAWSService != "", "-capi-auth aws-sigv4 needs -capi-aws-region and -capi-aws-service")
+	default:
+		check(false, fmt.Sprintf("-capi-auth %q is unknown, basic or aws-sigv4", cfg.CAPIAuth))
+	}
+	discussionBase, err := url.Parse(cfg.DiscussionURL)
+	check(err == nil && (discussionBase.Scheme == "http" || discussionBase.Scheme == "https") && discussionBase.Host != "",
+		fmt.Sprintf("-discussion-url %q is not an http(s) URL", cfg.DiscussionURL))
+	check(cfg.MostCommentedSize > 0, "-most-commented-size must be positive")
+	check(IsEdition(cfg.AutoEdition), fmt.Sprintf("-auto-default-edition %q is not an edition", cfg.AutoEdition))
+	for country, edition := range cfg.CountryEditions {
+		check(IsEdition(edition), fmt.Sprintf("-country-editions for %s: %q is not an edition", country, edition))
+	}
+	check(cfg.MostViewedSource == SourceCAPI || cfg.MostViewedSource == SourceOphan, fmt.Sprintf("-most-viewed-source %q is unknown", cfg.MostViewedSource))
+	check(cfg.MostViewedSource != SourceOphan || cfg.OphanAPIKey != "", "-most-viewed-source ophan needs -ophan-api-key")
+	ophanBase, err := url.Parse(cfg.OphanURL)
+	check(err == nil && (ophanBase.Scheme == "http" || ophanBase.Scheme == "https") && ophanBase.Host != "",
+		fmt.Sprintf("-ophan-url %q is not an http(s) URL", cfg.OphanURL))
+	check(cfg.OphanCount > 0, "-ophan-count must be positive")
+	check(cfg.OphanCacheTTL > 0, "-ophan-cache-ttl must be positive")
+	check(cfg.OphanAPIKey == "" || cfg.OphanCacheTTL <= cfg.CacheTTL, "-ophan-cache-ttl must be at most -cache-ttl")
+	check(cfg.CacheCleanupInterval > 0, "-cache-cleanup-interval must be positive")
+	if cfg.CAPIProxy != "" {
+		u, err := url.Parse(cfg.CAPIProxy)
+		check(err == nil && u.Host != "", fmt.Sprintf("-capi-proxy %q is not a URL", cfg.CAPIProxy))
+	}
+	check(cfg.CAPIMaxInFlight >= 0 && cfg.CAPIQueue >= 0, "-capi-max-in-flight and -capi-queue must not be negative")
+	check(cfg.CAPIQueueTimeout > 0, "-capi-queue-timeout must be positive")
+	check(cfg.ShedRetryAfter >= 0, "-shed-retry-after must not be negative")
+	check(cfg.FanOutWorkers > 0, "-fan-out-workers must be positive")
+	check(cfg.DecayHalfLife > 0, "-decay-half-life must be positive")
+	check(cfg.RequestBudget > 0, "-request-budget must be positive")
+	check(cfg.CompressMinBytes >= 0, "-compress-min-bytes must not be negative")
+	check(cfg.CompressCacheBytes >= 0, "-compress-cache-bytes must not be negative")
+	check(cfg.CORSMaxAge >= 0, "-cors-max-age must not be negative")
+	check(cfg.ShutdownTimeout > 0, "-shutdown-timeout must be positive")
+	check(cfg.UpstreamTimeout >= 0, "-upstream-timeout must not be negative")
+	for path, timeout := range cfg.EditionTimeouts {
+		check(timeout > 0, fmt.Sprintf("-edition-timeouts for %s must be positive", path))
+	}
+	check(cfg.ReadinessTimeout > 0, "-readiness-timeout must be positive")
+	check(cfg.ReadyMaxCAPIAge >= 0, "-ready-max-capi-age must not be negative")
+	check(cfg.HealthWindow > 0, "-health-window must be positive")
+	check(cfg.SnapshotRetention > 0, "-snapshot-retention must be positive")
+	check(cfg.StreamKeepAlive > 0, "-stream-keepalive must be positive")
+	check(cfg.HealthThreshold >= 0 && cfg.HealthThreshold <= 1, "-health-threshold must be between 0 and 1")
+	check(cfg.ChaosErrorRate >= 0 && cfg.ChaosErrorRate <= 1, "-chaos-error-rate must be between 0 and 1")
+	check(cfg.ChaosLatencyRate >= 0 && cfg.ChaosLatencyRate <= 1, "-chaos-latency-rate must be between 0 and 1")
+	if cfg.ImageResizerURL != "" {
+		u, err := url.Parse(cfg.ImageResizerURL)
+		check(err == nil && u.Scheme != "" && u.Host != "", fmt.Sprintf("-image-resizer-url %q is not an absolute URL", cfg.ImageResizerURL))
+		check(cfg.ImageSalt != "", "-image-resizer-url needs -image-salt to sign its URLs")
+		check(len(cfg.ImageWidths) > 0, "-image-resizer-url needs at least one -image-widths")
+		for _, width := range cfg.ImageWidths {
+			check(width > 0, fmt.Sprintf("-image-widths %d must be positive", width))
+		}
+		check(cfg.ImageQuality >= 1 && cfg.ImageQuality <= 100, "-image-quality must be between 1 and 100")
+	}
+	if cfg.EnrichURL != "" {
+		_, err := url.Parse(cfg.EnrichURL)
+		check(err == nil, fmt.Sprintf("-enrich-url is invalid: %v", err))
+		check(cfg.EnrichWorkers > 0, "-enrich-workers must be positive")
+		check(cfg.EnrichTimeout > 0, "-enrich-timeout must be positive")
+		check(cfg.EnrichMaxBytes > 0, "-enrich-max-bytes must be positive")
+		check(cfg.EnrichBudget > 0, "-enrich-budget must be positive")
+	}
+	check(cfg.FeatureRefresh > 0, "-feature-refresh must be positive")
+	check(cfg.OverridesRefresh > 0, "-overrides-refresh must be positive")
+	if strings.HasPrefix(cfg.Overrides, "s3://") {
+		_, _, err := S3Location(cfg.Overrides)
+		check(err == nil, fmt.Sprintf("-overrides %q is not an s3://bucket/key URL", cfg.Overrides))
+	}
+	check(cfg.SlowLogThreshold >= 0, "-slow-log-threshold must not be negative")
+	check(cfg.MinItems >= 0, "-min-items must not be negative")
+	check(cfg.MaxLimit > 0, "-max-limit must be positive")
+	check(cfg.DefaultLimit >= 0, "-default-limit must not be negative")
+	for edition, limit := range cfg.EditionLimits {
+		check(limit > 0, fmt.Sprintf("-edition-limits for %s must be positive", edition))
+	}
+	check(cfg.RateLimitRetries >= 0, "-rate-limit-retries must not be negative")
+	check(cfg.CAPIRetries >= 0, "-capi-retries must not be negative")
+	check(cfg.RetryBackoff >= 0, "-retry-backoff must not be negative")
+	check(cfg.RetryMaxBackoff >= cfg.RetryBackoff, "-retry-max-backoff must be at least -retry-backoff")
+	check(cfg.BreakerThreshold >= 0, "-breaker-threshold must not be negative")
+	check(cfg.BreakerThreshold == 0 || cfg.BreakerCooldown > 0, "-breaker-cooldown must be positive")
+	check(cfg.MaxRedirects >= 0, "-max-redirects must not be negative")
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration:\n  %s", strings.Join(problems, "\n  "))
+	}
+
+	return nil
+}
+
+// S3Location splits an s3://bucket/key URL
+func S3Location(location string) (bucket, key string, err error) {
+	u, err := url.Parse(location)
+	if err != nil || u.Scheme != "s3" || u.Host == "" || strings.TrimPrefix(u.Path, "/") == "" {
+		return "", "", fmt.Errorf("%q is not an s3://bucket/key URL", location)
+	}
+
+	return u.Host, strings.TrimPrefix(u.Path, "/"), nil
+}
@@ -0,0 +1,66 @@
+package config
+
+import (
+	"flag"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		problem string
+	}{
+		{"valid", nil, ""},
+		{"valid with redis", []string{"-cache-backend=redis", "-redis-url=redis://localhost:6379/0"}, ""},
+		{"no API key", []string{"-api-key="}, "a CAPI key is required"},
+		{"relative CAPI URL", []string{"-capi-url=content.guardianapis.com"}, "-capi-url"},
+		{"credentials in the CAPI URL", []string{"-capi-url=https://user:pw@content.guardianapis.com"}, "must not contain credentials"},
+		{"unparseable redis URL", []string{"-cache-backend=redis", "-redis-url=::"}, "-redis-url is invalid"},
+		{"unknown cache backend", []string{"-cache-backend=memcached"}, "-cache-backend"},
+		{"cache TTL not positive", []string{"-cache-ttl=0s"}, "-cache-ttl must be positive"},
+		{"soft TTL past the TTL", []string{"-cache-ttl=1m", "-cache-soft-ttl=2m"}, "-cache-soft-ttl"},
+		{"warming slower than expiry", []string{"-cache-ttl=1m", "-warm-interval=2m"}, "-warm-interval"},
+		{"backoff ceiling under its base", []string{"-retry-backoff=2s", "-retry-max-backoff=1s"}, "-retry-max-backoff"},
+		{"unknown edition", []string{"-auto-default-edition=fr"}, "-auto-default-edition"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cfg Config
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			Register(fs, &cfg)
+			if err := fs.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+
+			err := cfg.Validate()
+			switch {
+			case tt.problem == "" && err != nil:
+				t.Errorf("Validate() = %v, want no problems", err)
+			case tt.problem != "" && (err == nil || !strings.Contains(err.Error(), tt.problem)):
+				t.Errorf("Validate() = %v, want %q", err, tt.problem)
+			}
+		})
+	}
+}
+
+func TestValidateReportsEveryProblem(t *testing.T) {
+	var cfg Config
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	Register(fs, &cfg)
+	if err := fs.Parse([]string{"-api-key=", "-cache-ttl=0s", "-fan-out-workers=0"}); err != nil {
+		t.Fatal(err)
+	}
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("Validate() found no problems")
+	}
+	for _, problem := range []string{"a CAPI key is required", "-cache-ttl must be positive", "-fan-out-workers must be positive"} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("Validate() = %v, without %q", err, problem)
+		}
+	}
+}
@@ -0,0 +1,19 @@
+//go:build debug
+// +build debug
+
+package config
+
+import (
+	"flag"
+	"time"
+)
+
+// debugBuild is set in builds with the debug tag
+const debugBuild = true
+
+// registerDebug adds the settings that only debug builds have
+func registerDebug(fs *flag.FlagSet, cfg *Config) {
+	fs.Float64Var(&cfg.ChaosErrorRate, "chaos-error-rate", 0, "fraction of CAPI requests failed on purpose, with -debug")
+	fs.Float64Var(&cfg.ChaosLatencyRate, "chaos-latency-rate", 0, "fraction of CAPI requests delayed on purpose, with -debug")
+	fs.DurationVar(&cfg.ChaosLatency, "chaos-latency", time.Second, "delay added to requests picked by -chaos-latency-rate")
+}
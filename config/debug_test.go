@@ -0,0 +1,43 @@
+//go:build debug
+
+package config
+
+import (
+	"flag"
+	"strings"
+	"testing"
+)
+
+func TestDebugNeedsAdminCredentials(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		token    string
+		wantErr  bool
+	}{
+		{name: "no credentials", wantErr: true},
+		{name: "password", password: "secret"},
+		{name: "token", token: "t0ken"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cfg Config
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			Register(fs, &cfg)
+			if err := fs.Parse(nil); err != nil {
+				t.Fatal(err)
+			}
+			cfg.APIKey = "test"
+			cfg.Debug = true
+			cfg.AdminPassword = tt.password
+			cfg.AdminToken = tt.token
+
+			err := cfg.Validate()
+			gotErr := err != nil && strings.Contains(err.Error(), "-debug needs")
+			if gotErr != tt.wantErr {
+				t.Errorf("Validate() = %v, want the -debug problem: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
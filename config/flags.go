@@ -0,0 +1,203 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// listFlag is a comma-separated list flag
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(v string) error {
+	*l = nil
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+
+	return nil
+}
+
+// intListFlag is a comma-separated list of numbers
+type intListFlag []int
+
+func (l *intListFlag) String() string {
+	items := make([]string, len(*l))
+	for i, n := range *l {
+		items[i] = strconv.Itoa(n)
+	}
+
+	return strings.Join(items, ",")
+}
+
+func (l *intListFlag) Set(v string) error {
+	*l = nil
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+
+		n, err := strconv.Atoi(item)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", item)
+		}
+		*l = append(*l, n)
+	}
+
+	return nil
+}
+
+// cidrListFlag is a comma-separated list of CIDR networks, e.g.
+// "10.0.0.0/8,fd00::/8"
+type cidrListFlag []*net.IPNet
+
+func (l *cidrListFlag) String() string {
+	var networks []string
+	for _, network := range *l {
+		networks = append(networks, network.String())
+	}
+
+	return strings.Join(networks, ",")
+}
+
+func (l *cidrListFlag) Set(v string) error {
+	*l = nil
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(item)
+		if err != nil {
+			return fmt.Errorf("expected a CIDR, got %q", item)
+		}
+
+		*l = append(*l, network)
+	}
+
+	return nil
+}
+
+// intMapFlag is a comma-separated list of key=number pairs, e.g. "uk=10,au=5"
+type intMapFlag map[string]int
+
+func (m intMapFlag) String() string {
+	var pairs []string
+	for key, value := range m {
+		pairs = append(pairs, fmt.Sprintf("%s=%d", key, value))
+	}
+
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (m intMapFlag) Set(v string) error {
+	for _, pair := range strings.Split(v, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("expected key=number, got %q", pair)
+		}
+
+		n, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return fmt.Errorf("expected key=number, got %q", pair)
+		}
+
+		m[parts[0]] = n
+	}
+
+	return nil
+}
+
+// stringMapFlag is a comma-separated list of key=value pairs, e.g.
+// "uk=the UK,au=Australia"
+type stringMapFlag map[string]string
+
+func (m stringMapFlag) String() string {
+	var pairs []string
+	for key, value := range m {
+		pairs = append(pairs, key+"="+value)
+	}
+
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (m stringMapFlag) Set(v string) error {
+	for _, pair := range strings.Split(v, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("expected key=value, got %q", pair)
+		}
+
+		m[parts[0]] = strings.TrimSpace(parts[1])
+	}
+
+	return nil
+}
+
+// durationMapFlag is a comma-separated list of key=duration pairs, e.g.
+// "uk=2s,football=5s"
+type durationMapFlag map[string]time.Duration
+
+func (m durationMapFlag) String() string {
+	var pairs []string
+	for key, value := range m {
+		pairs = append(pairs, key+"="+value.String())
+	}
+
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (m durationMapFlag) Set(v string) error {
+	for _, pair := range strings.Split(v, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("expected key=duration, got %q", pair)
+		}
+
+		d, err := time.ParseDuration(parts[1])
+		if err != nil {
+			return fmt.Errorf("expected key=duration, got %q", pair)
+		}
+
+		m[parts[0]] = d
+	}
+
+	return nil
+}
+
+// headerFlag collects repeated "Name: value" flags into a header set
+type headerFlag http.Header
+
+func (h headerFlag) String() string {
+	var pairs []string
+	for name, values := range h {
+		for _, value := range values {
+			pairs = append(pairs, name+": "+value)
+		}
+	}
+
+	return strings.Join(pairs, ", ")
+}
+
+func (h headerFlag) Set(v string) error {
+	parts := strings.SplitN(v, ":", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+		return fmt.Errorf("expected \"Name: value\", got %q", v)
+	}
+
+	http.Header(h).Add(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+	return nil
+}
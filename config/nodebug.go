@@ -0,0 +1,13 @@
+//go:build !debug
+// +build !debug
+
+package config
+
+import "flag"
+
+// debugBuild is set in builds with the debug tag
+const debugBuild = false
+
+// registerDebug does nothing: chaos settings can't be set without the debug
+// tag
+func registerDebug(fs *flag.FlagSet, cfg *Config) {}
@@ -0,0 +1,115 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// ApplySources fills in settings from the config file at path, if
+// there is one, and then the environment. Highest first, a setting comes
+// from the command line, an ONWARD_* environment variable (ONWARD_API_KEY
+// for -api-key), the file, and then the flag's default.
+func ApplySources(fs *flag.FlagSet, path string) error {
+	explicit := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	if path != "" {
+		if err := applyConfigFile(fs, path, explicit); err != nil {
+			return err
+		}
+	}
+
+	return applyEnv(fs, explicit)
+}
+
+// applyConfigFile sets flags from a YAML (or JSON) file of flag names to
+// values, skipping any given on the command line. Lists and maps are given
+// as YAML lists and maps, e.g.
+//
+//	api-key: my-key
+//	proxy-paths: [search, tags]
+//	edition-limits: {uk: 10, au: 5}
+func applyConfigFile(fs *flag.FlagSet, path string, explicit map[string]bool) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return errors.Wrap(err, "Unable to read config file")
+	}
+
+	var settings map[string]interface{}
+	if err := yaml.Unmarshal(data, &settings); err != nil {
+		return errors.Wrapf(err, "Unable to parse config file %s", path)
+	}
+
+	for name, value := range settings {
+		if fs.Lookup(name) == nil {
+			return fmt.Errorf("config file %s: unknown setting %q", path, name)
+		}
+
+		if explicit[name] {
+			continue
+		}
+
+		for _, v := range flagValues(value) {
+			if err := fs.Set(name, v); err != nil {
+				return fmt.Errorf("config file %s: invalid %s: %v", path, name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// flagValues converts a config file value to what's passed to a flag's Set:
+// lists of scalars become one comma-separated value, except lists of
+// "Name: value" headers, which are set one at a time
+func flagValues(value interface{}) []string {
+	switch v := value.(type) {
+	case []interface{}:
+		var items []string
+		for _, item := range v {
+			items = append(items, fmt.Sprint(item))
+		}
+
+		if len(items) > 0 && strings.Contains(items[0], ":") {
+			return items
+		}
+		return []string{strings.Join(items, ",")}
+	case map[string]interface{}:
+		var pairs []string
+		for key, item := range v {
+			pairs = append(pairs, fmt.Sprintf("%s=%v", key, item))
+		}
+
+		sort.Strings(pairs)
+		return []string{strings.Join(pairs, ",")}
+	}
+
+	return []string{fmt.Sprint(value)}
+}
+
+// applyEnv sets flags from ONWARD_* environment variables, skipping any
+// given on the command line
+func applyEnv(fs *flag.FlagSet, explicit map[string]bool) error {
+	var err error
+
+	fs.VisitAll(func(f *flag.Flag) {
+		key := "ONWARD_" + strings.ToUpper(strings.Replace(f.Name, "-", "_", -1))
+		value, ok := os.LookupEnv(key)
+		if !ok || explicit[f.Name] || err != nil {
+			return
+		}
+
+		if setErr := fs.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("invalid %s: %v", key, setErr)
+		}
+	})
+
+	return err
+}
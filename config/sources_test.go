@@ -0,0 +1,141 @@
+package config
+
+import (
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeConfigFile writes a config file for the test, returning its path
+func writeConfigFile(t *testing.T, name, contents string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// loadConfig is the configuration from args, then the config file at path
+// and the environment
+func loadConfig(args []string, path string) (Config, error) {
+	var cfg Config
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	Register(fs, &cfg)
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+
+	err := ApplySources(fs, path)
+	return cfg, err
+}
+
+func TestConfigFile(t *testing.T) {
+	path := writeConfigFile(t, "onward.yaml", `
+api-key: file-key
+cache-ttl: 2m
+proxy-paths: [search, tags]
+edition-limits: {uk: 10, au: 5}
+capi-header:
+  - "X-Team: onward"
+  - "X-Env: test"
+`)
+
+	cfg, err := loadConfig(nil, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("the file's settings don't validate, %s", err)
+	}
+
+	if cfg.APIKey != "file-key" {
+		t.Errorf("APIKey is %q, want the file's", cfg.APIKey)
+	}
+	if cfg.CacheTTL != 2*time.Minute {
+		t.Errorf("CacheTTL is %s, want the file's 2m", cfg.CacheTTL)
+	}
+	if want := []string{"search", "tags"}; !reflect.DeepEqual(cfg.ProxyPaths, want) {
+		t.Errorf("ProxyPaths are %v, want %v", cfg.ProxyPaths, want)
+	}
+	if want := map[string]int{"uk": 10, "au": 5}; !reflect.DeepEqual(cfg.EditionLimits, want) {
+		t.Errorf("EditionLimits are %v, want %v", cfg.EditionLimits, want)
+	}
+	if want := (http.Header{"X-Team": {"onward"}, "X-Env": {"test"}}); !reflect.DeepEqual(cfg.CAPIHeaders, want) {
+		t.Errorf("CAPIHeaders are %v, want %v", cfg.CAPIHeaders, want)
+	}
+
+	// JSON is YAML too
+	path = writeConfigFile(t, "onward.json", `{"api-key": "json-key", "cache-ttl": "3m"}`)
+	if cfg, err = loadConfig(nil, path); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.APIKey != "json-key" || cfg.CacheTTL != 3*time.Minute {
+		t.Errorf("got %q and %s, want the JSON file's", cfg.APIKey, cfg.CacheTTL)
+	}
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	path := writeConfigFile(t, "onward.yaml", "api-key: file-key\n")
+
+	tests := []struct {
+		name string
+		args []string
+		env  string
+		file string
+		want string
+	}{
+		{"default", nil, "", "", "test"},
+		{"file over the default", nil, "", path, "file-key"},
+		{"environment over the file", nil, "env-key", path, "env-key"},
+		{"flag over the environment", []string{"-api-key=flag-key"}, "env-key", path, "flag-key"},
+		{"flag over the file", []string{"-api-key=flag-key"}, "", path, "flag-key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.env != "" {
+				t.Setenv("ONWARD_API_KEY", tt.env)
+			}
+
+			cfg, err := loadConfig(tt.args, tt.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.APIKey != tt.want {
+				t.Errorf("APIKey is %q, want %q", cfg.APIKey, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigFileProblems(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+		problem  string
+	}{
+		{"unknown setting", "api-kee: file-key\n", `unknown setting "api-kee"`},
+		{"invalid value", "cache-ttl: soon\n", "invalid cache-ttl"},
+		{"not YAML", "api-key: [unclosed\n", "Unable to parse config file"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadConfig(nil, writeConfigFile(t, "onward.yaml", tt.contents))
+			if err == nil || !strings.Contains(err.Error(), tt.problem) {
+				t.Errorf("got %v, want %q", err, tt.problem)
+			}
+		})
+	}
+
+	if _, err := loadConfig(nil, filepath.Join(t.TempDir(), "missing.yaml")); err == nil || !strings.Contains(err.Error(), "Unable to read config file") {
+		t.Errorf("a missing file got %v, want it unreadable", err)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"text/tabwriter"
+
+	"github.com/guardian/onward/config"
+	"github.com/guardian/onward/handlers"
+	"github.com/pkg/errors"
+)
+
+const fetchUsage = `usage: onward fetch [flags] most-viewed {edition}[/{section}]
+       onward fetch [flags] related {content-path}
+
+Fetches a list from CAPI, uncached, and prints it with any -overrides
+applied. Takes the server's flags, and the same environment and -config file,
+as well as:
+`
+
+// runFetch is the fetch subcommand, which prints a list straight from CAPI,
+// mapped as the server would, without serving anything. Flags can come
+// before or after the arguments. It returns the exit status: 1 if the fetch
+// failed, 2 if it was asked for wrongly.
+func runFetch(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("fetch", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+
+	var cfg config.Config
+	config.Register(fs, &cfg)
+	configPath := fs.String("config", "", "YAML or JSON file of settings, keyed by flag name")
+	format := fs.String("format", "json", "output format, json or table")
+	limit := fs.Int("limit", 0, "most trails to print (0 for all)")
+	fs.Usage = func() {
+		fmt.Fprint(stderr, fetchUsage)
+		fs.PrintDefaults()
+	}
+
+	// flag stops at the first argument, so parse again after each one
+	var positional []string
+	for {
+		if err := fs.Parse(args); err == flag.ErrHelp {
+			return 0
+		} else if err != nil {
+			return 2
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+
+	if len(positional) != 2 {
+		fs.Usage()
+		return 2
+	}
+	if *format != "json" && *format != "table" {
+		fmt.Fprintf(stderr, "-format %q is unknown, json or table\n", *format)
+		return 2
+	}
+
+	if err := config.ApplySources(fs, *configPath); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	api, err := newCAPIClient(cfg)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.RequestBudget)
+	defer cancel()
+
+	il, err := handlers.FetchList(ctx, api, cfg, positional[0], positional[1], *limit)
+	var httpErr *handlers.HTTPError
+	if errors.As(err, &httpErr) && httpErr.Status == http.StatusBadRequest {
+		fmt.Fprintln(stderr, httpErr.Message)
+		return 2
+	} else if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	if *format == "table" {
+		writeTable(stdout, il)
+		return 0
+	}
+
+	enc := json.NewEncoder(stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(il); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	return 0
+}
+
+// writeTable prints a list's trails as aligned columns, for reading in a
+// terminal
+func writeTable(w io.Writer, il handlers.ItemList) {
+	fmt.Fprintln(w, il.Heading)
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "#\tHEADLINE\tBYLINE\tURL")
+	for i, item := range il.Trails {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", i+1, item.LinkText, item.Byline, item.URL)
+	}
+	tw.Flush()
+}
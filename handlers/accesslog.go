@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// accessLogger writes access log lines to stdout as bare JSON, one per line
+var accessLogger = log.New(os.Stdout, "", 0)
+
+// accessEntry is one access log line
+type accessEntry struct {
+	Time       string  `json:"time"`
+	RequestID  string  `json:"requestId"`
+	Method     string  `json:"method"`
+	Path       string  `json:"path"`
+	Status     int     `json:"status"`
+	Bytes      int     `json:"bytes"`
+	DurationMS float64 `json:"durationMs"`
+	Cache      string  `json:"cache,omitempty"`
+	Client     string  `json:"client"`
+}
+
+// accessLog logs every request as a line of JSON. It needs withRequestID and
+// trackTimings further out.
+func accessLog(trusted []*net.IPNet, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+
+		h.ServeHTTP(sw, r)
+
+		entry := accessEntry{
+			Time:       start.UTC().Format(time.RFC3339Nano),
+			RequestID:  requestIDFrom(r.Context()),
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			Status:     sw.status,
+			Bytes:      sw.bytes,
+			DurationMS: float64(time.Since(start)) / float64(time.Millisecond),
+			Client:     clientIP(r, trusted),
+		}
+
+		if t := timingsFrom(r.Context()); t != nil {
+			t.mu.Lock()
+			entry.Cache = t.lookup
+			t.mu.Unlock()
+		}
+
+		line, err := json.Marshal(entry)
+		if err != nil {
+			log.Printf("Unable to write access log (should never happen), %s", err)
+			return
+		}
+
+		accessLogger.Print(string(line))
+	})
+}
@@ -0,0 +1,253 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/guardian/onward/capi"
+	"github.com/guardian/onward/config"
+)
+
+// adminPrefixes are the routes basic auth protects
+var adminPrefixes = []string{"/admin/", "/debug/"}
+
+// requireAdmin guards admin and debug routes, leaving the rest of the API
+// alone. Clients must be on cfg.AdminAllow if it's set, and send the basic
+// auth credentials or the bearer token if either is set.
+func requireAdmin(cfg config.Config, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isAdminPath(r.URL.Path) && !allowAdmin(w, r, cfg) {
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// adminOnly guards h as requireAdmin guards the admin routes, whatever its
+// path
+func adminOnly(cfg config.Config, h func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if allowAdmin(w, r, cfg) {
+			h(w, r)
+		}
+	}
+}
+
+// allowAdmin reports whether r may use an admin route, refusing it if not
+func allowAdmin(w http.ResponseWriter, r *http.Request, cfg config.Config) bool {
+	if len(cfg.AdminAllow) > 0 && !inNetworks(clientIP(r, cfg.TrustedProxies), cfg.AdminAllow) {
+		writeError(w, &HTTPError{Status: http.StatusForbidden, Message: "Forbidden"})
+		return false
+	}
+
+	needsAuth := cfg.AdminPassword != "" || cfg.AdminToken != ""
+	if needsAuth && !validCredentials(r, cfg) && !validToken(r, cfg) {
+		if cfg.AdminPassword != "" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="onward admin", charset="UTF-8"`)
+		} else {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="onward admin"`)
+		}
+		writeError(w, &HTTPError{Status: http.StatusUnauthorized, Message: "Unauthorized"})
+		return false
+	}
+
+	return true
+}
+
+func isAdminPath(path string) bool {
+	for _, prefix := range adminPrefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func validCredentials(r *http.Request, cfg config.Config) bool {
+	user, password, ok := r.BasicAuth()
+	if !ok || cfg.AdminPassword == "" {
+		return false
+	}
+
+	userOK := subtle.ConstantTimeCompare([]byte(user), []byte(cfg.AdminUser)) == 1
+	passwordOK := subtle.ConstantTimeCompare([]byte(password), []byte(cfg.AdminPassword)) == 1
+	return userOK && passwordOK
+}
+
+func validToken(r *http.Request, cfg config.Config) bool {
+	auth := r.Header.Get("Authorization")
+	if cfg.AdminToken == "" || !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+
+	token := strings.TrimPrefix(auth, "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(cfg.AdminToken)) == 1
+}
+
+// adminDocs describe the admin routes, by pattern, for the OpenAPI document
+var adminDocs = map[string][]routeDoc{
+	"/admin/purge/": {
+		{Path: "/admin/purge/{edition}", Summary: "Drop an edition's cached list", Returns: "none"},
+		{Path: "/admin/purge/{edition}/{section}", Summary: "Drop a section's cached list", Returns: "none"},
+	},
+	"/admin/cache":       {{Path: "/admin/cache", Summary: "List the cached entries", Returns: "json"}},
+	"/admin/cache/":      {{Path: "/admin/cache/{key}", Summary: "Drop a cached entry", Returns: "none"}},
+	"/admin/cache/flush": {{Path: "/admin/cache/flush", Summary: "Drop every cached entry", Returns: "none"}},
+	"/admin/overrides":   {{Path: "/admin/overrides", Summary: "The editors' pin and block overrides, read or replaced", Returns: "json"}},
+}
+
+// purgeHandler drops an edition or section's cached most-viewed list, so the
+// next request fetches it afresh: POST /admin/purge/{edition}[/{section}]
+func purgeHandler(c Cache) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.Path, "/admin/purge/")
+		if !config.IsEdition(path) && !isEditionSection(path) {
+			writeError(w, pathError(path))
+			return
+		}
+
+		c.Delete(query{Path: path, MostViewed: true}.cacheKey())
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// cacheListing is a cached entry as the admin API lists it
+type cacheListing struct {
+	Key        string    `json:"key"`
+	State      string    `json:"state"`
+	FetchedAt  time.Time `json:"fetchedAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+	AgeSeconds int       `json:"ageSeconds"`
+	TTLSeconds int       `json:"ttlSeconds"`
+}
+
+// cacheStateNames are how cache states are listed
+var cacheStateNames = map[cacheState]string{
+	cacheAbsent: "expired",
+	cacheStale:  "stale",
+	cacheFresh:  "fresh",
+}
+
+// cacheListHandler lists the cached keys, with each entry's state, age and
+// time left: GET /admin/cache
+func cacheListHandler(c Cache, cfg config.Config) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		keys, err := c.Keys(r.Context())
+		if err != nil {
+			writeError(w, &HTTPError{Status: http.StatusServiceUnavailable, Message: "Unable to list the cache", Err: err})
+			return
+		}
+		sort.Strings(keys)
+
+		now := time.Now()
+		listings := []cacheListing{}
+		for _, key := range keys {
+			entry, found := c.Get(key)
+			if !found {
+				// gone since it was listed
+				continue
+			}
+
+			ttl := entry.expiresAt(cfg).Sub(now)
+			if ttl < 0 {
+				ttl = 0
+			}
+
+			listings = append(listings, cacheListing{
+				Key:        key,
+				State:      cacheStateNames[entry.state(cfg, now)],
+				FetchedAt:  entry.FetchedAt,
+				ExpiresAt:  entry.expiresAt(cfg),
+				AgeSeconds: int(now.Sub(entry.FetchedAt).Seconds()),
+				TTLSeconds: int(ttl.Seconds()),
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if _, err := w.Write(renderOptionsFor(r, cfg).json(listings)); err != nil {
+			logWriteFailure(r.URL.Path, err)
+		}
+	}
+}
+
+// cacheDeleteHandler drops one cached entry by its key, as GET /admin/cache
+// lists it, URL-escaped: DELETE /admin/cache/{key}
+func cacheDeleteHandler(c Cache) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(r.URL.Path, "/admin/cache/")
+		if r.URL.RawQuery != "" {
+			// an unescaped ? splits the key's own query off into the URL's
+			key += "?" + r.URL.RawQuery
+		}
+
+		if _, found := c.Get(key); !found {
+			writeError(w, &HTTPError{Status: http.StatusNotFound, Message: "Not cached"})
+			return
+		}
+
+		c.Delete(key)
+		log.Printf("Admin deleted cache entry %s", key)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// cacheFlushHandler drops every cached entry: POST /admin/cache/flush
+func cacheFlushHandler(c Cache) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := c.Flush(r.Context()); err != nil {
+			writeError(w, &HTTPError{Status: http.StatusServiceUnavailable, Message: "Unable to flush the cache", Err: err})
+			return
+		}
+
+		log.Printf("Admin flushed the cache")
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// maxOverridesBytes is the largest set of override rules the admin API takes
+const maxOverridesBytes = 1 << 20
+
+// overridesHandler serves the override rules in force, GET /admin/overrides,
+// and replaces them with a request's YAML or JSON rules, PUT
+// /admin/overrides. Replaced rules stay until -overrides next changes.
+func overridesHandler(api capi.Client, cfg config.Config) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxOverridesBytes))
+			if err != nil {
+				writeError(w, &HTTPError{Status: http.StatusRequestEntityTooLarge, Message: "Overrides too large", Err: err})
+				return
+			}
+
+			rules, err := parseOverrides(data)
+			if err != nil {
+				writeError(w, &HTTPError{Status: http.StatusBadRequest, Message: err.Error()})
+				return
+			}
+
+			ctx, cancel := context.WithTimeout(r.Context(), cfg.RequestBudget)
+			defer cancel()
+
+			if err := overrides.replace(ctx, api, rules); err != nil {
+				writeError(w, err)
+				return
+			}
+			log.Printf("Admin replaced the overrides: %d blocked, pins in %d lists", len(rules.Block), len(rules.Pins))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if _, err := w.Write(renderOptionsFor(r, cfg).json(overrides.current())); err != nil {
+			logWriteFailure(r.URL.Path, err)
+		}
+	}
+}
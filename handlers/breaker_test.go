@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/guardian/onward/capi"
+)
+
+func TestOpenBreakerServesExpiredEntries(t *testing.T) {
+	tests := []struct {
+		name    string
+		backend string
+		maxAge  time.Duration
+		age     time.Duration
+		// later is how far Redis's clock moves on once the entry is set
+		later  time.Duration
+		served bool
+	}{
+		{name: "memory", backend: "memory", age: time.Hour, served: true},
+		{name: "redis, past the cache TTL", backend: "redis", age: time.Hour, later: 6 * time.Minute, served: true},
+		{name: "redis, past the cleanup interval", backend: "redis", age: time.Hour, later: 11 * time.Minute},
+		{name: "redis within max age", backend: "redis", maxAge: 2 * time.Hour, age: time.Hour, later: time.Hour, served: true},
+		{name: "memory past max age", backend: "memory", maxAge: 30 * time.Minute, age: time.Hour},
+		{name: "redis past max age", backend: "redis", maxAge: 30 * time.Minute, age: time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusInternalServerError)
+			})
+			cfg := testConfig(t, stub.URL)
+			cfg.CAPIRetries = 0
+			cfg.CacheMaxAge = tt.maxAge
+			cfg.CacheBackend = tt.backend
+			redis := miniredis.RunT(t)
+			cfg.RedisURL = "redis://" + redis.Addr()
+			c := testCache(t, cfg)
+
+			q := query{Path: "uk", MostViewed: true}
+			var response capi.Response
+			response.Response.Results = capiItems("a", "b")
+			c.Set(q.cacheKey(), cacheEntry{Response: response, FetchedAt: time.Now().Add(-tt.age), ExpiresAt: time.Now().Add(-time.Second)})
+			redis.FastForward(tt.later)
+
+			cfg.BreakerThreshold, cfg.BreakerCooldown = 1, time.Minute
+			api := testCAPI(cfg)
+			if _, err := capi.Fetch(context.Background(), api, "uk", nil); !capi.IsTransient(err) {
+				t.Fatalf("got %v, want a transient error to open the breaker", err)
+			}
+
+			items, err := cachedGet(context.Background(), api, q, c, cfg)
+			if !tt.served {
+				if err == nil {
+					t.Errorf("got %v, %v, want the breaker's refusal", itemIDs(items.Response.Results), err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("got %v, want the expired entry", err)
+			}
+			if got := itemIDs(items.Response.Results); !reflect.DeepEqual(got, []string{"a", "b"}) {
+				t.Errorf("got %v, want the expired entry's list", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"net/http"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/guardian/onward/config"
+)
+
+// version is the release, set at build time with
+// -ldflags "-X github.com/guardian/onward/handlers.version=..."
+var version = "dev"
+
+// buildInfo describes the running binary
+type buildInfo struct {
+	Version   string `json:"version"`
+	Revision  string `json:"revision,omitempty"`
+	GoVersion string `json:"goVersion"`
+}
+
+func currentBuild() buildInfo {
+	info := buildInfo{Version: version, GoVersion: runtime.Version()}
+
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range bi.Settings {
+			if setting.Key == "vcs.revision" {
+				info.Revision = setting.Value
+			}
+		}
+	}
+
+	return info
+}
+
+// versionHandler reports what's running
+func versionHandler(cfg config.Config) func(w http.ResponseWriter, r *http.Request) {
+	build := currentBuild()
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(renderOptionsFor(r, cfg).json(build))
+	}
+}
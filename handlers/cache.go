@@ -0,0 +1,402 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/guardian/onward/cache"
+	"github.com/guardian/onward/capi"
+	"github.com/guardian/onward/config"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Cache stores CAPI responses by key, in one of package cache's backends.
+// Entries are kept past their expiry until the backend drops them, so stale
+// can be told from absent, and for at least stale past it, so they can still
+// be served while they're refreshed.
+type Cache struct {
+	cache.Cache
+	stale time.Duration
+}
+
+func (c Cache) Get(key string) (cacheEntry, bool) {
+	value, found := c.Cache.Get(key)
+	if !found {
+		return cacheEntry{}, false
+	}
+
+	entry, ok := value.(cacheEntry)
+	return entry, ok
+}
+
+func (c Cache) Set(key string, entry cacheEntry) {
+	c.Cache.Set(key, entry, entry.ExpiresAt.Add(c.stale))
+}
+
+// cacheEntry is a cached CAPI response along with when it was fetched and
+// when it stops being served. Proxied queries are cached as the raw Body
+// rather than a decoded Response.
+type cacheEntry struct {
+	Response  capi.Response
+	Body      []byte `json:",omitempty"`
+	FetchedAt time.Time
+	ExpiresAt time.Time
+
+	// Validators are CAPI's ETag and Last-Modified for Response, sent back
+	// when it's refreshed so an unchanged one needn't be downloaded again
+	Validators http.Header `json:",omitempty"`
+}
+
+// newCacheEntry stamps a response fetched now with its expiry, ttl from now
+func newCacheEntry(response capi.Response, body []byte, ttl time.Duration) cacheEntry {
+	now := time.Now()
+	return cacheEntry{Response: response, Body: body, FetchedAt: now, ExpiresAt: now.Add(ttl)}
+}
+
+// cacheState is what can be done with a cache lookup's result
+type cacheState int
+
+const (
+	// cacheAbsent entries must be fetched again before anything is served
+	cacheAbsent cacheState = iota
+
+	// cacheStale entries are served but refreshed in the background
+	cacheStale
+
+	// cacheFresh entries are served as they are
+	cacheFresh
+)
+
+// expiringWithin reports whether the entry expires in less than d. Zero d is
+// never.
+func (e cacheEntry) expiringWithin(d time.Duration, cfg config.Config) bool {
+	return d > 0 && time.Until(e.expiresAt(cfg)) < d
+}
+
+// expiresAt is when the entry expires
+func (e cacheEntry) expiresAt(cfg config.Config) time.Time {
+	if e.ExpiresAt.IsZero() {
+		// written before entries carried their expiry
+		return e.FetchedAt.Add(cfg.CacheTTL)
+	}
+
+	return e.ExpiresAt
+}
+
+// state classifies the entry at now. Entries are stale once they pass
+// cfg.CacheSoftTTL or expire, and absent once they've been expired for
+// cfg.CacheStaleTTL or pass cfg.CacheMaxAge.
+func (e cacheEntry) state(cfg config.Config, now time.Time) cacheState {
+	age := now.Sub(e.FetchedAt)
+	expiresAt := e.expiresAt(cfg)
+
+	switch {
+	case cfg.CacheMaxAge > 0 && age > cfg.CacheMaxAge:
+		return cacheAbsent
+	case !now.Before(expiresAt.Add(cfg.CacheStaleTTL)):
+		return cacheAbsent
+	case !now.Before(expiresAt):
+		return cacheStale
+	case cfg.CacheSoftTTL > 0 && age > cfg.CacheSoftTTL:
+		return cacheStale
+	}
+
+	return cacheFresh
+}
+
+// newCache builds the cache backend named by cfg.CacheBackend
+func newCache(cfg config.Config) (Cache, error) {
+	switch cfg.CacheBackend {
+	case "memory":
+		return Cache{cache.NewMemory(cfg.CacheCleanupInterval, countEviction), cfg.CacheStaleTTL}, nil
+	case "redis":
+		// kept past expiry as long as they can be served, or would be kept
+		// in memory
+		keepFor := cfg.CacheCleanupInterval
+		if cfg.CacheMaxAge > 0 {
+			keepFor = cfg.CacheMaxAge
+		}
+
+		backend, err := cache.NewRedis(cfg.RedisURL, keepFor, entryCodec{})
+		if err != nil {
+			return Cache{}, err
+		}
+
+		return Cache{backend, cfg.CacheStaleTTL}, nil
+	default:
+		return Cache{}, fmt.Errorf("unknown cache backend %q", cfg.CacheBackend)
+	}
+}
+
+// countEviction records an entry leaving the in-memory cache, either because
+// it expired or because it was deleted before then
+func countEviction(key string, expired bool) {
+	reason := "removed"
+	if expired {
+		reason = "expired"
+	}
+
+	cacheEvictionsTotal.WithLabelValues(reason).Inc()
+}
+
+// entryCodec stores cache entries as JSON, for Redis
+type entryCodec struct{}
+
+func (entryCodec) Marshal(value interface{}) ([]byte, error) {
+	return json.Marshal(value)
+}
+
+func (entryCodec) Unmarshal(data []byte) (interface{}, error) {
+	var entry cacheEntry
+	err := json.Unmarshal(data, &entry)
+	return entry, err
+}
+
+// cachedGet gets q through the cache, honouring the request's cache
+// directive: no-cache always fetches (bypassing any coalesced fetch) and then
+// caches the result, and no-store fetches without touching the cache at all
+func cachedGet(ctx context.Context, api capi.Client, q query, c Cache, cfg config.Config) (capi.Response, error) {
+	key := q.cacheKey()
+	t := timingsFrom(ctx)
+
+	fresh := freshnessFrom(ctx)
+	fresh.noteBackends(q.backends(cfg)...)
+
+	switch cacheDirectiveFrom(ctx) {
+	case cacheNoStore:
+		items, err := uncachedGet(ctx, api, q, cfg)
+		fresh.note(time.Now(), time.Time{})
+		return items, err
+	case cacheNoCache:
+		items, err := uncachedGet(ctx, api, q, cfg)
+		if err != nil {
+			return items, err
+		}
+
+		start := time.Now()
+		cacheSet(ctx, c, q, items, cfg)
+		t.addCache(start)
+		fresh.note(start, start.Add(cacheTTL(cfg, q)))
+
+		return items, nil
+	}
+
+	start := time.Now()
+	span := traceCache(ctx, "get", key)
+	entry, found := c.Get(key)
+	span.SetAttributes(attribute.Bool("onward.cache.found", found))
+	span.End()
+	t.addCache(start)
+
+	if found {
+		switch entry.state(cfg, time.Now()) {
+		case cacheFresh:
+			countLookup(t, "hit")
+			if entry.expiringWithin(cfg.RefreshAhead, cfg) {
+				revalidate(api, q, c, cfg)
+			}
+			fresh.note(entry.FetchedAt, entry.expiresAt(cfg))
+			return entry.Response, nil
+		case cacheStale:
+			if features.enabled(featureStaleServing) {
+				countLookup(t, "stale")
+				revalidate(api, q, c, cfg)
+				fresh.note(entry.FetchedAt, entry.expiresAt(cfg))
+				return entry.Response, nil
+			}
+		}
+	}
+
+	countLookup(t, "miss")
+
+	// get from CAPI, set cache and return
+	items, err := fetches.do(ctx, key, cfg.CoalesceWindow, cfg.RequestBudget, func(ctx context.Context) (capi.Response, error) {
+		return refetch(ctx, api, q, entry, found, cfg)
+	})
+
+	// with the breaker open, whatever's still cached beats an error, however
+	// long ago it expired, as long as it's within cfg.CacheMaxAge; it's
+	// replaced as soon as CAPI is back
+	if err != nil && found && capi.IsCircuitOpen(err) && (cfg.CacheMaxAge == 0 || time.Since(entry.FetchedAt) <= cfg.CacheMaxAge) {
+		log.Printf("CAPI circuit open, serving %s from an expired cache entry", q.Path)
+		fresh.note(entry.FetchedAt, time.Now())
+		return entry.Response, nil
+	}
+
+	// past the cache, an edition's last good list beats an error too
+	if err != nil && q.isEditionList() {
+		if list, ok := loadLastGood(ctx, q.Path); ok {
+			log.Printf("CAPI GET failed, serving %s's last-known-good list from %s: %s", q.Path, list.CapturedAt.Format(time.RFC3339), err)
+			fresh.noteLastGood(list.CapturedAt)
+			return list.Response, nil
+		}
+	}
+
+	if err != nil {
+		return items, errors.Wrap(err, "CAPI GET failed")
+	}
+
+	start = time.Now()
+	cacheSet(ctx, c, q, items, cfg)
+	t.addCache(start)
+	fresh.note(start, start.Add(cacheTTL(cfg, q)))
+
+	return items, nil
+}
+
+// revalidating holds the cache keys being refreshed in the background
+var revalidating sync.Map
+
+// revalidate refreshes a query's cache entry in the background, unless a
+// refresh of it is already under way, so there's only ever one per key. Its
+// fetch is shared with any misses for the key meanwhile.
+func revalidate(api capi.Client, q query, c Cache, cfg config.Config) {
+	key := q.cacheKey()
+	if _, busy := revalidating.LoadOrStore(key, true); busy {
+		return
+	}
+
+	started := workers.run(func(ctx context.Context) {
+		defer revalidating.Delete(key)
+
+		ctx, cancel := context.WithTimeout(ctx, cfg.RequestBudget)
+		defer cancel()
+
+		entry, found := c.Get(key)
+		items, err := fetches.do(ctx, key, cfg.CoalesceWindow, cfg.RequestBudget, func(ctx context.Context) (capi.Response, error) {
+			return refetch(ctx, api, q, entry, found, cfg)
+		})
+		if err != nil {
+			log.Printf("Unable to refresh %s, %s", q.Path, err)
+			return
+		}
+
+		cacheSet(ctx, c, q, items, cfg)
+	})
+
+	if !started {
+		revalidating.Delete(key)
+	}
+}
+
+// refetch fetches q from CAPI to replace its cache entry, if found. When the
+// entry has validators the GET is conditional, and if CAPI answers 304 the
+// entry's response comes back as it was, marked NotModified.
+func refetch(ctx context.Context, api capi.Client, q query, entry cacheEntry, found bool, cfg config.Config) (capi.Response, error) {
+	if !found || len(entry.Validators) == 0 {
+		return capiGet(ctx, api, q, cfg)
+	}
+
+	items, err := capiGet(capi.WithConditional(ctx, capi.Revalidation(entry.Validators)), api, q, cfg)
+	if err != nil || !items.NotModified {
+		return items, err
+	}
+
+	capiNotModifiedTotal.Inc()
+
+	response := entry.Response
+	response.NotModified = true
+
+	// a 304 needn't repeat the validators, and then the old ones still hold
+	response.Validators = items.Validators
+	if len(response.Validators) == 0 {
+		response.Validators = entry.Validators
+	}
+
+	return response, nil
+}
+
+// countLookup records a cache lookup result in the metrics and the request's
+// timings
+func countLookup(t *timings, result string) {
+	cacheLookupsTotal.WithLabelValues(result).Inc()
+	t.noteLookup(result)
+}
+
+// cacheSet caches a fetched response. One CAPI said was unchanged is cached
+// again as if it had just been fetched, with its TTL starting over, but isn't
+// recorded as a new list.
+func cacheSet(ctx context.Context, c Cache, q query, items capi.Response, cfg config.Config) {
+	entry := newCacheEntry(items, nil, cacheTTL(cfg, q))
+	entry.Validators = items.Validators
+
+	span := traceCache(ctx, "set", q.cacheKey())
+	store(c, q.cacheKey(), entry, cfg)
+	span.End()
+
+	if q.isEditionList() && !items.NotModified {
+		if editionSnapshots.record(q.Path, items.Response.Results) {
+			listChanges.publish(q.Path, items)
+		}
+		saveLastGood(q.Path, items)
+	}
+}
+
+// store caches entry under key, unless it serialises to more than
+// cfg.MaxEntryBytes, in which case it's counted and left uncached so one huge
+// response can't take over the cache
+func store(c Cache, key string, entry cacheEntry, cfg config.Config) {
+	if cfg.MaxEntryBytes > 0 {
+		if size := len(asJSON(entry)); size > cfg.MaxEntryBytes {
+			log.Printf("Not caching %s, %d bytes is over the %d byte limit", key, size, cfg.MaxEntryBytes)
+			cacheOversizedTotal.Inc()
+			return
+		}
+	}
+
+	c.Set(key, entry)
+}
+
+// uncachedGet fetches q straight from CAPI
+func uncachedGet(ctx context.Context, api capi.Client, q query, cfg config.Config) (capi.Response, error) {
+	items, err := capiGet(ctx, api, q, cfg)
+	if err != nil {
+		return items, errors.Wrap(err, "CAPI GET failed")
+	}
+
+	return items, nil
+}
+
+// cachedFetch is cachedGet for arbitrary CAPI queries, caching the raw body
+func cachedFetch(ctx context.Context, api capi.Client, path string, params url.Values, c Cache, cfg config.Config) ([]byte, error) {
+	key := "capi:" + path + "?" + params.Encode()
+	t := timingsFrom(ctx)
+	directive := cacheDirectiveFrom(ctx)
+
+	start := time.Now()
+	entry, found := c.Get(key)
+	t.addCache(start)
+
+	if found && directive == cacheDefault {
+		if entry.state(cfg, time.Now()) != cacheAbsent {
+			countLookup(t, "hit")
+			return entry.Body, nil
+		}
+
+		c.Delete(key)
+	}
+
+	countLookup(t, "miss")
+
+	body, err := capi.Fetch(ctx, api, path, params)
+	if err != nil {
+		return nil, errors.Wrap(err, "CAPI GET failed")
+	}
+
+	if directive == cacheNoStore {
+		return body, nil
+	}
+
+	start = time.Now()
+	store(c, key, newCacheEntry(capi.Response{}, body, cfg.CacheTTL), cfg)
+	t.addCache(start)
+
+	return body, nil
+}
@@ -0,0 +1,590 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/guardian/onward/cache"
+	"github.com/guardian/onward/capi"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// recordingCache is an in-memory cache backend that records the calls made
+// to it
+type recordingCache struct {
+	mu      sync.Mutex
+	entries map[string]interface{}
+	calls   []string
+}
+
+func newRecordingCache() *recordingCache {
+	return &recordingCache{entries: map[string]interface{}{}}
+}
+
+// cache is the recording cache as the service uses it
+func (c *recordingCache) cache() Cache {
+	return Cache{Cache: c}
+}
+
+func (c *recordingCache) record(call string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.calls = append(c.calls, call)
+}
+
+// made are the calls made so far, e.g. "get uk", and forgets them
+func (c *recordingCache) made() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	calls := c.calls
+	c.calls = nil
+	if calls == nil {
+		calls = []string{}
+	}
+	return calls
+}
+
+// entry is the entry cached under key, without recording a call
+func (c *recordingCache) entry(key string) (cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key].(cacheEntry)
+	return entry, ok
+}
+
+func (c *recordingCache) Get(key string) (interface{}, bool) {
+	c.record("get " + key)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	value, ok := c.entries[key]
+	return value, ok
+}
+
+func (c *recordingCache) Set(key string, value interface{}, expiresAt time.Time) {
+	c.record("set " + key)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = value
+}
+
+func (c *recordingCache) Delete(key string) {
+	c.record("delete " + key)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, key)
+}
+
+func (c *recordingCache) Ping(context.Context) error { return nil }
+
+func (c *recordingCache) Keys(context.Context) ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	keys := []string{}
+	for key := range c.entries {
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (c *recordingCache) Flush(context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = map[string]interface{}{}
+	return nil
+}
+
+func (c *recordingCache) Close() {}
+
+var _ cache.Cache = &recordingCache{}
+
+// useWorkers runs background work in its own group until the test ends, and
+// returns it so the test can wait on its wg for the work to finish
+func useWorkers(t testing.TB) *background {
+	saved := workers
+	workers = newBackground()
+	t.Cleanup(func() {
+		workers.stop(time.Second)
+		workers = saved
+	})
+
+	return workers
+}
+
+func TestCachedGet(t *testing.T) {
+	stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(mostViewedBody("a", "b")))
+	})
+	cfg := testConfig(t, stub.URL)
+	c := newRecordingCache()
+	q := query{Path: "uk/film", MostViewed: true}
+	key := q.cacheKey()
+
+	items, err := cachedGet(context.Background(), testCAPI(cfg), q, c.cache(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := itemIDs(items.Response.Results); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("got %v, want CAPI's list", got)
+	}
+	if got, want := c.made(), []string{"get " + key, "set " + key}; !reflect.DeepEqual(got, want) {
+		t.Errorf("a miss made %v, want %v", got, want)
+	}
+
+	entry, _ := c.entry(key)
+	if ttl := entry.ExpiresAt.Sub(entry.FetchedAt); ttl != cfg.CacheTTL {
+		t.Errorf("cached for %s, want the cache TTL %s", ttl, cfg.CacheTTL)
+	}
+
+	if _, err := cachedGet(context.Background(), testCAPI(cfg), q, c.cache(), cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := c.made(), []string{"get " + key}; !reflect.DeepEqual(got, want) {
+		t.Errorf("a hit made %v, want %v", got, want)
+	}
+	if calls := stub.requests(); calls != 1 {
+		t.Errorf("CAPI got %d requests, want 1", calls)
+	}
+}
+
+func TestCachedGetDirectives(t *testing.T) {
+	tests := []struct {
+		name      string
+		directive cacheDirective
+		calls     []string
+	}{
+		{"no-cache", cacheNoCache, []string{"set "}},
+		{"no-store", cacheNoStore, []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(mostViewedBody("fetched")))
+			})
+			cfg := testConfig(t, stub.URL)
+			c := newRecordingCache()
+			q := query{Path: "uk/music", MostViewed: true}
+			c.entries[q.cacheKey()] = newCacheEntry(capi.Response{}, nil, time.Hour)
+
+			items, err := cachedGet(withCacheDirective(context.Background(), tt.directive), testCAPI(cfg), q, c.cache(), cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := itemIDs(items.Response.Results); !reflect.DeepEqual(got, []string{"fetched"}) {
+				t.Errorf("got %v, want CAPI's list, not the cached one", got)
+			}
+
+			want := []string{}
+			for _, call := range tt.calls {
+				want = append(want, call+q.cacheKey())
+			}
+			if got := c.made(); !reflect.DeepEqual(got, want) {
+				t.Errorf("made %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestStaleEntriesAreRevalidated(t *testing.T) {
+	stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(mostViewedBody("new")))
+	})
+	cfg := testConfig(t, stub.URL)
+	cfg.CacheSoftTTL = time.Minute
+	bg := useWorkers(t)
+
+	c := newRecordingCache()
+	q := query{Path: "uk/books", MostViewed: true}
+	key := q.cacheKey()
+	stale := newCacheEntry(capi.Response{}, nil, cfg.CacheTTL)
+	stale.Response.Response.Results = capiItems("old")
+	stale.FetchedAt = stale.FetchedAt.Add(-2 * time.Minute)
+	c.entries[key] = stale
+
+	items, err := cachedGet(context.Background(), testCAPI(cfg), q, c.cache(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := itemIDs(items.Response.Results); !reflect.DeepEqual(got, []string{"old"}) {
+		t.Errorf("got %v, want the stale entry served", got)
+	}
+
+	bg.wg.Wait()
+
+	// the lookup, then the background refresh's own lookup and its set
+	if got, want := c.made(), []string{"get " + key, "get " + key, "set " + key}; !reflect.DeepEqual(got, want) {
+		t.Errorf("made %v, want %v", got, want)
+	}
+	entry, _ := c.entry(key)
+	if got := itemIDs(entry.Response.Response.Results); !reflect.DeepEqual(got, []string{"new"}) {
+		t.Errorf("revalidated to %v, want CAPI's list", got)
+	}
+}
+
+func TestExpiredEntriesAreServedWithinStaleTTL(t *testing.T) {
+	tests := []struct {
+		name     string
+		staleTTL time.Duration
+		expired  time.Duration
+		want     string
+	}{
+		{name: "within the stale TTL", staleTTL: time.Minute, expired: 30 * time.Second, want: "old"},
+		{name: "past the stale TTL", staleTTL: time.Minute, expired: 2 * time.Minute, want: "new"},
+		{name: "no stale TTL", expired: time.Second, want: "new"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(mostViewedBody("new")))
+			})
+			cfg := testConfig(t, stub.URL)
+			cfg.CacheStaleTTL = tt.staleTTL
+			bg := useWorkers(t)
+
+			c := newRecordingCache()
+			q := query{Path: "uk/books", MostViewed: true}
+			expired := newCacheEntry(capi.Response{}, nil, -tt.expired)
+			expired.Response.Response.Results = capiItems("old")
+			c.entries[q.cacheKey()] = expired
+
+			items, err := cachedGet(context.Background(), testCAPI(cfg), q, c.cache(), cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := itemIDs(items.Response.Results); !reflect.DeepEqual(got, []string{tt.want}) {
+				t.Errorf("got %v, want %v", got, []string{tt.want})
+			}
+
+			bg.wg.Wait()
+			entry, _ := c.entry(q.cacheKey())
+			if got := itemIDs(entry.Response.Response.Results); !reflect.DeepEqual(got, []string{"new"}) {
+				t.Errorf("cached %v afterwards, want CAPI's list", got)
+			}
+		})
+	}
+}
+
+func TestCacheMaxAge(t *testing.T) {
+	tests := []struct {
+		name      string
+		maxAge    time.Duration
+		staleTTL  time.Duration
+		age       time.Duration
+		expiresIn time.Duration
+		want      string
+		calls     int64
+	}{
+		{name: "no max age", age: 2 * time.Hour, expiresIn: time.Hour, want: "old"},
+		{name: "younger than the max age", maxAge: time.Hour, age: 30 * time.Minute, expiresIn: time.Hour, want: "old"},
+		{name: "older than the max age, unexpired", maxAge: time.Hour, age: 2 * time.Hour, expiresIn: time.Hour, want: "new", calls: 1},
+		{name: "older than the max age, within the stale TTL", maxAge: time.Hour, staleTTL: time.Hour, age: 2 * time.Hour, expiresIn: -time.Minute, want: "new", calls: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(mostViewedBody("new")))
+			})
+			cfg := testConfig(t, stub.URL)
+			cfg.CacheMaxAge = tt.maxAge
+			cfg.CacheStaleTTL = tt.staleTTL
+			bg := useWorkers(t)
+
+			c := newRecordingCache()
+			q := query{Path: "uk/travel", MostViewed: true}
+			now := time.Now()
+			old := cacheEntry{FetchedAt: now.Add(-tt.age), ExpiresAt: now.Add(tt.expiresIn)}
+			old.Response.Response.Results = capiItems("old")
+			c.entries[q.cacheKey()] = old
+
+			items, err := cachedGet(context.Background(), testCAPI(cfg), q, c.cache(), cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			bg.wg.Wait()
+
+			if got := itemIDs(items.Response.Results); !reflect.DeepEqual(got, []string{tt.want}) {
+				t.Errorf("got %v, want %v", got, []string{tt.want})
+			}
+			if calls := stub.requests(); calls != tt.calls {
+				t.Errorf("CAPI got %d requests, want %d", calls, tt.calls)
+			}
+		})
+	}
+}
+
+func TestRevalidateRunsOncePerKey(t *testing.T) {
+	release := make(chan struct{})
+	stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Write([]byte(mostViewedBody("a")))
+	})
+	cfg := testConfig(t, stub.URL)
+	bg := useWorkers(t)
+	c := newRecordingCache()
+	q := query{Path: "uk/travel", MostViewed: true}
+
+	for i := 0; i < 5; i++ {
+		revalidate(testCAPI(cfg), q, c.cache(), cfg)
+	}
+	close(release)
+
+	bg.wg.Wait()
+	if calls := stub.requests(); calls != 1 {
+		t.Errorf("CAPI got %d requests, want 1", calls)
+	}
+	if _, ok := c.entry(q.cacheKey()); !ok {
+		t.Error("revalidation cached nothing")
+	}
+}
+
+func TestRefreshAhead(t *testing.T) {
+	tests := []struct {
+		name         string
+		refreshAhead time.Duration
+		refreshes    int64
+	}{
+		{"off", 0, 0},
+		{"not close to expiry", 5 * time.Second, 0},
+		{"close to expiry", 30 * time.Second, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			release := make(chan struct{})
+			stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+				<-release
+				w.Write([]byte(mostViewedBody("new")))
+			})
+			cfg := testConfig(t, stub.URL)
+			cfg.RefreshAhead = tt.refreshAhead
+			bg := useWorkers(t)
+
+			// fresh for another 10s
+			c := newRecordingCache()
+			q := query{Path: "uk/film", MostViewed: true}
+			entry := newCacheEntry(capi.Response{}, nil, 10*time.Second)
+			entry.Response.Response.Results = capiItems("old")
+			c.entries[q.cacheKey()] = entry
+
+			// every hit is served from the cache without waiting on CAPI
+			for i := 0; i < 5; i++ {
+				items, err := cachedGet(context.Background(), testCAPI(cfg), q, c.cache(), cfg)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := itemIDs(items.Response.Results); !reflect.DeepEqual(got, []string{"old"}) {
+					t.Fatalf("got %v, want the cached entry", got)
+				}
+			}
+			close(release)
+			bg.wg.Wait()
+
+			if calls := stub.requests(); calls != tt.refreshes {
+				t.Errorf("CAPI got %d requests, want %d", calls, tt.refreshes)
+			}
+			want := []string{"old"}
+			if tt.refreshes > 0 {
+				want = []string{"new"}
+			}
+			cached, _ := c.entry(q.cacheKey())
+			if got := itemIDs(cached.Response.Response.Results); !reflect.DeepEqual(got, want) {
+				t.Errorf("the cache has %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestRefetchRevalidatesWithValidators(t *testing.T) {
+	stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v2"`)
+		w.Write([]byte(mostViewedBody("changed")))
+	})
+	cfg := testConfig(t, stub.URL)
+	q := query{Path: "uk/money", MostViewed: true}
+
+	entry := newCacheEntry(capi.Response{}, nil, cfg.CacheTTL)
+	entry.Response.Response.Results = capiItems("cached")
+	entry.Validators = http.Header{"Etag": {`"v1"`}}
+
+	items, err := refetch(context.Background(), testCAPI(cfg), q, entry, true, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !items.NotModified || !reflect.DeepEqual(itemIDs(items.Response.Results), []string{"cached"}) {
+		t.Errorf("got %v (not modified %v), want the cached list back unchanged", itemIDs(items.Response.Results), items.NotModified)
+	}
+	if got := items.Validators.Get("ETag"); got != `"v1"` {
+		t.Errorf("kept ETag %q, want the old one", got)
+	}
+
+	entry.Validators = http.Header{"Etag": {`"v0"`}}
+	if items, err = refetch(context.Background(), testCAPI(cfg), q, entry, true, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if items.NotModified || !reflect.DeepEqual(itemIDs(items.Response.Results), []string{"changed"}) {
+		t.Errorf("got %v (not modified %v), want CAPI's new list", itemIDs(items.Response.Results), items.NotModified)
+	}
+	if got := items.Validators.Get("ETag"); got != `"v2"` {
+		t.Errorf("got ETag %q, want CAPI's new one", got)
+	}
+}
+
+func TestCacheSet(t *testing.T) {
+	cfg := testConfig(t, "")
+	q := query{Path: "uk/science", MostViewed: true}
+	items := capi.Response{Validators: http.Header{"Etag": {`"v1"`}}}
+	items.Response.Results = capiItems("a")
+
+	c := newRecordingCache()
+	cacheSet(context.Background(), c.cache(), q, items, cfg)
+
+	entry, ok := c.entry(q.cacheKey())
+	if !ok {
+		t.Fatal("nothing cached")
+	}
+	if got := entry.Validators.Get("ETag"); got != `"v1"` {
+		t.Errorf("cached ETag %q, want CAPI's", got)
+	}
+	if ttl := entry.ExpiresAt.Sub(entry.FetchedAt); ttl != cacheTTL(cfg, q) {
+		t.Errorf("cached for %s, want %s", ttl, cacheTTL(cfg, q))
+	}
+
+	cfg.MaxEntryBytes = 10
+	c = newRecordingCache()
+	cacheSet(context.Background(), c.cache(), q, items, cfg)
+	if got := c.made(); len(got) != 0 {
+		t.Errorf("an oversized entry made %v, want it left uncached", got)
+	}
+}
+
+func TestOversizedEntriesAreServedUncached(t *testing.T) {
+	stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(mostViewedBody("a", "b", "c")))
+	})
+
+	tests := []struct {
+		name          string
+		maxEntryBytes int
+		requests      int64
+		oversized     float64
+	}{
+		{"no limit", 0, 1, 0},
+		{"under the limit", 1 << 20, 1, 0},
+		{"over the limit", 100, 2, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig(t, stub.URL)
+			cfg.MaxEntryBytes = tt.maxEntryBytes
+			h := mostViewedHandler(testCAPI(cfg), testCache(t, cfg), cfg)
+
+			before := stub.requests()
+			oversizedBefore := testutil.ToFloat64(cacheOversizedTotal)
+
+			for i := 0; i < 2; i++ {
+				w := serve(h, "/most-viewed/uk")
+				if w.Code != http.StatusOK {
+					t.Fatalf("got %d, want 200: %s", w.Code, w.Body)
+				}
+				if got := decodeTrails(t, w).urls(); !reflect.DeepEqual(got, []string{"a", "b", "c"}) {
+					t.Errorf("got trails %v, want CAPI's", got)
+				}
+			}
+
+			if calls := stub.requests() - before; calls != tt.requests {
+				t.Errorf("CAPI got %d requests, want %d", calls, tt.requests)
+			}
+			if oversized := testutil.ToFloat64(cacheOversizedTotal) - oversizedBefore; oversized != tt.oversized {
+				t.Errorf("counted %v oversized entries, want %v", oversized, tt.oversized)
+			}
+		})
+	}
+}
+
+func TestEvictionMetrics(t *testing.T) {
+	cfg := testConfig(t, "http://capi.invalid")
+	cfg.CacheCleanupInterval = 5 * time.Millisecond
+	cfg.CacheStaleTTL = 0
+	c := testCache(t, cfg)
+
+	expiredBefore := testutil.ToFloat64(cacheEvictionsTotal.WithLabelValues("expired"))
+	removedBefore := testutil.ToFloat64(cacheEvictionsTotal.WithLabelValues("removed"))
+
+	c.Set("expiring", cacheEntry{ExpiresAt: time.Now().Add(5 * time.Millisecond)})
+	c.Set("deleted", cacheEntry{ExpiresAt: time.Now().Add(time.Hour)})
+	c.Set("fresh", cacheEntry{ExpiresAt: time.Now().Add(time.Hour)})
+	c.Delete("deleted")
+
+	deadline := time.Now().Add(time.Second)
+	for testutil.ToFloat64(cacheEvictionsTotal.WithLabelValues("expired")) == expiredBefore && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if expired := testutil.ToFloat64(cacheEvictionsTotal.WithLabelValues("expired")) - expiredBefore; expired != 1 {
+		t.Errorf("counted %v expiries, want 1", expired)
+	}
+	if removed := testutil.ToFloat64(cacheEvictionsTotal.WithLabelValues("removed")) - removedBefore; removed != 1 {
+		t.Errorf("counted %v removals, want 1", removed)
+	}
+}
+
+func TestCacheEntryState(t *testing.T) {
+	now := time.Now()
+	cfg := testConfig(t, "http://capi.invalid")
+	cfg.CacheTTL = time.Minute
+	cfg.CacheStaleTTL = 10 * time.Minute
+
+	tests := []struct {
+		name      string
+		fetched   time.Duration
+		expiresIn time.Duration
+		softTTL   time.Duration
+		maxAge    time.Duration
+		want      cacheState
+	}{
+		{name: "fresh", fetched: -30 * time.Second, expiresIn: 30 * time.Second, want: cacheFresh},
+		{name: "just expired", fetched: -time.Minute, expiresIn: 0, want: cacheStale},
+		{name: "expired within the stale TTL", fetched: -5 * time.Minute, expiresIn: -4 * time.Minute, want: cacheStale},
+		{name: "expired past the stale TTL", fetched: -20 * time.Minute, expiresIn: -19 * time.Minute, want: cacheAbsent},
+		{name: "past the soft TTL", fetched: -30 * time.Second, expiresIn: 30 * time.Second, softTTL: 20 * time.Second, want: cacheStale},
+		{name: "past the max age", fetched: -5 * time.Minute, expiresIn: -4 * time.Minute, maxAge: 2 * time.Minute, want: cacheAbsent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := cfg
+			cfg.CacheSoftTTL = tt.softTTL
+			cfg.CacheMaxAge = tt.maxAge
+			entry := cacheEntry{FetchedAt: now.Add(tt.fetched), ExpiresAt: now.Add(tt.expiresIn)}
+
+			if got := entry.state(cfg, now); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// entries cached before they carried an expiry expire a TTL after they
+	// were fetched
+	if got := (cacheEntry{FetchedAt: now.Add(-2 * time.Minute)}).state(cfg, now); got != cacheStale {
+		t.Errorf("an entry without an expiry fetched two TTLs ago is %v, want stale", got)
+	}
+}
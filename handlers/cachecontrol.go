@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// cacheDirective is how a request asks for the cache to be used
+type cacheDirective int
+
+const (
+	// cacheDefault uses the cache as normal
+	cacheDefault cacheDirective = iota
+
+	// cacheNoCache skips cached entries but still caches what's fetched
+	cacheNoCache
+
+	// cacheNoStore neither reads from nor writes to the cache
+	cacheNoStore
+)
+
+type cacheDirectiveKey struct{}
+
+// requestCacheDirective reads a request's Cache-Control header. no-store wins
+// over no-cache if both are sent.
+func requestCacheDirective(r *http.Request) cacheDirective {
+	directive := cacheDefault
+
+	for _, token := range strings.Split(r.Header.Get("Cache-Control"), ",") {
+		switch strings.ToLower(strings.TrimSpace(token)) {
+		case "no-store":
+			return cacheNoStore
+		case "no-cache":
+			directive = cacheNoCache
+		}
+	}
+
+	return directive
+}
+
+func withCacheDirective(ctx context.Context, d cacheDirective) context.Context {
+	return context.WithValue(ctx, cacheDirectiveKey{}, d)
+}
+
+// cacheDirectiveFrom returns the request's cache directive, cacheDefault if
+// it didn't send one
+func cacheDirectiveFrom(ctx context.Context) cacheDirective {
+	d, _ := ctx.Value(cacheDirectiveKey{}).(cacheDirective)
+	return d
+}
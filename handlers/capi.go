@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"context"
+	"net/url"
+	"regexp"
+
+	"github.com/guardian/onward/capi"
+	"github.com/guardian/onward/config"
+	"github.com/pkg/errors"
+)
+
+// query describes a CAPI request
+type query struct {
+	Path string
+
+	// MostViewed asks for the most-viewed ordering rather than the path's
+	// regular results
+	MostViewed bool
+
+	// Tag optionally scopes the results to a CAPI tag, e.g. football/football
+	Tag string
+
+	// Related asks for the content related to the item at Path instead
+	Related bool
+
+	// MostCommented asks for the most-commented list, from the Discussion
+	// API, instead of anything under Path
+	MostCommented bool
+
+	// Ophan asks for Ophan's most-read list instead of CAPI's most-viewed
+	Ophan bool
+}
+
+// isEditionList reports whether q is for an edition's own most-viewed list,
+// with no tag
+func (q query) isEditionList() bool {
+	return config.IsEdition(q.Path) && q.MostViewed && q.Tag == ""
+}
+
+var tagPattern = regexp.MustCompile(`^[a-z0-9-]+/[a-z0-9-]+$`)
+
+// validTag reports whether tag looks like a CAPI tag ID: lowercase letters,
+// digits and hyphens either side of a single slash
+func validTag(tag string) bool {
+	return tagPattern.MatchString(tag)
+}
+
+// params are the CAPI parameters for the query, bar the API key
+func (q query) params() url.Values {
+	params := url.Values{}
+	params.Set("show-fields", "headline,byline,showByline,thumbnail,liveBloggingNow")
+	params.Set("show-tags", "tone")
+	params.Set("show-elements", "image")
+
+	if q.MostViewed {
+		params.Set("show-most-viewed", "true")
+	}
+
+	if q.Tag != "" {
+		params.Set("tag", q.Tag)
+	}
+
+	if q.Related {
+		params.Set("show-related", "true")
+	}
+
+	return params
+}
+
+// cacheKey identifies the cache entry for a query's response. It's built from
+// everything sent upstream, so queries only share an entry if CAPI would give
+// them the same response. Output format isn't part of it as responses are
+// cached before rendering.
+func (q query) cacheKey() string {
+	switch {
+	case q.MostCommented:
+		return "discussion:most-commented"
+	case q.Ophan:
+		return "ophan:most-read"
+	}
+
+	return q.Path + "?" + q.params().Encode()
+}
+
+// backendHeader names the upstreams a list came from, so a client can tell
+// live data from preview's
+const backendHeader = "X-Backend"
+
+// backends are the upstreams q's response comes from: the CAPI host, as
+// well as Ophan or the Discussion API for the lists they rank and CAPI fills
+// in
+func (q query) backends(cfg config.Config) []string {
+	host := cfg.CAPIURL
+	if base, err := url.Parse(cfg.CAPIURL); err == nil {
+		host = base.Host
+	}
+
+	switch {
+	case q.MostCommented:
+		return []string{"discussion", host}
+	case q.Ophan:
+		return []string{"ophan", host}
+	}
+
+	return []string{host}
+}
+
+// capiGet fetches the list q asks for, from CAPI with api or, for the lists
+// they rank, from the Discussion API or Ophan
+func capiGet(ctx context.Context, api capi.Client, q query, cfg config.Config) (capi.Response, error) {
+	switch {
+	case q.MostCommented:
+		return mostCommentedGet(ctx, api, cfg)
+	case q.Ophan:
+		return ophanGet(ctx, api, cfg)
+	}
+
+	// a conditional GET if the caller is revalidating, and either way noting
+	// the validators CAPI sends back
+	cond := capi.ConditionalFrom(ctx)
+	if cond == nil {
+		cond = capi.Revalidation(nil)
+		ctx = capi.WithConditional(ctx, cond)
+	}
+
+	response, err := capi.Get(ctx, api, q.Path, q.params())
+	if err != nil {
+		return response, err
+	}
+
+	response.Validators = cond.Validators
+	if cond.NotModified && len(cond.Request) == 0 {
+		return response, capi.UpstreamError(errors.New("status 304"), "Unexpected CAPI response")
+	}
+	if cond.NotModified {
+		response.NotModified = true
+		return response, nil
+	}
+
+	switch {
+	case q.Related:
+		response.Response.Results = response.Response.Related
+	case !q.MostViewed:
+		// without show-most-viewed the regular results are the list
+		response.Response.Results = response.Response.Latest
+	}
+
+	return response, err
+}
+
+// fallsBackToLatest reports whether an empty most-viewed list for path should
+// be replaced by the path's latest content
+func fallsBackToLatest(cfg config.Config, path string) bool {
+	for _, p := range cfg.LatestFallback {
+		if p == path {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// recordingCAPI is a stub CAPI answering every request with body, which
+// keeps the query each request was made with
+type recordingCAPI struct {
+	*stubCAPI
+
+	mu      sync.Mutex
+	queries []url.Values
+}
+
+func newRecordingCAPI(t *testing.T, body string) *recordingCAPI {
+	t.Helper()
+
+	rc := &recordingCAPI{}
+	rc.stubCAPI = newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		rc.mu.Lock()
+		rc.queries = append(rc.queries, r.URL.Query())
+		rc.mu.Unlock()
+
+		w.Write([]byte(body))
+	})
+
+	return rc
+}
+
+// last is the query of the latest request CAPI got
+func (rc *recordingCAPI) last() url.Values {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if len(rc.queries) == 0 {
+		return nil
+	}
+	return rc.queries[len(rc.queries)-1]
+}
+
+func TestMostViewedToggle(t *testing.T) {
+	stub := newRecordingCAPI(t, `{"response":{"status":"ok",
+		"mostViewed":[{"id":"most-viewed","type":"article"}],
+		"results":[{"id":"latest","type":"article"}]}}`)
+	cfg := testConfig(t, stub.URL)
+
+	tests := []struct {
+		target string
+		sent   string
+		urls   []string
+	}{
+		{"/most-viewed/uk", "true", []string{"most-viewed"}},
+		{"/most-viewed/uk?most-viewed=true", "true", []string{"most-viewed"}},
+		{"/most-viewed/uk?most-viewed=false", "", []string{"latest"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			w := serve(mostViewedHandler(testCAPI(cfg), testCache(t, cfg), cfg), tt.target)
+			if w.Code != http.StatusOK {
+				t.Fatalf("got %d, want 200: %s", w.Code, w.Body)
+			}
+
+			if got := stub.last().Get("show-most-viewed"); got != tt.sent {
+				t.Errorf("sent show-most-viewed=%q, want %q", got, tt.sent)
+			}
+			if got := decodeTrails(t, w).urls(); !reflect.DeepEqual(got, tt.urls) {
+				t.Errorf("got trails %v, want %v", got, tt.urls)
+			}
+		})
+	}
+}
+
+func TestCacheKeys(t *testing.T) {
+	keys := map[string]query{}
+	for _, q := range []query{
+		{Path: "uk", MostViewed: true},
+		{Path: "uk"},
+		{Path: "uk", MostViewed: true, Tag: "politics/politics"},
+		{Path: "uk/sport", MostViewed: true},
+		{Path: "politics/2026/oct/14/budget", Related: true},
+		{Path: "uk", MostCommented: true},
+		{Path: "uk", Ophan: true},
+	} {
+		key := q.cacheKey()
+		if other, ok := keys[key]; ok {
+			t.Errorf("%+v and %+v share the cache key %s", q, other, key)
+		}
+		keys[key] = q
+	}
+
+	if (query{Path: "uk", MostViewed: true}).cacheKey() != (query{Path: "uk", MostViewed: true}).cacheKey() {
+		t.Error("the same query has different cache keys")
+	}
+}
+
+func TestRequestsWithTheSameUpstreamQueryShareAnEntry(t *testing.T) {
+	stub := newRecordingCAPI(t, mostViewedBody("a", "b"))
+	cfg := testConfig(t, stub.URL)
+	h := mostViewedHandler(testCAPI(cfg), testCache(t, cfg), cfg)
+
+	targets := []struct {
+		target string
+		calls  int64
+	}{
+		{"/most-viewed/uk", 1},
+		{"/most-viewed/uk?tag=politics/politics", 2},
+		{"/most-viewed/uk?most-viewed=false", 3},
+		// limits and formats are applied to the cached list
+		{"/most-viewed/uk?limit=1", 3},
+		{"/most-viewed/uk?format=csv", 3},
+		{"/most-viewed/uk?tag=politics/politics&limit=1", 3},
+	}
+
+	for _, tt := range targets {
+		if w := serve(h, tt.target); w.Code != http.StatusOK {
+			t.Fatalf("%s got %d, want 200: %s", tt.target, w.Code, w.Body)
+		}
+		if calls := stub.requests(); calls != tt.calls {
+			t.Errorf("after %s CAPI got %d requests, want %d", tt.target, calls, tt.calls)
+		}
+	}
+}
+
+func TestTagFilter(t *testing.T) {
+	stub := newRecordingCAPI(t, mostViewedBody("a"))
+	cfg := testConfig(t, stub.URL)
+	h := mostViewedHandler(testCAPI(cfg), testCache(t, cfg), cfg)
+
+	tests := []struct {
+		tag    string
+		status int
+	}{
+		{"football/football", http.StatusOK},
+		{"politics/eu-referendum", http.StatusOK},
+		{"", http.StatusOK},
+		{"football", http.StatusBadRequest},
+		{"football/football/extra", http.StatusBadRequest},
+		{"Football/Football", http.StatusBadRequest},
+		{"football/foot ball", http.StatusBadRequest},
+		{"football/football,politics/politics", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.tag, func(t *testing.T) {
+			before := stub.requests()
+			w := serve(h, "/most-viewed/uk?tag="+url.QueryEscape(tt.tag))
+			if w.Code != tt.status {
+				t.Fatalf("got %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status != http.StatusOK {
+				if stub.requests() != before {
+					t.Error("an invalid tag was sent to CAPI")
+				}
+				return
+			}
+
+			// each tag, and no tag, is a list of its own
+			if stub.requests() != before+1 {
+				t.Fatalf("CAPI got %d requests, want this tag's own", stub.requests()-before)
+			}
+			sent := stub.last()
+			if got, ok := sent["tag"]; tt.tag != "" && (len(got) != 1 || got[0] != tt.tag) || tt.tag == "" && ok {
+				t.Errorf("sent tag %v, want %q", got, tt.tag)
+			}
+		})
+	}
+}
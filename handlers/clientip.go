@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// clientIP is the address a request came from. X-Forwarded-For is only
+// believed when the request arrives from one of the trusted proxies, and then
+// read from the right, skipping hops that are themselves trusted, so a client
+// can't pick its own address by sending the header. Otherwise it's the
+// connection's RemoteAddr.
+func clientIP(r *http.Request, trusted []*net.IPNet) string {
+	remote, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remote = r.RemoteAddr
+	}
+
+	if !inNetworks(remote, trusted) {
+		return remote
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+
+		remote = hop
+		if !inNetworks(hop, trusted) {
+			break
+		}
+	}
+
+	return remote
+}
+
+// inNetworks reports whether addr is an IP in any of networks
+func inNetworks(addr string, networks []*net.IPNet) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
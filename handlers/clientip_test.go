@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"net"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	var trusted []*net.IPNet
+	for _, cidr := range []string{"10.0.0.0/8", "fd00::/8"} {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		trusted = append(trusted, network)
+	}
+
+	tests := []struct {
+		name   string
+		remote string
+		xff    []string
+		want   string
+	}{
+		{"no proxy", "203.0.113.7:1234", nil, "203.0.113.7"},
+		{"untrusted proxy", "203.0.113.7:1234", []string{"198.51.100.1"}, "203.0.113.7"},
+		{"trusted proxy", "10.0.0.1:1234", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"trusted proxy, no header", "10.0.0.1:1234", nil, "10.0.0.1"},
+		{"multiple hops", "10.0.0.1:1234", []string{"192.0.2.9, 198.51.100.1, 10.0.0.2"}, "198.51.100.1"},
+		{"multiple headers", "10.0.0.1:1234", []string{"192.0.2.9", "198.51.100.1, 10.0.0.2"}, "198.51.100.1"},
+		{"spoofed first hop", "10.0.0.1:1234", []string{"10.9.9.9, 198.51.100.1"}, "198.51.100.1"},
+		{"all hops trusted", "10.0.0.1:1234", []string{"10.0.0.3, 10.0.0.2"}, "10.0.0.3"},
+		{"garbage hop", "10.0.0.1:1234", []string{"198.51.100.1, not-an-ip"}, "10.0.0.1"},
+		{"IPv6 trusted proxy", "[fd00::1]:1234", []string{"2001:db8::5"}, "2001:db8::5"},
+		{"no port", "203.0.113.7", nil, "203.0.113.7"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/most-viewed/uk", nil)
+			r.RemoteAddr = tt.remote
+			for _, xff := range tt.xff {
+				r.Header.Add("X-Forwarded-For", xff)
+			}
+
+			if got := clientIP(r, trusted); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/guardian/onward/capi"
+	"github.com/pkg/errors"
+)
+
+// coalescer shares one CAPI fetch among every request for the same key that
+// arrives while it's in flight, or within a short window after it finishes,
+// so a burst of misses makes a single upstream call
+type coalescer struct {
+	mu    sync.Mutex
+	calls map[string]*coalescedCall
+}
+
+type coalescedCall struct {
+	done     chan struct{}
+	finished time.Time
+	response capi.Response
+	err      error
+}
+
+// fetches coalesces cache-miss fetches
+var fetches = &coalescer{calls: map[string]*coalescedCall{}}
+
+// do returns the result of fetch for key, joining a call already in flight
+// or one that succeeded less than window ago rather than starting another.
+// Failures are shared with the requests waiting on them but not kept.
+//
+// The fetch is shared, so it runs on ctx's values but not its cancellation,
+// bounded by budget instead: a caller giving up doesn't fail the others, it
+// just stops waiting. A panicking fetch fails every caller.
+func (co *coalescer) do(ctx context.Context, key string, window, budget time.Duration, fetch func(context.Context) (capi.Response, error)) (capi.Response, error) {
+	co.mu.Lock()
+	call, ok := co.calls[key]
+	if !ok || !call.finished.IsZero() && time.Since(call.finished) >= window {
+		call = &coalescedCall{done: make(chan struct{})}
+		co.calls[key] = call
+		go co.run(context.WithoutCancel(ctx), key, call, window, budget, fetch)
+	}
+	co.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.response, call.err
+	case <-ctx.Done():
+		return capi.Response{}, errors.Wrap(ctx.Err(), "Gave up waiting for CAPI")
+	}
+}
+
+// run makes call's fetch and shares its result
+func (co *coalescer) run(ctx context.Context, key string, call *coalescedCall, window, budget time.Duration, fetch func(context.Context) (capi.Response, error)) {
+	ctx, cancel := context.WithTimeout(ctx, budget)
+	defer cancel()
+
+	var response capi.Response
+	var err error
+	defer func() {
+		if p := recover(); p != nil {
+			log.Printf("CAPI fetch for %s panicked: %v\n%s", key, p, debug.Stack())
+			err = fmt.Errorf("CAPI fetch panicked: %v", p)
+		}
+
+		co.mu.Lock()
+		call.response, call.err = response, err
+		call.finished = time.Now()
+		close(call.done)
+
+		if err != nil || window <= 0 {
+			co.forgetLocked(key, call)
+		} else {
+			time.AfterFunc(window, func() { co.forget(key, call) })
+		}
+		co.mu.Unlock()
+	}()
+
+	response, err = fetch(ctx)
+}
+
+// forget drops a finished call, unless a newer one has replaced it
+func (co *coalescer) forget(key string, call *coalescedCall) {
+	co.mu.Lock()
+	defer co.mu.Unlock()
+
+	co.forgetLocked(key, call)
+}
+
+// forgetLocked is forget with co.mu held
+func (co *coalescer) forgetLocked(key string, call *coalescedCall) {
+	if co.calls[key] == call {
+		delete(co.calls, key)
+	}
+}
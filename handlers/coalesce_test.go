@@ -0,0 +1,240 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/guardian/onward/capi"
+	"github.com/guardian/onward/config"
+	"github.com/pkg/errors"
+)
+
+func TestConcurrentMissesMakeOneUpstreamFetch(t *testing.T) {
+	const n = 20
+
+	release := make(chan struct{})
+	stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Write([]byte(mostViewedBody("a", "b")))
+	})
+	cfg := testConfig(t, stub.URL)
+	h := mostViewedHandler(testCAPI(cfg), testCache(t, cfg), cfg)
+
+	var wg sync.WaitGroup
+	codes := make(chan int, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- serve(h, "/most-viewed/au/culture").Code
+		}()
+	}
+
+	// hold the fetch until every request has had time to miss and join it
+	for stub.requests() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	wg.Wait()
+	close(codes)
+	for code := range codes {
+		if code != http.StatusOK {
+			t.Errorf("got %d, want 200", code)
+		}
+	}
+
+	if calls := stub.requests(); calls != 1 {
+		t.Errorf("CAPI got %d requests for %d concurrent misses, want 1", calls, n)
+	}
+}
+
+func TestCoalescerDoesntKeepFailures(t *testing.T) {
+	co := &coalescer{calls: map[string]*coalescedCall{}}
+	var calls int32
+	failing := func(context.Context) (capi.Response, error) {
+		atomic.AddInt32(&calls, 1)
+		return capi.Response{}, errors.New("CAPI is down")
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := co.do(context.Background(), "uk", time.Minute, time.Second, failing); err == nil {
+			t.Fatal("got no error from a failing fetch")
+		}
+	}
+
+	if calls != 2 {
+		t.Errorf("fetched %d times, want a failure to be tried again", calls)
+	}
+}
+
+func TestCoalescerSharesWithinWindow(t *testing.T) {
+	co := &coalescer{calls: map[string]*coalescedCall{}}
+	var calls int32
+	fetch := func(context.Context) (capi.Response, error) {
+		atomic.AddInt32(&calls, 1)
+		return capi.Response{}, nil
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := co.do(context.Background(), "uk", time.Minute, time.Second, fetch); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := co.do(context.Background(), "us", time.Minute, time.Second, fetch); err != nil {
+		t.Fatal(err)
+	}
+
+	if calls != 2 {
+		t.Errorf("fetched %d times, want once per key", calls)
+	}
+}
+
+func TestCoalescerOutlivesLeader(t *testing.T) {
+	co := &coalescer{calls: map[string]*coalescedCall{}}
+	release := make(chan struct{})
+	fetch := func(ctx context.Context) (capi.Response, error) {
+		select {
+		case <-release:
+			var response capi.Response
+			response.Response.Results = capiItems("a")
+			return response, nil
+		case <-ctx.Done():
+			return capi.Response{}, ctx.Err()
+		}
+	}
+
+	leaderCtx, cancelLeader := context.WithCancel(context.Background())
+	leader := make(chan error, 1)
+	go func() {
+		_, err := co.do(leaderCtx, "uk", 0, time.Second, fetch)
+		leader <- err
+	}()
+	for {
+		co.mu.Lock()
+		_, started := co.calls["uk"]
+		co.mu.Unlock()
+		if started {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	waiter := make(chan error, 1)
+	var got capi.Response
+	go func() {
+		var err error
+		got, err = co.do(context.Background(), "uk", 0, time.Second, fetch)
+		waiter <- err
+	}()
+
+	cancelLeader()
+	if err := <-leader; !errors.Is(err, context.Canceled) {
+		t.Errorf("the cancelled leader got %v, want context.Canceled", err)
+	}
+
+	close(release)
+	if err := <-waiter; err != nil {
+		t.Fatalf("the waiter failed with its leader: %s", err)
+	}
+	if ids := itemIDs(got.Response.Results); len(ids) != 1 || ids[0] != "a" {
+		t.Errorf("the waiter got %v, want the fetched list", ids)
+	}
+}
+
+func TestCoalescerWaiterGivesUp(t *testing.T) {
+	co := &coalescer{calls: map[string]*coalescedCall{}}
+	release := make(chan struct{})
+	defer close(release)
+	fetch := func(ctx context.Context) (capi.Response, error) {
+		<-release
+		return capi.Response{}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := co.do(ctx, "uk", 0, time.Second, fetch); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want the caller's deadline", err)
+	}
+}
+
+func TestCoalescerRecoversPanics(t *testing.T) {
+	co := &coalescer{calls: map[string]*coalescedCall{}}
+	panicking := func(context.Context) (capi.Response, error) {
+		panic("bad CAPI response")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := co.do(ctx, "uk", time.Minute, time.Second, panicking); err == nil || errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want the panic as an error", err)
+	}
+
+	var calls int32
+	if _, err := co.do(ctx, "uk", time.Minute, time.Second, func(context.Context) (capi.Response, error) {
+		atomic.AddInt32(&calls, 1)
+		return capi.Response{}, nil
+	}); err != nil || calls != 1 {
+		t.Errorf("after a panic got %v after %d fetches, want a fresh fetch", err, calls)
+	}
+}
+
+func TestMissesShareFetchesWithRefreshes(t *testing.T) {
+	tests := []struct {
+		name    string
+		refresh func(q query, c Cache, cfg config.Config)
+	}{
+		{"revalidation", func(q query, c Cache, cfg config.Config) { revalidate(testCAPI(cfg), q, c, cfg) }},
+		{"warm", func(q query, c Cache, cfg config.Config) {
+			if _, err := warmFetch(context.Background(), testCAPI(cfg), q, c, cfg); err != nil {
+				t.Error(err)
+			}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			release := make(chan struct{})
+			stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+				<-release
+				w.Write([]byte(mostViewedBody("a")))
+			})
+			cfg := testConfig(t, stub.URL)
+			bg := useWorkers(t)
+			c := testCache(t, cfg)
+			q := query{Path: "uk", MostViewed: true}
+
+			refreshed := make(chan struct{})
+			go func() {
+				defer close(refreshed)
+				tt.refresh(q, c, cfg)
+			}()
+			for stub.requests() == 0 {
+				time.Sleep(time.Millisecond)
+			}
+
+			missed := make(chan error, 1)
+			go func() {
+				_, err := cachedGet(context.Background(), testCAPI(cfg), q, c, cfg)
+				missed <- err
+			}()
+			time.Sleep(20 * time.Millisecond)
+			close(release)
+
+			if err := <-missed; err != nil {
+				t.Fatal(err)
+			}
+			<-refreshed
+			bg.wg.Wait()
+
+			if calls := stub.requests(); calls != 1 {
+				t.Errorf("CAPI got %d requests for a %s and a miss at once, want 1", calls, tt.name)
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"container/list"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andybalholm/brotli"
+)
+
+// Content codings responses can be compressed with, most preferred first
+const (
+	encodingBrotli = "br"
+	encodingGzip   = "gzip"
+)
+
+// compression is how response bodies are compressed. It's off until
+// configured.
+var compression compressor
+
+// compressor compresses bodies of at least minBytes, caching the compressed
+// bytes by ETag and coding so a cache hit that renders the same body isn't
+// compressed again. A nil bodies cache is compression off.
+type compressor struct {
+	minBytes int
+	bodies   *bodyCache
+}
+
+// configure turns compression on, keeping up to maxBytes of compressed
+// bodies for ttl, or off
+func (c *compressor) configure(enabled bool, minBytes, maxBytes int, ttl time.Duration) {
+	c.minBytes = minBytes
+	c.bodies = nil
+	if enabled {
+		c.bodies = newBodyCache(maxBytes, ttl)
+	}
+}
+
+// close drops the compressed bodies
+func (c *compressor) close() {
+	if c.bodies != nil {
+		c.bodies.flush()
+	}
+}
+
+// bodyCache keeps compressed bodies for ttl, least recently used dropped
+// first once they add up to more than maxBytes. Clients choose what's
+// compressed, so it has to be bounded. Expired bodies are dropped as they're
+// found, so it needs no janitor.
+type bodyCache struct {
+	maxBytes int
+	ttl      time.Duration
+
+	mu    sync.Mutex
+	bytes int
+	order *list.List // of *cachedBody, most recently used first
+	byKey map[string]*list.Element
+}
+
+// cachedBody is a compressed body in a bodyCache
+type cachedBody struct {
+	key       string
+	body      []byte
+	expiresAt time.Time
+}
+
+func newBodyCache(maxBytes int, ttl time.Duration) *bodyCache {
+	return &bodyCache{maxBytes: maxBytes, ttl: ttl, order: list.New(), byKey: map[string]*list.Element{}}
+}
+
+func (bc *bodyCache) get(key string) ([]byte, bool) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	el, found := bc.byKey[key]
+	if !found {
+		return nil, false
+	}
+
+	cached := el.Value.(*cachedBody)
+	if !time.Now().Before(cached.expiresAt) {
+		bc.remove(el)
+		return nil, false
+	}
+
+	bc.order.MoveToFront(el)
+	return cached.body, true
+}
+
+// set keeps body under key, unless it's bigger than the whole cache
+func (bc *bodyCache) set(key string, body []byte) {
+	if len(body) > bc.maxBytes {
+		return
+	}
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	if el, found := bc.byKey[key]; found {
+		bc.remove(el)
+	}
+
+	bc.byKey[key] = bc.order.PushFront(&cachedBody{key: key, body: body, expiresAt: time.Now().Add(bc.ttl)})
+	bc.bytes += len(body)
+
+	for bc.bytes > bc.maxBytes {
+		bc.remove(bc.order.Back())
+	}
+}
+
+// remove drops el, with bc.mu held
+func (bc *bodyCache) remove(el *list.Element) {
+	cached := bc.order.Remove(el).(*cachedBody)
+	delete(bc.byKey, cached.key)
+	bc.bytes -= len(cached.body)
+}
+
+func (bc *bodyCache) flush() {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	bc.order.Init()
+	bc.byKey = map[string]*list.Element{}
+	bc.bytes = 0
+}
+
+// acceptedEncoding picks the coding to compress a response with from an
+// Accept-Encoding header: brotli if the client takes it, then gzip, otherwise
+// none
+func acceptedEncoding(acceptEncoding string) string {
+	accepted := map[string]bool{}
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		fields := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(fields[0]))
+
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+
+		accepted[coding] = q > 0
+	}
+
+	for _, coding := range []string{encodingBrotli, encodingGzip} {
+		if ok, listed := accepted[coding]; ok || !listed && accepted["*"] {
+			return coding
+		}
+	}
+
+	return ""
+}
+
+// negotiate picks a coding for a body of the given size, or none if
+// compression is off, the body is too small to be worth it, or the client
+// doesn't take any coding the service offers. Responses it's considered for
+// vary on Accept-Encoding.
+func (c *compressor) negotiate(w http.ResponseWriter, r *http.Request, size int) string {
+	if c.bodies == nil || size < c.minBytes {
+		return ""
+	}
+
+	w.Header().Add("Vary", "Accept-Encoding")
+	return acceptedEncoding(r.Header.Get("Accept-Encoding"))
+}
+
+// compress is body compressed with coding, from the cache if it's been
+// compressed before
+func (c *compressor) compress(body []byte, etag, coding string) []byte {
+	key := coding + ":" + etag
+	if cached, found := c.bodies.get(key); found {
+		return cached
+	}
+
+	var buf bytes.Buffer
+	var err error
+
+	switch coding {
+	case encodingBrotli:
+		bw := brotli.NewWriterLevel(&buf, brotli.DefaultCompression)
+		if _, err = bw.Write(body); err == nil {
+			err = bw.Close()
+		}
+	case encodingGzip:
+		gw := gzip.NewWriter(&buf)
+		if _, err = gw.Write(body); err == nil {
+			err = gw.Close()
+		}
+	}
+
+	if err != nil {
+		log.Fatalf("Unable to compress response body (should never happen), %s", err)
+	}
+
+	c.bodies.set(key, buf.Bytes())
+	return buf.Bytes()
+}
@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBodyCache(t *testing.T) {
+	bc := newBodyCache(10, time.Minute)
+
+	bc.set("a", []byte("aaaa"))
+	bc.set("b", []byte("bbbb"))
+	if _, found := bc.get("a"); !found {
+		t.Fatal("a missing before the cache was full")
+	}
+
+	// a was used last, so b goes to make room
+	bc.set("c", []byte("cccc"))
+	for key, want := range map[string]bool{"a": true, "b": false, "c": true} {
+		if _, found := bc.get(key); found != want {
+			t.Errorf("%s cached: %v, want %v", key, found, want)
+		}
+	}
+	if bc.bytes > bc.maxBytes {
+		t.Errorf("holding %d bytes, want at most %d", bc.bytes, bc.maxBytes)
+	}
+
+	bc.set("big", []byte(strings.Repeat("x", 11)))
+	if _, found := bc.get("big"); found {
+		t.Error("kept a body bigger than the cache")
+	}
+}
+
+func TestBodyCacheExpiry(t *testing.T) {
+	bc := newBodyCache(100, time.Millisecond)
+	bc.set("a", []byte("aaaa"))
+	time.Sleep(5 * time.Millisecond)
+
+	if _, found := bc.get("a"); found {
+		t.Error("an expired body was served")
+	}
+	if bc.bytes != 0 || len(bc.byKey) != 0 {
+		t.Errorf("the expired body is still held: %d bytes, %d keys", bc.bytes, len(bc.byKey))
+	}
+}
@@ -0,0 +1,83 @@
+//go:build debug
+// +build debug
+
+package handlers
+
+import (
+	"flag"
+	"net/http"
+	"net/url"
+
+	"github.com/guardian/onward/config"
+)
+
+const redacted = "REDACTED"
+
+// secretFlags are the settings whose values are never shown. CAPI headers are
+// included as they're how tokens get sent.
+var secretFlags = map[string]bool{
+	"api-key":        true,
+	"api-keys":       true,
+	"capi-password":  true,
+	"path-api-keys":  true,
+	"admin-password": true,
+	"admin-token":    true,
+	"capi-header":    true,
+	"ophan-api-key":  true,
+	"image-salt":     true,
+}
+
+// urlFlags are settings that are URLs, which may carry a password
+var urlFlags = map[string]bool{
+	"redis-url":         true,
+	"capi-url":          true,
+	"capi-proxy":        true,
+	"enrich-url":        true,
+	"discussion-url":    true,
+	"ophan-url":         true,
+	"image-resizer-url": true,
+}
+
+// effectiveConfig is every setting's value as the service resolved it, keyed
+// by flag name, with secrets redacted
+func effectiveConfig(fs *flag.FlagSet) map[string]string {
+	settings := map[string]string{}
+
+	fs.VisitAll(func(f *flag.Flag) {
+		value := f.Value.String()
+
+		switch {
+		case secretFlags[f.Name] && value != "":
+			value = redacted
+		case urlFlags[f.Name]:
+			value = withoutPassword(value)
+		}
+
+		settings[f.Name] = value
+	})
+
+	return settings
+}
+
+// withoutPassword masks the password in a URL's user info, if it has one
+func withoutPassword(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.User == nil {
+		return rawURL
+	}
+
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), redacted)
+	}
+
+	return u.String()
+}
+
+// configHandler serves the running configuration, with secrets redacted. It's
+// only registered in debug mode.
+func configHandler(fs *flag.FlagSet, cfg config.Config) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(renderOptionsFor(r, cfg).json(effectiveConfig(fs)))
+	}
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// corsExposedHeaders are the response headers browsers let other origins read
+const corsExposedHeaders = "ETag, Link, Retry-After, X-Backend, X-Effective-Limit, X-Last-Known-Good, X-Request-Id, X-Truncated"
+
+// cors lets browsers on the allowed origins call the API. Allowed origins get
+// their Origin echoed back, and preflight OPTIONS requests are answered here
+// without reaching h. Admin and debug routes are left out.
+func cors(allowed []string, maxAge time.Duration, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/admin/") || strings.HasPrefix(r.URL.Path, "/debug/") {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		// the response depends on Origin whether or not it's allowed, so
+		// caches mustn't share it between origins
+		w.Header().Add("Vary", "Origin")
+
+		origin := r.Header.Get("Origin")
+		ok := origin != "" && originAllowed(origin, allowed)
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+		if !ok {
+			if preflight {
+				writeError(w, &HTTPError{Status: http.StatusForbidden, Message: "Origin not allowed"})
+				return
+			}
+
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+
+		if !preflight {
+			w.Header().Set("Access-Control-Expose-Headers", corsExposedHeaders)
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+		w.Header().Add("Vary", "Access-Control-Request-Headers")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, POST, OPTIONS")
+		if headers := r.Header.Get("Access-Control-Request-Headers"); headers != "" {
+			w.Header().Set("Access-Control-Allow-Headers", headers)
+		}
+		if maxAge > 0 {
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(maxAge.Seconds())))
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// originAllowed reports whether origin matches any of the allowed patterns.
+// A pattern is "*" for any origin, an origin such as
+// https://www.theguardian.com, or one whose host starts "*." for any
+// subdomain (https://*.theguardian.com). Patterns without a scheme match
+// either.
+func originAllowed(origin string, allowed []string) bool {
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+
+	for _, pattern := range allowed {
+		if pattern == "*" {
+			return true
+		}
+
+		scheme, host := "", pattern
+		if i := strings.Index(pattern, "://"); i != -1 {
+			scheme, host = pattern[:i], pattern[i+3:]
+		}
+
+		if scheme != "" && !strings.EqualFold(scheme, u.Scheme) {
+			continue
+		}
+
+		if strings.HasPrefix(host, "*.") {
+			if strings.HasSuffix(strings.ToLower(u.Host), strings.ToLower(host[1:])) {
+				return true
+			}
+			continue
+		}
+
+		if strings.EqualFold(host, u.Host) {
+			return true
+		}
+	}
+
+	return false
+}
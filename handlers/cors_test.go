@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestOriginAllowed(t *testing.T) {
+	allowed := []string{"https://*.theguardian.com", "http://localhost:3000", "guardian.co.uk"}
+
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{"https://www.theguardian.com", true},
+		{"https://api.nextgen.theguardian.com", true},
+		{"HTTPS://WWW.THEGUARDIAN.COM", true},
+		{"http://www.theguardian.com", false},
+		{"https://theguardian.com", false},
+		{"https://eviltheguardian.com", false},
+		{"https://www.theguardian.com.evil.example", false},
+		{"http://localhost:3000", true},
+		{"http://localhost:3001", false},
+		{"https://guardian.co.uk", true},
+		{"http://guardian.co.uk", true},
+		{"null", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if got := originAllowed(tt.origin, allowed); got != tt.want {
+			t.Errorf("originAllowed(%q) is %v, want %v", tt.origin, got, tt.want)
+		}
+	}
+
+	if !originAllowed("https://anywhere.example", []string{"*"}) {
+		t.Error("* doesn't allow every origin")
+	}
+}
+
+func TestCORS(t *testing.T) {
+	reached := false
+	h := cors([]string{"https://*.theguardian.com"}, 10*time.Minute, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+	}))
+
+	tests := []struct {
+		name          string
+		method        string
+		path          string
+		origin        string
+		requestMethod string
+		status        int
+		reached       bool
+		headers       map[string]string
+	}{
+		{
+			name: "allowed", method: "GET", path: "/most-viewed/uk", origin: "https://www.theguardian.com",
+			status: http.StatusOK, reached: true,
+			headers: map[string]string{"Access-Control-Allow-Origin": "https://www.theguardian.com", "Access-Control-Expose-Headers": corsExposedHeaders},
+		},
+		{
+			name: "not allowed", method: "GET", path: "/most-viewed/uk", origin: "https://evil.example",
+			status: http.StatusOK, reached: true,
+			headers: map[string]string{"Access-Control-Allow-Origin": ""},
+		},
+		{
+			name: "no origin", method: "GET", path: "/most-viewed/uk",
+			status: http.StatusOK, reached: true,
+			headers: map[string]string{"Access-Control-Allow-Origin": ""},
+		},
+		{
+			name: "preflight", method: "OPTIONS", path: "/most-viewed/uk", origin: "https://www.theguardian.com", requestMethod: "POST",
+			status: http.StatusNoContent,
+			headers: map[string]string{
+				"Access-Control-Allow-Origin":  "https://www.theguardian.com",
+				"Access-Control-Allow-Methods": "GET, HEAD, POST, OPTIONS",
+				"Access-Control-Allow-Headers": "Content-Type",
+				"Access-Control-Max-Age":       "600",
+			},
+		},
+		{
+			name: "preflight not allowed", method: "OPTIONS", path: "/most-viewed/uk", origin: "https://evil.example", requestMethod: "POST",
+			status:  http.StatusForbidden,
+			headers: map[string]string{"Access-Control-Allow-Origin": ""},
+		},
+		{
+			name: "admin", method: "GET", path: "/admin/cache", origin: "https://www.theguardian.com",
+			status: http.StatusOK, reached: true,
+			headers: map[string]string{"Access-Control-Allow-Origin": "", "Vary": ""},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reached = false
+			r := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if tt.requestMethod != "" {
+				r.Header.Set("Access-Control-Request-Method", tt.requestMethod)
+				r.Header.Set("Access-Control-Request-Headers", "Content-Type")
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if w.Code != tt.status {
+				t.Errorf("got %d, want %d", w.Code, tt.status)
+			}
+			if reached != tt.reached {
+				t.Errorf("reached the handler: %v, want %v", reached, tt.reached)
+			}
+			for name, want := range tt.headers {
+				if got := w.Header().Get(name); got != want {
+					t.Errorf("%s is %q, want %q", name, got, want)
+				}
+			}
+			if vary := w.Header().Values("Vary"); tt.path != "/admin/cache" && (len(vary) == 0 || vary[0] != "Origin") {
+				t.Errorf("Vary is %v, want Origin", vary)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+//go:build debug
+// +build debug
+
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/guardian/onward/config"
+)
+
+// editionDiff is how an edition's cached list changed between two fills
+type editionDiff struct {
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+}
+
+// diffHandler serves the trail URLs added to and removed from an edition's
+// list between its last cache fill and the one before, or ?back=n fills
+// before. It's only registered in debug mode.
+func diffHandler(cfg config.Config) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		edition := strings.TrimPrefix(r.URL.Path, "/diff/")
+		if !config.IsEdition(edition) {
+			writeError(w, &HTTPError{Status: http.StatusNotFound, Message: "Not found"})
+			return
+		}
+
+		back := 1
+		if raw := r.URL.Query().Get("back"); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < 1 {
+				writeError(w, &HTTPError{Status: http.StatusBadRequest, Message: "Invalid back"})
+				return
+			}
+			back = n
+		}
+
+		added, removed, ok := editionSnapshots.diff(edition, back)
+		if !ok {
+			writeError(w, &HTTPError{Status: http.StatusNotFound, Message: "No snapshot that far back"})
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(renderOptionsFor(r, cfg).json(editionDiff{Added: added, Removed: removed}))
+	}
+}
+
+// snapshotsHandler serves an edition's retained snapshots, newest first. It's
+// only registered in debug mode.
+func snapshotsHandler(cfg config.Config) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		edition := strings.TrimPrefix(r.URL.Path, "/snapshots/")
+		if !config.IsEdition(edition) {
+			writeError(w, &HTTPError{Status: http.StatusNotFound, Message: "Not found"})
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(renderOptionsFor(r, cfg).json(editionSnapshots.history(edition)))
+	}
+}
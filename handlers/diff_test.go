@@ -0,0 +1,105 @@
+//go:build debug
+
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/guardian/onward/capi"
+)
+
+func TestDiffHandler(t *testing.T) {
+	useSnapshots(t, 3)
+	cfg := testConfig(t, "http://capi.invalid")
+	c := testCache(t, cfg)
+	h := diffHandler(cfg)
+
+	fill := func(ids ...string) {
+		var items capi.Response
+		items.Response.Results = capiItems(ids...)
+		cacheSet(context.Background(), c, query{Path: "uk", MostViewed: true}, items, cfg)
+	}
+
+	if w := serve(h, "/diff/uk"); w.Code != http.StatusNotFound {
+		t.Errorf("before any fill got %d, want 404: %s", w.Code, w.Body)
+	}
+
+	fill("a", "b", "c")
+	if w := serve(h, "/diff/uk"); w.Code != http.StatusNotFound {
+		t.Errorf("after one fill got %d, want 404: %s", w.Code, w.Body)
+	}
+
+	fill("b", "d", "a", "e")
+	fill("d", "e", "f")
+
+	tests := []struct {
+		target  string
+		added   []string
+		removed []string
+	}{
+		{"/diff/uk", []string{"f"}, []string{"b", "a"}},
+		{"/diff/uk?back=1", []string{"f"}, []string{"b", "a"}},
+		{"/diff/uk?back=2", []string{"d", "e", "f"}, []string{"a", "b", "c"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			w := serve(h, tt.target)
+			if w.Code != http.StatusOK {
+				t.Fatalf("got %d, want 200: %s", w.Code, w.Body)
+			}
+
+			var got editionDiff
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got.Added, tt.added) || !reflect.DeepEqual(got.Removed, tt.removed) {
+				t.Errorf("got added %v, removed %v, want added %v, removed %v", got.Added, got.Removed, tt.added, tt.removed)
+			}
+		})
+	}
+
+	for target, status := range map[string]int{
+		"/diff/uk?back=3":    http.StatusNotFound,
+		"/diff/uk?back=0":    http.StatusBadRequest,
+		"/diff/uk?back=many": http.StatusBadRequest,
+		"/diff/football":     http.StatusNotFound,
+	} {
+		if w := serve(h, target); w.Code != status {
+			t.Errorf("%s got %d, want %d", target, w.Code, status)
+		}
+	}
+}
+
+func TestSnapshotsHandler(t *testing.T) {
+	s := useSnapshots(t, 2)
+	cfg := testConfig(t, "http://capi.invalid")
+	h := snapshotsHandler(cfg)
+
+	for _, ids := range [][]string{{"a"}, {"b"}, {"c"}} {
+		s.record("uk", capiItems(ids...))
+	}
+
+	w := serve(h, "/snapshots/uk")
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d, want 200: %s", w.Code, w.Body)
+	}
+	var got []snapshot
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]string{{"c"}, {"b"}}; !reflect.DeepEqual(snapshotURLs(got), want) {
+		t.Errorf("got snapshots %v, want %v", snapshotURLs(got), want)
+	}
+	if len(got) == 2 && got[0].CachedAt.Before(got[1].CachedAt) {
+		t.Errorf("the snapshots aren't newest first: %v", got)
+	}
+
+	if w := serve(h, "/snapshots/football"); w.Code != http.StatusNotFound {
+		t.Errorf("/snapshots/football got %d, want 404", w.Code)
+	}
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/guardian/onward/capi"
+	"github.com/guardian/onward/config"
+)
+
+// discussion is one of the Discussion API's most active discussions
+type discussion struct {
+	Key          string `json:"key"`
+	WebURL       string `json:"webUrl"`
+	CommentCount int    `json:"commentCount"`
+}
+
+type discussionResponse struct {
+	Discussions []discussion `json:"discussions"`
+}
+
+// mostCommentedGet lists the articles with the most active discussions, most
+// active first. The discussions come from the Discussion API and their
+// articles are then resolved through CAPI.
+func mostCommentedGet(ctx context.Context, api capi.Client, cfg config.Config) (capi.Response, error) {
+	target := strings.TrimSuffix(cfg.DiscussionURL, "/") + "/popular?pageSize=" + strconv.Itoa(cfg.MostCommentedSize)
+
+	var response discussionResponse
+	if err := fetchJSON(ctx, "Discussion API", target, cfg, &response); err != nil {
+		return capi.Response{}, err
+	}
+
+	var paths []string
+	for _, d := range response.Discussions {
+		if path := webPath(d.WebURL); path != "" {
+			paths = append(paths, path)
+		}
+	}
+
+	return resolveArticles(ctx, api, paths)
+}
+
+// mostCommentedHandler serves /most-commented/{edition}. The Discussion API
+// ranks discussions across the whole site, so every edition gets the same
+// list, cached once.
+func mostCommentedHandler(api capi.Client, c Cache, cfg config.Config) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		path, f := splitFormat(normalizePath(strings.TrimPrefix(r.URL.Path, "/most-commented/")))
+		if !config.IsEdition(path) {
+			writeError(w, &HTTPError{Status: http.StatusNotFound, Message: "Unknown edition", Code: codeUnknownEdition})
+			return
+		}
+
+		var err error
+		if f == "" {
+			if f, err = requestedFormat(r); err != nil {
+				writeError(w, err)
+				return
+			}
+		}
+
+		limit, clamped, err := requestedLimit(r, defaultLimit(cfg, path), cfg.MaxLimit)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		seen, err := requestedSeen(r)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.RequestBudget)
+		defer cancel()
+		ctx = withCacheDirective(ctx, requestCacheDirective(r))
+		ctx, fresh := withFreshness(ctx)
+
+		items, err := cachedGet(ctx, api, query{MostCommented: true}, c, cfg)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		if cfg.EmptyNoContent && len(items.Response.Results) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		il := applyFilters(asItemList(items, "", cfg.MostCommentedHeading), r).dedupe(seen)
+		if limit > 0 && len(il.Trails) > limit {
+			il.Trails = il.Trails[:limit]
+		}
+
+		if clamped {
+			w.Header().Set("X-Effective-Limit", strconv.Itoa(limit))
+		}
+
+		setCacheControl(w, cfg, fresh)
+		opts := renderOptionsFor(r, cfg)
+
+		if f == formatNDJSON {
+			writeNDJSON(w, r, il, opts)
+			return
+		}
+
+		writeBody(w, r, contentTypes[f], renderItemList(il, f, opts))
+	}
+}
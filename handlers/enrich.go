@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/guardian/onward/capi"
+	"github.com/guardian/onward/config"
+)
+
+// enrich augments each trail with metadata from the enrichment service at
+// cfg.EnrichURL, with at most cfg.EnrichWorkers requests in flight and each
+// bounded by cfg.EnrichTimeout. The service is sent the trail's URL and
+// answers with a JSON object of trail fields to set. A trail it can't enrich
+// is kept as it was, as are any not enriched within cfg.EnrichBudget.
+func enrich(ctx context.Context, il ItemList, cfg config.Config) ItemList {
+	ctx, cancel := context.WithTimeout(ctx, cfg.EnrichBudget)
+	defer cancel()
+
+	trails := make([]Item, len(il.Trails))
+	copy(trails, il.Trails)
+	sem := make(chan struct{}, cfg.EnrichWorkers)
+
+	var wg sync.WaitGroup
+	for i, item := range il.Trails {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+
+		if ctx.Err() != nil {
+			log.Printf("Enrichment budget spent, %d of %d trails left as they were", len(il.Trails)-i, len(il.Trails))
+			break
+		}
+
+		wg.Add(1)
+
+		go func(i int, item Item) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			enriched, err := enrichItem(ctx, item, cfg)
+			if err != nil {
+				log.Printf("Unable to enrich %s, %s", item.URL, err)
+				return
+			}
+
+			trails[i] = enriched
+		}(i, item)
+	}
+
+	wg.Wait()
+	il.Trails = trails
+	return il
+}
+
+func enrichItem(ctx context.Context, item Item, cfg config.Config) (Item, error) {
+	ctx, cancel := context.WithTimeout(ctx, cfg.EnrichTimeout)
+	defer cancel()
+
+	target := strings.Replace(cfg.EnrichURL, "{url}", url.QueryEscape(item.URL), -1)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return item, err
+	}
+	req.Header.Set("User-Agent", capi.UserAgent)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return item, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return item, fmt.Errorf("status %d", resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, cfg.EnrichMaxBytes+1))
+	if err != nil {
+		return item, err
+	}
+
+	if int64(len(body)) > cfg.EnrichMaxBytes {
+		return item, fmt.Errorf("response over %d bytes", cfg.EnrichMaxBytes)
+	}
+
+	// fields the service leaves out keep their values, but a trail is
+	// always identified by its own URL
+	enriched := item
+	if err := json.Unmarshal(body, &enriched); err != nil {
+		return item, err
+	}
+	enriched.URL = item.URL
+
+	return enriched, nil
+}
@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// enrichedTrails is the trails of a list, as far as enrichment goes
+func enrichedTrails(t *testing.T, w *httptest.ResponseRecorder) map[string]string {
+	t.Helper()
+
+	var il struct {
+		Trails []struct {
+			URL      string `json:"url"`
+			LinkText string `json:"linkText"`
+		} `json:"trails"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &il); err != nil {
+		t.Fatalf("%q isn't a list, %s", w.Body, err)
+	}
+
+	linkText := map[string]string{}
+	for _, trail := range il.Trails {
+		linkText[trail.URL] = trail.LinkText
+	}
+	return linkText
+}
+
+func TestEnrichment(t *testing.T) {
+	stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"response":{"status":"ok","mostViewed":[
+			{"id":"a","type":"article","webTitle":"A"},
+			{"id":"b","type":"article","webTitle":"B"},
+			{"id":"c","type":"article","webTitle":"C"},
+			{"id":"d","type":"article","webTitle":"D"},
+			{"id":"e","type":"article","webTitle":"E"}
+		]}}`))
+	})
+
+	// the most enrichment requests in flight at once
+	var mu sync.Mutex
+	var inFlight, most int
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		if inFlight++; inFlight > most {
+			most = inFlight
+		}
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+		}()
+		time.Sleep(10 * time.Millisecond)
+
+		switch r.URL.Query().Get("url") {
+		case "a":
+			w.Write([]byte(`{"linkText":"A, enriched"}`))
+		case "b":
+			w.WriteHeader(http.StatusInternalServerError)
+		case "c":
+			w.Write([]byte(`not JSON`))
+		case "d":
+			// past the per-trail timeout
+			select {
+			case <-time.After(time.Second):
+			case <-r.Context().Done():
+			}
+		case "e":
+			// a trail can't be turned into another
+			w.Write([]byte(`{"url":"elsewhere","linkText":"E, enriched"}`))
+		}
+	}))
+	t.Cleanup(service.Close)
+
+	cfg := testConfig(t, stub.URL)
+	cfg.EnrichURL = service.URL + "/items?url={url}"
+	cfg.EnrichWorkers = 2
+	cfg.EnrichTimeout = 100 * time.Millisecond
+
+	w := serve(mostViewedHandler(testCAPI(cfg), testCache(t, cfg), cfg), "/most-viewed/uk")
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d, want 200: %s", w.Code, w.Body)
+	}
+
+	want := map[string]string{"a": "A, enriched", "b": "B", "c": "C", "d": "D", "e": "E, enriched"}
+	got := enrichedTrails(t, w)
+	for url, linkText := range want {
+		if got[url] != linkText {
+			t.Errorf("%s's link text is %q, want %q", url, got[url], linkText)
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("got trails %v, want %v", got, want)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if most > cfg.EnrichWorkers {
+		t.Errorf("%d enrichment requests were in flight at once, want at most %d", most, cfg.EnrichWorkers)
+	}
+}
+
+func TestEnrichmentBudget(t *testing.T) {
+	stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(mostViewedBody("a", "b", "c", "d", "e")))
+	})
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(200 * time.Millisecond):
+			w.Write([]byte(`{"linkText":"Enriched"}`))
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(service.Close)
+
+	cfg := testConfig(t, stub.URL)
+	cfg.EnrichURL = service.URL + "/items?url={url}"
+	cfg.EnrichWorkers = 1
+	cfg.EnrichTimeout = time.Second
+	cfg.EnrichBudget = 100 * time.Millisecond
+
+	start := time.Now()
+	w := serve(mostViewedHandler(testCAPI(cfg), testCache(t, cfg), cfg), "/most-viewed/uk")
+	elapsed := time.Since(start)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d, want 200: %s", w.Code, w.Body)
+	}
+	if elapsed > cfg.EnrichBudget+300*time.Millisecond {
+		t.Errorf("took %s, past the %s enrichment budget", elapsed, cfg.EnrichBudget)
+	}
+	got := enrichedTrails(t, w)
+	if len(got) != 5 {
+		t.Fatalf("got trails %v, want all five", got)
+	}
+	for url, linkText := range got {
+		if linkText == "Enriched" {
+			t.Errorf("%s was enriched, past the budget", url)
+		}
+	}
+}
+
+func TestEnrichMaxBytes(t *testing.T) {
+	stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"response":{"status":"ok","mostViewed":[
+			{"id":"small","type":"article","webTitle":"Small"},
+			{"id":"large","type":"article","webTitle":"Large"}
+		]}}`))
+	})
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("url") == "large" {
+			w.Write([]byte(`{"linkText":"Large, enriched","byline":"` + strings.Repeat("x", 200) + `"}`))
+			return
+		}
+		w.Write([]byte(`{"linkText":"Small, enriched"}`))
+	}))
+	t.Cleanup(service.Close)
+
+	cfg := testConfig(t, stub.URL)
+	cfg.EnrichURL = service.URL + "/items?url={url}"
+	cfg.EnrichMaxBytes = 100
+
+	w := serve(mostViewedHandler(testCAPI(cfg), testCache(t, cfg), cfg), "/most-viewed/uk")
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d, want 200: %s", w.Code, w.Body)
+	}
+
+	want := map[string]string{"small": "Small, enriched", "large": "Large"}
+	if got := enrichedTrails(t, w); !reflect.DeepEqual(got, want) {
+		t.Errorf("got link text %v, want %v", got, want)
+	}
+}
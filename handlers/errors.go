@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/guardian/onward/capi"
+	"github.com/pkg/errors"
+)
+
+// HTTPError is an error that knows the HTTP status it should be served with.
+// Message is safe to show to clients; Err, if set, is the underlying cause
+// and is only logged.
+type HTTPError struct {
+	Status  int
+	Message string
+	Err     error
+
+	// Code tells clients what went wrong without parsing Message. Left
+	// empty, it's the general code for Status.
+	Code string
+
+	// RetryAfter, if set, is sent to clients as a Retry-After header
+	RetryAfter time.Duration
+}
+
+func (e *HTTPError) Error() string {
+	if e.Err == nil {
+		return e.Message
+	}
+
+	return e.Message + ": " + e.Err.Error()
+}
+
+// Error codes, as sent to clients in error bodies. Upstream codes mean CAPI
+// (or another service onward calls) failed, rather than the request.
+const (
+	codeBadRequest          = "bad_request"
+	codeUnauthorized        = "unauthorized"
+	codeForbidden           = "forbidden"
+	codeNotFound            = "not_found"
+	codeUnknownEdition      = "unknown_edition"
+	codeMethodNotAllowed    = "method_not_allowed"
+	codeRateLimited         = "rate_limited"
+	codeInternal            = "internal_error"
+	codeUnavailable         = "unavailable"
+	codeOverloaded          = capi.CodeOverloaded
+	codeTimeout             = "timeout"
+	codeCancelled           = "cancelled"
+	codeUpstreamError       = "upstream_error"
+	codeUpstreamTimeout     = "upstream_timeout"
+	codeUpstreamRateLimited = capi.CodeUpstreamRateLimited
+	codeUpstreamUnavailable = capi.CodeUpstreamUnavailable
+)
+
+// statusClientClosedRequest is nginx's status for a request its client gave
+// up on. The client never sees it, but logs and metrics do, apart from 5xxs.
+const statusClientClosedRequest = 499
+
+// statusCodes are the codes for errors that don't give their own
+var statusCodes = map[int]string{
+	http.StatusBadRequest:          codeBadRequest,
+	http.StatusUnauthorized:        codeUnauthorized,
+	http.StatusForbidden:           codeForbidden,
+	http.StatusNotFound:            codeNotFound,
+	http.StatusMethodNotAllowed:    codeMethodNotAllowed,
+	http.StatusTooManyRequests:     codeRateLimited,
+	http.StatusInternalServerError: codeInternal,
+	http.StatusBadGateway:          codeUpstreamError,
+	http.StatusServiceUnavailable:  codeUnavailable,
+	http.StatusGatewayTimeout:      codeUpstreamTimeout,
+	statusClientClosedRequest:      codeCancelled,
+}
+
+// code is the error's code, or its status's if it hasn't one
+func (e *HTTPError) code() string {
+	if e.Code != "" {
+		return e.Code
+	}
+
+	if code, ok := statusCodes[e.Status]; ok {
+		return code
+	}
+
+	return codeInternal
+}
+
+// errorBody is the JSON body of every error response
+type errorBody struct {
+	Status    int    `json:"status"`
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"requestId,omitempty"`
+}
+
+// writeError logs err and writes it as a JSON error response, with the
+// request ID so clients can quote it. Errors that aren't (or don't wrap) an
+// HTTPError or a capi.Error are served as a 500, unless the request ran out of time or was
+// cancelled. Errors are never cached, whatever caching headers were set
+// before they happened.
+func writeError(w http.ResponseWriter, err error) {
+	httpErr := httpErrorFor(err)
+
+	id := w.Header().Get(requestIDHeader)
+	logged := err.Error()
+	if id != "" {
+		logged += " (request " + id + ")"
+	}
+
+	if httpErr.Status == statusClientClosedRequest {
+		log.Printf("Client went away, %s", logged)
+	} else {
+		log.Printf("%s", logged)
+	}
+
+	if httpErr.RetryAfter > 0 {
+		seconds := int(math.Ceil(httpErr.RetryAfter.Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	}
+
+	for _, name := range []string{"Expires", "Last-Modified", "ETag", lastGoodHeader} {
+		w.Header().Del(name)
+	}
+	w.Header().Set("Cache-Control", "no-store")
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpErr.Status)
+	if err := json.NewEncoder(w).Encode(errorBody{Status: httpErr.Status, Code: httpErr.code(), Message: httpErr.Message, RequestID: id}); err != nil {
+		logWriteFailure("error response", err)
+	}
+}
+
+// httpErrorFor is the HTTPError behind err, the one for a failed upstream
+// request behind it, or what's said to clients about any other error. A
+// cancelled request is the client's doing, whatever failed because of it, so
+// it's never a 5xx.
+func httpErrorFor(err error) *HTTPError {
+	httpErr, ok := errors.Cause(err).(*HTTPError)
+	capiErr, upstream := errors.Cause(err).(*capi.Error)
+	switch {
+	case errors.Is(err, context.Canceled):
+		return &HTTPError{Status: statusClientClosedRequest, Message: "Request cancelled", Code: codeCancelled}
+	case ok:
+		return httpErr
+	case upstream:
+		return &HTTPError{Status: capiErr.Status, Message: capiErr.Message, Err: capiErr.Err, Code: capiErr.Code, RetryAfter: capiErr.RetryAfter}
+	case errors.Is(err, context.DeadlineExceeded):
+		return &HTTPError{Status: http.StatusGatewayTimeout, Message: "Request timed out", Code: codeTimeout}
+	default:
+		return &HTTPError{Status: http.StatusInternalServerError, Message: "Internal server error"}
+	}
+}
+
+// logWriteFailure notes a response body that couldn't be written. By then the
+// status has gone, so it's almost always the client having disconnected, not
+// a server error; there's nothing to do but stop writing.
+func logWriteFailure(what string, err error) {
+	log.Printf("Client went away mid-write of %s, %s", what, err)
+}
+
+// notFoundHandler serves a JSON 404 for any route the service doesn't have
+func notFoundHandler(w http.ResponseWriter, r *http.Request) {
+	writeError(w, &HTTPError{Status: http.StatusNotFound, Message: "Not found"})
+}
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestHTTPErrorFor(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		code   string
+	}{
+		{"cancelled", context.Canceled, statusClientClosedRequest, codeCancelled},
+		{"cancelled CAPI request", errors.Wrap(context.Canceled, "CAPI request cancelled"), statusClientClosedRequest, codeCancelled},
+		{"timed out", errors.Wrap(context.DeadlineExceeded, "CAPI request failed"), http.StatusGatewayTimeout, codeTimeout},
+		{"HTTPError", errors.Wrap(&HTTPError{Status: http.StatusNotFound, Message: "Not found"}, "while fetching"), http.StatusNotFound, codeNotFound},
+		{"anything else", errors.New("oops"), http.StatusInternalServerError, codeInternal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			httpErr := httpErrorFor(tt.err)
+			if httpErr.Status != tt.status || httpErr.code() != tt.code {
+				t.Errorf("got %d %s, want %d %s", httpErr.Status, httpErr.code(), tt.status, tt.code)
+			}
+
+			w := httptest.NewRecorder()
+			writeError(w, tt.err)
+			if w.Code != tt.status {
+				t.Errorf("written as %d, want %d", w.Code, tt.status)
+			}
+		})
+	}
+}
+
+func TestNotFoundHandler(t *testing.T) {
+	rt := newRouter()
+	rt.handleFunc("/healthz", get, healthzHandler(testConfig(t, "http://capi.invalid")), opsDocs["/healthz"])
+
+	for _, target := range []string{"/", "/search?q=brexit", "/most-viewed"} {
+		t.Run(target, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			rt.ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+
+			if w.Code != http.StatusNotFound {
+				t.Fatalf("got %d, want 404: %s", w.Code, w.Body)
+			}
+			if got := w.Header().Get("Content-Type"); got != "application/json" {
+				t.Errorf("Content-Type is %q, want application/json", got)
+			}
+
+			var body errorBody
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("got %q, which isn't a JSON error: %s", w.Body, err)
+			}
+			if body.Status != http.StatusNotFound || body.Code != codeNotFound || body.Message != "Not found" {
+				t.Errorf("got %+v, want a 404 %s", body, codeNotFound)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/guardian/onward/config"
+)
+
+// strongETag is an ETag for a response body, the hash of the body as
+// rendered. It's weakened when the body is sent compressed, so it stays the
+// same whatever the encoding.
+func strongETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:12]) + `"`
+}
+
+// etagMatches reports whether an If-None-Match header matches etag, using
+// the weak comparison that RFC 7232 requires for If-None-Match
+func etagMatches(ifNoneMatch, etag string) bool {
+	if strings.TrimSpace(ifNoneMatch) == "*" {
+		return true
+	}
+
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// writeBody writes a complete response body with its ETag, compressed if the
+// client takes it, or a 304 if the client already has it
+func writeBody(w http.ResponseWriter, r *http.Request, contentType string, body []byte) {
+	etag := strongETag(body)
+	coding := compression.negotiate(w, r, len(body))
+	if coding != "" {
+		etag = "W/" + etag
+	}
+	w.Header().Set("ETag", etag)
+
+	if match := r.Header.Get("If-None-Match"); match != "" && etagMatches(match, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	if coding != "" {
+		w.Header().Set("Content-Encoding", coding)
+		body = compression.compress(body, etag, coding)
+	}
+
+	if _, err := w.Write(body); err != nil {
+		logWriteFailure(r.URL.Path, err)
+	}
+}
+
+// setCacheControl tells the CDN how long it may cache a successful response:
+// cfg.CDNMaxAge, or however long the service's own cached copy has left if
+// that's sooner. Expires says the same for caches that predate
+// Cache-Control, and Last-Modified is when the data was fetched upstream.
+// Last-known-good data says when it was captured in X-Last-Known-Good too,
+// and X-Backend names the upstreams the data came from.
+func setCacheControl(w http.ResponseWriter, cfg config.Config, fresh *freshness) {
+	if backends := fresh.backendNames(); len(backends) > 0 {
+		w.Header().Set(backendHeader, strings.Join(backends, ", "))
+	}
+
+	fetchedAt, expiresAt := fresh.times()
+	if !fetchedAt.IsZero() {
+		w.Header().Set("Last-Modified", fetchedAt.UTC().Format(http.TimeFormat))
+	}
+
+	if fresh.isLastGood() {
+		w.Header().Set(lastGoodHeader, fetchedAt.UTC().Format(http.TimeFormat))
+	}
+
+	if cfg.CDNMaxAge <= 0 {
+		return
+	}
+
+	maxAge := cfg.CDNMaxAge
+	if !expiresAt.IsZero() {
+		if remaining := time.Until(expiresAt); remaining < maxAge {
+			maxAge = remaining
+		}
+		if maxAge < 0 {
+			maxAge = 0
+		}
+	}
+
+	value := fmt.Sprintf("public, max-age=%d", int(maxAge.Seconds()))
+	if cfg.CDNStaleWhileRevalidate > 0 {
+		value += fmt.Sprintf(", stale-while-revalidate=%d", int(cfg.CDNStaleWhileRevalidate.Seconds()))
+	}
+	if cfg.CDNStaleIfError > 0 {
+		value += fmt.Sprintf(", stale-if-error=%d", int(cfg.CDNStaleIfError.Seconds()))
+	}
+
+	w.Header().Set("Cache-Control", value)
+
+	// for older caches that don't understand Cache-Control
+	w.Header().Set("Expires", time.Now().Add(maxAge).UTC().Format(http.TimeFormat))
+}
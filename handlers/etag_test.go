@@ -0,0 +1,244 @@
+package handlers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+)
+
+func TestCacheControlUsesCDNValues(t *testing.T) {
+	tests := []struct {
+		name    string
+		ttl     time.Duration
+		maxAge  time.Duration
+		swr     time.Duration
+		sie     time.Duration
+		want    string
+		expires time.Duration
+	}{
+		{"longer cache TTL", time.Hour, time.Minute, 0, 0, "public, max-age=60", time.Minute},
+		{"with SWR", time.Hour, time.Minute, 30 * time.Second, 0, "public, max-age=60, stale-while-revalidate=30", time.Minute},
+		{"with SWR and SIE", time.Hour, 2 * time.Minute, 10 * time.Minute, time.Hour, "public, max-age=120, stale-while-revalidate=600, stale-if-error=3600", 2 * time.Minute},
+		{"no CDN max-age", time.Hour, 0, 30 * time.Second, 0, "", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(mostViewedBody("a", "b")))
+			})
+			cfg := testConfig(t, stub.URL)
+			cfg.CacheTTL = tt.ttl
+			cfg.CDNMaxAge, cfg.CDNStaleWhileRevalidate, cfg.CDNStaleIfError = tt.maxAge, tt.swr, tt.sie
+
+			w := serve(mostViewedHandler(testCAPI(cfg), testCache(t, cfg), cfg), "/most-viewed/uk")
+			if w.Code != http.StatusOK {
+				t.Fatalf("got %d, want 200: %s", w.Code, w.Body)
+			}
+
+			if got := w.Header().Get("Cache-Control"); got != tt.want {
+				t.Errorf("Cache-Control is %q, want %q", got, tt.want)
+			}
+
+			if tt.expires == 0 {
+				if got := w.Header().Get("Expires"); got != "" {
+					t.Errorf("Expires is %q, want none", got)
+				}
+				return
+			}
+			expires, err := http.ParseTime(w.Header().Get("Expires"))
+			if err != nil {
+				t.Fatalf("Expires %q doesn't parse, %s", w.Header().Get("Expires"), err)
+			}
+			if ahead := time.Until(expires); ahead < tt.expires-2*time.Second || ahead > tt.expires+time.Second {
+				t.Errorf("Expires is %s ahead, want %s", ahead, tt.expires)
+			}
+		})
+	}
+}
+
+func TestCacheControlIsCappedByCacheTTLLeft(t *testing.T) {
+	stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(mostViewedBody("a")))
+	})
+	cfg := testConfig(t, stub.URL)
+	cfg.CacheTTL = 30 * time.Second
+	cfg.CDNMaxAge = time.Hour
+
+	w := serve(mostViewedHandler(testCAPI(cfg), testCache(t, cfg), cfg), "/most-viewed/uk")
+
+	// the cached copy has just under 30s left
+	if got := w.Header().Get("Cache-Control"); got != "public, max-age=29" && got != "public, max-age=30" {
+		t.Errorf("Cache-Control is %q, want max-age of the 30s left", got)
+	}
+}
+
+func TestErrorsAreNotCached(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		capi   int
+		want   int
+	}{
+		{"no item at that position", "/most-viewed/uk/9", http.StatusOK, http.StatusNotFound},
+		{"no such page", "/most-viewed/uk?limit=1&page=9", http.StatusOK, http.StatusNotFound},
+		{"bad request", "/most-viewed/uk?limit=lots", http.StatusOK, http.StatusBadRequest},
+		{"CAPI failure", "/most-viewed/uk", http.StatusInternalServerError, http.StatusBadGateway},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.capi)
+				w.Write([]byte(mostViewedBody("a", "b")))
+			})
+			cfg := testConfig(t, stub.URL)
+			cfg.CAPIRetries = 0
+			cfg.CDNMaxAge = time.Minute
+			cfg.CDNStaleIfError = time.Hour
+
+			w := serve(mostViewedHandler(testCAPI(cfg), testCache(t, cfg), cfg), tt.target)
+			if w.Code != tt.want {
+				t.Fatalf("got %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+
+			if got := w.Header().Get("Cache-Control"); got != "no-store" {
+				t.Errorf("Cache-Control is %q, want no-store", got)
+			}
+			for _, name := range []string{"Expires", "Last-Modified", "ETag"} {
+				if got := w.Header().Get(name); got != "" {
+					t.Errorf("%s is %q, want none", name, got)
+				}
+			}
+		})
+	}
+}
+
+func TestNotModified(t *testing.T) {
+	stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(mostViewedBody("a")))
+	})
+	cfg := testConfig(t, stub.URL)
+	h := mostViewedHandler(testCAPI(cfg), testCache(t, cfg), cfg)
+
+	first := serve(h, "/most-viewed/uk")
+	etag := first.Header().Get("ETag")
+	if etag == "" || first.Header().Get("Last-Modified") == "" {
+		t.Fatalf("got ETag %q and Last-Modified %q, want both", etag, first.Header().Get("Last-Modified"))
+	}
+
+	r := httptest.NewRequest("GET", "/most-viewed/uk", nil)
+	r.Header.Set("If-None-Match", etag)
+	w := httptest.NewRecorder()
+	h(w, r)
+
+	if w.Code != http.StatusNotModified {
+		t.Errorf("got %d, want 304", w.Code)
+	}
+	if w.Body.Len() != 0 {
+		t.Errorf("got body %q, want none", w.Body)
+	}
+}
+
+// withCompression turns compression on for the test, of bodies of any size
+func withCompression(t *testing.T) {
+	t.Helper()
+
+	compression.configure(true, 0, 1<<20, time.Minute)
+	t.Cleanup(func() { compression.configure(false, 0, 0, 0) })
+}
+
+func TestETagsAreWeakenedByCompression(t *testing.T) {
+	withCompression(t)
+	body := []byte(strings.Repeat(`{"url":"politics/1"}`, 20))
+	strong := strongETag(body)
+
+	tests := []struct {
+		acceptEncoding string
+		coding         string
+		etag           string
+		decode         func(io.Reader) (io.Reader, error)
+	}{
+		{"", "", strong, func(r io.Reader) (io.Reader, error) { return r, nil }},
+		{"gzip", encodingGzip, "W/" + strong, func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) }},
+		{"gzip, br", encodingBrotli, "W/" + strong, func(r io.Reader) (io.Reader, error) { return brotli.NewReader(r), nil }},
+	}
+
+	for _, tt := range tests {
+		t.Run("Accept-Encoding "+tt.acceptEncoding, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/most-viewed/uk", nil)
+			r.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			w := httptest.NewRecorder()
+			writeBody(w, r, "application/json", body)
+
+			if got := w.Header().Get("ETag"); got != tt.etag {
+				t.Errorf("ETag is %s, want %s", got, tt.etag)
+			}
+			if got := w.Header().Get("Content-Encoding"); got != tt.coding {
+				t.Errorf("Content-Encoding is %q, want %q", got, tt.coding)
+			}
+
+			decoded, err := tt.decode(w.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := ioutil.ReadAll(decoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, body) {
+				t.Errorf("decoded body is %q, want %q", got, body)
+			}
+		})
+	}
+}
+
+func TestIfNoneMatchAcrossEncodings(t *testing.T) {
+	withCompression(t)
+	body := []byte(strings.Repeat(`{"url":"politics/1"}`, 20))
+
+	etags := map[string]string{}
+	for _, acceptEncoding := range []string{"identity", "gzip", "br"} {
+		r := httptest.NewRequest("GET", "/most-viewed/uk", nil)
+		r.Header.Set("Accept-Encoding", acceptEncoding)
+		w := httptest.NewRecorder()
+		writeBody(w, r, "application/json", body)
+		etags[acceptEncoding] = w.Header().Get("ETag")
+	}
+
+	for from, etag := range etags {
+		for _, acceptEncoding := range []string{"identity", "gzip", "br"} {
+			t.Run(from+" ETag, "+acceptEncoding+" request", func(t *testing.T) {
+				r := httptest.NewRequest("GET", "/most-viewed/uk", nil)
+				r.Header.Set("Accept-Encoding", acceptEncoding)
+				r.Header.Set("If-None-Match", etag)
+				w := httptest.NewRecorder()
+				writeBody(w, r, "application/json", body)
+
+				if w.Code != http.StatusNotModified {
+					t.Errorf("got %d, want 304", w.Code)
+				}
+				if w.Body.Len() != 0 {
+					t.Errorf("got a %d byte body, want none", w.Body.Len())
+				}
+			})
+		}
+	}
+
+	r := httptest.NewRequest("GET", "/most-viewed/uk", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	r.Header.Set("If-None-Match", `W/"stale", "also-stale"`)
+	w := httptest.NewRecorder()
+	writeBody(w, r, "application/json", body)
+	if w.Code != http.StatusOK {
+		t.Errorf("with a stale ETag got %d, want 200", w.Code)
+	}
+}
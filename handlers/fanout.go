@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/guardian/onward/capi"
+	"github.com/guardian/onward/config"
+)
+
+// fetchEditions calls fetch for each edition, with at most workers calls in
+// flight at once. Results and errors are returned in the order of editions.
+func fetchEditions(editions []string, workers int, fetch func(edition string) (capi.Response, error)) ([]capi.Response, []error) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	results := make([]capi.Response, len(editions))
+	errs := make([]error, len(editions))
+	sem := make(chan struct{}, workers)
+
+	var wg sync.WaitGroup
+	for i, edition := range editions {
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func(i int, edition string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			results[i], errs[i] = fetch(edition)
+		}(i, edition)
+	}
+
+	wg.Wait()
+	return results, errs
+}
+
+// editionList is an edition's entry in /most-viewed/all: its list, or the
+// error that kept it out
+type editionList struct {
+	*ItemList
+	Error *errorBody `json:"error,omitempty"`
+}
+
+// allEditionsHandler serves the most-viewed lists of every edition, keyed by
+// edition. An edition that can't be fetched gets an error in place of its
+// list, unless none can, when the response is the first edition's error.
+func allEditionsHandler(api capi.Client, c Cache, cfg config.Config) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.RequestBudget)
+		defer cancel()
+		ctx = withCacheDirective(ctx, requestCacheDirective(r))
+		ctx, fresh := withFreshness(ctx)
+
+		results, errs := fetchEditions(config.Editions, cfg.FanOutWorkers, func(edition string) (capi.Response, error) {
+			return cachedGet(ctx, api, query{Path: edition, MostViewed: true}, c, cfg)
+		})
+
+		id := w.Header().Get(requestIDHeader)
+		lists := map[string]ItemList{}
+		failures := map[string]*errorBody{}
+		var fetched []string
+		for i, edition := range config.Editions {
+			if errs[i] != nil {
+				log.Printf("Leaving %s out of all editions, %s (request %s)", edition, errs[i], id)
+
+				httpErr := httpErrorFor(errs[i])
+				failures[edition] = &errorBody{Status: httpErr.Status, Code: httpErr.code(), Message: httpErr.Message}
+				continue
+			}
+
+			lists[edition] = asItemList(results[i], edition, editionHeading(cfg, edition))
+			fetched = append(fetched, edition)
+		}
+
+		if len(fetched) == 0 {
+			writeError(w, errs[0])
+			return
+		}
+
+		if r.URL.Query().Get("merge-duplicates") == "true" {
+			mergeDuplicates(fetched, lists)
+		}
+
+		response := map[string]editionList{}
+		for edition := range lists {
+			list := lists[edition]
+			response[edition] = editionList{ItemList: &list}
+		}
+		for edition, failure := range failures {
+			response[edition] = editionList{Error: failure}
+		}
+
+		// a partial response mustn't be cached, so the missing editions are
+		// tried again on the next request
+		if len(failures) > 0 {
+			now := time.Now()
+			fresh.note(now, now)
+		}
+
+		setCacheControl(w, cfg, fresh)
+		writeBody(w, r, "application/json", renderOptionsFor(r, cfg).json(response))
+	}
+}
+
+// mergeDuplicates removes trails that already appeared in an earlier edition,
+// in the order given, noting the later editions on the first occurrence
+func mergeDuplicates(editions []string, lists map[string]ItemList) {
+	type position struct {
+		edition string
+		index   int
+	}
+	first := map[string]position{}
+
+	for _, edition := range editions {
+		list := lists[edition]
+		var kept []Item
+
+		for _, item := range list.Trails {
+			if p, seen := first[item.URL]; seen {
+				if p.edition != edition {
+					original := &lists[p.edition].Trails[p.index]
+					if n := len(original.AlsoIn); n == 0 || original.AlsoIn[n-1] != edition {
+						original.AlsoIn = append(original.AlsoIn, edition)
+					}
+				}
+				continue
+			}
+
+			first[item.URL] = position{edition: edition, index: len(kept)}
+			kept = append(kept, item)
+		}
+
+		list.Trails = kept
+		lists[edition] = list
+	}
+}
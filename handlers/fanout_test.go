@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/guardian/onward/capi"
+)
+
+func TestFetchEditions(t *testing.T) {
+	editions := []string{"uk", "us", "au", "international", "europe", "uk/sport", "us/sport"}
+	const workers = 2
+
+	var mu sync.Mutex
+	inFlight, most := 0, 0
+	results, errs := fetchEditions(editions, workers, func(edition string) (capi.Response, error) {
+		mu.Lock()
+		inFlight++
+		if inFlight > most {
+			most = inFlight
+		}
+		mu.Unlock()
+
+		time.Sleep(5 * time.Millisecond)
+
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+
+		if edition == "au" {
+			return capi.Response{}, errors.New("unavailable")
+		}
+		var resp capi.Response
+		resp.Response.Results = capiItems(edition)
+		return resp, nil
+	})
+
+	if most > workers {
+		t.Errorf("%d fetches ran at once, want at most %d", most, workers)
+	}
+	for i, edition := range editions {
+		if edition == "au" {
+			if errs[i] == nil {
+				t.Errorf("%s didn't fail", edition)
+			}
+			continue
+		}
+		if errs[i] != nil {
+			t.Errorf("%s failed, %s", edition, errs[i])
+			continue
+		}
+		if ids := itemIDs(results[i].Response.Results); len(ids) != 1 || ids[0] != edition {
+			t.Errorf("result %d is %v, want %s's", i, ids, edition)
+		}
+	}
+}
+
+func TestMergeDuplicates(t *testing.T) {
+	trails := func(urls ...string) ItemList {
+		il := ItemList{}
+		for _, url := range urls {
+			il.Trails = append(il.Trails, Item{URL: url})
+		}
+		return il
+	}
+	lists := map[string]ItemList{
+		"uk": trails("budget", "uk-only"),
+		"us": trails("election", "budget", "budget"),
+		"au": trails("budget", "election", "au-only"),
+	}
+
+	mergeDuplicates([]string{"uk", "us", "au"}, lists)
+
+	want := map[string][]Item{
+		"uk": {{URL: "budget", AlsoIn: []string{"us", "au"}}, {URL: "uk-only"}},
+		"us": {{URL: "election", AlsoIn: []string{"au"}}},
+		"au": {{URL: "au-only"}},
+	}
+	for edition, trails := range want {
+		if got := lists[edition].Trails; !reflect.DeepEqual(got, trails) {
+			t.Errorf("%s is %+v, want %+v", edition, got, trails)
+		}
+	}
+}
+
+func TestAllEditionsMergeDuplicates(t *testing.T) {
+	stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/uk" || r.URL.Path == "/us" {
+			w.Write([]byte(mostViewedBody("budget", strings.TrimPrefix(r.URL.Path, "/")+"-only")))
+			return
+		}
+		w.Write([]byte(mostViewedBody(strings.TrimPrefix(r.URL.Path, "/") + "-only")))
+	})
+	cfg := testConfig(t, stub.URL)
+	h := allEditionsHandler(testCAPI(cfg), testCache(t, cfg), cfg)
+
+	tests := []struct {
+		target string
+		uk     []string
+		us     []string
+		alsoIn []string
+	}{
+		{"/most-viewed/all", []string{"budget", "uk-only"}, []string{"budget", "us-only"}, nil},
+		{"/most-viewed/all?merge-duplicates=true", []string{"budget", "uk-only"}, []string{"us-only"}, []string{"us"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			w := serve(h, tt.target)
+			if w.Code != http.StatusOK {
+				t.Fatalf("got %d, want 200: %s", w.Code, w.Body)
+			}
+
+			var editions map[string]struct {
+				Trails []struct {
+					URL    string   `json:"url"`
+					AlsoIn []string `json:"alsoIn"`
+				} `json:"trails"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &editions); err != nil {
+				t.Fatal(err)
+			}
+
+			for edition, want := range map[string][]string{"uk": tt.uk, "us": tt.us} {
+				var urls []string
+				for _, trail := range editions[edition].Trails {
+					urls = append(urls, trail.URL)
+				}
+				if !reflect.DeepEqual(urls, want) {
+					t.Errorf("%s has trails %v, want %v", edition, urls, want)
+				}
+			}
+			if got := editions["uk"].Trails[0].AlsoIn; !reflect.DeepEqual(got, tt.alsoIn) {
+				t.Errorf("uk's budget is also in %v, want %v", got, tt.alsoIn)
+			}
+		})
+	}
+}
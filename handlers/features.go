@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Features that can be switched off at runtime. They're all on unless the
+// flag provider says otherwise.
+const (
+	featureStaleServing = "stale-serving"
+	featureBackfill     = "backfill"
+	featureV2           = "v2"
+)
+
+var knownFeatures = []string{featureStaleServing, featureBackfill, featureV2}
+
+// FlagProvider is a source of feature flags. Flags it doesn't mention keep
+// their defaults.
+type FlagProvider interface {
+	Flags(ctx context.Context, names []string) (map[string]bool, error)
+}
+
+// envFlags reads flags from the environment, ONWARD_FEATURE_STALE_SERVING
+// for "stale-serving" and so on
+type envFlags struct{}
+
+func (envFlags) Flags(ctx context.Context, names []string) (map[string]bool, error) {
+	flags := map[string]bool{}
+
+	for _, name := range names {
+		key := "ONWARD_FEATURE_" + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+		if raw, ok := os.LookupEnv(key); ok {
+			on, err := strconv.ParseBool(raw)
+			if err != nil {
+				log.Printf("Ignoring %s, %s", key, err)
+				continue
+			}
+			flags[name] = on
+		}
+	}
+
+	return flags, nil
+}
+
+// featureFlags caches a provider's flags, refreshing them in the background
+type featureFlags struct {
+	mu    sync.RWMutex
+	flags map[string]bool
+}
+
+// features are the service's feature flags. Until New sets a provider
+// everything is on.
+var features = &featureFlags{}
+
+// enabled reports whether a feature is on
+func (f *featureFlags) enabled(name string) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	on, ok := f.flags[name]
+	return !ok || on
+}
+
+// refresh replaces the cached flags with the provider's. On failure the old
+// ones are kept.
+func (f *featureFlags) refresh(ctx context.Context, provider FlagProvider) {
+	flags, err := provider.Flags(ctx, knownFeatures)
+	if err != nil {
+		log.Printf("Unable to refresh feature flags, %s", err)
+		return
+	}
+
+	f.mu.Lock()
+	f.flags = flags
+	f.mu.Unlock()
+}
+
+// watch refreshes the flags from provider every interval, until ctx is done
+func (f *featureFlags) watch(ctx context.Context, provider FlagProvider, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			f.refresh(ctx, provider)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
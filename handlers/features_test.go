@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// fakeFlags is a flag provider whose flags can be changed as it goes, or
+// made to fail
+type fakeFlags struct {
+	mu    sync.Mutex
+	flags map[string]bool
+	err   error
+}
+
+func (f *fakeFlags) Flags(ctx context.Context, names []string) (map[string]bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	flags := map[string]bool{}
+	for name, on := range f.flags {
+		flags[name] = on
+	}
+	return flags, f.err
+}
+
+func (f *fakeFlags) set(name string, on bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.flags[name] = on
+}
+
+// useFeatures swaps in a fresh set of feature flags for the test, restoring
+// the service's afterwards
+func useFeatures(t *testing.T) *featureFlags {
+	t.Helper()
+
+	saved := features
+	features = &featureFlags{}
+	t.Cleanup(func() { features = saved })
+
+	return features
+}
+
+func TestFeatureFlags(t *testing.T) {
+	f := useFeatures(t)
+	provider := &fakeFlags{flags: map[string]bool{}}
+
+	for _, name := range knownFeatures {
+		if !f.enabled(name) {
+			t.Errorf("%s is off before any flags are read, want it on", name)
+		}
+	}
+
+	provider.set(featureBackfill, false)
+	f.refresh(context.Background(), provider)
+	if f.enabled(featureBackfill) {
+		t.Error("backfill is on, want the provider to have switched it off")
+	}
+	if !f.enabled(featureStaleServing) {
+		t.Error("stale-serving is off, want the provider's silence to leave it on")
+	}
+
+	// a failing provider leaves the flags as they were
+	provider.err = errors.New("flag service unavailable")
+	f.refresh(context.Background(), provider)
+	if f.enabled(featureBackfill) {
+		t.Error("backfill is on after a failed refresh, want it left off")
+	}
+}
+
+func TestFeatureFlagsAreWatched(t *testing.T) {
+	f := useFeatures(t)
+	provider := &fakeFlags{flags: map[string]bool{}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		f.watch(ctx, provider, 5*time.Millisecond)
+		close(done)
+	}()
+
+	provider.set(featureV2, false)
+	for deadline := time.Now().Add(time.Second); f.enabled(featureV2); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("v2 is still on, want the refresh to have switched it off")
+		}
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("watch didn't stop once its context was done")
+	}
+}
+
+func TestEnvFlags(t *testing.T) {
+	t.Setenv("ONWARD_FEATURE_STALE_SERVING", "false")
+	t.Setenv("ONWARD_FEATURE_BACKFILL", "not-a-bool")
+
+	flags, err := envFlags{}.Flags(context.Background(), knownFeatures)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if on, ok := flags[featureStaleServing]; !ok || on {
+		t.Errorf("stale-serving is %v (set %v), want it off", on, ok)
+	}
+	for _, name := range []string{featureBackfill, featureV2} {
+		if _, ok := flags[name]; ok {
+			t.Errorf("%s is set, want it left to its default", name)
+		}
+	}
+}
+
+func TestBackfillCanBeSwitchedOff(t *testing.T) {
+	stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"response":{"status":"ok",
+			"mostViewed":[{"id":"a","type":"article"}],
+			"results":[{"id":"b","type":"article"},{"id":"c","type":"article"}]}}`))
+	})
+	f := useFeatures(t)
+	provider := &fakeFlags{flags: map[string]bool{}}
+
+	tests := []struct {
+		name     string
+		backfill bool
+		trails   int
+	}{
+		{"on", true, 3},
+		{"off", false, 1},
+		{"back on", true, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider.set(featureBackfill, tt.backfill)
+			f.refresh(context.Background(), provider)
+
+			cfg := testConfig(t, stub.URL)
+			cfg.MinItems = 3
+
+			w := serve(mostViewedHandler(testCAPI(cfg), testCache(t, cfg), cfg), "/most-viewed/uk")
+			if w.Code != http.StatusOK {
+				t.Fatalf("got %d, want 200: %s", w.Code, w.Body)
+			}
+
+			var il struct {
+				Trails []json.RawMessage `json:"trails"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &il); err != nil {
+				t.Fatal(err)
+			}
+			if len(il.Trails) != tt.trails {
+				t.Errorf("got %d trails, want %d", len(il.Trails), tt.trails)
+			}
+		})
+	}
+}
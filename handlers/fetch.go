@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/guardian/onward/capi"
+	"github.com/guardian/onward/config"
+	"github.com/pkg/errors"
+)
+
+// FetchList fetches a list straight from CAPI with api, uncached, and maps it
+// as the server would, with any -overrides applied and cut to limit trails
+// (0 for all). kind is "most-viewed", for an edition or section path, or
+// "related", for a content path. Asking for anything else is a 400
+// HTTPError.
+func FetchList(ctx context.Context, api capi.Client, cfg config.Config, kind, path string, limit int) (ItemList, error) {
+	var q query
+	var heading string
+	switch path = normalizePath(path); kind {
+	case "most-viewed":
+		if !config.IsEdition(path) && !isEditionSection(path) {
+			return ItemList{}, &HTTPError{Status: http.StatusBadRequest, Message: pathError(path).Error()}
+		}
+		q, heading = query{Path: path, MostViewed: true}, editionHeading(cfg, path)
+	case "related":
+		q, heading = query{Path: webPath(path), Related: true}, cfg.RelatedHeading
+	default:
+		return ItemList{}, &HTTPError{Status: http.StatusBadRequest, Message: fmt.Sprintf("%q can't be fetched, only most-viewed or related", kind)}
+	}
+
+	images.configure(cfg.ImageResizerURL, cfg.ImageSalt, cfg.ImageWidths, cfg.ImageQuality)
+	if err := openOverrides(cfg); err != nil {
+		return ItemList{}, err
+	}
+
+	if err := overrides.reload(ctx, api, cfg); err != nil {
+		return ItemList{}, errors.Wrap(err, "Unable to load overrides")
+	}
+
+	items, err := capiGet(ctx, api, q, cfg)
+	if err != nil {
+		return ItemList{}, errors.Wrapf(err, "Unable to fetch %s", q.Path)
+	}
+
+	return asItemList(items, q.Path, heading).limit(limit), nil
+}
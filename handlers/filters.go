@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// filter returns the list with only the trails keep returns true for
+func (il ItemList) filter(keep func(Item) bool) ItemList {
+	trails := []Item{}
+	for _, item := range il.Trails {
+		if keep(item) {
+			trails = append(trails, item)
+		}
+	}
+
+	il.Trails = trails
+	return il
+}
+
+// applyFilters narrows the list by the request's filter parameters. It runs
+// on the mapped list, after the cache, so filters don't fragment the cache,
+// and before anything that limits the list's length.
+func applyFilters(il ItemList, r *http.Request) ItemList {
+	switch r.URL.Query().Get("liveblog") {
+	case "true":
+		il = il.filter(func(item Item) bool { return item.IsLiveblog })
+	case "false":
+		il = il.filter(func(item Item) bool { return !item.IsLiveblog })
+	}
+
+	if r.URL.Query().Get("exclude-liveblogs") == "true" {
+		il = il.filter(func(item Item) bool { return !item.IsLiveblog })
+	}
+
+	if excluded := excludedTones(r); len(excluded) > 0 {
+		il = il.filter(func(item Item) bool { return !item.hasTone(excluded) })
+	}
+
+	if r.URL.Query().Get("has-image") == "true" {
+		il = il.filter(func(item Item) bool { return item.Image != "" })
+	}
+
+	return il
+}
+
+// excludedTones are the tone tags in the request's ?exclude-tone=, e.g.
+// tone/minutebyminute,tone/advertisement-features
+func excludedTones(r *http.Request) map[string]bool {
+	excluded := map[string]bool{}
+	for _, tone := range strings.Split(r.URL.Query().Get("exclude-tone"), ",") {
+		if tone = strings.TrimSpace(tone); tone != "" {
+			excluded[tone] = true
+		}
+	}
+
+	return excluded
+}
+
+// hasTone reports whether the trail has any of the tones
+func (item Item) hasTone(tones map[string]bool) bool {
+	for _, tone := range item.Tones {
+		if tones[tone] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// maxSeenBytes bounds a POSTed list of seen trails
+const maxSeenBytes = 64 << 10
+
+// requestedSeen is the set of trails the client has already seen, as CAPI
+// paths. They're listed, as IDs or guardian.com URLs, in ?dedupe= and, for a
+// POST, a JSON array body.
+func requestedSeen(r *http.Request) (map[string]bool, error) {
+	ids := strings.Split(r.URL.Query().Get("dedupe"), ",")
+
+	if r.Method == http.MethodPost {
+		var posted []string
+		err := json.NewDecoder(io.LimitReader(r.Body, maxSeenBytes)).Decode(&posted)
+		if err != nil && err != io.EOF {
+			return nil, &HTTPError{Status: http.StatusBadRequest, Message: "Seen trails must be a JSON array of IDs", Err: err}
+		}
+
+		ids = append(ids, posted...)
+	}
+
+	seen := map[string]bool{}
+	for _, id := range ids {
+		if path := webPath(strings.TrimSpace(id)); path != "" {
+			seen[path] = true
+		}
+	}
+
+	return seen, nil
+}
+
+// dedupe drops the trails the client has already seen. It runs before the
+// list is limited, so later trails move up to make up the numbers.
+func (il ItemList) dedupe(seen map[string]bool) ItemList {
+	if len(seen) == 0 {
+		return il
+	}
+
+	return il.filter(func(item Item) bool { return !seen[item.URL] })
+}
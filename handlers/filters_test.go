@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHasImage(t *testing.T) {
+	var showFields string
+	stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		showFields = r.URL.Query().Get("show-fields")
+		w.Write([]byte(`{"response":{"status":"ok","mostViewed":[
+			{"id":"with-image","type":"article","fields":{"headline":"With","thumbnail":"https://media.guim.co.uk/1/500.jpg"}},
+			{"id":"no-fields","type":"article"},
+			{"id":"no-thumbnail","type":"article","fields":{"headline":"Without"}},
+			{"id":"empty-thumbnail","type":"article","fields":{"headline":"Empty","thumbnail":""}},
+			{"id":"also-with-image","type":"article","fields":{"headline":"Also","thumbnail":"https://media.guim.co.uk/2/500.jpg"}}
+		]}}`))
+	})
+	cfg := testConfig(t, stub.URL)
+	cfg.LiveblogStyle = "bool" // so trails decode as Items
+	h := mostViewedHandler(testCAPI(cfg), testCache(t, cfg), cfg)
+
+	var il struct {
+		Trails []Item `json:"trails"`
+	}
+	decode := func(target string) {
+		t.Helper()
+
+		w := serve(h, target)
+		if w.Code != http.StatusOK {
+			t.Fatalf("got %d, want 200: %s", w.Code, w.Body)
+		}
+		il.Trails = nil
+		if err := json.Unmarshal(w.Body.Bytes(), &il); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	decode("/most-viewed/uk")
+	if len(il.Trails) != 5 {
+		t.Fatalf("got %d trails unfiltered, want 5", len(il.Trails))
+	}
+	if got := il.Trails[0].Image; got != "https://media.guim.co.uk/1/500.jpg" {
+		t.Errorf("first trail's image is %q, want its thumbnail", got)
+	}
+	if !strings.Contains(","+showFields+",", ",thumbnail,") {
+		t.Errorf("CAPI was asked for fields %q, without thumbnail", showFields)
+	}
+
+	decode("/most-viewed/uk?has-image=true")
+	var urls, images []string
+	for _, trail := range il.Trails {
+		urls = append(urls, trail.URL)
+		images = append(images, trail.Image)
+	}
+	if want := []string{"with-image", "also-with-image"}; !reflect.DeepEqual(urls, want) {
+		t.Errorf("has-image kept %v, want %v", urls, want)
+	}
+	if want := []string{"https://media.guim.co.uk/1/500.jpg", "https://media.guim.co.uk/2/500.jpg"}; !reflect.DeepEqual(images, want) {
+		t.Errorf("has-image trails have images %v, want %v", images, want)
+	}
+}
+
+func TestLiveblogFilter(t *testing.T) {
+	stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"response":{"status":"ok","mostViewed":[
+			{"id":"article","type":"article"},
+			{"id":"liveblog","type":"liveblog"},
+			{"id":"minute-by-minute","type":"article","tags":[{"id":"tone/minutebyminute","type":"tone"}]},
+			{"id":"another-article","type":"article"},
+			{"id":"blogging-now","type":"article","fields":{"liveBloggingNow":"true"}}
+		]}}`))
+	})
+	cfg := testConfig(t, stub.URL)
+	h := mostViewedHandler(testCAPI(cfg), testCache(t, cfg), cfg)
+
+	tests := []struct {
+		target string
+		want   []string
+	}{
+		{"/most-viewed/uk", []string{"article", "liveblog", "minute-by-minute", "another-article", "blogging-now"}},
+		{"/most-viewed/uk?liveblog=true", []string{"liveblog", "minute-by-minute", "blogging-now"}},
+		{"/most-viewed/uk?liveblog=false", []string{"article", "another-article"}},
+		{"/most-viewed/uk?exclude-liveblogs=true", []string{"article", "another-article"}},
+		// filtered before the limit, so it's the first two liveblogs
+		{"/most-viewed/uk?liveblog=true&limit=2", []string{"liveblog", "minute-by-minute"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			w := serve(h, tt.target)
+			if w.Code != http.StatusOK {
+				t.Fatalf("got %d, want 200: %s", w.Code, w.Body)
+			}
+			if got := decodeTrails(t, w).urls(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
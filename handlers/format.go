@@ -0,0 +1,414 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+const guardianURL = "https://www.theguardian.com"
+
+// format is an output format for an ItemList
+type format string
+
+const (
+	formatJSON   format = "json"
+	formatNDJSON format = "ndjson"
+	formatRSS    format = "rss"
+	formatCSV    format = "csv"
+
+	// formatMsgpack is MessagePack, a compact binary alternative to JSON
+	formatMsgpack format = "msgpack"
+
+	// formatSitemap is an XML sitemap of the trails' URLs, for SEO tooling
+	formatSitemap format = "sitemap"
+
+	// formatJSONFeed is a JSON Feed 1.1 (https://jsonfeed.org), for feed
+	// readers
+	formatJSONFeed format = "jsonfeed"
+
+	// formatAtom is an Atom (RFC 4287) feed, for readers that prefer it to RSS
+	formatAtom format = "atom"
+)
+
+var contentTypes = map[format]string{
+	formatJSON:   "application/json",
+	formatNDJSON: "application/x-ndjson",
+	formatRSS:    "application/rss+xml; charset=utf-8",
+	formatCSV:    "text/csv; charset=utf-8",
+
+	formatMsgpack: "application/msgpack",
+	formatSitemap: "application/xml; charset=utf-8",
+
+	formatJSONFeed: "application/feed+json",
+	formatAtom:     "application/atom+xml; charset=utf-8",
+}
+
+// splitFormat strips a recognised extension (e.g. ".json") from the path and
+// returns the format it selects, or an empty format if there isn't one.
+func splitFormat(path string) (string, format) {
+	i := strings.LastIndex(path, ".")
+	if i == -1 || strings.Contains(path[i:], "/") {
+		return path, ""
+	}
+
+	f := format(path[i+1:])
+	if _, ok := contentTypes[f]; !ok {
+		return path, ""
+	}
+
+	return path[:i], f
+}
+
+// requestedFormat picks a format from the request's ?format=, if it has one,
+// or its Accept header
+func requestedFormat(r *http.Request) (format, error) {
+	raw := r.URL.Query().Get("format")
+	if raw == "" {
+		return acceptFormat(r), nil
+	}
+
+	if _, ok := contentTypes[format(raw)]; !ok {
+		return "", &HTTPError{Status: http.StatusBadRequest, Message: "Unknown format"}
+	}
+
+	return format(raw), nil
+}
+
+// acceptFormat picks a format from the request's Accept header, defaulting
+// to JSON.
+func acceptFormat(r *http.Request) format {
+	accept := r.Header.Get("Accept")
+
+	switch {
+	case strings.Contains(accept, contentTypes[formatNDJSON]):
+		return formatNDJSON
+	case strings.Contains(accept, contentTypes[formatMsgpack]):
+		return formatMsgpack
+	case strings.Contains(accept, "application/rss+xml"):
+		return formatRSS
+	case strings.Contains(accept, "application/atom+xml"):
+		return formatAtom
+	}
+
+	return formatJSON
+}
+
+// renderItemList renders the list in any format but NDJSON, which is
+// streamed by writeNDJSON instead
+func renderItemList(il ItemList, f format, opts renderOptions) []byte {
+	switch f {
+	case formatRSS:
+		return il.asRSS()
+	case formatCSV:
+		return il.asCSV()
+	case formatMsgpack:
+		return asMsgpack(il)
+	case formatSitemap:
+		return il.asSitemap()
+	case formatJSONFeed:
+		return il.asJSONFeed()
+	case formatAtom:
+		return il.asAtom(time.Now())
+	}
+
+	return opts.listJSON(il)
+}
+
+// writeNDJSON streams each trail as its own line of JSON, flushing as it goes
+// so clients can start on the list before all of it has arrived. The stream
+// stops as soon as the client goes away.
+func writeNDJSON(w http.ResponseWriter, r *http.Request, il ItemList, opts renderOptions) {
+	w.Header().Set("Content-Type", contentTypes[formatNDJSON])
+
+	flusher, _ := w.(http.Flusher)
+
+	for _, item := range il.Trails {
+		if err := r.Context().Err(); err != nil {
+			logWriteFailure(r.URL.Path, err)
+			return
+		}
+
+		if _, err := w.Write(append(opts.marshal(item), '\n')); err != nil {
+			logWriteFailure(r.URL.Path, err)
+			return
+		}
+
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
+
+// asMsgpack encodes a list or a trail as MessagePack, with the same field
+// names as the JSON
+func asMsgpack(v interface{}) []byte {
+	var buf bytes.Buffer
+
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	if err := enc.Encode(v); err != nil {
+		log.Fatalf("Unable to encode MessagePack (should never happen), %s", err)
+	}
+
+	return buf.Bytes()
+}
+
+type rss struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	DC      string     `xml:"xmlns:dc,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title       string    `xml:"title"`
+	Link        string    `xml:"link"`
+	Description string    `xml:"description"`
+	Items       []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string `xml:"title"`
+	Link        string `xml:"link"`
+	GUID        string `xml:"guid"`
+	Description string `xml:"description,omitempty"`
+	Creator     string `xml:"dc:creator,omitempty"`
+	PubDate     string `xml:"pubDate,omitempty"`
+}
+
+func (il ItemList) asRSS() []byte {
+	feed := rss{
+		Version: "2.0",
+		DC:      "http://purl.org/dc/elements/1.1/",
+		Channel: rssChannel{
+			Title:       il.Heading,
+			Link:        guardianURL,
+			Description: il.Heading,
+		},
+	}
+
+	for _, item := range il.Trails {
+		link := guardianURL + "/" + item.URL
+		entry := rssItem{
+			Title:       item.LinkText,
+			Link:        link,
+			GUID:        link,
+			Description: item.Byline,
+			Creator:     item.Byline,
+		}
+
+		if item.PublishedAt != nil {
+			entry.PubDate = item.PublishedAt.Format(time.RFC1123Z)
+		}
+
+		feed.Channel.Items = append(feed.Channel.Items, entry)
+	}
+
+	out, err := xml.Marshal(feed)
+	if err != nil {
+		log.Fatalf("Unable to marshal item list as RSS (should never happen), %s", err)
+	}
+
+	return append([]byte(xml.Header), out...)
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Link    atomLink    `xml:"link"`
+	Author  atomAuthor  `xml:"author"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	ID        string      `xml:"id"`
+	Title     string      `xml:"title"`
+	Link      atomLink    `xml:"link"`
+	Updated   string      `xml:"updated"`
+	Published string      `xml:"published,omitempty"`
+	Author    *atomAuthor `xml:"author,omitempty"`
+}
+
+// asAtom renders the list as an Atom feed. Atom requires every entry to have
+// an updated time, so trails without a publication date, and the feed
+// itself, are stamped with the newest trail's, or now if none has one. The
+// same list renders the same way, so its ETag holds.
+func (il ItemList) asAtom(now time.Time) []byte {
+	var newest time.Time
+	for _, item := range il.Trails {
+		if item.PublishedAt != nil && item.PublishedAt.After(newest) {
+			newest = *item.PublishedAt
+		}
+	}
+	if newest.IsZero() {
+		newest = now
+	}
+
+	stamp := newest.UTC().Format(time.RFC3339)
+
+	feed := atomFeed{
+		ID:      guardianURL + "/",
+		Title:   il.Heading,
+		Updated: stamp,
+		Link:    atomLink{Href: guardianURL},
+		Author:  atomAuthor{Name: "The Guardian"},
+	}
+
+	for _, item := range il.Trails {
+		link := guardianURL + "/" + item.URL
+		entry := atomEntry{
+			ID:      link,
+			Title:   item.LinkText,
+			Link:    atomLink{Href: link},
+			Updated: stamp,
+		}
+
+		if item.PublishedAt != nil {
+			entry.Published = item.PublishedAt.UTC().Format(time.RFC3339)
+			entry.Updated = entry.Published
+		}
+
+		if item.Byline != "" {
+			entry.Author = &atomAuthor{Name: item.Byline}
+		}
+
+		feed.Entries = append(feed.Entries, entry)
+	}
+
+	out, err := xml.Marshal(feed)
+	if err != nil {
+		log.Fatalf("Unable to marshal item list as an Atom feed (should never happen), %s", err)
+	}
+
+	return append([]byte(xml.Header), out...)
+}
+
+type sitemap struct {
+	XMLName xml.Name     `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+func (il ItemList) asSitemap() []byte {
+	var urls sitemap
+
+	for _, item := range il.Trails {
+		entry := sitemapURL{Loc: guardianURL + "/" + item.URL}
+
+		if item.PublishedAt != nil {
+			entry.LastMod = item.PublishedAt.Format(time.RFC3339)
+		}
+
+		urls.URLs = append(urls.URLs, entry)
+	}
+
+	out, err := xml.Marshal(urls)
+	if err != nil {
+		log.Fatalf("Unable to marshal item list as a sitemap (should never happen), %s", err)
+	}
+
+	return append([]byte(xml.Header), out...)
+}
+
+type jsonFeed struct {
+	Version     string         `json:"version"`
+	Title       string         `json:"title"`
+	HomePageURL string         `json:"home_page_url"`
+	Items       []jsonFeedItem `json:"items"`
+}
+
+type jsonFeedItem struct {
+	ID            string           `json:"id"`
+	URL           string           `json:"url"`
+	Title         string           `json:"title"`
+	Image         string           `json:"image,omitempty"`
+	DatePublished string           `json:"date_published,omitempty"`
+	Authors       []jsonFeedAuthor `json:"authors,omitempty"`
+}
+
+type jsonFeedAuthor struct {
+	Name string `json:"name"`
+}
+
+func (il ItemList) asJSONFeed() []byte {
+	feed := jsonFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       il.Heading,
+		HomePageURL: guardianURL,
+		Items:       []jsonFeedItem{},
+	}
+
+	for _, item := range il.Trails {
+		link := guardianURL + "/" + item.URL
+		entry := jsonFeedItem{
+			ID:    link,
+			URL:   link,
+			Title: item.LinkText,
+			Image: item.Image,
+		}
+
+		if item.PublishedAt != nil {
+			entry.DatePublished = item.PublishedAt.Format(time.RFC3339)
+		}
+
+		if item.Byline != "" {
+			entry.Authors = []jsonFeedAuthor{{Name: item.Byline}}
+		}
+
+		feed.Items = append(feed.Items, entry)
+	}
+
+	out, err := json.Marshal(feed)
+	if err != nil {
+		log.Fatalf("Unable to marshal item list as a JSON Feed (should never happen), %s", err)
+	}
+
+	return out
+}
+
+func (il ItemList) asCSV() []byte {
+	var buf bytes.Buffer
+	cw := csv.NewWriter(&buf)
+
+	cw.Write([]string{"url", "linkText", "showByline", "byline", "image", "isLiveBlog"})
+	for _, item := range il.Trails {
+		cw.Write([]string{
+			item.URL,
+			item.LinkText,
+			strconv.FormatBool(item.ShowByline),
+			item.Byline,
+			item.Image,
+			strconv.FormatBool(item.IsLiveblog),
+		})
+	}
+
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		log.Fatalf("Unable to write item list as CSV (should never happen), %s", err)
+	}
+
+	return buf.Bytes()
+}
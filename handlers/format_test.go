@@ -0,0 +1,434 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func TestSplitFormat(t *testing.T) {
+	tests := []struct {
+		path   string
+		want   string
+		format format
+	}{
+		{"uk", "uk", ""},
+		{"uk.json", "uk", formatJSON},
+		{"uk.rss", "uk", formatRSS},
+		{"uk.csv", "uk", formatCSV},
+		{"uk/sport.rss", "uk/sport", formatRSS},
+		{"uk.html", "uk.html", ""},
+		{"uk.json/sport", "uk.json/sport", ""},
+	}
+
+	for _, tt := range tests {
+		if path, f := splitFormat(tt.path); path != tt.want || f != tt.format {
+			t.Errorf("splitFormat(%q) is %q, %q, want %q, %q", tt.path, path, f, tt.want, tt.format)
+		}
+	}
+}
+
+func TestExtensionsPickTheFormat(t *testing.T) {
+	var paths []string
+	stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		w.Write([]byte(mostViewedBody("a")))
+	})
+	cfg := testConfig(t, stub.URL)
+
+	tests := []struct {
+		target      string
+		capiPath    string
+		contentType string
+	}{
+		{"/most-viewed/uk.json", "/uk", "application/json"},
+		{"/most-viewed/us.rss", "/us", "application/rss+xml; charset=utf-8"},
+		{"/most-viewed/au.csv", "/au", "text/csv; charset=utf-8"},
+		{"/most-viewed/uk/sport.json", "/uk/sport", "application/json"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			paths = nil
+			w := serve(mostViewedHandler(testCAPI(cfg), testCache(t, cfg), cfg), tt.target)
+			if w.Code != http.StatusOK {
+				t.Fatalf("got %d, want 200: %s", w.Code, w.Body)
+			}
+			if got := w.Header().Get("Content-Type"); got != tt.contentType {
+				t.Errorf("Content-Type is %q, want %q", got, tt.contentType)
+			}
+			if len(paths) != 1 || paths[0] != tt.capiPath {
+				t.Errorf("CAPI was asked for %v, want %s", paths, tt.capiPath)
+			}
+		})
+	}
+}
+
+func TestNDJSON(t *testing.T) {
+	stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(mostViewedBody("a", "b", "c")))
+	})
+	cfg := testConfig(t, stub.URL)
+	h := mostViewedHandler(testCAPI(cfg), testCache(t, cfg), cfg)
+
+	tests := []struct {
+		name   string
+		target string
+		accept string
+		want   []string
+	}{
+		{"format parameter", "/most-viewed/uk?format=ndjson", "", []string{"a", "b", "c"}},
+		{"extension", "/most-viewed/uk.ndjson", "", []string{"a", "b", "c"}},
+		{"Accept header", "/most-viewed/uk", "application/x-ndjson", []string{"a", "b", "c"}},
+		{"limited", "/most-viewed/uk?format=ndjson&limit=2", "", []string{"a", "b"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tt.target, nil)
+			r.Header.Set("Accept", tt.accept)
+			w := httptest.NewRecorder()
+			h(w, r)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("got %d, want 200: %s", w.Code, w.Body)
+			}
+			if got := w.Header().Get("Content-Type"); got != "application/x-ndjson" {
+				t.Errorf("Content-Type is %q, want application/x-ndjson", got)
+			}
+			if !w.Flushed {
+				t.Error("the trails weren't flushed as they were written")
+			}
+
+			var urls []string
+			lines := bufio.NewScanner(w.Body)
+			for lines.Scan() {
+				var trail struct {
+					URL      string `json:"url"`
+					LinkText string `json:"linkText"`
+				}
+				if err := json.Unmarshal(lines.Bytes(), &trail); err != nil {
+					t.Fatalf("line %d, %q, isn't a trail: %s", len(urls)+1, lines.Text(), err)
+				}
+				urls = append(urls, trail.URL)
+			}
+
+			if !reflect.DeepEqual(urls, tt.want) {
+				t.Errorf("got %d lines, of trails %v, want %v", len(urls), urls, tt.want)
+			}
+		})
+	}
+}
+
+func TestMsgpackRoundTrip(t *testing.T) {
+	published := time.Date(2026, 10, 14, 9, 30, 0, 0, time.UTC)
+	il := ItemList{
+		Heading: "Most viewed in UK news",
+		Trails: []Item{
+			{URL: "politics/1", LinkText: "Budget", ShowByline: true, Byline: "A Writer", Image: "https://i.guim.co.uk/1.jpg", PublishedAt: &published},
+			{URL: "sport/2", LinkText: "Result", IsLiveblog: true},
+		},
+	}
+
+	dec := msgpack.NewDecoder(bytes.NewReader(asMsgpack(il)))
+	dec.SetCustomStructTag("json")
+	var got ItemList
+	if err := dec.Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Heading != il.Heading || len(got.Trails) != len(il.Trails) {
+		t.Fatalf("got %+v, want %+v", got, il)
+	}
+	for i, trail := range got.Trails {
+		want := il.Trails[i]
+		if trail.URL != want.URL || trail.LinkText != want.LinkText || trail.ShowByline != want.ShowByline ||
+			trail.Byline != want.Byline || trail.Image != want.Image || trail.IsLiveblog != want.IsLiveblog {
+			t.Errorf("trail %d is %+v, want %+v", i, trail, want)
+		}
+		if (trail.PublishedAt == nil) != (want.PublishedAt == nil) || want.PublishedAt != nil && !trail.PublishedAt.Equal(*want.PublishedAt) {
+			t.Errorf("trail %d was published at %v, want %v", i, trail.PublishedAt, want.PublishedAt)
+		}
+	}
+}
+
+func TestMsgpackMatchesJSON(t *testing.T) {
+	stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(mostViewedBody("a", "b")))
+	})
+	cfg := testConfig(t, stub.URL)
+	h := mostViewedHandler(testCAPI(cfg), testCache(t, cfg), cfg)
+
+	asJSON := serve(h, "/most-viewed/uk")
+	asMsgpack := serve(h, "/most-viewed/uk?format=msgpack")
+	if got := asMsgpack.Header().Get("Content-Type"); got != "application/msgpack" {
+		t.Fatalf("Content-Type is %q, want application/msgpack", got)
+	}
+
+	var fromJSON, fromMsgpack map[string]interface{}
+	if err := json.Unmarshal(asJSON.Body.Bytes(), &fromJSON); err != nil {
+		t.Fatal(err)
+	}
+	if err := msgpack.Unmarshal(asMsgpack.Body.Bytes(), &fromMsgpack); err != nil {
+		t.Fatal(err)
+	}
+
+	// the same fields under the same names, whatever the number types
+	for name := range fromJSON {
+		if _, ok := fromMsgpack[name]; !ok {
+			t.Errorf("the MessagePack has no %q", name)
+		}
+	}
+	if fromMsgpack["heading"] != fromJSON["heading"] {
+		t.Errorf("heading is %v, want %v", fromMsgpack["heading"], fromJSON["heading"])
+	}
+	if trails, _ := fromMsgpack["trails"].([]interface{}); len(trails) != 2 {
+		t.Errorf("got %d trails, want 2", len(trails))
+	}
+}
+
+func TestPositionFormats(t *testing.T) {
+	stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(mostViewedBody("a", "b", "c")))
+	})
+	cfg := testConfig(t, stub.URL)
+	h := mostViewedHandler(testCAPI(cfg), testCache(t, cfg), cfg)
+
+	tests := []struct {
+		target      string
+		contentType string
+		check       func(t *testing.T, body []byte)
+	}{
+		{"/most-viewed/uk/2", "application/json", func(t *testing.T, body []byte) {
+			var trail struct {
+				URL string `json:"url"`
+			}
+			if err := json.Unmarshal(body, &trail); err != nil || trail.URL != "b" {
+				t.Errorf("got %s, want the second trail (%v)", body, err)
+			}
+		}},
+		{"/most-viewed/uk/2?format=msgpack", "application/msgpack", func(t *testing.T, body []byte) {
+			var trail map[string]interface{}
+			if err := msgpack.Unmarshal(body, &trail); err != nil || trail["url"] != "b" {
+				t.Errorf("got %v, want the second trail (%v)", trail, err)
+			}
+		}},
+		{"/most-viewed/uk/2?format=csv", "text/csv; charset=utf-8", func(t *testing.T, body []byte) {
+			lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+			if len(lines) != 2 || !strings.HasPrefix(lines[1], "b,") {
+				t.Errorf("got %q, want a header and the second trail", body)
+			}
+		}},
+		{"/most-viewed/uk/2?format=ndjson", "application/x-ndjson", func(t *testing.T, body []byte) {
+			if lines := strings.Split(strings.TrimSpace(string(body)), "\n"); len(lines) != 1 || !strings.Contains(lines[0], `"url":"b"`) {
+				t.Errorf("got %q, want just the second trail", body)
+			}
+		}},
+		{"/most-viewed/uk/2?format=rss", "application/rss+xml; charset=utf-8", func(t *testing.T, body []byte) {
+			if n := strings.Count(string(body), "<item>"); n != 1 || !strings.Contains(string(body), guardianURL+"/b") {
+				t.Errorf("got %d items in %s, want just the second trail", n, body)
+			}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			w := serve(h, tt.target)
+			if w.Code != http.StatusOK {
+				t.Fatalf("got %d, want 200: %s", w.Code, w.Body)
+			}
+			if got := w.Header().Get("Content-Type"); got != tt.contentType {
+				t.Errorf("Content-Type is %q, want %q", got, tt.contentType)
+			}
+			tt.check(t, w.Body.Bytes())
+		})
+	}
+}
+
+func TestSitemap(t *testing.T) {
+	stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"response":{"status":"ok","mostViewed":[
+			{"id":"politics/1","type":"article","webTitle":"Budget","webPublicationDate":"2026-10-14T09:30:00Z"},
+			{"id":"sport/2","type":"article","webTitle":"Result"},
+			{"id":"culture/3","type":"article","webTitle":"Review","webPublicationDate":"2026-10-13T18:00:00Z"}
+		]}}`))
+	})
+	cfg := testConfig(t, stub.URL)
+	h := mostViewedHandler(testCAPI(cfg), testCache(t, cfg), cfg)
+
+	for _, target := range []string{"/most-viewed/uk?format=sitemap", "/most-viewed/uk?format=sitemap&limit=2"} {
+		t.Run(target, func(t *testing.T) {
+			w := serve(h, target)
+			if w.Code != http.StatusOK {
+				t.Fatalf("got %d, want 200: %s", w.Code, w.Body)
+			}
+			if got := w.Header().Get("Content-Type"); got != "application/xml; charset=utf-8" {
+				t.Errorf("Content-Type is %q, want application/xml", got)
+			}
+			if !strings.HasPrefix(w.Body.String(), xml.Header) {
+				t.Errorf("%s has no XML declaration", w.Body)
+			}
+
+			var urlset struct {
+				XMLName xml.Name
+				URLs    []struct {
+					Loc     string `xml:"loc"`
+					LastMod string `xml:"lastmod"`
+				} `xml:"url"`
+			}
+			if err := xml.Unmarshal(w.Body.Bytes(), &urlset); err != nil {
+				t.Fatalf("%s isn't XML: %s", w.Body, err)
+			}
+			if urlset.XMLName.Space != "http://www.sitemaps.org/schemas/sitemap/0.9" || urlset.XMLName.Local != "urlset" {
+				t.Errorf("the root element is %v, want a sitemap urlset", urlset.XMLName)
+			}
+
+			want := []struct{ Loc, LastMod string }{
+				{guardianURL + "/politics/1", "2026-10-14T09:30:00Z"},
+				{guardianURL + "/sport/2", ""},
+				{guardianURL + "/culture/3", "2026-10-13T18:00:00Z"},
+			}
+			if strings.Contains(target, "limit=2") {
+				want = want[:2]
+			}
+			if len(urlset.URLs) != len(want) {
+				t.Fatalf("got %d URLs, want %d: %s", len(urlset.URLs), len(want), w.Body)
+			}
+			for i, url := range urlset.URLs {
+				if url.Loc != want[i].Loc || url.LastMod != want[i].LastMod {
+					t.Errorf("URL %d is %+v, want %+v", i, url, want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestJSONFeed(t *testing.T) {
+	stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"response":{"status":"ok","mostViewed":[
+			{"id":"politics/1","type":"article","webTitle":"Budget","webPublicationDate":"2026-10-14T09:30:00Z",
+				"fields":{"byline":"A Writer","thumbnail":"https://i.guim.co.uk/1.jpg"}},
+			{"id":"sport/2","type":"article","webTitle":"Result"}
+		]}}`))
+	})
+	cfg := testConfig(t, stub.URL)
+	h := mostViewedHandler(testCAPI(cfg), testCache(t, cfg), cfg)
+
+	w := serve(h, "/most-viewed/uk?format=jsonfeed")
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d, want 200: %s", w.Code, w.Body)
+	}
+	if got := w.Header().Get("Content-Type"); got != "application/feed+json" {
+		t.Errorf("Content-Type is %q, want application/feed+json", got)
+	}
+
+	var feed struct {
+		Version     string                   `json:"version"`
+		Title       string                   `json:"title"`
+		HomePageURL string                   `json:"home_page_url"`
+		Items       []map[string]interface{} `json:"items"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &feed); err != nil {
+		t.Fatalf("%s isn't JSON: %s", w.Body, err)
+	}
+	if feed.Version != "https://jsonfeed.org/version/1.1" {
+		t.Errorf("version is %q, want JSON Feed 1.1's", feed.Version)
+	}
+	if feed.Title != "Most viewed in the UK" || feed.HomePageURL != guardianURL {
+		t.Errorf("the feed is %q at %q, want the edition's heading at %q", feed.Title, feed.HomePageURL, guardianURL)
+	}
+
+	want := []map[string]interface{}{
+		{
+			"id":             guardianURL + "/politics/1",
+			"url":            guardianURL + "/politics/1",
+			"title":          "Budget",
+			"image":          "https://i.guim.co.uk/1.jpg",
+			"date_published": "2026-10-14T09:30:00Z",
+			"authors":        []interface{}{map[string]interface{}{"name": "A Writer"}},
+		},
+		// without the optional fields
+		{
+			"id":    guardianURL + "/sport/2",
+			"url":   guardianURL + "/sport/2",
+			"title": "Result",
+		},
+	}
+	if !reflect.DeepEqual(feed.Items, want) {
+		t.Errorf("items are %v, want %v", feed.Items, want)
+	}
+
+	if got := serve(h, "/most-viewed/uk"); got.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("without a format Content-Type is %q, want JSON", got.Header().Get("Content-Type"))
+	}
+}
+
+// disconnectedWriter is a response writer whose client has gone away: every
+// write fails
+type disconnectedWriter struct {
+	*httptest.ResponseRecorder
+	writes int
+}
+
+func (w *disconnectedWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return 0, errors.New("write: broken pipe")
+}
+
+func TestClientDisconnects(t *testing.T) {
+	stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(mostViewedBody("a", "b", "c")))
+	})
+	cfg := testConfig(t, stub.URL)
+	h := mostViewedHandler(testCAPI(cfg), testCache(t, cfg), cfg)
+
+	tests := []struct {
+		name     string
+		target   string
+		canceled bool
+		writes   int
+	}{
+		{"JSON", "/most-viewed/uk", false, 1},
+		{"NDJSON", "/most-viewed/uk?format=ndjson", false, 1},
+		{"NDJSON, request canceled", "/most-viewed/uk?format=ndjson", true, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// warm the cache, so a canceled request still has a list to stream
+			serve(h, tt.target)
+
+			r := httptest.NewRequest("GET", tt.target, nil)
+			if tt.canceled {
+				ctx, cancel := context.WithCancel(r.Context())
+				cancel()
+				r = r.WithContext(ctx)
+			}
+			w := &disconnectedWriter{ResponseRecorder: httptest.NewRecorder()}
+			logs := captureLog(t)
+
+			h(w, r)
+
+			if w.Code != http.StatusOK {
+				t.Errorf("got %d, want the 200 already sent", w.Code)
+			}
+			if w.writes != tt.writes {
+				t.Errorf("%d writes, want %d: writing should stop once the client has gone", w.writes, tt.writes)
+			}
+			if !strings.Contains(logs.String(), "Client went away") {
+				t.Errorf("the disconnect wasn't logged: %s", logs)
+			}
+		})
+	}
+}
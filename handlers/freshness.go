@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// freshness is when the data behind a response was fetched from upstream,
+// and when the service's cached copy of it expires. Responses built from
+// several lookups are as old as the oldest and expire with the first to go.
+type freshness struct {
+	mu        sync.Mutex
+	fetchedAt time.Time
+	expiresAt time.Time
+
+	// lastGood is set once any of the data is a last-known-good list
+	lastGood bool
+
+	// backends are the upstreams the data came from
+	backends map[string]bool
+}
+
+type freshnessKey struct{}
+
+// withFreshness gives ctx a freshness for cache lookups under it to note
+func withFreshness(ctx context.Context) (context.Context, *freshness) {
+	f := &freshness{}
+	return context.WithValue(ctx, freshnessKey{}, f), f
+}
+
+// freshnessFrom is ctx's freshness, or nil if it hasn't one. A nil freshness
+// ignores what it's told.
+func freshnessFrom(ctx context.Context) *freshness {
+	f, _ := ctx.Value(freshnessKey{}).(*freshness)
+	return f
+}
+
+// note records data fetched at fetchedAt and cached until expiresAt. Zero
+// expiresAt is data the service didn't cache.
+func (f *freshness) note(fetchedAt, expiresAt time.Time) {
+	if f == nil {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.fetchedAt.IsZero() || fetchedAt.Before(f.fetchedAt) {
+		f.fetchedAt = fetchedAt
+	}
+	if !expiresAt.IsZero() && (f.expiresAt.IsZero() || expiresAt.Before(f.expiresAt)) {
+		f.expiresAt = expiresAt
+	}
+}
+
+// noteLastGood records a last-known-good list captured at capturedAt, served
+// because CAPI failed. It's already out of date, so it expires now.
+func (f *freshness) noteLastGood(capturedAt time.Time) {
+	if f == nil {
+		return
+	}
+
+	f.note(capturedAt, time.Now())
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.lastGood = true
+}
+
+// noteBackends records upstreams some of the data came from
+func (f *freshness) noteBackends(names ...string) {
+	if f == nil {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.backends == nil {
+		f.backends = map[string]bool{}
+	}
+	for _, name := range names {
+		f.backends[name] = true
+	}
+}
+
+// backendNames are the noted upstreams, sorted
+func (f *freshness) backendNames() []string {
+	if f == nil {
+		return nil
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var names []string
+	for name := range f.backends {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// isLastGood reports whether any of the data is a last-known-good list
+func (f *freshness) isLastGood() bool {
+	if f == nil {
+		return false
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.lastGood
+}
+
+// times are the noted fetch and expiry times, zero if nothing was noted
+func (f *freshness) times() (fetchedAt, expiresAt time.Time) {
+	if f == nil {
+		return time.Time{}, time.Time{}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.fetchedAt, f.expiresAt
+}
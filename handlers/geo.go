@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/guardian/onward/config"
+	"github.com/oschwald/maxminddb-golang"
+	"github.com/pkg/errors"
+)
+
+// geoIP is the MaxMind country database named by -geoip-db, if there is one
+var geoIP *maxminddb.Reader
+
+func openGeoIP(path string) error {
+	if path == "" {
+		return nil
+	}
+
+	db, err := maxminddb.Open(path)
+	if err != nil {
+		return errors.Wrap(err, "Unable to open GeoIP database")
+	}
+
+	geoIP = db
+	return nil
+}
+
+// autoEdition is the edition for the client's country, falling back to
+// cfg.AutoEdition, and the request header the country came from. Without a
+// header it came from the client's IP, so a CDN can't share the response.
+func autoEdition(r *http.Request, cfg config.Config) (edition, header string) {
+	country, header := clientCountry(r, cfg)
+
+	if edition, ok := cfg.CountryEditions[country]; ok {
+		return edition, header
+	}
+
+	return cfg.AutoEdition, header
+}
+
+// clientCountry is the client's ISO country code, from the first of
+// cfg.GeoHeaders the request has, otherwise from its IP in the GeoIP
+// database. header is the header it came from, if any.
+func clientCountry(r *http.Request, cfg config.Config) (country, header string) {
+	for _, name := range cfg.GeoHeaders {
+		if country := headerCountry(r.Header.Get(name)); country != "" {
+			return country, name
+		}
+	}
+
+	if geoIP == nil {
+		return "", ""
+	}
+
+	ip := net.ParseIP(clientIP(r, cfg.TrustedProxies))
+	if ip == nil {
+		return "", ""
+	}
+
+	var record struct {
+		Country struct {
+			ISOCode string `maxminddb:"iso_code"`
+		} `maxminddb:"country"`
+	}
+	if err := geoIP.Lookup(ip, &record); err != nil {
+		return "", ""
+	}
+
+	return strings.ToUpper(record.Country.ISOCode), ""
+}
+
+// headerCountry parses a country header, either a bare code
+// (CloudFront-Viewer-Country: GB) or a country field among comma-separated
+// ones (X-GU-GeoLocation: ip=1.2.3.4,country=GB,city=London)
+func headerCountry(value string) string {
+	value = strings.TrimSpace(value)
+	if len(value) == 2 {
+		return strings.ToUpper(value)
+	}
+
+	for _, field := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(field), "=", 2)
+		if len(parts) == 2 && strings.EqualFold(parts[0], "country") && len(parts[1]) == 2 {
+			return strings.ToUpper(parts[1])
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/graphql-go/graphql"
+	"github.com/guardian/onward/capi"
+	"github.com/guardian/onward/config"
+)
+
+// maxGraphQLBytes bounds a POSTed GraphQL request
+const maxGraphQLBytes = 64 << 10
+
+// graphqlRequest is a GraphQL query, POSTed as JSON or given in ?query=
+type graphqlRequest struct {
+	Query         string                 `json:"query"`
+	Variables     map[string]interface{} `json:"variables"`
+	OperationName string                 `json:"operationName"`
+}
+
+var graphqlItem = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Item",
+	Fields: graphql.Fields{
+		"url":         &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		"linkText":    &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		"showByline":  &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean)},
+		"byline":      &graphql.Field{Type: graphql.String},
+		"image":       &graphql.Field{Type: graphql.String},
+		"isLiveBlog":  &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean)},
+		"publishedAt": &graphql.Field{Type: graphql.DateTime},
+		"backfilled":  &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean)},
+		"sectionId": &graphql.Field{
+			Type:    graphql.String,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) { return p.Source.(Item).SectionID, nil },
+		},
+		"sectionName": &graphql.Field{
+			Type:    graphql.String,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) { return p.Source.(Item).SectionName, nil },
+		},
+	},
+})
+
+var graphqlItemList = graphql.NewObject(graphql.ObjectConfig{
+	Name: "ItemList",
+	Fields: graphql.Fields{
+		"heading": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		"trails":  &graphql.Field{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphqlItem)))},
+	},
+})
+
+// graphqlSchema is the onward-journey schema. Its resolvers fetch through
+// the same cache as the HTTP routes.
+func graphqlSchema(api capi.Client, c Cache, cfg config.Config) graphql.Schema {
+	limitArg := &graphql.ArgumentConfig{Type: graphql.Int, Description: "most trails returned, capped at -max-limit"}
+
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{
+		Query: graphql.NewObject(graphql.ObjectConfig{
+			Name: "Query",
+			Fields: graphql.Fields{
+				"mostViewed": &graphql.Field{
+					Type: graphqlItemList,
+					Args: graphql.FieldConfigArgument{
+						"edition": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+						"section": &graphql.ArgumentConfig{Type: graphql.String},
+						"limit":   limitArg,
+					},
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						path, _ := p.Args["edition"].(string)
+						if !config.IsEdition(path) {
+							return nil, &HTTPError{Status: http.StatusBadRequest, Message: "Unknown edition"}
+						}
+
+						if section, _ := p.Args["section"].(string); section != "" {
+							path += "/" + section
+							if !isEditionSection(path) {
+								return nil, &HTTPError{Status: http.StatusBadRequest, Message: "Invalid section"}
+							}
+						}
+
+						items, err := getMostViewed(p.Context, api, query{Path: path, MostViewed: true}, c, cfg)
+						if err != nil {
+							return nil, err
+						}
+
+						return graphqlLimit(asItemList(items, path, editionHeading(cfg, path)), p.Args, cfg)
+					},
+				},
+				"related": &graphql.Field{
+					Type: graphqlItemList,
+					Args: graphql.FieldConfigArgument{
+						"contentId": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+						"limit":     limitArg,
+					},
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						contentID, _ := p.Args["contentId"].(string)
+						path := webPath(contentID)
+						if path == "" {
+							return nil, &HTTPError{Status: http.StatusBadRequest, Message: "No content ID"}
+						}
+
+						items, err := cachedGet(p.Context, api, query{Path: path, Related: true}, c, cfg)
+						if err != nil {
+							return nil, err
+						}
+
+						return graphqlLimit(asItemList(items, path, cfg.RelatedHeading), p.Args, cfg)
+					},
+				},
+			},
+		}),
+	})
+	if err != nil {
+		log.Fatalf("Unable to build GraphQL schema (should never happen), %s", err)
+	}
+
+	return schema
+}
+
+// graphqlLimit cuts the list to the query's limit argument, or the default
+// limit if it hasn't one
+func graphqlLimit(il ItemList, args map[string]interface{}, cfg config.Config) (ItemList, error) {
+	limit := cfg.DefaultLimit
+	if n, ok := args["limit"].(int); ok {
+		if n < 1 {
+			return il, &HTTPError{Status: http.StatusBadRequest, Message: "Invalid limit"}
+		}
+		limit = n
+	}
+
+	if cfg.MaxLimit > 0 && (limit == 0 || limit > cfg.MaxLimit) {
+		limit = cfg.MaxLimit
+	}
+
+	return il.limit(limit), nil
+}
+
+// graphqlHandler serves GraphQL queries over the onward-journey data, so
+// clients can pick the trail fields they need. Results, errors included, are
+// always a 200 with GraphQL's own data and errors.
+func graphqlHandler(api capi.Client, c Cache, cfg config.Config) func(w http.ResponseWriter, r *http.Request) {
+	schema := graphqlSchema(api, c, cfg)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req graphqlRequest
+
+		switch r.Method {
+		case http.MethodGet:
+			req.Query = r.URL.Query().Get("query")
+			req.OperationName = r.URL.Query().Get("operationName")
+			if raw := r.URL.Query().Get("variables"); raw != "" {
+				if err := json.Unmarshal([]byte(raw), &req.Variables); err != nil {
+					writeError(w, &HTTPError{Status: http.StatusBadRequest, Message: "Invalid variables", Err: err})
+					return
+				}
+			}
+		case http.MethodPost:
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxGraphQLBytes)).Decode(&req); err != nil {
+				writeError(w, &HTTPError{Status: http.StatusBadRequest, Message: "Invalid GraphQL request", Err: err})
+				return
+			}
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			writeError(w, &HTTPError{Status: http.StatusMethodNotAllowed, Message: "Method not allowed"})
+			return
+		}
+
+		if req.Query == "" {
+			writeError(w, &HTTPError{Status: http.StatusBadRequest, Message: "No query"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.RequestBudget)
+		defer cancel()
+		ctx = withCacheDirective(ctx, requestCacheDirective(r))
+
+		result := graphql.Do(graphql.Params{
+			Schema:         schema,
+			RequestString:  req.Query,
+			VariableValues: req.Variables,
+			OperationName:  req.OperationName,
+			Context:        ctx,
+		})
+
+		body, err := json.Marshal(result)
+		if err != nil {
+			log.Fatalf("Unable to marshal GraphQL result (should never happen), %s", err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if _, err := w.Write(body); err != nil {
+			logWriteFailure(r.URL.Path, err)
+		}
+	}
+}
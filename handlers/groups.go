@@ -0,0 +1,69 @@
+package handlers
+
+import "net/http"
+
+// GroupedItemList is an ItemList with its trails grouped by section
+type GroupedItemList struct {
+	Heading  string         `json:"heading"`
+	Sections []SectionGroup `json:"sections"`
+}
+
+// SectionGroup is the trails from one section, in list order
+type SectionGroup struct {
+	ID      string `json:"id"`
+	Heading string `json:"heading"`
+	Trails  []Item `json:"trails"`
+}
+
+// requestedGrouping checks the request's ?group-by=, which can only be
+// "section", and reports whether it asks for grouping
+func requestedGrouping(r *http.Request) (bool, error) {
+	switch r.URL.Query().Get("group-by") {
+	case "":
+		return false, nil
+	case "section":
+		return true, nil
+	}
+
+	return false, &HTTPError{Status: http.StatusBadRequest, Message: "Invalid group-by"}
+}
+
+// groupBySection groups the trails by section. Sections are in the order
+// their first trail appears, so the most popular section comes first.
+func (il ItemList) groupBySection() GroupedItemList {
+	grouped := GroupedItemList{Heading: il.Heading, Sections: []SectionGroup{}}
+	index := map[string]int{}
+
+	for _, item := range il.Trails {
+		i, ok := index[item.SectionID]
+		if !ok {
+			i = len(grouped.Sections)
+			index[item.SectionID] = i
+			grouped.Sections = append(grouped.Sections, SectionGroup{ID: item.SectionID, Heading: item.SectionName})
+		}
+
+		grouped.Sections[i].Trails = append(grouped.Sections[i].Trails, item)
+	}
+
+	return grouped
+}
+
+// annotateBylines sets each trail's BylineCount to how many trails in the
+// list have the same byline. Trails without a byline are left alone.
+func (il ItemList) annotateBylines() ItemList {
+	counts := map[string]int{}
+	for _, item := range il.Trails {
+		if item.Byline != "" {
+			counts[item.Byline]++
+		}
+	}
+
+	trails := make([]Item, len(il.Trails))
+	for i, item := range il.Trails {
+		item.BylineCount = counts[item.Byline]
+		trails[i] = item
+	}
+
+	il.Trails = trails
+	return il
+}
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestGroupBySection(t *testing.T) {
+	stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"response":{"status":"ok","mostViewed":[
+			{"id":"politics/1","type":"article","sectionId":"politics","sectionName":"Politics"},
+			{"id":"sport/2","type":"article","sectionId":"sport","sectionName":"Sport"},
+			{"id":"politics/3","type":"article","sectionId":"politics","sectionName":"Politics"},
+			{"id":"sport/4","type":"article","sectionId":"sport","sectionName":"Sport"}
+		]}}`))
+	})
+	cfg := testConfig(t, stub.URL)
+	h := mostViewedHandler(testCAPI(cfg), testCache(t, cfg), cfg)
+
+	w := serve(h, "/most-viewed/uk?group-by=section")
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d, want 200: %s", w.Code, w.Body)
+	}
+
+	var grouped struct {
+		Heading  string `json:"heading"`
+		Sections []struct {
+			ID      string `json:"id"`
+			Heading string `json:"heading"`
+			Trails  []struct {
+				URL string `json:"url"`
+			} `json:"trails"`
+		} `json:"sections"`
+		Trails json.RawMessage `json:"trails"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &grouped); err != nil {
+		t.Fatal(err)
+	}
+
+	if grouped.Heading != "Most viewed in the UK" {
+		t.Errorf("heading is %q, want the edition's", grouped.Heading)
+	}
+	if grouped.Trails != nil {
+		t.Errorf("the grouped list also has flat trails, %s", grouped.Trails)
+	}
+
+	type section struct {
+		id, heading string
+		urls        []string
+	}
+	var got []section
+	for _, s := range grouped.Sections {
+		var urls []string
+		for _, trail := range s.Trails {
+			urls = append(urls, trail.URL)
+		}
+		got = append(got, section{s.ID, s.Heading, urls})
+	}
+	// in order of each section's most popular trail
+	want := []section{
+		{"politics", "Politics", []string{"politics/1", "politics/3"}},
+		{"sport", "Sport", []string{"sport/2", "sport/4"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got sections %+v, want %+v", got, want)
+	}
+
+	if flat := serve(h, "/most-viewed/uk"); len(decodeTrails(t, flat).Trails) != 4 {
+		t.Errorf("without group-by got %s, want a flat list", flat.Body)
+	}
+	if bad := serve(h, "/most-viewed/uk?group-by=byline"); bad.Code != http.StatusBadRequest {
+		t.Errorf("group-by=byline got %d, want 400", bad.Code)
+	}
+}
+
+func TestAnnotateBylines(t *testing.T) {
+	stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"response":{"status":"ok","mostViewed":[
+			{"id":"a","type":"article","fields":{"byline":"A Writer"}},
+			{"id":"b","type":"article","fields":{"byline":"Another Writer"}},
+			{"id":"c","type":"article","fields":{"byline":"A Writer"}},
+			{"id":"d","type":"article"},
+			{"id":"e","type":"article","fields":{"byline":"A Writer"}}
+		]}}`))
+	})
+	cfg := testConfig(t, stub.URL)
+	h := mostViewedHandler(testCAPI(cfg), testCache(t, cfg), cfg)
+
+	counts := func(target string) map[string]*int {
+		w := serve(h, target)
+		if w.Code != http.StatusOK {
+			t.Fatalf("got %d, want 200: %s", w.Code, w.Body)
+		}
+
+		var il struct {
+			Trails []struct {
+				URL         string `json:"url"`
+				BylineCount *int   `json:"bylineCount"`
+			} `json:"trails"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &il); err != nil {
+			t.Fatal(err)
+		}
+
+		got := map[string]*int{}
+		for _, trail := range il.Trails {
+			got[trail.URL] = trail.BylineCount
+		}
+		return got
+	}
+
+	annotated := counts("/most-viewed/uk?annotate-bylines=true")
+	if len(annotated) != 5 {
+		t.Fatalf("got %d trails, want 5", len(annotated))
+	}
+	want := map[string]int{"a": 3, "b": 1, "c": 3, "e": 3}
+	for url, count := range annotated {
+		switch {
+		case want[url] == 0 && count != nil:
+			t.Errorf("%s has no byline but a count of %d", url, *count)
+		case want[url] != 0 && (count == nil || *count != want[url]):
+			t.Errorf("%s's byline count is %v, want %d", url, count, want[url])
+		}
+	}
+
+	for url, count := range counts("/most-viewed/uk") {
+		if count != nil {
+			t.Errorf("without annotate-bylines %s has a byline count of %d", url, *count)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+
+	"github.com/guardian/onward/capi"
+	"github.com/guardian/onward/config"
+	"github.com/guardian/onward/onwardpb"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// onwardServer serves the gRPC API. It fetches through the same cache as the
+// HTTP routes, so a list cached by one is a hit for the other.
+type onwardServer struct {
+	onwardpb.UnimplementedOnwardServiceServer
+
+	api capi.Client
+	c   Cache
+	cfg config.Config
+}
+
+// serveGRPC starts the gRPC API on cfg.GRPCAddr, returning the server so it
+// can be stopped at shutdown
+func serveGRPC(api capi.Client, c Cache, cfg config.Config) (*grpc.Server, error) {
+	lis, err := net.Listen("tcp", cfg.GRPCAddr)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to listen for gRPC")
+	}
+
+	srv := grpc.NewServer()
+	onwardpb.RegisterOnwardServiceServer(srv, &onwardServer{api: api, c: c, cfg: cfg})
+
+	go func() {
+		if err := srv.Serve(lis); err != nil {
+			log.Fatalf("gRPC server failed, %s", err)
+		}
+	}()
+
+	return srv, nil
+}
+
+func (s *onwardServer) GetMostViewed(ctx context.Context, req *onwardpb.GetMostViewedRequest) (*onwardpb.ItemList, error) {
+	path := req.GetEdition()
+	if !config.IsEdition(path) {
+		return nil, status.Error(codes.InvalidArgument, "Unknown edition")
+	}
+
+	if req.GetSection() != "" {
+		path += "/" + req.GetSection()
+		if !isEditionSection(path) {
+			return nil, status.Error(codes.InvalidArgument, "Invalid section")
+		}
+	}
+
+	limit, err := grpcLimit(req.GetLimit(), defaultLimit(s.cfg, path), s.cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	requestsTotal.WithLabelValues(editionLabel(path)).Inc()
+
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.RequestBudget)
+	defer cancel()
+
+	items, err := getMostViewed(ctx, s.api, query{Path: path, MostViewed: true}, s.c, s.cfg)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	return asProtoItemList(asItemList(items, path, editionHeading(s.cfg, path)).limit(limit)), nil
+}
+
+func (s *onwardServer) GetRelated(ctx context.Context, req *onwardpb.GetRelatedRequest) (*onwardpb.ItemList, error) {
+	path := webPath(req.GetContentId())
+	if path == "" {
+		return nil, status.Error(codes.InvalidArgument, "No content ID")
+	}
+
+	limit, err := grpcLimit(req.GetLimit(), s.cfg.DefaultLimit, s.cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.RequestBudget)
+	defer cancel()
+
+	items, err := cachedGet(ctx, s.api, query{Path: path, Related: true}, s.c, s.cfg)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	return asProtoItemList(asItemList(items, path, s.cfg.RelatedHeading).limit(limit)), nil
+}
+
+// grpcLimit is a request's limit, def if it hasn't one, capped at
+// -max-limit like the HTTP routes' ?limit=
+func grpcLimit(requested int32, def int, cfg config.Config) (int, error) {
+	if requested < 0 {
+		return 0, status.Error(codes.InvalidArgument, "Invalid limit")
+	}
+
+	limit := def
+	if requested > 0 {
+		limit = int(requested)
+	}
+
+	if cfg.MaxLimit > 0 && (limit == 0 || limit > cfg.MaxLimit) {
+		limit = cfg.MaxLimit
+	}
+
+	return limit, nil
+}
+
+// grpcError logs err and turns it into a gRPC status with the code closest to
+// the HTTP status it would have been served with
+func grpcError(err error) error {
+	log.Printf("%s (gRPC)", err)
+
+	httpErr := httpErrorFor(err)
+
+	code := codes.Internal
+	switch httpErr.Status {
+	case http.StatusBadRequest:
+		code = codes.InvalidArgument
+	case http.StatusNotFound:
+		code = codes.NotFound
+	case http.StatusTooManyRequests:
+		code = codes.ResourceExhausted
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		code = codes.Unavailable
+	case http.StatusGatewayTimeout:
+		code = codes.DeadlineExceeded
+	case statusClientClosedRequest:
+		code = codes.Canceled
+	}
+
+	return status.Error(code, httpErr.Message)
+}
+
+func asProtoItemList(il ItemList) *onwardpb.ItemList {
+	trails := make([]*onwardpb.Item, 0, len(il.Trails))
+	for _, item := range il.Trails {
+		trail := &onwardpb.Item{
+			Url:         item.URL,
+			LinkText:    item.LinkText,
+			ShowByline:  item.ShowByline,
+			Byline:      item.Byline,
+			Image:       item.Image,
+			IsLiveBlog:  item.IsLiveblog,
+			Backfilled:  item.Backfilled,
+			SectionId:   item.SectionID,
+			SectionName: item.SectionName,
+		}
+
+		if item.PublishedAt != nil {
+			trail.PublishedAt = timestamppb.New(*item.PublishedAt)
+		}
+
+		trails = append(trails, trail)
+	}
+
+	return &onwardpb.ItemList{Heading: il.Heading, Trails: trails}
+}
+
+// stopGRPC lets gRPC calls in flight finish, cutting them off if ctx is done
+// first
+func stopGRPC(ctx context.Context, srv *grpc.Server) {
+	stopped := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		log.Printf("gRPC calls still in flight at shutdown")
+		srv.Stop()
+	}
+}
@@ -0,0 +1,19 @@
+package handlers
+
+import (
+	"strings"
+
+	"github.com/guardian/onward/config"
+)
+
+// editionHeading is the heading for a path's list: HeadingTemplate with
+// {edition} replaced by the edition's display name, or DefaultHeading for
+// paths without one
+func editionHeading(cfg config.Config, path string) string {
+	name, ok := cfg.EditionNames[path]
+	if !ok {
+		return cfg.DefaultHeading
+	}
+
+	return strings.Replace(cfg.HeadingTemplate, "{edition}", name, -1)
+}
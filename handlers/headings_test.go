@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"flag"
+	"net/http"
+	"testing"
+
+	"github.com/guardian/onward/config"
+)
+
+func TestEditionHeading(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		path string
+		want string
+	}{
+		{"uk", nil, "uk", "Most viewed in the UK"},
+		{"us", nil, "us", "Most viewed in the US"},
+		{"au", nil, "au", "Most viewed in Australia"},
+		{"section", nil, "uk/sport", "Most viewed"},
+		{"unmapped", nil, "europe", "Most viewed"},
+		{"renamed", []string{"-edition-names=au=Oz"}, "au", "Most viewed in Oz"},
+		{"others kept when renaming one", []string{"-edition-names=au=Oz"}, "uk", "Most viewed in the UK"},
+		{"template", []string{"-heading-template=Popular in {edition} today"}, "us", "Popular in the US today"},
+		{"default heading", []string{"-default-heading=Popular"}, "europe", "Popular"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cfg config.Config
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			config.Register(fs, &cfg)
+			if err := fs.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := editionHeading(cfg, tt.path); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListsHaveTheirEditionsHeading(t *testing.T) {
+	stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(mostViewedBody("a")))
+	})
+	cfg := testConfig(t, stub.URL)
+	h := mostViewedHandler(testCAPI(cfg), testCache(t, cfg), cfg)
+
+	for target, want := range map[string]string{
+		"/most-viewed/uk":       "Most viewed in the UK",
+		"/most-viewed/au":       "Most viewed in Australia",
+		"/most-viewed/uk/sport": "Most viewed",
+	} {
+		w := serve(h, target)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s got %d, want 200: %s", target, w.Code, w.Body)
+		}
+		if got := decodeTrails(t, w).Heading; got != want {
+			t.Errorf("%s has heading %q, want %q", target, got, want)
+		}
+	}
+}
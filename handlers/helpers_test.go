@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"bytes"
+	"flag"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/guardian/onward/capi"
+	"github.com/guardian/onward/config"
+)
+
+// testConfig is the configuration as it is with no flags given, but with
+// CAPI at capiURL
+func testConfig(t testing.TB, capiURL string) config.Config {
+	t.Helper()
+
+	var cfg config.Config
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	config.Register(fs, &cfg)
+	if err := fs.Parse(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.CAPIURL = capiURL
+	cfg.WarmInterval = 0
+	return cfg
+}
+
+// testCache is a cache for cfg, closed when the test ends
+func testCache(t testing.TB, cfg config.Config) Cache {
+	t.Helper()
+
+	c, err := newCache(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+
+	return c
+}
+
+// testCAPI is a CAPI client for cfg, sending its requests over HTTP
+func testCAPI(cfg config.Config) capi.Client {
+	return capi.New(cfg, capi.NewHTTPClient(cfg, Transport(http.DefaultTransport)))
+}
+
+// serve is h's response to a GET of target
+func serve(h http.HandlerFunc, target string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h(w, httptest.NewRequest("GET", target, nil))
+
+	return w
+}
+
+// stubCAPI is a stand-in for CAPI serving h, which counts the requests it
+// gets in calls
+type stubCAPI struct {
+	*httptest.Server
+	calls int64
+}
+
+func newStubCAPI(t testing.TB, h http.HandlerFunc) *stubCAPI {
+	t.Helper()
+
+	stub := &stubCAPI{}
+	stub.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&stub.calls, 1)
+		h(w, r)
+	}))
+	t.Cleanup(stub.Close)
+
+	return stub
+}
+
+func (s *stubCAPI) requests() int64 {
+	return atomic.LoadInt64(&s.calls)
+}
+
+// mostViewedBody is a CAPI response most-viewed ranking items with the given
+// IDs
+func mostViewedBody(ids ...string) string {
+	body := `{"response":{"status":"ok","mostViewed":[`
+	for i, id := range ids {
+		if i > 0 {
+			body += ","
+		}
+		body += `{"id":"` + id + `","type":"article","webTitle":"Title of ` + id + `","webUrl":"https://www.theguardian.com/` + id + `"}`
+	}
+	return body + `]}}`
+}
+
+// itemIDs are the IDs of items, in order
+func itemIDs(items []capi.Item) []string {
+	ids := []string{}
+	for _, item := range items {
+		ids = append(ids, item.ID)
+	}
+	return ids
+}
+
+// capiItems are items with the given IDs
+func capiItems(ids ...string) []capi.Item {
+	items := make([]capi.Item, len(ids))
+	for i, id := range ids {
+		items[i] = capi.Item{ID: id}
+	}
+	return items
+}
+
+// debugPaths are requests for each of the debugging endpoints, which only
+// builds with the debug tag serve
+var debugPaths = []string{"/preview/uk", "/raw/uk", "/diff/uk", "/snapshots/uk", "/config", "/debug/pprof/", "/debug/pprof/cmdline"}
+
+// logBuffer collects what's logged, safe for handlers logging from other
+// goroutines
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// captureLog collects the standard logger's output until the test ends
+func captureLog(t testing.TB) *logBuffer {
+	t.Helper()
+
+	b := &logBuffer{}
+	log.SetOutput(b)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	return b
+}
+
+// useSnapshots swaps in empty edition snapshots keeping size lists each,
+// restoring the service's when the test ends
+func useSnapshots(t testing.TB, size int) *snapshots {
+	saved := editionSnapshots
+	editionSnapshots = newSnapshots(size)
+	t.Cleanup(func() { editionSnapshots = saved })
+
+	return editionSnapshots
+}
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/guardian/onward/capi"
+)
+
+// imageCrop is a trail image at one size
+type imageCrop struct {
+	URL         string
+	Width       int
+	Height      int
+	AspectRatio string
+}
+
+// images turns trails' image elements into crops, through the resizer if
+// one's configured. New configures it.
+var images imageResizer
+
+// imageResizer builds crops of media images through the image-resizing
+// service at base (i.guim.co.uk), signing each URL with salt. Without a base
+// the crops are CAPI's own assets.
+type imageResizer struct {
+	base    string
+	salt    string
+	widths  []int
+	quality int
+}
+
+func (ir *imageResizer) configure(base, salt string, widths []int, quality int) {
+	ir.base = strings.TrimSuffix(base, "/")
+	ir.salt = salt
+	ir.widths = append([]int{}, widths...)
+	sort.Ints(ir.widths)
+	ir.quality = quality
+}
+
+// crops are item's image crops, smallest first, from its main image or else
+// its thumbnail element
+func (ir imageResizer) crops(item capi.Item) []imageCrop {
+	element, ok := imageElement(item)
+	if !ok {
+		return nil
+	}
+
+	var master *capi.Asset
+	var crops []imageCrop
+	for i, asset := range element.Assets {
+		if asset.Type != "image" || asset.File == "" {
+			continue
+		}
+
+		if asset.IsMaster() {
+			master = &element.Assets[i]
+			continue
+		}
+
+		crops = append(crops, imageCrop{
+			URL:         asset.File,
+			Width:       int(asset.TypeData.Width),
+			Height:      int(asset.TypeData.Height),
+			AspectRatio: asset.TypeData.AspectRatio,
+		})
+	}
+
+	if ir.base != "" && master != nil && master.TypeData.Width > 0 {
+		if resized := ir.resized(*master); resized != nil {
+			return resized
+		}
+	}
+
+	sort.Slice(crops, func(i, j int) bool { return crops[i].Width < crops[j].Width })
+	return crops
+}
+
+// resized is the master image at each configured width no wider than it, or
+// nil if it isn't on a host the resizer serves
+func (ir imageResizer) resized(master capi.Asset) []imageCrop {
+	width, height := int(master.TypeData.Width), int(master.TypeData.Height)
+
+	var crops []imageCrop
+	for _, w := range ir.widths {
+		if w > width {
+			break
+		}
+
+		resizedURL, ok := ir.url(master.File, w)
+		if !ok {
+			return nil
+		}
+
+		crops = append(crops, imageCrop{
+			URL:         resizedURL,
+			Width:       w,
+			Height:      height * w / width,
+			AspectRatio: master.TypeData.AspectRatio,
+		})
+	}
+
+	return crops
+}
+
+// url is the resizer's URL for a media file at width, or false if the file
+// isn't on one of the *.guim.co.uk hosts it serves. Each host is under its
+// own prefix (media.guim.co.uk under /img/media/), and the signature is the
+// MD5 of the salt, file path and query.
+func (ir imageResizer) url(file string, width int) (string, bool) {
+	u, err := url.Parse(file)
+	if err != nil || !strings.HasSuffix(u.Host, ".guim.co.uk") {
+		return "", false
+	}
+
+	params := url.Values{}
+	params.Set("width", strconv.Itoa(width))
+	params.Set("quality", strconv.Itoa(ir.quality))
+	params.Set("auto", "format")
+	params.Set("fit", "max")
+	query := params.Encode()
+
+	sum := md5.Sum([]byte(ir.salt + u.EscapedPath() + "?" + query))
+	host := strings.TrimSuffix(u.Host, ".guim.co.uk")
+
+	return fmt.Sprintf("%s/img/%s%s?%s&s=%s", ir.base, host, u.EscapedPath(), query, hex.EncodeToString(sum[:])), true
+}
+
+// imageElement is the item's main image element, or its thumbnail if the
+// main media isn't an image
+func imageElement(item capi.Item) (capi.Element, bool) {
+	for _, relation := range []string{"main", "thumbnail"} {
+		for _, element := range item.Elements {
+			if element.Type == "image" && element.Relation == relation {
+				return element, true
+			}
+		}
+	}
+
+	return capi.Element{}, false
+}
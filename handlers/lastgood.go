@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/guardian/onward/capi"
+	"github.com/guardian/onward/config"
+	"github.com/pkg/errors"
+)
+
+// lastGoodHeader marks a response served from a last-known-good list, giving
+// when the list was fetched
+const lastGoodHeader = "X-Last-Known-Good"
+
+// lastGoodList is an edition's list as it was last fetched successfully
+type lastGoodList struct {
+	CapturedAt time.Time
+	Response   capi.Response
+}
+
+// lastGoodStore keeps each edition's last good list somewhere that outlives
+// the cache and the instance, to serve if CAPI fails once the cache has
+// expired
+type lastGoodStore interface {
+	Save(ctx context.Context, edition string, data []byte) error
+
+	// Load is the edition's saved list, and whether there is one
+	Load(ctx context.Context, edition string) ([]byte, bool, error)
+}
+
+var (
+	_ lastGoodStore = diskStore{}
+	_ lastGoodStore = s3Store{}
+)
+
+// lastGood is the store named by -last-good-store, if there is one
+var lastGood lastGoodStore
+
+func openLastGood(cfg config.Config) error {
+	switch cfg.LastGoodStore {
+	case "":
+		return nil
+	case "disk":
+		if err := os.MkdirAll(cfg.LastGoodDir, 0755); err != nil {
+			return errors.Wrap(err, "Unable to create last-known-good directory")
+		}
+
+		lastGood = diskStore{dir: cfg.LastGoodDir}
+	case "s3":
+		sess, err := session.NewSession()
+		if err != nil {
+			return errors.Wrap(err, "Unable to create AWS session")
+		}
+
+		lastGood = s3Store{client: s3.New(sess), bucket: cfg.LastGoodBucket, prefix: cfg.LastGoodPrefix}
+	}
+
+	return nil
+}
+
+// saveLastGood stores an edition's newly fetched list in the background, so a
+// slow store never holds up the request that fetched it. Failures are only
+// logged; the previous list stays in place.
+func saveLastGood(edition string, items capi.Response) {
+	if lastGood == nil {
+		return
+	}
+
+	data := asJSON(lastGoodList{CapturedAt: time.Now(), Response: items})
+
+	workers.run(func(ctx context.Context) {
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+
+		if err := lastGood.Save(ctx, edition, data); err != nil {
+			log.Printf("Unable to save last-known-good %s list, %s", edition, err)
+		}
+	})
+}
+
+// loadLastGood is an edition's last good list, if the store has one
+func loadLastGood(ctx context.Context, edition string) (lastGoodList, bool) {
+	if lastGood == nil {
+		return lastGoodList{}, false
+	}
+
+	data, found, err := lastGood.Load(ctx, edition)
+	if err != nil {
+		log.Printf("Unable to load last-known-good %s list, %s", edition, err)
+		return lastGoodList{}, false
+	}
+
+	if !found {
+		return lastGoodList{}, false
+	}
+
+	var list lastGoodList
+	if err := json.Unmarshal(data, &list); err != nil {
+		log.Printf("Unable to decode last-known-good %s list, %s", edition, err)
+		return lastGoodList{}, false
+	}
+
+	return list, true
+}
+
+// diskStore keeps last good lists as files in a local directory, one per
+// edition
+type diskStore struct {
+	dir string
+}
+
+func (d diskStore) Save(ctx context.Context, edition string, data []byte) error {
+	// written alongside and renamed into place, so a crash mid-write can't
+	// leave a torn list behind
+	tmp, err := ioutil.TempFile(d.dir, edition+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), d.path(edition))
+}
+
+func (d diskStore) Load(ctx context.Context, edition string) ([]byte, bool, error) {
+	data, err := ioutil.ReadFile(d.path(edition))
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	return data, true, nil
+}
+
+func (d diskStore) path(edition string) string {
+	return filepath.Join(d.dir, edition+".json")
+}
+
+// s3Store keeps last good lists as objects in an S3 bucket, so every instance
+// shares them. Credentials and region come from the usual AWS environment.
+type s3Store struct {
+	client *s3.S3
+	bucket string
+	prefix string
+}
+
+func (s s3Store) Save(ctx context.Context, edition string, data []byte) error {
+	_, err := s.client.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(s.key(edition)),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/json"),
+	})
+
+	return err
+}
+
+func (s s3Store) Load(ctx context.Context, edition string) ([]byte, bool, error) {
+	out, err := s.client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(edition)),
+	})
+	if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == s3.ErrCodeNoSuchKey {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	defer out.Body.Close()
+
+	data, err := ioutil.ReadAll(out.Body)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return data, true, nil
+}
+
+func (s s3Store) key(edition string) string {
+	return s.prefix + edition + ".json"
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import "strconv"
+
+// legacyItem is an Item with isLiveBlog as a "true"/"false" string, for
+// clients that parse it that way
+type legacyItem struct {
+	Item
+	IsLiveblog string `json:"isLiveBlog"`
+}
+
+type legacyItemList struct {
+	Heading string       `json:"heading"`
+	Trails  []legacyItem `json:"trails"`
+}
+
+type legacySectionGroup struct {
+	ID      string       `json:"id"`
+	Heading string       `json:"heading"`
+	Trails  []legacyItem `json:"trails"`
+}
+
+type legacyGroupedItemList struct {
+	Heading  string               `json:"heading"`
+	Sections []legacySectionGroup `json:"sections"`
+}
+
+func (item Item) asLegacy() legacyItem {
+	return legacyItem{Item: item, IsLiveblog: strconv.FormatBool(item.IsLiveblog)}
+}
+
+func legacyTrails(trails []Item) []legacyItem {
+	legacy := make([]legacyItem, len(trails))
+	for i, item := range trails {
+		legacy[i] = item.asLegacy()
+	}
+
+	return legacy
+}
+
+func (il ItemList) asLegacy() legacyItemList {
+	return legacyItemList{Heading: il.Heading, Trails: legacyTrails(il.Trails)}
+}
+
+// withLegacyLiveblog swaps any v1 trails in v for their legacy form. Other
+// values, v2 included, are returned as they are.
+func withLegacyLiveblog(v interface{}) interface{} {
+	switch v := v.(type) {
+	case Item:
+		return v.asLegacy()
+	case ItemList:
+		return v.asLegacy()
+	case map[string]ItemList:
+		lists := map[string]legacyItemList{}
+		for key, il := range v {
+			lists[key] = il.asLegacy()
+		}
+		return lists
+	case GroupedItemList:
+		grouped := legacyGroupedItemList{Heading: v.Heading, Sections: []legacySectionGroup{}}
+		for _, section := range v.Sections {
+			grouped.Sections = append(grouped.Sections, legacySectionGroup{
+				ID:      section.ID,
+				Heading: section.Heading,
+				Trails:  legacyTrails(section.Trails),
+			})
+		}
+		return grouped
+	}
+
+	return v
+}
@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLiveblogStyle(t *testing.T) {
+	il := ItemList{Heading: "Most viewed", Trails: []Item{
+		{URL: "https://www.theguardian.com/a", IsLiveblog: true},
+		{URL: "https://www.theguardian.com/b"},
+	}}
+
+	tests := []struct {
+		name  string
+		style string
+		want  []string
+	}{
+		{"default", "", []string{`"isLiveBlog":"true"`, `"isLiveBlog":"false"`}},
+		{"string", "string", []string{`"isLiveBlog":"true"`, `"isLiveBlog":"false"`}},
+		{"bool", "bool", []string{`"isLiveBlog":true`, `"isLiveBlog":false`}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig(t, "")
+			if tt.style != "" {
+				cfg.LiveblogStyle = tt.style
+			}
+
+			body := string(renderOptionsFor(httptest.NewRequest("GET", "/most-viewed/uk", nil), cfg).listJSON(il))
+			for _, want := range tt.want {
+				if !strings.Contains(body, want) {
+					t.Errorf("%s doesn't contain %s", body, want)
+				}
+			}
+			if n := strings.Count(body, `"isLiveBlog"`); n != len(il.Trails) {
+				t.Errorf("%s has isLiveBlog %d times, want %d", body, n, len(il.Trails))
+			}
+		})
+	}
+}
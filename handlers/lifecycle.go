@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// background runs the service's goroutines (the warmer, feature flag
+// refreshes, background revalidation) under one context, so shutdown can
+// stop all of them and wait for them to finish
+type background struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	// mu orders run's wg.Add before stop's Wait, which WaitGroup needs
+	mu      sync.Mutex
+	stopped bool
+}
+
+// workers is the service's background work
+var workers = newBackground()
+
+func newBackground() *background {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &background{ctx: ctx, cancel: cancel}
+}
+
+// run starts f in its own goroutine, reporting whether it did: once the
+// work is stopping nothing new is started. f should return promptly once ctx
+// is done.
+func (b *background) run(f func(ctx context.Context)) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.stopped {
+		return false
+	}
+
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		f(b.ctx)
+	}()
+
+	return true
+}
+
+// stop cancels the background work and waits up to timeout for it to finish,
+// reporting whether it did
+func (b *background) stop(timeout time.Duration) bool {
+	b.mu.Lock()
+	b.stopped = true
+	b.cancel()
+	b.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		b.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/guardian/onward/capi"
+	"go.uber.org/goleak"
+)
+
+func TestShutdownLeavesNoGoroutines(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	var version int64
+	stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		// a new list every fetch, so the change feed has something to send
+		w.Write([]byte(mostViewedBody("a", "b", strings.Repeat("c", int(atomic.AddInt64(&version, 1))%5+1))))
+	})
+
+	savedChanges := listChanges
+	listChanges = newChangeFeed()
+	defer func() { listChanges = savedChanges }()
+
+	bg := newBackground()
+	savedWorkers := workers
+	workers = bg
+	defer func() { workers = savedWorkers }()
+
+	cfg := testConfig(t, stub.URL)
+	cfg.WarmInterval = 10 * time.Millisecond
+	cfg.CacheTTL = 50 * time.Millisecond
+	cfg.RefreshAhead = 40 * time.Millisecond
+	cfg.StreamKeepAlive = 10 * time.Millisecond
+	cfg.ShutdownTimeout = 5 * time.Second
+	cfg.CompressMinBytes = 0
+	cfg.RateLimit = 6000
+
+	transport := &http.Transport{}
+	api := capi.New(cfg, capi.NewHTTPClient(cfg, transport))
+
+	savedCompression := compression
+	compression.configure(true, cfg.CompressMinBytes, cfg.CompressCacheBytes, cfg.CacheTTL)
+	defer func() { compression = savedCompression }()
+
+	c, err := newCache(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gate := &warmGate{}
+	workers.run(func(ctx context.Context) { warm(ctx, api, c, cfg, &inFlight{}, gate) })
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	limiter, err := newRateLimiter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	savedLimiter := clientLimiter
+	clientLimiter = limiter
+	defer func() { clientLimiter = savedLimiter }()
+
+	srv := &http.Server{Handler: rateLimit(cfg, clientLimiter, http.HandlerFunc(mostViewedHandler(api, c, cfg)))}
+	srv.RegisterOnShutdown(listChanges.close)
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(ln) }()
+
+	client := &http.Client{Transport: &http.Transport{}}
+	base := "http://" + ln.Addr().String()
+
+	// refresh-ahead revalidates entries close to expiry in the background
+	for i := 0; i < 5; i++ {
+		resp, err := client.Get(base + "/most-viewed/uk")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		time.Sleep(15 * time.Millisecond)
+	}
+
+	stream, err := client.Get(base + "/most-viewed/uk/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := bufio.NewScanner(stream.Body)
+	if !events.Scan() || !strings.HasPrefix(events.Text(), "event: list") {
+		t.Fatalf("stream began %q, want a list", events.Text())
+	}
+
+	shutdown(srv, nil, c, cfg)
+
+	if err := <-served; err != http.ErrServerClosed {
+		t.Errorf("Serve returned %v, want ErrServerClosed", err)
+	}
+	for events.Scan() {
+	}
+	stream.Body.Close()
+
+	client.CloseIdleConnections()
+	transport.CloseIdleConnections()
+	stub.Close()
+}
+
+func TestBackgroundRunRacesStop(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		bg := newBackground()
+
+		var wg sync.WaitGroup
+		for j := 0; j < 10; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				bg.run(func(ctx context.Context) { <-ctx.Done() })
+			}()
+		}
+
+		if !bg.stop(time.Second) {
+			t.Fatal("background work still running after stop")
+		}
+		wg.Wait()
+
+		if bg.run(func(context.Context) {}) {
+			t.Fatal("started work after stop")
+		}
+	}
+}
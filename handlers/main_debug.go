@@ -0,0 +1,30 @@
+//go:build debug
+// +build debug
+
+package handlers
+
+import (
+	"flag"
+	"net/http/pprof"
+
+	"github.com/guardian/onward/capi"
+	"github.com/guardian/onward/config"
+)
+
+// registerDebugRoutes adds the debugging endpoints. They only exist in builds
+// with the debug tag, and every one needs the admin credentials, whatever its
+// path.
+func registerDebugRoutes(rt *router, api capi.Client, c Cache, cfg config.Config) {
+	rt.handleFunc("/preview/", get, adminOnly(cfg, previewHandler(api, c, cfg)), routeDoc{Path: "/preview/{edition}", Summary: "An edition's list as an HTML table", Returns: "html"})
+	rt.handleFunc("/raw/", get, adminOnly(cfg, rawHandler(api, cfg)), routeDoc{Path: "/raw/{edition}", Summary: "CAPI's response for an edition, uncached", Returns: "json"})
+	rt.handleFunc("/diff/", get, adminOnly(cfg, diffHandler(cfg)), routeDoc{Path: "/diff/{edition}", Summary: "The trails added to and removed from an edition's list between fills", Returns: "json"})
+	rt.handleFunc("/snapshots/", get, adminOnly(cfg, snapshotsHandler(cfg)), routeDoc{Path: "/snapshots/{edition}", Summary: "An edition's recent lists", Returns: "json"})
+	rt.handleFunc("/config", get, adminOnly(cfg, configHandler(flag.CommandLine, cfg)), routeDoc{Path: "/config", Summary: "The running configuration, secrets redacted", Returns: "json"})
+
+	// pprof takes POSTs to /symbol
+	rt.handleFunc("/debug/pprof/", get, adminOnly(cfg, pprof.Index), routeDoc{Path: "/debug/pprof/", Summary: "Go runtime profiles", Returns: "text"})
+	rt.handleFunc("/debug/pprof/cmdline", get, adminOnly(cfg, pprof.Cmdline))
+	rt.handleFunc("/debug/pprof/profile", get, adminOnly(cfg, pprof.Profile))
+	rt.handleFunc("/debug/pprof/symbol", getPost, adminOnly(cfg, pprof.Symbol))
+	rt.handleFunc("/debug/pprof/trace", get, adminOnly(cfg, pprof.Trace))
+}
@@ -0,0 +1,70 @@
+//go:build debug
+
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDebugRoutesNeedAdmin(t *testing.T) {
+	cfg := testConfig(t, "http://capi.invalid")
+	cfg.AdminPassword = "secret"
+
+	rt := newRouter()
+	registerDebugRoutes(rt, testCAPI(cfg), testCache(t, cfg), cfg)
+
+	for _, path := range debugPaths {
+		t.Run(path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			rt.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+			if w.Code != http.StatusUnauthorized {
+				t.Errorf("without credentials got %d, want %d", w.Code, http.StatusUnauthorized)
+			}
+
+			r := httptest.NewRequest(http.MethodGet, path, nil)
+			r.SetBasicAuth("admin", "wrong")
+			w = httptest.NewRecorder()
+			rt.ServeHTTP(w, r)
+			if w.Code != http.StatusUnauthorized {
+				t.Errorf("with the wrong password got %d, want %d", w.Code, http.StatusUnauthorized)
+			}
+		})
+	}
+
+	for _, path := range []string{"/config", "/debug/pprof/", "/debug/pprof/cmdline"} {
+		t.Run(path+" authorized", func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, path, nil)
+			r.SetBasicAuth("admin", "secret")
+			w := httptest.NewRecorder()
+			rt.ServeHTTP(w, r)
+			if w.Code != http.StatusOK {
+				t.Errorf("with credentials got %d, want %d", w.Code, http.StatusOK)
+			}
+		})
+	}
+}
+
+func TestDebugRoutesAreRegistered(t *testing.T) {
+	cfg := testConfig(t, "http://capi.invalid")
+
+	rt := newRouter()
+	registerDebugRoutes(rt, testCAPI(cfg), testCache(t, cfg), cfg)
+
+	for _, path := range debugPaths {
+		if _, pattern := rt.mux.Handler(httptest.NewRequest(http.MethodGet, path, nil)); pattern == "/" {
+			t.Errorf("%s isn't routed", path)
+		}
+	}
+
+	documented := map[string]bool{}
+	for _, doc := range rt.docs {
+		documented[doc.Path] = true
+	}
+	for _, path := range []string{"/preview/{edition}", "/raw/{edition}", "/diff/{edition}", "/snapshots/{edition}", "/config", "/debug/pprof/"} {
+		if !documented[path] {
+			t.Errorf("%s isn't in the OpenAPI document", path)
+		}
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !debug
+// +build !debug
+
+package handlers
+
+import (
+	"log"
+
+	"github.com/guardian/onward/capi"
+	"github.com/guardian/onward/config"
+)
+
+// registerDebugRoutes does nothing: debugging endpoints are left out of
+// builds without the debug tag, so they can't reach production by accident.
+func registerDebugRoutes(rt *router, api capi.Client, c Cache, cfg config.Config) {
+	log.Printf("-debug has no effect: debugging endpoints aren't in this build (build with -tags debug)")
+}
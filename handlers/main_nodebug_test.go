@@ -0,0 +1,26 @@
+//go:build !debug
+
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDebugRoutesAreLeftOut(t *testing.T) {
+	cfg := testConfig(t, "http://capi.invalid")
+
+	rt := newRouter()
+	registerDebugRoutes(rt, testCAPI(cfg), testCache(t, cfg), cfg)
+
+	for _, path := range debugPaths {
+		if _, pattern := rt.mux.Handler(httptest.NewRequest(http.MethodGet, path, nil)); pattern != "/" {
+			t.Errorf("%s is routed, to %s", path, pattern)
+		}
+	}
+
+	if len(rt.docs) != 0 {
+		t.Errorf("documented %d debug routes, want none", len(rt.docs))
+	}
+}
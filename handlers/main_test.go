@@ -0,0 +1,586 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/guardian/onward/capi"
+)
+
+// trailList is the v1 list shape, as far as the tests look
+type trailList struct {
+	Heading string `json:"heading"`
+	Trails  []struct {
+		URL      string `json:"url"`
+		LinkText string `json:"linkText"`
+	} `json:"trails"`
+}
+
+func (il trailList) urls() []string {
+	urls := []string{}
+	for _, trail := range il.Trails {
+		urls = append(urls, trail.URL)
+	}
+	return urls
+}
+
+func decodeTrails(t *testing.T, w *httptest.ResponseRecorder) trailList {
+	t.Helper()
+
+	var il trailList
+	if err := json.Unmarshal(w.Body.Bytes(), &il); err != nil {
+		t.Fatalf("%q isn't a list, %s", w.Body, err)
+	}
+	return il
+}
+
+// fakeCAPI answers CAPI requests in process, without a server
+type fakeCAPI struct {
+	h http.HandlerFunc
+
+	mu       sync.Mutex
+	requests []*http.Request
+}
+
+func (f *fakeCAPI) Do(req *http.Request) (*http.Response, error) {
+	f.mu.Lock()
+	f.requests = append(f.requests, req)
+	f.mu.Unlock()
+
+	w := httptest.NewRecorder()
+	f.h(w, req)
+	return w.Result(), nil
+}
+
+func TestMostViewedHandler(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		capi   int
+		want   int
+		urls   []string
+	}{
+		{"edition", "/most-viewed/uk", http.StatusOK, http.StatusOK, []string{"a", "b", "c"}},
+		{"limited", "/most-viewed/uk?limit=2", http.StatusOK, http.StatusOK, []string{"a", "b"}},
+		{"second page", "/most-viewed/uk?limit=2&page=2", http.StatusOK, http.StatusOK, []string{"c"}},
+		{"section", "/most-viewed/uk/sport", http.StatusOK, http.StatusOK, []string{"a", "b", "c"}},
+		{"bad limit", "/most-viewed/uk?limit=-1", http.StatusOK, http.StatusBadRequest, nil},
+		{"CAPI failing", "/most-viewed/uk", http.StatusInternalServerError, http.StatusBadGateway, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.capi)
+				w.Write([]byte(mostViewedBody("a", "b", "c")))
+			})
+			cfg := testConfig(t, stub.URL)
+			cfg.CAPIRetries = 0
+
+			w := serve(mostViewedHandler(testCAPI(cfg), testCache(t, cfg), cfg), tt.target)
+			if w.Code != tt.want {
+				t.Fatalf("got %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			if tt.urls == nil {
+				return
+			}
+
+			if got := w.Header().Get("Content-Type"); got != "application/json" {
+				t.Errorf("Content-Type is %q, want application/json", got)
+			}
+			if got := decodeTrails(t, w).urls(); !reflect.DeepEqual(got, tt.urls) {
+				t.Errorf("got trails %v, want %v", got, tt.urls)
+			}
+		})
+	}
+}
+
+func TestNormalizePath(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"uk", "uk"},
+		{"uk/", "uk"},
+		{"/uk", "uk"},
+		{"uk//", "uk"},
+		{"uk//sport", "uk/sport"},
+		{"//uk///sport//", "uk/sport"},
+		{"", ""},
+		{"///", ""},
+	}
+
+	for _, tt := range tests {
+		if got := normalizePath(tt.path); got != tt.want {
+			t.Errorf("normalizePath(%q) is %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestSlashesAreNormalized(t *testing.T) {
+	var paths []string
+	stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		w.Write([]byte(mostViewedBody("a", "b")))
+	})
+	cfg := testConfig(t, stub.URL)
+	h := mostViewedHandler(testCAPI(cfg), testCache(t, cfg), cfg)
+
+	for _, target := range []string{"/most-viewed/uk", "/most-viewed/uk/", "/most-viewed//uk", "/most-viewed/uk//"} {
+		w := serve(h, target)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s got %d, want 200: %s", target, w.Code, w.Body)
+		}
+		if got := decodeTrails(t, w).urls(); !reflect.DeepEqual(got, []string{"a", "b"}) {
+			t.Errorf("%s got trails %v, want [a b]", target, got)
+		}
+	}
+
+	// all one list, fetched once
+	if !reflect.DeepEqual(paths, []string{"/uk"}) {
+		t.Errorf("CAPI was asked for %v, want just /uk", paths)
+	}
+}
+
+func TestMostViewedHandlerCachesCAPIResponses(t *testing.T) {
+	stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(mostViewedBody("a", "b")))
+	})
+	cfg := testConfig(t, stub.URL)
+	h := mostViewedHandler(testCAPI(cfg), testCache(t, cfg), cfg)
+
+	for i := 0; i < 3; i++ {
+		if w := serve(h, "/most-viewed/uk?limit=1"); w.Code != http.StatusOK {
+			t.Fatalf("got %d, want 200: %s", w.Code, w.Body)
+		}
+	}
+
+	if calls := stub.requests(); calls != 1 {
+		t.Errorf("CAPI got %d requests, want 1", calls)
+	}
+}
+
+func TestMostViewedHandlerWithFakeCAPIClient(t *testing.T) {
+	fake := &fakeCAPI{h: func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(mostViewedBody("politics/1", "sport/2")))
+	}}
+	cfg := testConfig(t, "https://content.guardianapis.com")
+	cfg.APIKey = "secret"
+
+	w := serve(mostViewedHandler(capi.New(cfg, fake), testCache(t, cfg), cfg), "/most-viewed/us/2")
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d, want 200: %s", w.Code, w.Body)
+	}
+
+	if len(fake.requests) != 1 {
+		t.Fatalf("CAPI got %d requests, want 1", len(fake.requests))
+	}
+	req := fake.requests[0]
+	if req.URL.Host != "content.guardianapis.com" || req.URL.Path != "/us" {
+		t.Errorf("requested %s%s, want content.guardianapis.com/us", req.URL.Host, req.URL.Path)
+	}
+	if got := req.URL.Query().Get("show-most-viewed"); got != "true" {
+		t.Errorf("show-most-viewed is %q, want true", got)
+	}
+	if got := req.URL.Query().Get("api-key"); got != "secret" {
+		t.Errorf("api-key is %q, want the configured key", got)
+	}
+
+	var trail struct {
+		URL string `json:"url"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &trail); err != nil {
+		t.Fatal(err)
+	}
+	if trail.URL != "sport/2" {
+		t.Errorf("got trail %s, want the second", trail.URL)
+	}
+}
+
+func FuzzMostViewedPath(f *testing.F) {
+	for _, seed := range []string{
+		"uk",
+		"uk/sport/3",
+		"uk.json",
+		"auto/sport",
+		"../",
+		"uk/../../admin",
+		"uk/%2e%2e/admin",
+		"uk/..%2f..%2fadmin",
+		"uk/?api-key=stolen",
+		"uk/#fragment",
+		"@evil.example.com",
+		"//evil.example.com/uk",
+		"uk/culture/über",
+		"uk/カルチャー",
+		"uk/\x00\n\r",
+		strings.Repeat("uk/", 2000),
+		strings.Repeat("ü", 10000),
+	} {
+		f.Add(seed)
+	}
+
+	const base = "https://content.guardianapis.com"
+
+	var mu sync.Mutex
+	var requested []*url.URL
+	fake := &fakeCAPI{h: func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requested = append(requested, r.URL)
+		mu.Unlock()
+		w.Write([]byte(mostViewedBody("a")))
+	}}
+	cfg := testConfig(f, base)
+	cfg.APIKey = "secret"
+	h := mostViewedHandler(capi.New(cfg, fake), testCache(f, cfg), cfg)
+
+	f.Fuzz(func(t *testing.T, raw string) {
+		// the handler must never send a request off the CAPI host, whatever
+		// the path; requestURL's own fuzz test covers the URLs it builds
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		r := httptest.NewRequest("GET", "/", nil).WithContext(ctx)
+		r.URL.Path = "/most-viewed/" + raw
+		h(httptest.NewRecorder(), r)
+
+		mu.Lock()
+		defer mu.Unlock()
+		for _, u := range requested {
+			if u.Scheme != "https" || u.Host != "content.guardianapis.com" || u.User != nil {
+				t.Fatalf("GET /most-viewed/%s requested %s, off the CAPI host", raw, capi.RedactedURL(u.String()))
+			}
+		}
+		requested = nil
+	})
+}
+
+func TestRequestBudgetBoundsRetries(t *testing.T) {
+	stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(100 * time.Millisecond):
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case <-r.Context().Done():
+		}
+	})
+	cfg := testConfig(t, stub.URL)
+	cfg.CAPIRetries = 10
+	cfg.RetryBackoff = 10 * time.Millisecond
+	cfg.RetryMaxBackoff = 10 * time.Millisecond
+	cfg.UpstreamTimeout = time.Second
+	cfg.RequestBudget = 350 * time.Millisecond
+
+	start := time.Now()
+	w := serve(mostViewedHandler(testCAPI(cfg), testCache(t, cfg), cfg), "/most-viewed/uk")
+	elapsed := time.Since(start)
+
+	if w.Code < 500 {
+		t.Errorf("got %d, want a 5xx: %s", w.Code, w.Body)
+	}
+	if calls := stub.requests(); calls < 2 {
+		t.Errorf("CAPI got %d requests, want retries within the budget", calls)
+	}
+	// a little over the budget for the handler's own work, but well short
+	// of eleven attempts
+	if elapsed > cfg.RequestBudget+100*time.Millisecond {
+		t.Errorf("took %s, over the %s budget", elapsed, cfg.RequestBudget)
+	}
+}
+
+func TestHiddenBylinesAreCleared(t *testing.T) {
+	tests := []struct {
+		name       string
+		fields     string
+		showByline bool
+		byline     string
+	}{
+		{"shown", `{"byline":"A Writer","showByline":"true"}`, true, "A Writer"},
+		{"hidden", `{"byline":"A Writer","showByline":"false"}`, false, ""},
+		{"shown by default", `{"byline":"A Writer"}`, true, "A Writer"},
+		{"no byline", `{}`, false, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(`{"response":{"status":"ok","mostViewed":[{"id":"a","type":"article","webTitle":"A","fields":` + tt.fields + `}]}}`))
+			})
+			cfg := testConfig(t, stub.URL)
+
+			w := serve(mostViewedHandler(testCAPI(cfg), testCache(t, cfg), cfg), "/most-viewed/uk")
+			if w.Code != http.StatusOK {
+				t.Fatalf("got %d, want 200: %s", w.Code, w.Body)
+			}
+
+			var il struct {
+				Trails []struct {
+					ShowByline bool   `json:"showByline"`
+					Byline     string `json:"byline"`
+				} `json:"trails"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &il); err != nil || len(il.Trails) != 1 {
+				t.Fatalf("got %s, want one trail (%v)", w.Body, err)
+			}
+			if trail := il.Trails[0]; trail.ShowByline != tt.showByline || trail.Byline != tt.byline {
+				t.Errorf("got showByline %t and byline %q, want %t and %q", trail.ShowByline, trail.Byline, tt.showByline, tt.byline)
+			}
+		})
+	}
+}
+
+func TestPublishedAt(t *testing.T) {
+	stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"response":{"status":"ok","mostViewed":[` +
+			`{"id":"dated","type":"article","webTitle":"Dated","webPublicationDate":"2026-10-14T09:30:00Z"},` +
+			`{"id":"offset","type":"article","webTitle":"Offset","webPublicationDate":"2026-10-14T10:30:00+01:00"},` +
+			`{"id":"undated","type":"article","webTitle":"Undated"}]}}`))
+	})
+	cfg := testConfig(t, stub.URL)
+
+	w := serve(mostViewedHandler(testCAPI(cfg), testCache(t, cfg), cfg), "/most-viewed/uk")
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d, want 200: %s", w.Code, w.Body)
+	}
+
+	var il struct {
+		Trails []map[string]json.RawMessage `json:"trails"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &il); err != nil || len(il.Trails) != 3 {
+		t.Fatalf("got %s, want three trails (%v)", w.Body, err)
+	}
+
+	want := time.Date(2026, 10, 14, 9, 30, 0, 0, time.UTC)
+	for _, trail := range il.Trails[:2] {
+		var published time.Time
+		if err := json.Unmarshal(trail["publishedAt"], &published); err != nil {
+			t.Fatalf("publishedAt is %s, not RFC 3339: %s", trail["publishedAt"], err)
+		}
+		if !published.Equal(want) {
+			t.Errorf("%s was published at %s, want %s", trail["url"], published, want)
+		}
+	}
+	if published, ok := il.Trails[2]["publishedAt"]; ok {
+		t.Errorf("the undated trail was published at %s, want no publishedAt", published)
+	}
+}
+
+func TestSplitPosition(t *testing.T) {
+	tests := []struct {
+		path     string
+		want     string
+		position int
+		ok       bool
+	}{
+		{"uk/3", "uk", 3, true},
+		{"uk/sport/2", "uk/sport", 2, true},
+		{"uk/0", "uk", 0, true},
+		{"uk", "uk", 0, false},
+		{"uk/sport", "uk/sport", 0, false},
+		{"sport/3", "sport/3", 0, false},
+		{"uk/three", "uk/three", 0, false},
+	}
+
+	for _, tt := range tests {
+		path, position, ok := splitPosition(tt.path)
+		if path != tt.want || position != tt.position || ok != tt.ok {
+			t.Errorf("splitPosition(%q) is %q, %d, %t, want %q, %d, %t", tt.path, path, position, ok, tt.want, tt.position, tt.ok)
+		}
+	}
+}
+
+func TestItemAtPosition(t *testing.T) {
+	stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(mostViewedBody("a", "b", "c")))
+	})
+	cfg := testConfig(t, stub.URL)
+	h := mostViewedHandler(testCAPI(cfg), testCache(t, cfg), cfg)
+
+	tests := []struct {
+		target string
+		status int
+		url    string
+	}{
+		{"/most-viewed/uk/1", http.StatusOK, "a"},
+		{"/most-viewed/uk/3", http.StatusOK, "c"},
+		{"/most-viewed/uk/4", http.StatusNotFound, ""},
+		{"/most-viewed/uk/0", http.StatusNotFound, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			w := serve(h, tt.target)
+			if w.Code != tt.status {
+				t.Fatalf("got %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.url == "" {
+				return
+			}
+
+			var trail struct {
+				URL string `json:"url"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &trail); err != nil || trail.URL != tt.url {
+				t.Errorf("got %s, want the trail %s (%v)", w.Body, tt.url, err)
+			}
+		})
+	}
+
+	// every position is served from the one cached list
+	if calls := stub.requests(); calls != 1 {
+		t.Errorf("CAPI got %d requests, want 1", calls)
+	}
+}
+
+func TestMinItemsBackfill(t *testing.T) {
+	stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"response":{"status":"ok",
+			"mostViewed":[{"id":"a","type":"article"},{"id":"b","type":"article"}],
+			"results":[{"id":"b","type":"article"},{"id":"c","type":"article"},{"id":"d","type":"article"},{"id":"e","type":"article"}]}}`))
+	})
+
+	tests := []struct {
+		name       string
+		minItems   int
+		urls       []string
+		backfilled []string
+	}{
+		{"off", 0, []string{"a", "b"}, nil},
+		{"already long enough", 2, []string{"a", "b"}, nil},
+		{"short", 4, []string{"a", "b", "c", "d"}, []string{"c", "d"}},
+		{"not enough to backfill from", 10, []string{"a", "b", "c", "d", "e"}, []string{"c", "d", "e"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig(t, stub.URL)
+			cfg.MinItems = tt.minItems
+
+			w := serve(mostViewedHandler(testCAPI(cfg), testCache(t, cfg), cfg), "/most-viewed/uk")
+			if w.Code != http.StatusOK {
+				t.Fatalf("got %d, want 200: %s", w.Code, w.Body)
+			}
+
+			var il struct {
+				Trails []struct {
+					URL        string `json:"url"`
+					Backfilled bool   `json:"backfilled"`
+				} `json:"trails"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &il); err != nil {
+				t.Fatal(err)
+			}
+
+			var urls, backfilled []string
+			for _, trail := range il.Trails {
+				urls = append(urls, trail.URL)
+				if trail.Backfilled {
+					backfilled = append(backfilled, trail.URL)
+				}
+			}
+			if !reflect.DeepEqual(urls, tt.urls) {
+				t.Errorf("got trails %v, want %v", urls, tt.urls)
+			}
+			if !reflect.DeepEqual(backfilled, tt.backfilled) {
+				t.Errorf("backfilled %v, want %v", backfilled, tt.backfilled)
+			}
+		})
+	}
+}
+
+func TestLatestFallback(t *testing.T) {
+	stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"response":{"status":"ok","mostViewed":[],
+			"results":[{"id":"c","type":"article"},{"id":"d","type":"article"}]}}`))
+	})
+
+	tests := []struct {
+		name       string
+		fallback   []string
+		target     string
+		backfilled []string
+	}{
+		{"off", nil, "/most-viewed/uk", nil},
+		{"another edition's", []string{"au"}, "/most-viewed/uk", nil},
+		{"this edition's", []string{"au", "uk"}, "/most-viewed/uk", []string{"c", "d"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig(t, stub.URL)
+			cfg.LatestFallback = tt.fallback
+
+			w := serve(mostViewedHandler(testCAPI(cfg), testCache(t, cfg), cfg), tt.target)
+			if w.Code != http.StatusOK {
+				t.Fatalf("got %d, want 200: %s", w.Code, w.Body)
+			}
+
+			var il struct {
+				Trails []struct {
+					URL        string `json:"url"`
+					Backfilled bool   `json:"backfilled"`
+				} `json:"trails"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &il); err != nil {
+				t.Fatal(err)
+			}
+
+			// all of the latest content is flagged, so clients can tell it
+			// isn't most-viewed
+			var backfilled []string
+			for _, trail := range il.Trails {
+				if !trail.Backfilled {
+					t.Errorf("%s isn't flagged as backfilled", trail.URL)
+				}
+				backfilled = append(backfilled, trail.URL)
+			}
+			if !reflect.DeepEqual(backfilled, tt.backfilled) {
+				t.Errorf("got trails %v, want %v", backfilled, tt.backfilled)
+			}
+		})
+	}
+}
+
+func TestEmptyNoContent(t *testing.T) {
+	tests := []struct {
+		name           string
+		emptyNoContent bool
+		ids            []string
+		status         int
+	}{
+		{"off, empty", false, nil, http.StatusOK},
+		{"on, empty", true, nil, http.StatusNoContent},
+		{"on, with trails", true, []string{"a"}, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(mostViewedBody(tt.ids...)))
+			})
+			cfg := testConfig(t, stub.URL)
+			cfg.EmptyNoContent = tt.emptyNoContent
+
+			w := serve(mostViewedHandler(testCAPI(cfg), testCache(t, cfg), cfg), "/most-viewed/uk")
+			if w.Code != tt.status {
+				t.Fatalf("got %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+
+			if tt.status == http.StatusNoContent {
+				if w.Body.Len() != 0 {
+					t.Errorf("a 204 has a body, %s", w.Body)
+				}
+				return
+			}
+			if got := decodeTrails(t, w).urls(); len(got) != len(tt.ids) {
+				t.Errorf("got trails %v, want %v", got, tt.ids)
+			}
+		})
+	}
+}
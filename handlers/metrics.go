@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/guardian/onward/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var requestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "onward_requests_total",
+	Help: "Most-viewed requests served, by edition.",
+}, []string{"edition"})
+
+var cacheEvictionsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "onward_cache_evictions_total",
+	Help: "Entries evicted from the in-memory cache, by reason (expired or removed).",
+}, []string{"reason"})
+
+var cacheOversizedTotal = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "onward_cache_oversized_total",
+	Help: "Responses served but not cached for being over the cache entry size limit.",
+})
+
+var rateLimitedTotal = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "onward_rate_limited_total",
+	Help: "Requests refused for being over their client's rate limit.",
+})
+
+var capiNotModifiedTotal = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "onward_capi_not_modified_total",
+	Help: "Cache refreshes CAPI answered with 304 Not Modified, so the cached response was kept.",
+})
+
+var requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "onward_request_duration_seconds",
+	Help:    "Time taken to serve requests, by route and edition.",
+	Buckets: prometheus.DefBuckets,
+}, []string{"route", "edition"})
+
+var httpRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "onward_http_requests_total",
+	Help: "Requests served, by route, edition and status code.",
+}, []string{"route", "edition", "code"})
+
+var requestsInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "onward_requests_in_flight",
+	Help: "Requests currently being served.",
+})
+
+var cacheLookupsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "onward_cache_lookups_total",
+	Help: "Cache lookups, by result (hit, stale or miss).",
+}, []string{"result"})
+
+// traceparentPattern matches a W3C traceparent header, capturing the trace ID
+var traceparentPattern = regexp.MustCompile(`^[0-9a-f]{2}-([0-9a-f]{32})-[0-9a-f]{16}-[0-9a-f]{2}$`)
+
+// traceID is the trace ID from the request's traceparent header, if it has a
+// valid one
+func traceID(r *http.Request) string {
+	match := traceparentPattern.FindStringSubmatch(r.Header.Get("traceparent"))
+	if match == nil || match[1] == "00000000000000000000000000000000" {
+		return ""
+	}
+
+	return match[1]
+}
+
+// observeLatency records how long each request takes, and counts requests by
+// status and in flight. Traced requests carry their trace ID as an exemplar,
+// so a dashboard can jump from a slow sample to its trace. Exemplars are only
+// exposed in the OpenMetrics format. Streams are only counted by status.
+func observeLatency(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route, edition := routeLabels(r.URL.Path)
+
+		if isStream(r) {
+			sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+			h.ServeHTTP(sw, r)
+			httpRequestsTotal.WithLabelValues(route, edition, strconv.Itoa(sw.status)).Inc()
+			return
+		}
+
+		requestsInFlight.Inc()
+		defer requestsInFlight.Dec()
+
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(sw, r)
+		elapsed := time.Since(start).Seconds()
+
+		httpRequestsTotal.WithLabelValues(route, edition, strconv.Itoa(sw.status)).Inc()
+
+		observer := requestDuration.WithLabelValues(route, edition)
+		if id := traceID(r); id != "" {
+			observer.(prometheus.ExemplarObserver).ObserveWithExemplar(elapsed, prometheus.Labels{"trace_id": id})
+			return
+		}
+
+		observer.Observe(elapsed)
+	})
+}
+
+// statusWriter remembers the status a response was written with, and how
+// many body bytes. It passes flushes through, so NDJSON still streams.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	sw.status = status
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *statusWriter) Write(b []byte) (int, error) {
+	n, err := sw.ResponseWriter.Write(b)
+	sw.bytes += n
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (sw *statusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
+
+func (sw *statusWriter) Flush() {
+	if flusher, ok := sw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// routes are the route labels for request metrics, matched by prefix in
+// order; anything else is "other"
+var routes = []string{
+	"/most-viewed/all",
+	"/most-viewed/",
+	"/v2/most-viewed/",
+	"/most-commented/",
+	"/related/",
+	"/graphql",
+	"/summary",
+	"/capi/",
+	"/admin/",
+	"/healthz",
+	"/healthcheck",
+	"/readyz",
+	"/ready",
+	"/version",
+	"/metrics",
+	"/openapi.json",
+}
+
+// routeLabels are the route and edition labels for a request path. Only
+// most-viewed and most-commented routes have an edition.
+func routeLabels(path string) (route, edition string) {
+	for _, prefix := range routes {
+		if !strings.HasPrefix(path, prefix) {
+			continue
+		}
+
+		if strings.HasSuffix(prefix, "most-viewed/") || prefix == "/most-commented/" {
+			rest, _ := splitFormat(normalizePath(strings.TrimPrefix(path, prefix)))
+			if strings.HasSuffix(rest, "/stream") {
+				// streams get a label of their own, apart from requests
+				prefix += "stream"
+			}
+			return prefix, editionLabel(strings.SplitN(rest, "/", 2)[0])
+		}
+
+		return prefix, ""
+	}
+
+	return "other", ""
+}
+
+// editionLabel is the metrics label for a path. Anything other than a known
+// edition is bucketed as "other" so arbitrary sections can't blow up the
+// number of series.
+func editionLabel(path string) string {
+	if config.IsEdition(path) {
+		return path
+	}
+
+	return "other"
+}
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// scrape is the default registry's metrics, in the OpenMetrics format so
+// exemplars are included
+func scrape(t *testing.T) string {
+	t.Helper()
+
+	r := httptest.NewRequest("GET", "/metrics", nil)
+	r.Header.Set("Accept", "application/openmetrics-text; version=1.0.0")
+	w := httptest.NewRecorder()
+	promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{EnableOpenMetrics: true}).ServeHTTP(w, r)
+
+	return w.Body.String()
+}
+
+func TestTracedRequestsAreExemplars(t *testing.T) {
+	h := observeLatency(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	const traced = "4bf92f3577b34da6a3ce929d0e0e4736"
+	r := httptest.NewRequest("GET", "/most-viewed/au", nil)
+	r.Header.Set("traceparent", "00-"+traced+"-00f067aa0ba902b7-01")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	const untraced = "00000000000000000000000000000000"
+	r = httptest.NewRequest("GET", "/most-viewed/au", nil)
+	r.Header.Set("traceparent", "00-"+untraced+"-00f067aa0ba902b7-01")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	exemplars := map[string]int{}
+	for _, line := range strings.Split(scrape(t), "\n") {
+		if !strings.HasPrefix(line, "onward_request_duration_seconds_bucket{") {
+			continue
+		}
+		for _, id := range []string{traced, untraced} {
+			if strings.Contains(line, `# {trace_id="`+id+`"}`) {
+				exemplars[id]++
+			}
+		}
+	}
+
+	if exemplars[traced] != 1 {
+		t.Errorf("the traced request is %d buckets' exemplar, want 1", exemplars[traced])
+	}
+	if exemplars[untraced] != 0 {
+		t.Errorf("the all-zero trace ID is %d buckets' exemplar, want none", exemplars[untraced])
+	}
+}
+
+func TestEditionMetrics(t *testing.T) {
+	stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(mostViewedBody("a")))
+	})
+	cfg := testConfig(t, stub.URL)
+	h := mostViewedHandler(testCAPI(cfg), testCache(t, cfg), cfg)
+
+	want := map[string]float64{"uk": 2, "us": 1, "au": 0, "other": 2}
+	before := map[string]float64{}
+	for edition := range want {
+		before[edition] = testutil.ToFloat64(requestsTotal.WithLabelValues(edition))
+	}
+
+	for _, target := range []string{"/most-viewed/uk", "/most-viewed/uk.json", "/most-viewed/us", "/most-viewed/uk/sport", "/most-viewed/us/politics"} {
+		if w := serve(h, target); w.Code != http.StatusOK {
+			t.Fatalf("%s got %d, want 200: %s", target, w.Code, w.Body)
+		}
+	}
+
+	for edition, n := range want {
+		if got := testutil.ToFloat64(requestsTotal.WithLabelValues(edition)) - before[edition]; got != n {
+			t.Errorf("%s counted %v requests, want %v", edition, got, n)
+		}
+	}
+}
+
+func TestRouteLabels(t *testing.T) {
+	tests := []struct {
+		path    string
+		route   string
+		edition string
+	}{
+		{"/most-viewed/uk", "/most-viewed/", "uk"},
+		{"/most-viewed/us.rss", "/most-viewed/", "us"},
+		{"/most-viewed//au/", "/most-viewed/", "au"},
+		{"/most-viewed/uk/sport", "/most-viewed/", "uk"},
+		{"/most-viewed/football", "/most-viewed/", "other"},
+		{"/most-viewed/uk/stream", "/most-viewed/stream", "uk"},
+		{"/v2/most-viewed/us", "/v2/most-viewed/", "us"},
+		{"/most-commented/au", "/most-commented/", "au"},
+		{"/healthz", "/healthz", ""},
+		{"/wp-login.php", "other", ""},
+	}
+
+	for _, tt := range tests {
+		if route, edition := routeLabels(tt.path); route != tt.route || edition != tt.edition {
+			t.Errorf("routeLabels(%q) is %q, %q, want %q, %q", tt.path, route, edition, tt.route, tt.edition)
+		}
+	}
+}
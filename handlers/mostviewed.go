@@ -0,0 +1,424 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/guardian/onward/capi"
+	"github.com/guardian/onward/config"
+)
+
+// ItemList is the collection of items
+type ItemList struct {
+	Heading    string      `json:"heading"`
+	Trails     []Item      `json:"trails"`
+	Pagination *pagination `json:"pagination,omitempty"`
+
+	// Edition is the edition /most-viewed/auto resolved to
+	Edition string `json:"edition,omitempty"`
+}
+
+// Item is the basic article data model
+type Item struct {
+	URL        string `json:"url"`
+	LinkText   string `json:"linkText"`
+	ShowByline bool   `json:"showByline"`
+	Byline     string `json:"byline"`
+	Image      string `json:"image"`
+	IsLiveblog bool   `json:"isLiveBlog"`
+
+	// PublishedAt is omitted when CAPI doesn't give a date
+	PublishedAt *time.Time `json:"publishedAt,omitempty"`
+
+	// Backfilled is set on items that aren't most-viewed but were added to
+	// reach the minimum list length
+	Backfilled bool `json:"backfilled,omitempty"`
+
+	// AlsoIn lists the other editions a trail appeared in, when duplicates are
+	// merged across editions
+	AlsoIn []string `json:"alsoIn,omitempty"`
+
+	// BylineCount is how many trails in the list share this trail's byline,
+	// when bylines are annotated
+	BylineCount int `json:"bylineCount,omitempty"`
+
+	// Rank is the trail's 1-based place in the most-viewed list, only sent
+	// when ?with-rank=true asks for it
+	Rank int `json:"rank,omitempty"`
+
+	// SectionID and SectionName are only used to group trails, and aren't
+	// part of the v1 shape
+	SectionID   string `json:"-"`
+	SectionName string `json:"-"`
+
+	// Tones are the trail's tone tag IDs, only used to filter trails
+	Tones []string `json:"-"`
+
+	// Crops are the trail's image at different sizes, which only v2 sends
+	Crops []imageCrop `json:"-"`
+}
+
+func mostViewedHandler(api capi.Client, c Cache, cfg config.Config) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var items capi.Response
+		var err error
+
+		version, urlPath := apiVersion(r)
+
+		path, f := splitFormat(normalizePath(strings.TrimPrefix(urlPath, "/most-viewed/")))
+
+		if edition, ok := streamedEdition(path); ok {
+			streamEdition(w, r, edition, api, c, cfg)
+			return
+		}
+		if f == "" {
+			if f, err = requestedFormat(r); err != nil {
+				writeError(w, err)
+				return
+			}
+		}
+
+		// auto stands for the client's edition, e.g. auto/sport for uk/sport
+		var resolved, geoHeader string
+		if path == "auto" || strings.HasPrefix(path, "auto/") {
+			resolved, geoHeader = autoEdition(r, cfg)
+			path = resolved + strings.TrimPrefix(path, "auto")
+		}
+
+		path, position, hasPosition := splitPosition(path)
+		if !config.IsEdition(path) && !isEditionSection(path) {
+			writeError(w, pathError(path))
+			return
+		}
+
+		tag := r.URL.Query().Get("tag")
+		if tag != "" && !validTag(tag) {
+			writeError(w, &HTTPError{Status: http.StatusBadRequest, Message: "Invalid tag"})
+			return
+		}
+
+		source, err := requestedSource(r, cfg)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		q, err := mostViewedQuery(path, tag, r.URL.Query().Get("most-viewed") != "false", source)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		limit, clamped, err := requestedLimit(r, defaultLimit(cfg, path), cfg.MaxLimit)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		page, err := requestedPage(r)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		grouped, err := requestedGrouping(r)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		seen, err := requestedSeen(r)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		offset, hasOffset, err := requestedOffset(r)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		maxBytes, err := requestedMaxBytes(r)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		requestsTotal.WithLabelValues(editionLabel(path)).Inc()
+
+		// every upstream attempt for this request shares one deadline
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.RequestBudget)
+		defer cancel()
+		ctx = withCacheDirective(ctx, requestCacheDirective(r))
+		ctx, fresh := withFreshness(ctx)
+
+		items, err = getMostViewed(ctx, api, q, c, cfg)
+
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		backfill := features.enabled(featureBackfill)
+
+		if backfill && q.MostViewed && len(items.Response.Results) == 0 && fallsBackToLatest(cfg, path) {
+			// every item is backfilled, so clients can tell it isn't most-viewed
+			items = items.WithBackfill(len(items.Response.Latest))
+		}
+
+		if backfill && cfg.MinItems > 0 && q.MostViewed {
+			items = items.WithBackfill(cfg.MinItems)
+		}
+
+		if cfg.EmptyNoContent && len(items.Response.Results) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		if r.URL.Query().Get("rank") == "decay" {
+			items.Response.Results = rankByDecay(items.Response.Results, cfg.DecayHalfLife, time.Now())
+		}
+
+		il := applyFilters(asItemList(items, path, editionHeading(cfg, path)), r).dedupe(seen)
+
+		// ranks are the trails' places once filtered, so survive sorting and
+		// pagination
+		if r.URL.Query().Get("with-rank") == "true" {
+			il = il.withRanks()
+		}
+
+		if il, err = applySort(il, r); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		opts := renderOptionsFor(r, cfg)
+
+		if resolved != "" {
+			il.Edition = resolved
+
+			for _, name := range cfg.GeoHeaders {
+				w.Header().Add("Vary", name)
+			}
+		}
+
+		// only once the response is known to succeed, as errors aren't cached
+		setCaching := func() {
+			setCacheControl(w, cfg, fresh)
+
+			if resolved != "" && geoHeader == "" && geoIP != nil {
+				// picked by IP, so no shared cache can tell clients apart
+				w.Header().Set("Cache-Control", "private")
+				w.Header().Del("Expires")
+			}
+		}
+
+		if hasPosition {
+			if position < 1 || position > len(il.Trails) {
+				writeError(w, &HTTPError{Status: http.StatusNotFound, Message: "No item at that position"})
+				return
+			}
+
+			setCaching()
+
+			// JSON and MessagePack send the trail on its own, and the feeds and
+			// the rest a list of just it
+			trail := il.Trails[position-1]
+			switch f {
+			case formatJSON:
+				writeBody(w, r, contentTypes[f], opts.json(trail))
+			case formatMsgpack:
+				writeBody(w, r, contentTypes[f], asMsgpack(trail))
+			case formatNDJSON:
+				il.Trails = []Item{trail}
+				writeNDJSON(w, r, il, opts)
+			default:
+				il.Trails = []Item{trail}
+				writeBody(w, r, contentTypes[f], renderItemList(il, f, opts))
+			}
+			return
+		}
+
+		// the list is cached whole, so every page size and offset is cut from
+		// the same entry
+		total := len(il.Trails)
+		if hasOffset {
+			il = il.window(offset, limit)
+		} else {
+			var pages int
+			il, pages = il.page(limit, page)
+			if page > pages {
+				writeError(w, &HTTPError{Status: http.StatusNotFound, Message: "No such page"})
+				return
+			}
+
+			if limit > 0 {
+				w.Header().Set("Link", paginationLinks(r, page, pages))
+			}
+
+			offset = (page - 1) * limit
+		}
+
+		setCaching()
+
+		if limit > 0 || hasOffset {
+			il.Pagination = &pagination{Total: total, Offset: offset, Limit: limit}
+		}
+
+		if clamped {
+			w.Header().Set("X-Effective-Limit", strconv.Itoa(limit))
+		}
+
+		if cfg.EnrichURL != "" {
+			il = enrich(ctx, il, cfg)
+		}
+
+		if r.URL.Query().Get("annotate-bylines") == "true" {
+			il = il.annotateBylines()
+		}
+
+		if f == formatNDJSON {
+			writeNDJSON(w, r, il, opts)
+			return
+		}
+
+		contentType := contentTypes[f]
+		render := func(il ItemList) []byte { return renderItemList(il, f, opts) }
+
+		switch {
+		case grouped && f == formatJSON:
+			render = func(il ItemList) []byte { return opts.listJSON(il.groupBySection()) }
+		case version == 2 && f == formatJSON:
+			contentType = v2ContentType
+			render = func(il ItemList) []byte { return opts.listJSON(il.asV2()) }
+		}
+
+		if maxBytes > 0 {
+			var truncated bool
+			if il, truncated = il.fit(maxBytes, render); truncated {
+				w.Header().Set("X-Truncated", "true")
+			}
+		}
+
+		writeBody(w, r, contentType, render(il))
+	}
+}
+
+// mostViewedQuery is the query for path's list from source, filtered to tag
+// if it's set: its most-viewed trails, or its latest if mostViewed is false
+func mostViewedQuery(path, tag string, mostViewed bool, source string) (query, error) {
+	if source == config.SourceOphan && mostViewed {
+		// Ophan's list is site-wide, so it only stands in for editions'
+		if !config.IsEdition(path) || tag != "" {
+			return query{}, &HTTPError{Status: http.StatusBadRequest, Message: "Ophan only serves edition lists"}
+		}
+
+		return query{Path: path, Ophan: true}, nil
+	}
+
+	return query{Path: path, MostViewed: mostViewed, Tag: tag}, nil
+}
+
+// getMostViewed fetches a query's list, through the cache for editions and
+// their sections. Each section is cached under its own key, apart from its
+// edition's list.
+func getMostViewed(ctx context.Context, api capi.Client, q query, c Cache, cfg config.Config) (capi.Response, error) {
+	if config.IsEdition(q.Path) || isEditionSection(q.Path) {
+		return cachedGet(ctx, api, q, c, cfg)
+	}
+
+	items, err := fetches.do(ctx, q.cacheKey(), cfg.CoalesceWindow, cfg.RequestBudget, func(ctx context.Context) (capi.Response, error) {
+		return capiGet(ctx, api, q, cfg)
+	})
+	fresh := freshnessFrom(ctx)
+	fresh.note(time.Now(), time.Time{})
+	fresh.noteBackends(q.backends(cfg)...)
+
+	return items, err
+}
+
+// splitPosition splits a trailing item position off an edition or section
+// path, so "uk/3" asks for the third item of "uk" and "uk/sport/3" the third
+// of "uk/sport". Positions are 1-based, matching how most-viewed lists are
+// numbered on the site.
+func splitPosition(path string) (string, int, bool) {
+	i := strings.LastIndex(path, "/")
+	if i == -1 || !config.IsEdition(path[:i]) && !isEditionSection(path[:i]) {
+		return path, 0, false
+	}
+
+	position, err := strconv.Atoi(path[i+1:])
+	if err != nil {
+		return path, 0, false
+	}
+
+	return path[:i], position, true
+}
+
+// normalizePath collapses repeated slashes and trims leading and trailing
+// ones, so "uk/", "/uk" and "uk//" are all "uk"
+func normalizePath(path string) string {
+	var segments []string
+	for _, segment := range strings.Split(path, "/") {
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+
+	return strings.Join(segments, "/")
+}
+
+// asItemList maps the response for the list at path to trails, with the
+// editors' overrides applied
+func asItemList(resp capi.Response, path, heading string) ItemList {
+	// a response missing mostViewed altogether still lists no trails, not null
+	items := []Item{}
+
+	for _, capiItem := range overrides.apply(path, resp.Response.Results) {
+		item := Item{
+			URL:        capiItem.ID,
+			LinkText:   capiItem.Headline(),
+			ShowByline: capiItem.ShowByline(),
+			Image:      capiItem.Thumbnail(),
+			Backfilled: capiItem.Backfilled,
+			IsLiveblog: capiItem.IsLiveblog(),
+
+			SectionID:   capiItem.SectionID,
+			SectionName: capiItem.SectionName,
+			Tones:       capiItem.Tones(),
+			Crops:       images.crops(capiItem),
+		}
+
+		if !capiItem.WebPublicationDate.IsZero() {
+			published := capiItem.WebPublicationDate
+			item.PublishedAt = &published
+		}
+
+		// a hidden byline isn't sent at all, so clients can't render it by mistake
+		if item.ShowByline {
+			item.Byline = capiItem.Byline()
+		}
+
+		items = append(items, item)
+	}
+
+	return ItemList{
+		Heading: heading,
+		Trails:  items,
+	}
+}
+
+func asJSON(v interface{}) []byte {
+	respJSON, err := json.Marshal(v)
+	if err != nil {
+		log.Fatalf("Unable to marshal response (should never happen), %s", err)
+	}
+
+	return respJSON
+}
@@ -0,0 +1,270 @@
+package handlers
+
+import (
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/guardian/onward/config"
+)
+
+// param is a path or query parameter in the OpenAPI document
+type param struct {
+	Description string
+	Schema      map[string]interface{}
+}
+
+var (
+	stringSchema  = map[string]interface{}{"type": "string"}
+	integerSchema = map[string]interface{}{"type": "integer", "minimum": 0}
+	booleanSchema = map[string]interface{}{"type": "boolean"}
+)
+
+// pathParams describe the parameters in routeDoc paths
+var pathParams = map[string]param{
+	"edition": {"An edition, or auto for the client's", map[string]interface{}{"type": "string", "enum": append(append([]string{}, config.Editions...), "auto")}},
+	"section": {"A section ID, e.g. sport", map[string]interface{}{"type": "string", "pattern": sectionPattern.String()}},
+	"path":    {"A content or CAPI path, which may contain slashes", stringSchema},
+	"key":     {"A cache key as GET /admin/cache lists it, URL-escaped", stringSchema},
+}
+
+// queryParams describe the query parameters routeDocs take
+var queryParams = map[string]param{
+	"limit":             {"Most trails to return", integerSchema},
+	"offset":            {"Trails to skip, as an alternative to page", integerSchema},
+	"page":              {"1-based page of limit trails", integerSchema},
+	"format":            {"Response format, instead of the Accept header", map[string]interface{}{"type": "string", "enum": formatNames()}},
+	"pretty":            {"Indent JSON responses", booleanSchema},
+	"newline":           {"End JSON responses with a newline", booleanSchema},
+	"tag":               {"Only trails with this CAPI tag, e.g. football/football", stringSchema},
+	"most-viewed":       {"false for the path's latest content instead", booleanSchema},
+	"source":            {"capi or ophan", map[string]interface{}{"type": "string", "enum": []string{config.SourceCAPI, config.SourceOphan}}},
+	"exclude-liveblogs": {"Leave out liveblogs", booleanSchema},
+	"liveblog":          {"Only liveblogs (true) or none (false)", booleanSchema},
+	"has-image":         {"Only trails with (true) or without (false) an image", booleanSchema},
+	"exclude-tone":      {"Tone tags to leave out, comma-separated", stringSchema},
+	"dedupe":            {"IDs or URLs of trails already seen, comma-separated", stringSchema},
+	"group-by":          {"Group trails, by section", stringSchema},
+	"sort":              {"Reorder the trails", stringSchema},
+	"with-rank":         {"Give each trail its most-viewed rank", booleanSchema},
+	"annotate-bylines":  {"Count the trails sharing each byline", booleanSchema},
+	"max-bytes":         {"Drop trails until the response fits", integerSchema},
+	"merge-duplicates":  {"Merge trails that appear in more than one edition", booleanSchema},
+	"query":             {"A GraphQL query", stringSchema},
+	"operationName":     {"The GraphQL operation to run", stringSchema},
+	"variables":         {"GraphQL variables, as JSON", stringSchema},
+}
+
+// listQuery are the query parameters every list route takes
+var listQuery = []string{"limit", "offset", "page", "format", "pretty", "newline", "exclude-liveblogs", "liveblog", "has-image", "exclude-tone", "dedupe", "group-by", "sort", "with-rank", "annotate-bylines", "max-bytes"}
+
+func formatNames() []string {
+	var names []string
+	for f := range contentTypes {
+		names = append(names, string(f))
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+var pathParamPattern = regexp.MustCompile(`\{([a-z]+)\}`)
+
+// openAPIDocument is an OpenAPI 3 document describing routes, with their
+// response schemas generated from the response types
+func openAPIDocument(routes []routeDoc) map[string]interface{} {
+	schemas := map[string]interface{}{}
+	schemaOf(reflect.TypeOf(ItemList{}), schemas)
+	schemaOf(reflect.TypeOf(errorBody{}), schemas)
+
+	paths := map[string]interface{}{}
+	for _, route := range routes {
+		var params []interface{}
+		for _, match := range pathParamPattern.FindAllStringSubmatch(route.Path, -1) {
+			p := pathParams[match[1]]
+			params = append(params, map[string]interface{}{"name": match[1], "in": "path", "required": true, "description": p.Description, "schema": p.Schema})
+		}
+		for _, name := range route.Query {
+			p := queryParams[name]
+			params = append(params, map[string]interface{}{"name": name, "in": "query", "description": p.Description, "schema": p.Schema})
+		}
+
+		operations, _ := paths[route.Path].(map[string]interface{})
+		if operations == nil {
+			operations = map[string]interface{}{}
+			paths[route.Path] = operations
+		}
+
+		for _, method := range route.methods {
+			operation := map[string]interface{}{
+				"summary":   route.Summary,
+				"responses": openAPIResponses(route.Returns),
+			}
+			if len(params) > 0 {
+				operation["parameters"] = params
+			}
+
+			operations[strings.ToLower(method)] = operation
+		}
+	}
+
+	return map[string]interface{}{
+		"openapi":    "3.0.3",
+		"info":       map[string]interface{}{"title": "Guardian Onward", "version": version},
+		"paths":      paths,
+		"components": map[string]interface{}{"schemas": schemas},
+	}
+}
+
+// openAPIResponses are a route's responses: the successful one, by what it
+// returns, and errors
+func openAPIResponses(returns string) map[string]interface{} {
+	jsonOf := func(schema interface{}) map[string]interface{} {
+		return map[string]interface{}{"application/json": map[string]interface{}{"schema": schema}}
+	}
+
+	var ok map[string]interface{}
+	switch returns {
+	case "list":
+		ok = map[string]interface{}{"description": "The list", "content": jsonOf(schemaRef("ItemList"))}
+	case "lists":
+		failed := map[string]interface{}{"type": "object", "properties": map[string]interface{}{"error": schemaRef("Error")}, "required": []string{"error"}}
+		ok = map[string]interface{}{"description": "Each edition's list, or the error that kept it out", "content": jsonOf(map[string]interface{}{
+			"type": "object", "additionalProperties": map[string]interface{}{"oneOf": []interface{}{schemaRef("ItemList"), failed}},
+		})}
+	case "events":
+		ok = map[string]interface{}{"description": "Server-Sent Events, each list event an ItemList", "content": map[string]interface{}{
+			"text/event-stream": map[string]interface{}{"schema": stringSchema},
+		}}
+	case "text":
+		ok = map[string]interface{}{"description": "OK", "content": map[string]interface{}{"text/plain": map[string]interface{}{"schema": stringSchema}}}
+	case "html":
+		ok = map[string]interface{}{"description": "OK", "content": map[string]interface{}{"text/html": map[string]interface{}{"schema": stringSchema}}}
+	case "none":
+		return map[string]interface{}{
+			"204":     map[string]interface{}{"description": "Done"},
+			"default": errorResponse(),
+		}
+	default:
+		ok = map[string]interface{}{"description": "OK", "content": jsonOf(map[string]interface{}{"type": "object"})}
+	}
+
+	return map[string]interface{}{"200": ok, "default": errorResponse()}
+}
+
+func errorResponse() map[string]interface{} {
+	return map[string]interface{}{"description": "An error", "content": map[string]interface{}{
+		"application/json": map[string]interface{}{"schema": schemaRef("Error")},
+	}}
+}
+
+func schemaRef(name string) map[string]interface{} {
+	return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+}
+
+// schemaNames are the component names for types whose Go names aren't
+// right for clients
+var schemaNames = map[reflect.Type]string{
+	reflect.TypeOf(pagination{}): "Pagination",
+	reflect.TypeOf(errorBody{}):  "Error",
+}
+
+// schemaOf is the JSON schema for t, as encoding/json marshals it. Structs
+// are added to schemas as components and referred to.
+func schemaOf(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == reflect.TypeOf(time.Time{}):
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.String:
+		return stringSchema
+	case t.Kind() == reflect.Bool:
+		return booleanSchema
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case t.Kind() == reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": schemaOf(t.Elem(), schemas)}
+	case t.Kind() == reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaOf(t.Elem(), schemas)}
+	case t.Kind() != reflect.Struct:
+		return map[string]interface{}{}
+	}
+
+	name, ok := schemaNames[t]
+	if !ok {
+		name = t.Name()
+	}
+
+	if _, done := schemas[name]; !done {
+		schemas[name] = nil // placeholder, in case it refers to itself
+
+		properties := map[string]interface{}{}
+		var required []string
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			tag := field.Tag.Get("json")
+			if field.PkgPath != "" || tag == "-" {
+				continue
+			}
+
+			parts := strings.Split(tag, ",")
+			jsonName := parts[0]
+			if jsonName == "" {
+				jsonName = field.Name
+			}
+
+			properties[jsonName] = schemaOf(field.Type, schemas)
+			if !strings.Contains(tag, ",omitempty") {
+				required = append(required, jsonName)
+			}
+		}
+
+		schema := map[string]interface{}{"type": "object", "properties": properties}
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+		schemas[name] = schema
+	}
+
+	return schemaRef(name)
+}
+
+// routeDocs for the routes served whatever the configuration
+var (
+	mostViewedDocs = []routeDoc{
+		{Path: "/most-viewed/{edition}", Summary: "An edition's most-viewed list", Returns: "list", Query: append([]string{"tag", "most-viewed", "source"}, listQuery...)},
+		{Path: "/most-viewed/{edition}/{section}", Summary: "The most-viewed list for an edition's section", Returns: "list", Query: append([]string{"tag", "most-viewed"}, listQuery...)},
+		{Path: "/most-viewed/{edition}/stream", Summary: "An edition's most-viewed list, streamed as it changes", Returns: "events", Query: []string{"limit"}},
+	}
+	mostViewedV2Docs = []routeDoc{
+		{Path: "/v2/most-viewed/{edition}", Summary: "An edition's most-viewed list, version 2", Returns: "list", Query: append([]string{"tag", "most-viewed", "source"}, listQuery...)},
+	}
+	allEditionsDocs = []routeDoc{
+		{Path: "/most-viewed/all", Summary: "Every edition's most-viewed list", Returns: "lists", Query: []string{"merge-duplicates", "pretty"}},
+	}
+	mostCommentedDocs = []routeDoc{
+		{Path: "/most-commented/{edition}", Summary: "The most-commented list", Returns: "list", Query: listQuery},
+	}
+	relatedDocs = []routeDoc{
+		{Path: "/related/{path}", Summary: "Content related to an article", Returns: "list", Query: listQuery},
+	}
+	graphqlDocs = []routeDoc{
+		{Path: "/graphql", Summary: "GraphQL queries of the lists", Returns: "json", Query: []string{"query", "operationName", "variables"}},
+	}
+	summaryDocs = []routeDoc{{Path: "/summary", Summary: "The top trail of each edition", Returns: "json"}}
+	opsDocs     = map[string]routeDoc{
+		"/healthz":     {Path: "/healthz", Summary: "Liveness", Returns: "json"},
+		"/healthcheck": {Path: "/healthcheck", Summary: "Liveness, as /healthz", Returns: "json"},
+		"/readyz":      {Path: "/readyz", Summary: "Readiness", Returns: "json"},
+		"/ready":       {Path: "/ready", Summary: "Readiness, as /readyz", Returns: "json"},
+		"/version":     {Path: "/version", Summary: "The running build", Returns: "json"},
+		"/metrics":     {Path: "/metrics", Summary: "Prometheus metrics", Returns: "text"},
+	}
+)
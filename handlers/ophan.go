@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/guardian/onward/capi"
+	"github.com/guardian/onward/config"
+)
+
+// ophanItem is one of Ophan's most-read pages
+type ophanItem struct {
+	URL   string `json:"url"`
+	Count int    `json:"count"`
+}
+
+// ophanGet lists Ophan's most-read articles, most read first, resolved
+// through CAPI
+func ophanGet(ctx context.Context, api capi.Client, cfg config.Config) (capi.Response, error) {
+	params := url.Values{}
+	params.Set("api-key", cfg.OphanAPIKey)
+	params.Set("count", strconv.Itoa(cfg.OphanCount))
+
+	var mostRead []ophanItem
+	if err := fetchJSON(ctx, "Ophan", strings.TrimSuffix(cfg.OphanURL, "/")+"/mostread?"+params.Encode(), cfg, &mostRead); err != nil {
+		return capi.Response{}, err
+	}
+
+	var paths []string
+	for _, item := range mostRead {
+		if path := webPath(item.URL); path != "" {
+			paths = append(paths, path)
+		}
+	}
+
+	return resolveArticles(ctx, api, paths)
+}
+
+// requestedSource is the request's ?source=, or cfg.MostViewedSource if it
+// doesn't have one
+func requestedSource(r *http.Request, cfg config.Config) (string, error) {
+	source := r.URL.Query().Get("source")
+	if source == "" {
+		return cfg.MostViewedSource, nil
+	}
+
+	switch {
+	case source != config.SourceCAPI && source != config.SourceOphan:
+		return "", &HTTPError{Status: http.StatusBadRequest, Message: "Unknown source"}
+	case source == config.SourceOphan && cfg.OphanAPIKey == "":
+		return "", &HTTPError{Status: http.StatusBadRequest, Message: "Ophan isn't configured"}
+	}
+
+	return source, nil
+}
+
+// cacheTTL is how long q's response is cached. Ophan moves faster than CAPI,
+// so its lists get their own, shorter, TTL.
+func cacheTTL(cfg config.Config, q query) time.Duration {
+	if q.Ophan {
+		return cfg.OphanCacheTTL
+	}
+
+	return cfg.CacheTTL
+}
@@ -0,0 +1,332 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/guardian/onward/capi"
+	"github.com/guardian/onward/config"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// overrideRules are editors' changes to the lists: content pinned at fixed
+// positions, and content left out altogether. They're YAML or JSON, e.g.
+//
+//	block: [world/2026/oct/14/retracted]
+//	pins:
+//	  uk: [{id: politics/2026/oct/14/budget, position: 1}]
+type overrideRules struct {
+	// Block are content IDs left out of every list
+	Block []string `json:"block,omitempty" yaml:"block"`
+
+	// Pins put content IDs at fixed positions, keyed by the list they're
+	// in: an edition, e.g. uk, or an edition's section, e.g. uk/sport
+	Pins map[string][]pin `json:"pins,omitempty" yaml:"pins"`
+}
+
+type pin struct {
+	ID string `json:"id" yaml:"id"`
+
+	// Position is 1-based, as lists are numbered on the site. Past the end
+	// of a list it's the last trail.
+	Position int `json:"position" yaml:"position"`
+}
+
+// parseOverrides reads and checks rules. IDs may be given as guardian.com
+// URLs.
+func parseOverrides(data []byte) (overrideRules, error) {
+	var rules overrideRules
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&rules); err != nil && err != io.EOF {
+		return rules, errors.Wrap(err, "Unable to parse overrides")
+	}
+
+	blocked := map[string]bool{}
+	for i, id := range rules.Block {
+		rules.Block[i] = contentID(id)
+		if rules.Block[i] == "" {
+			return rules, errors.New("Overrides block an empty ID")
+		}
+		blocked[rules.Block[i]] = true
+	}
+
+	for list, pins := range rules.Pins {
+		if !config.IsEdition(list) && !isEditionSection(list) {
+			return rules, errors.Errorf("Overrides pin to %q, which isn't an edition or section", list)
+		}
+
+		positions := map[int]bool{}
+		for i, p := range pins {
+			pins[i].ID = contentID(p.ID)
+			switch {
+			case pins[i].ID == "":
+				return rules, errors.Errorf("Overrides pin an empty ID in %s", list)
+			case p.Position < 1:
+				return rules, errors.Errorf("Overrides pin %s in %s at position %d, positions start at 1", pins[i].ID, list, p.Position)
+			case positions[p.Position]:
+				return rules, errors.Errorf("Overrides pin more than one ID at position %d in %s", p.Position, list)
+			case blocked[pins[i].ID]:
+				return rules, errors.Errorf("Overrides both pin and block %s", pins[i].ID)
+			}
+			positions[p.Position] = true
+		}
+	}
+
+	return rules, nil
+}
+
+// contentID is the CAPI ID of id, which may be a guardian.com URL
+func contentID(id string) string {
+	if strings.Contains(id, "://") {
+		return webPath(id)
+	}
+
+	return normalizePath(id)
+}
+
+// pinnedItem is a pinned ID's CAPI item, to insert at position
+type pinnedItem struct {
+	position int
+	item     capi.Item
+}
+
+// curation holds the override rules in force, with the pinned content looked
+// up in CAPI so pins don't need a fetch per request
+type curation struct {
+	mu      sync.RWMutex
+	rules   overrideRules
+	blocked map[string]bool
+	pinned  map[string][]pinnedItem
+
+	// updating is held while rules are replaced or reloaded, so a reload
+	// can't undo rules set meanwhile
+	updating sync.Mutex
+
+	// source reads the rules from -overrides, and loaded is what it last
+	// gave, so rules are only replaced when it changes
+	source func(ctx context.Context) ([]byte, error)
+	loaded []byte
+}
+
+// overrides are the editors' rules, applied to every list. New opens their
+// source, if there is one.
+var overrides = &curation{}
+
+// set puts rules in force. Blocks apply at once; pins once the pinned content
+// has been found in CAPI, and until then, or if it can't be, the old pins
+// stay. Callers hold updating.
+func (o *curation) set(ctx context.Context, api capi.Client, rules overrideRules) error {
+	blocked := map[string]bool{}
+	for _, id := range rules.Block {
+		blocked[id] = true
+	}
+
+	// blocking content is usually urgent, so it mustn't wait on CAPI
+	o.mu.Lock()
+	o.rules.Block = rules.Block
+	o.blocked = blocked
+	o.mu.Unlock()
+
+	var ids []string
+	seen := map[string]bool{}
+	for _, pins := range rules.Pins {
+		for _, p := range pins {
+			if !seen[p.ID] {
+				seen[p.ID] = true
+				ids = append(ids, p.ID)
+			}
+		}
+	}
+	sort.Strings(ids)
+
+	resolved, err := resolveArticles(ctx, api, ids)
+	if err != nil {
+		return errors.Wrap(err, "Unable to look up pinned content")
+	}
+
+	found := map[string]capi.Item{}
+	for _, item := range resolved.Response.Results {
+		found[item.ID] = item
+	}
+
+	pinned := map[string][]pinnedItem{}
+	for list, pins := range rules.Pins {
+		for _, p := range pins {
+			item, ok := found[p.ID]
+			if !ok {
+				log.Printf("Not pinning %s in %s, CAPI doesn't have it", p.ID, list)
+				continue
+			}
+			pinned[list] = append(pinned[list], pinnedItem{position: p.Position, item: item})
+		}
+
+		sort.Slice(pinned[list], func(i, j int) bool { return pinned[list][i].position < pinned[list][j].position })
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.rules = rules
+	o.pinned = pinned
+	return nil
+}
+
+// current are the rules in force
+func (o *curation) current() overrideRules {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	return o.rules
+}
+
+// apply is the list at path with the rules applied: blocked items left out,
+// and pinned ones moved or inserted to their positions. items isn't
+// modified, as it's usually cached.
+func (o *curation) apply(path string, items []capi.Item) []capi.Item {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	pins := o.pinned[path]
+	if len(o.blocked) == 0 && len(pins) == 0 {
+		return items
+	}
+
+	pinnedIDs := map[string]bool{}
+	for _, p := range pins {
+		pinnedIDs[p.item.ID] = true
+	}
+
+	curated := make([]capi.Item, 0, len(items)+len(pins))
+	for _, item := range items {
+		if !o.blocked[item.ID] && !pinnedIDs[item.ID] {
+			curated = append(curated, item)
+		}
+	}
+
+	// in position order, so each lands where it's pinned
+	for _, p := range pins {
+		// old pins stay while new ones are looked up, but not past a block
+		if o.blocked[p.item.ID] {
+			continue
+		}
+
+		i := p.position - 1
+		if i > len(curated) {
+			i = len(curated)
+		}
+
+		curated = append(curated, capi.Item{})
+		copy(curated[i+1:], curated[i:])
+		curated[i] = p.item
+	}
+
+	return curated
+}
+
+// replace puts rules in force in place of whatever rules are, as the admin
+// API does
+func (o *curation) replace(ctx context.Context, api capi.Client, rules overrideRules) error {
+	o.updating.Lock()
+	defer o.updating.Unlock()
+
+	return o.set(ctx, api, rules)
+}
+
+// reload puts the source's rules in force if they've changed since they were
+// last loaded. Otherwise it looks the pinned content up again, so pins keep
+// up with changes to their headlines and images.
+func (o *curation) reload(ctx context.Context, api capi.Client, cfg config.Config) error {
+	o.updating.Lock()
+	defer o.updating.Unlock()
+
+	if o.source != nil {
+		data, err := o.source(ctx)
+		if err != nil {
+			return errors.Wrap(err, "Unable to read overrides")
+		}
+
+		if o.loaded == nil || !bytes.Equal(data, o.loaded) {
+			rules, err := parseOverrides(data)
+			if err != nil {
+				return err
+			}
+			if err := o.set(ctx, api, rules); err != nil {
+				return err
+			}
+
+			o.loaded = data
+			log.Printf("Loaded overrides from %s: %d blocked, pins in %d lists", cfg.Overrides, len(rules.Block), len(rules.Pins))
+			return nil
+		}
+	}
+
+	return o.set(ctx, api, o.current())
+}
+
+// watch reloads the rules every -overrides-refresh, until ctx is done. Rules
+// set through the admin API stay until the source changes.
+func (o *curation) watch(ctx context.Context, api capi.Client, cfg config.Config) {
+	ticker := time.NewTicker(cfg.OverridesRefresh)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := o.reload(ctx, api, cfg); err != nil {
+				log.Printf("Keeping the current overrides, %s", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// openOverrides sets the rules' source from -overrides: a file, or an S3
+// object given as s3://bucket/key
+func openOverrides(cfg config.Config) error {
+	if cfg.Overrides == "" {
+		return nil
+	}
+
+	if !strings.HasPrefix(cfg.Overrides, "s3://") {
+		overrides.source = func(context.Context) ([]byte, error) {
+			return ioutil.ReadFile(cfg.Overrides)
+		}
+		return nil
+	}
+
+	bucket, key, err := config.S3Location(cfg.Overrides)
+	if err != nil {
+		return err
+	}
+
+	sess, err := session.NewSession()
+	if err != nil {
+		return errors.Wrap(err, "Unable to create AWS session")
+	}
+	client := s3.New(sess)
+
+	overrides.source = func(ctx context.Context) ([]byte, error) {
+		out, err := client.GetObjectWithContext(ctx, &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+		if err != nil {
+			return nil, err
+		}
+		defer out.Body.Close()
+
+		return ioutil.ReadAll(out.Body)
+	}
+	return nil
+}
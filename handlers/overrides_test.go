@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"sync/atomic"
+	"testing"
+)
+
+func TestBlocksApplyWhenPinsCantBeLookedUp(t *testing.T) {
+	var failing int32
+	stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(`{"response":{"status":"ok","results":[{"id":"pinned","type":"article"}]}}`))
+	})
+	cfg := testConfig(t, stub.URL)
+	cfg.CAPIRetries = 0
+	api := testCAPI(cfg)
+
+	o := &curation{}
+	if err := o.set(context.Background(), api, overrideRules{Pins: map[string][]pin{"uk": {{ID: "pinned", Position: 1}}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	atomic.StoreInt32(&failing, 1)
+	rules := overrideRules{
+		Block: []string{"retracted", "pinned"},
+		Pins:  map[string][]pin{"uk": {{ID: "other", Position: 2}}},
+	}
+	if err := o.set(context.Background(), api, rules); err == nil {
+		t.Fatal("set succeeded with CAPI failing")
+	}
+
+	if got := o.current().Block; !reflect.DeepEqual(got, rules.Block) {
+		t.Errorf("blocking %v, want %v", got, rules.Block)
+	}
+
+	// the old pin stays until the new ones are found, unless it's blocked
+	got := itemIDs(o.apply("uk", capiItems("a", "retracted", "b")))
+	if want := []string{"a", "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("curated to %v, want %v", got, want)
+	}
+}
+
+func TestApplyPinsAndBlocks(t *testing.T) {
+	stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"response":{"status":"ok","results":[{"id":"late","type":"article"},{"id":"first","type":"article"}]}}`))
+	})
+	api := testCAPI(testConfig(t, stub.URL))
+
+	o := &curation{}
+	rules := overrideRules{
+		Block: []string{"retracted"},
+		Pins:  map[string][]pin{"uk": {{ID: "late", Position: 9}, {ID: "first", Position: 1}, {ID: "missing", Position: 2}}},
+	}
+	if err := o.set(context.Background(), api, rules); err != nil {
+		t.Fatal(err)
+	}
+
+	got := itemIDs(o.apply("uk", capiItems("a", "retracted", "late", "b")))
+	if want := []string{"first", "a", "b", "late"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("curated uk to %v, want %v", got, want)
+	}
+
+	got = itemIDs(o.apply("us", capiItems("a", "retracted")))
+	if want := []string{"a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("curated us to %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/guardian/onward/config"
+)
+
+// requestedLimit parses the request's ?limit=, capped at max, falling back to
+// def when it isn't given. It returns 0 when there's no limit, and whether
+// the requested value had to be clamped.
+func requestedLimit(r *http.Request, def, max int) (int, bool, error) {
+	raw := r.URL.Query().Get("limit")
+	if raw == "" {
+		return def, false, nil
+	}
+
+	limit, err := strconv.Atoi(raw)
+	if err != nil || limit < 1 {
+		return 0, false, &HTTPError{Status: http.StatusBadRequest, Message: "Invalid limit"}
+	}
+
+	if max > 0 && limit > max {
+		return max, true, nil
+	}
+
+	return limit, false, nil
+}
+
+// limit truncates the list to at most n trails. Zero means no limit.
+func (il ItemList) limit(n int) ItemList {
+	if n > 0 && len(il.Trails) > n {
+		il.Trails = il.Trails[:n]
+	}
+
+	return il
+}
+
+// requestedOffset parses the request's ?offset=, the number of trails to skip,
+// and reports whether it was given
+func requestedOffset(r *http.Request) (int, bool, error) {
+	raw := r.URL.Query().Get("offset")
+	if raw == "" {
+		return 0, false, nil
+	}
+
+	offset, err := strconv.Atoi(raw)
+	if err != nil || offset < 0 {
+		return 0, false, &HTTPError{Status: http.StatusBadRequest, Message: "Invalid offset"}
+	}
+
+	if r.URL.Query().Get("page") != "" {
+		return 0, false, &HTTPError{Status: http.StatusBadRequest, Message: "Use either offset or page"}
+	}
+
+	return offset, true, nil
+}
+
+// pagination describes which part of the full list a response holds
+type pagination struct {
+	Total  int `json:"total"`
+	Offset int `json:"offset"`
+	Limit  int `json:"limit,omitempty"`
+}
+
+// window returns at most n trails starting offset trails in. Zero n means
+// every trail from offset on.
+func (il ItemList) window(offset, n int) ItemList {
+	if offset >= len(il.Trails) {
+		il.Trails = []Item{}
+		return il
+	}
+
+	il.Trails = il.Trails[offset:]
+	return il.limit(n)
+}
+
+// requestedPage parses the request's 1-based ?page=, defaulting to the first
+func requestedPage(r *http.Request) (int, error) {
+	raw := r.URL.Query().Get("page")
+	if raw == "" {
+		return 1, nil
+	}
+
+	page, err := strconv.Atoi(raw)
+	if err != nil || page < 1 {
+		return 0, &HTTPError{Status: http.StatusBadRequest, Message: "Invalid page"}
+	}
+
+	return page, nil
+}
+
+// page returns the given 1-based page of the list, n trails to a page, along
+// with how many pages there are. Zero n means one page with every trail.
+func (il ItemList) page(n, page int) (ItemList, int) {
+	if n <= 0 {
+		return il, 1
+	}
+
+	pages := (len(il.Trails) + n - 1) / n
+	if pages == 0 {
+		pages = 1
+	}
+
+	start := (page - 1) * n
+	if start >= len(il.Trails) {
+		il.Trails = []Item{}
+		return il, pages
+	}
+
+	il.Trails = il.Trails[start:]
+	return il.limit(n), pages
+}
+
+// paginationLinks builds an RFC 5988 Link header for a page of a paginated
+// response, pointing at the first page and the pages either side
+func paginationLinks(r *http.Request, page, pages int) string {
+	link := func(n int, rel string) string {
+		u := *r.URL
+		values := u.Query()
+		values.Set("page", strconv.Itoa(n))
+		u.RawQuery = values.Encode()
+		return fmt.Sprintf(`<%s>; rel="%s"`, u.RequestURI(), rel)
+	}
+
+	links := []string{link(1, "first")}
+	if page > 1 {
+		links = append(links, link(page-1, "prev"))
+	}
+	if page < pages {
+		links = append(links, link(page+1, "next"))
+	}
+
+	return strings.Join(links, ", ")
+}
+
+// requestedMaxBytes parses the request's ?max-bytes=, returning 0 when it
+// isn't given
+func requestedMaxBytes(r *http.Request) (int, error) {
+	raw := r.URL.Query().Get("max-bytes")
+	if raw == "" {
+		return 0, nil
+	}
+
+	maxBytes, err := strconv.Atoi(raw)
+	if err != nil || maxBytes < 1 {
+		return 0, &HTTPError{Status: http.StatusBadRequest, Message: "Invalid max-bytes"}
+	}
+
+	return maxBytes, nil
+}
+
+// fit trims trailing trails until render's output is at most maxBytes long,
+// keeping the longest prefix that fits, and reports whether any were cut. If
+// not even an empty list fits, the list comes back empty.
+func (il ItemList) fit(maxBytes int, render func(ItemList) []byte) (ItemList, bool) {
+	if len(render(il)) <= maxBytes {
+		return il, false
+	}
+
+	// the longest prefix that fits is found by binary search, as the
+	// rendered size only grows with the number of trails
+	trails := il.Trails
+	fits := sort.Search(len(trails)+1, func(n int) bool {
+		il.Trails = trails[:n]
+		return len(render(il)) > maxBytes
+	}) - 1
+
+	if fits < 0 {
+		fits = 0
+	}
+
+	il.Trails = trails[:fits]
+	return il, true
+}
+
+// defaultLimit is the limit for a path when the request doesn't give one: the
+// edition's own default if it has one, otherwise the global default. Sections
+// share their edition's.
+func defaultLimit(cfg config.Config, path string) int {
+	if edition, _, ok := splitSection(path); ok {
+		path = edition
+	}
+
+	if limit, ok := cfg.EditionLimits[path]; ok {
+		return limit
+	}
+
+	return cfg.DefaultLimit
+}
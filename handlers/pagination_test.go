@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestRequestedLimit(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    int
+		clamped bool
+		wantErr bool
+	}{
+		{raw: "", want: 10},
+		{raw: "5", want: 5},
+		{raw: "100", want: 100},
+		{raw: "101", want: 100, clamped: true},
+		{raw: "1000000", want: 100, clamped: true},
+		{raw: "0", wantErr: true},
+		{raw: "-1", wantErr: true},
+		{raw: "ten", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/most-viewed/uk?limit="+tt.raw, nil)
+			limit, clamped, err := requestedLimit(r, 10, 100)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want one: %t", err, tt.wantErr)
+			}
+			if !tt.wantErr && (limit != tt.want || clamped != tt.clamped) {
+				t.Errorf("got %d, clamped %t, want %d, clamped %t", limit, clamped, tt.want, tt.clamped)
+			}
+		})
+	}
+}
+
+func TestLimitsAreClamped(t *testing.T) {
+	ids := make([]string, 8)
+	for i := range ids {
+		ids[i] = "item-" + strconv.Itoa(i)
+	}
+	stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(mostViewedBody(ids...)))
+	})
+	cfg := testConfig(t, stub.URL)
+	cfg.MaxLimit = 5
+	h := mostViewedHandler(testCAPI(cfg), testCache(t, cfg), cfg)
+
+	tests := []struct {
+		target    string
+		trails    int
+		effective string
+	}{
+		{"/most-viewed/uk?limit=3", 3, ""},
+		{"/most-viewed/uk?limit=5", 5, ""},
+		{"/most-viewed/uk?limit=500", 5, "5"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			w := serve(h, tt.target)
+			if w.Code != http.StatusOK {
+				t.Fatalf("got %d, want 200: %s", w.Code, w.Body)
+			}
+			if got := len(decodeTrails(t, w).Trails); got != tt.trails {
+				t.Errorf("got %d trails, want %d", got, tt.trails)
+			}
+			if got := w.Header().Get("X-Effective-Limit"); got != tt.effective {
+				t.Errorf("X-Effective-Limit is %q, want %q", got, tt.effective)
+			}
+		})
+	}
+}
+
+func TestEditionDefaultLimits(t *testing.T) {
+	stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(mostViewedBody("a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k", "l")))
+	})
+	cfg := testConfig(t, stub.URL)
+	cfg.DefaultLimit = 10
+	cfg.EditionLimits = map[string]int{"au": 5}
+	h := mostViewedHandler(testCAPI(cfg), testCache(t, cfg), cfg)
+
+	tests := []struct {
+		target string
+		trails int
+	}{
+		{"/most-viewed/uk", 10},
+		{"/most-viewed/au", 5},
+		{"/most-viewed/au/sport", 5},
+		{"/most-viewed/au?limit=8", 8},
+		{"/most-viewed/us?limit=2", 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			w := serve(h, tt.target)
+			if w.Code != http.StatusOK {
+				t.Fatalf("got %d, want 200: %s", w.Code, w.Body)
+			}
+			if got := len(decodeTrails(t, w).Trails); got != tt.trails {
+				t.Errorf("got %d trails, want %d", got, tt.trails)
+			}
+		})
+	}
+}
+
+func TestPaginationLinks(t *testing.T) {
+	stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(mostViewedBody("a", "b", "c", "d", "e", "f", "g")))
+	})
+	cfg := testConfig(t, stub.URL)
+	h := mostViewedHandler(testCAPI(cfg), testCache(t, cfg), cfg)
+
+	tests := []struct {
+		target string
+		urls   []string
+		link   string
+	}{
+		{"/most-viewed/uk?limit=2&page=2", []string{"c", "d"},
+			`</most-viewed/uk?limit=2&page=1>; rel="first", </most-viewed/uk?limit=2&page=1>; rel="prev", </most-viewed/uk?limit=2&page=3>; rel="next"`},
+		{"/most-viewed/uk?limit=2&page=1", []string{"a", "b"},
+			`</most-viewed/uk?limit=2&page=1>; rel="first", </most-viewed/uk?limit=2&page=2>; rel="next"`},
+		{"/most-viewed/uk?limit=2&page=4", []string{"g"},
+			`</most-viewed/uk?limit=2&page=1>; rel="first", </most-viewed/uk?limit=2&page=3>; rel="prev"`},
+		{"/most-viewed/uk?page=2&limit=3&tag=politics/politics", []string{"d", "e", "f"},
+			`</most-viewed/uk?limit=3&page=1&tag=politics%2Fpolitics>; rel="first", </most-viewed/uk?limit=3&page=1&tag=politics%2Fpolitics>; rel="prev", </most-viewed/uk?limit=3&page=3&tag=politics%2Fpolitics>; rel="next"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			w := serve(h, tt.target)
+			if w.Code != http.StatusOK {
+				t.Fatalf("got %d, want 200: %s", w.Code, w.Body)
+			}
+			if got := decodeTrails(t, w).urls(); !reflect.DeepEqual(got, tt.urls) {
+				t.Errorf("got trails %v, want %v", got, tt.urls)
+			}
+			if got := w.Header().Get("Link"); got != tt.link {
+				t.Errorf("Link is\n%s\nwant\n%s", got, tt.link)
+			}
+		})
+	}
+}
+
+func TestMaxBytes(t *testing.T) {
+	stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(mostViewedBody("a", "b", "c", "d", "e")))
+	})
+	cfg := testConfig(t, stub.URL)
+	h := mostViewedHandler(testCAPI(cfg), testCache(t, cfg), cfg)
+
+	// sizes[n] is the size of the list of the first n trails
+	sizes := make([]int, 6)
+	for n := 1; n < len(sizes); n++ {
+		sizes[n] = serve(h, "/most-viewed/uk?limit="+strconv.Itoa(n)).Body.Len()
+	}
+
+	tests := []struct {
+		name      string
+		maxBytes  int
+		trails    int
+		truncated bool
+	}{
+		{"roomy", sizes[5] + 100, 5, false},
+		{"exactly", sizes[5], 5, false},
+		{"a byte short", sizes[5] - 1, 4, true},
+		{"between", (sizes[2] + sizes[3]) / 2, 2, true},
+		{"one trail", sizes[1], 1, true},
+		{"too small for anything", 1, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(h, "/most-viewed/uk?max-bytes="+strconv.Itoa(tt.maxBytes))
+			if w.Code != http.StatusOK {
+				t.Fatalf("got %d, want 200: %s", w.Code, w.Body)
+			}
+
+			if got := len(decodeTrails(t, w).Trails); got != tt.trails {
+				t.Errorf("got %d trails, want %d", got, tt.trails)
+			}
+			if tt.trails > 0 && w.Body.Len() > tt.maxBytes {
+				t.Errorf("the response is %d bytes, over the %d budget", w.Body.Len(), tt.maxBytes)
+			}
+			if got := w.Header().Get("X-Truncated") == "true"; got != tt.truncated {
+				t.Errorf("truncated is %v, want %v", got, tt.truncated)
+			}
+		})
+	}
+
+	for _, raw := range []string{"0", "-1", "lots"} {
+		if w := serve(h, "/most-viewed/uk?max-bytes="+raw); w.Code != http.StatusBadRequest {
+			t.Errorf("max-bytes=%s: got %d, want 400", raw, w.Code)
+		}
+	}
+}
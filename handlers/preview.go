@@ -0,0 +1,69 @@
+//go:build debug
+// +build debug
+
+package handlers
+
+import (
+	"context"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/guardian/onward/capi"
+	"github.com/guardian/onward/config"
+)
+
+var previewTemplate = template.Must(template.New("preview").Funcs(template.FuncMap{
+	"position": func(i int) int { return i + 1 },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Heading}}</title>
+<style>
+body { font-family: sans-serif; }
+td, th { padding: 4px 8px; text-align: left; vertical-align: top; }
+img { max-width: 140px; }
+</style>
+</head>
+<body>
+<h1>{{.Heading}}</h1>
+<table>
+<tr><th>#</th><th>Image</th><th>Link</th><th>Byline</th><th>Live blog</th></tr>
+{{range $i, $item := .Trails}}<tr>
+<td>{{position $i}}</td>
+<td>{{if $item.Image}}<img src="{{$item.Image}}" alt="">{{end}}</td>
+<td><a href="https://www.theguardian.com/{{$item.URL}}">{{$item.LinkText}}</a></td>
+<td>{{$item.Byline}}</td>
+<td>{{if $item.IsLiveblog}}yes{{end}}</td>
+</tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// previewHandler renders an edition's most-viewed list as an HTML table, for
+// eyeballing the data. It's only registered in debug mode.
+func previewHandler(api capi.Client, c Cache, cfg config.Config) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := query{
+			Path:       normalizePath(strings.TrimPrefix(r.URL.Path, "/preview/")),
+			MostViewed: true,
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.RequestBudget)
+		defer cancel()
+
+		items, err := getMostViewed(ctx, api, q, c, cfg)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := previewTemplate.Execute(w, applyFilters(asItemList(items, q.Path, editionHeading(cfg, q.Path)), r)); err != nil {
+			log.Printf("Unable to render preview, %s", err)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+//go:build debug
+
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestPreviewHandler(t *testing.T) {
+	stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"response":{"status":"ok","mostViewed":[
+			{"id":"politics/1","type":"article","webTitle":"Budget","fields":{"thumbnail":"https://media.guim.co.uk/1/500.jpg"}},
+			{"id":"sport/2","type":"liveblog","webTitle":"<script>alert(1)</script>"}
+		]}}`))
+	})
+	cfg := testConfig(t, stub.URL)
+	c := testCache(t, cfg)
+
+	w := serve(previewHandler(testCAPI(cfg), c, cfg), "/preview/uk")
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d, want 200: %s", w.Code, w.Body)
+	}
+	if got := w.Header().Get("Content-Type"); got != "text/html; charset=utf-8" {
+		t.Errorf("Content-Type is %q, want text/html", got)
+	}
+
+	body := w.Body.String()
+	for _, want := range []string{
+		`<a href="https://www.theguardian.com/politics/1">Budget</a>`,
+		`<a href="https://www.theguardian.com/sport/2">`,
+		`<img src="https://media.guim.co.uk/1/500.jpg"`,
+		"&lt;script&gt;",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("the preview doesn't contain %s: %s", want, body)
+		}
+	}
+	if strings.Contains(body, "<script>") {
+		t.Errorf("a headline wasn't escaped: %s", body)
+	}
+
+	// the preview and JSON lists share the cache
+	if w := serve(mostViewedHandler(testCAPI(cfg), c, cfg), "/most-viewed/uk"); w.Code != http.StatusOK {
+		t.Fatalf("the JSON list got %d, want 200: %s", w.Code, w.Body)
+	}
+	if calls := stub.requests(); calls != 1 {
+		t.Errorf("CAPI got %d requests, want 1", calls)
+	}
+}
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/guardian/onward/capi"
+	"github.com/guardian/onward/config"
+)
+
+// proxyHandler passes CAPI queries through the same caching and error
+// handling as most-viewed, e.g. /capi/search?q=brexit. Only paths whose first
+// segment is in cfg.ProxyPaths are allowed, so this can't be used as an open
+// proxy onto CAPI with our key.
+func proxyHandler(api capi.Client, c Cache, cfg config.Config) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		path := normalizePath(strings.TrimPrefix(r.URL.Path, "/capi/"))
+		if !proxyAllowed(path, cfg.ProxyPaths) {
+			writeError(w, &HTTPError{Status: http.StatusForbidden, Message: "Path not allowed"})
+			return
+		}
+
+		params := r.URL.Query()
+		params.Del("api-key")
+
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.RequestBudget)
+		defer cancel()
+		ctx = withCacheDirective(ctx, requestCacheDirective(r))
+
+		if cfg.ConditionalPassthrough {
+			if cond := conditionalRequest(r); cond != nil {
+				passConditional(capi.WithConditional(ctx, cond), w, r, api, path, params)
+				return
+			}
+		}
+
+		body, err := cachedFetch(ctx, api, path, params, c, cfg)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if _, err := w.Write(body); err != nil {
+			logWriteFailure(r.URL.Path, err)
+		}
+	}
+}
+
+// passConditional serves a conditional request by asking CAPI itself,
+// bypassing the cache, and relaying CAPI's validators and any 304
+func passConditional(ctx context.Context, w http.ResponseWriter, r *http.Request, api capi.Client, path string, params url.Values) {
+	body, err := capi.Fetch(ctx, api, path, params)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	cond := capi.ConditionalFrom(ctx)
+	for name, values := range cond.Validators {
+		w.Header()[name] = values
+	}
+
+	if cond.NotModified {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(body); err != nil {
+		logWriteFailure(r.URL.Path, err)
+	}
+}
+
+func proxyAllowed(path string, allowed []string) bool {
+	first := strings.SplitN(path, "/", 2)[0]
+	for _, a := range allowed {
+		if first == a {
+			return true
+		}
+	}
+
+	return false
+}
+
+// conditionalHeaders are the request headers passed to CAPI in conditional
+// passthrough
+var conditionalHeaders = []string{"If-None-Match", "If-Modified-Since"}
+
+// conditionalRequest is the request's conditional headers, or nil if it
+// isn't conditional
+func conditionalRequest(r *http.Request) *capi.Conditional {
+	cond := &capi.Conditional{Request: http.Header{}, Validators: http.Header{}}
+	for _, name := range conditionalHeaders {
+		if value := r.Header.Get(name); value != "" {
+			cond.Request.Set(name, value)
+		}
+	}
+
+	if len(cond.Request) == 0 {
+		return nil
+	}
+
+	return cond
+}
@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestProxyHandler(t *testing.T) {
+	var got url.Values
+	stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		got = r.URL.Query()
+		w.Write([]byte(`{"response":{"status":"ok","results":[]}}`))
+	})
+	cfg := testConfig(t, stub.URL)
+	cfg.APIKey = "ours"
+	cfg.ProxyPaths = []string{"search"}
+
+	tests := []struct {
+		name   string
+		target string
+		want   int
+	}{
+		{"allowed", "/capi/search?q=brexit", http.StatusOK},
+		{"client's key", "/capi/search?q=brexit&api-key=theirs", http.StatusOK},
+		{"client's keys", "/capi/search?q=budget&api-key=theirs&api-key=also-theirs", http.StatusOK},
+		{"not allowed", "/capi/tags?q=brexit", http.StatusForbidden},
+		{"escaping the allowed path", "/capi/search/../tags", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got = nil
+			w := serve(proxyHandler(testCAPI(cfg), testCache(t, cfg), cfg), tt.target)
+			if w.Code != tt.want {
+				t.Fatalf("got %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			if tt.want != http.StatusOK {
+				if got != nil {
+					t.Errorf("CAPI was asked %v, want nothing", got)
+				}
+				return
+			}
+
+			if keys := got["api-key"]; len(keys) != 1 || keys[0] != "ours" {
+				t.Errorf("CAPI was sent api-key %v, want only the configured key", keys)
+			}
+			if got.Get("q") == "" {
+				t.Errorf("CAPI was sent %v, without the client's query", got)
+			}
+		})
+	}
+}
+
+func TestProxiedQueriesAreCached(t *testing.T) {
+	var queries []string
+	stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.Path+"?q="+r.URL.Query().Get("q"))
+		w.Write([]byte(`{"response":{"status":"ok","results":[{"id":"` + r.URL.Query().Get("q") + `"}]}}`))
+	})
+	cfg := testConfig(t, stub.URL)
+	cfg.ProxyPaths = []string{"search"}
+	h := proxyHandler(testCAPI(cfg), testCache(t, cfg), cfg)
+
+	for _, target := range []string{"/capi/search?q=brexit", "/capi/search?q=brexit", "/capi/search?q=budget", "/capi/search?q=brexit"} {
+		w := serve(h, target)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s got %d, want 200: %s", target, w.Code, w.Body)
+		}
+		if want := target[len("/capi/search?q="):]; !strings.Contains(w.Body.String(), `"`+want+`"`) {
+			t.Errorf("%s got %s, want the results for %s", target, w.Body, want)
+		}
+	}
+
+	if want := []string{"/search?q=brexit", "/search?q=budget"}; !reflect.DeepEqual(queries, want) {
+		t.Errorf("CAPI was asked %v, want %v", queries, want)
+	}
+}
+
+func TestConditionalPassthrough(t *testing.T) {
+	var sent string
+	stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		sent = r.Header.Get("If-None-Match")
+		w.Header().Set("ETag", `"v1"`)
+		if sent == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte(`{"response":{"status":"ok","results":[]}}`))
+	})
+
+	tests := []struct {
+		name        string
+		passthrough bool
+		ifNoneMatch string
+		status      int
+		sent        string
+		requests    int64
+	}{
+		{"unchanged", true, `"v1"`, http.StatusNotModified, `"v1"`, 2},
+		{"changed", true, `"v0"`, http.StatusOK, `"v0"`, 2},
+		{"not conditional", true, "", http.StatusOK, "", 1},
+		{"off", false, `"v1"`, http.StatusOK, "", 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig(t, stub.URL)
+			cfg.ProxyPaths = []string{"search"}
+			cfg.ConditionalPassthrough = tt.passthrough
+			h := proxyHandler(testCAPI(cfg), testCache(t, cfg), cfg)
+			before := stub.requests()
+
+			// twice, as conditional requests passed through aren't cached
+			for i := 0; i < 2; i++ {
+				sent = ""
+				r := httptest.NewRequest("GET", "/capi/search?q=brexit", nil)
+				if tt.ifNoneMatch != "" {
+					r.Header.Set("If-None-Match", tt.ifNoneMatch)
+				}
+				w := httptest.NewRecorder()
+				h(w, r)
+
+				if w.Code != tt.status {
+					t.Fatalf("got %d, want %d: %s", w.Code, tt.status, w.Body)
+				}
+				if tt.status == http.StatusNotModified && w.Body.Len() != 0 {
+					t.Errorf("a 304 has a body, %s", w.Body)
+				}
+				if tt.passthrough && tt.ifNoneMatch != "" && w.Header().Get("ETag") != `"v1"` {
+					t.Errorf("ETag is %q, want CAPI's", w.Header().Get("ETag"))
+				}
+				if i == 0 && sent != tt.sent {
+					t.Errorf("CAPI was sent If-None-Match %q, want %q", sent, tt.sent)
+				}
+			}
+
+			if calls := stub.requests() - before; calls != tt.requests {
+				t.Errorf("CAPI got %d requests, want %d", calls, tt.requests)
+			}
+		})
+	}
+}
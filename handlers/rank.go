@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/guardian/onward/capi"
+)
+
+// rankByDecay reorders items by popularity weighted with an exponential decay
+// on age, so fresher popular articles rank higher. Popularity comes from
+// CAPI's order: the first of n items scores n and the last 1. Undated items
+// get no weight and sink to the bottom. The input slice isn't modified.
+func rankByDecay(items []capi.Item, halfLife time.Duration, now time.Time) []capi.Item {
+	type scored struct {
+		item  capi.Item
+		score float64
+	}
+
+	ranked := make([]scored, len(items))
+	for i, item := range items {
+		score := 0.0
+		if !item.WebPublicationDate.IsZero() && halfLife > 0 {
+			age := now.Sub(item.WebPublicationDate)
+			if age < 0 {
+				age = 0
+			}
+
+			score = float64(len(items)-i) * math.Pow(0.5, float64(age)/float64(halfLife))
+		}
+
+		ranked[i] = scored{item: item, score: score}
+	}
+
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i].score > ranked[j].score
+	})
+
+	out := make([]capi.Item, len(ranked))
+	for i, r := range ranked {
+		out[i] = r.item
+	}
+
+	return out
+}
+
+// withRanks sets each trail's Rank to its 1-based place in the list
+func (il ItemList) withRanks() ItemList {
+	trails := make([]Item, len(il.Trails))
+	for i, item := range il.Trails {
+		item.Rank = i + 1
+		trails[i] = item
+	}
+
+	il.Trails = trails
+	return il
+}
+
+// applySort reorders the list by the request's ?sort=, which can be "byline"
+// or "-byline" for descending. Without it CAPI's popularity order is kept.
+func applySort(il ItemList, r *http.Request) (ItemList, error) {
+	switch r.URL.Query().Get("sort") {
+	case "":
+		return il, nil
+	case "byline":
+		il.Trails = sortByByline(il.Trails, false)
+	case "-byline":
+		il.Trails = sortByByline(il.Trails, true)
+	default:
+		return il, &HTTPError{Status: http.StatusBadRequest, Message: "Invalid sort"}
+	}
+
+	return il, nil
+}
+
+// sortByByline orders trails alphabetically by byline, ignoring case, with
+// trails that have no byline last whichever the direction. Ties keep their
+// popularity order. The input slice isn't modified.
+func sortByByline(trails []Item, descending bool) []Item {
+	sorted := make([]Item, len(trails))
+	copy(sorted, trails)
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := strings.ToLower(sorted[i].Byline), strings.ToLower(sorted[j].Byline)
+		switch {
+		case a == "" || b == "":
+			return a != "" && b == ""
+		case descending:
+			return a > b
+		}
+		return a < b
+	})
+
+	return sorted
+}
@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/guardian/onward/capi"
+)
+
+func TestRankByDecay(t *testing.T) {
+	now := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	dated := func(id string, age time.Duration) capi.Item {
+		return capi.Item{ID: id, WebPublicationDate: now.Add(-age)}
+	}
+
+	tests := []struct {
+		name     string
+		items    []capi.Item
+		halfLife time.Duration
+		want     []string
+	}{
+		{"fresher first", []capi.Item{dated("a", 24*time.Hour), dated("b", time.Hour), dated("c", 0)}, 6 * time.Hour, []string{"b", "c", "a"}},
+		{"same age keeps CAPI's order", []capi.Item{dated("a", time.Hour), dated("b", time.Hour), dated("c", time.Hour)}, 6 * time.Hour, []string{"a", "b", "c"}},
+		{"long half-life keeps CAPI's order", []capi.Item{dated("a", 24*time.Hour), dated("b", time.Hour), dated("c", 0)}, 1000 * time.Hour, []string{"a", "b", "c"}},
+		{"undated sink", []capi.Item{{ID: "a"}, dated("b", 48*time.Hour), {ID: "c"}}, 6 * time.Hour, []string{"b", "a", "c"}},
+		{"published in the future", []capi.Item{dated("a", time.Hour), dated("b", -time.Hour)}, 30 * time.Minute, []string{"b", "a"}},
+		{"empty", nil, 6 * time.Hour, []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := itemIDs(tt.items)
+			if got := itemIDs(rankByDecay(tt.items, tt.halfLife, now)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(itemIDs(tt.items), before) {
+				t.Error("the items were reordered in place")
+			}
+		})
+	}
+}
+
+func TestRankParam(t *testing.T) {
+	published := func(age time.Duration) string {
+		return time.Now().Add(-age).UTC().Format(time.RFC3339)
+	}
+	body := `{"response":{"status":"ok","mostViewed":[` +
+		`{"id":"old","type":"article","webTitle":"Old","webUrl":"https://www.theguardian.com/old","webPublicationDate":"` + published(72*time.Hour) + `"},` +
+		`{"id":"new","type":"article","webTitle":"New","webUrl":"https://www.theguardian.com/new","webPublicationDate":"` + published(time.Hour) + `"}]}}`
+	stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	})
+	cfg := testConfig(t, stub.URL)
+	cfg.DecayHalfLife = 6 * time.Hour
+	h := mostViewedHandler(testCAPI(cfg), testCache(t, cfg), cfg)
+
+	tests := []struct {
+		target string
+		want   []string
+	}{
+		{"/most-viewed/uk", []string{"old", "new"}},
+		{"/most-viewed/uk?rank=decay", []string{"new", "old"}},
+		{"/most-viewed/uk?rank=capi", []string{"old", "new"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			w := serve(h, tt.target)
+			if w.Code != http.StatusOK {
+				t.Fatalf("got %d, want 200: %s", w.Code, w.Body)
+			}
+			if got := decodeTrails(t, w).urls(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSortByByline(t *testing.T) {
+	bylined := func(url, byline string) Item {
+		return Item{URL: url, Byline: byline}
+	}
+	urls := func(trails []Item) []string {
+		urls := []string{}
+		for _, trail := range trails {
+			urls = append(urls, trail.URL)
+		}
+		return urls
+	}
+	trails := []Item{
+		bylined("a", "Zoe Writer"),
+		bylined("b", ""),
+		bylined("c", "amy writer"),
+		bylined("d", "Ben Writer"),
+		bylined("e", "Amy Writer"),
+		bylined("f", ""),
+	}
+
+	tests := []struct {
+		name       string
+		descending bool
+		want       []string
+	}{
+		// ignoring case, so ties keep their order, and no byline is last
+		{"ascending", false, []string{"c", "e", "d", "a", "b", "f"}},
+		{"descending", true, []string{"a", "d", "c", "e", "b", "f"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := urls(trails)
+			if got := urls(sortByByline(trails, tt.descending)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(urls(trails), before) {
+				t.Error("the trails were reordered in place")
+			}
+		})
+	}
+}
+
+func TestSortParam(t *testing.T) {
+	stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"response":{"status":"ok","mostViewed":[
+			{"id":"a","type":"article","fields":{"byline":"Cai Writer"}},
+			{"id":"b","type":"article","fields":{"byline":"Amy Writer"}},
+			{"id":"c","type":"article","fields":{"byline":"Ben Writer"}}
+		]}}`))
+	})
+	cfg := testConfig(t, stub.URL)
+	h := mostViewedHandler(testCAPI(cfg), testCache(t, cfg), cfg)
+
+	tests := []struct {
+		target string
+		status int
+		want   []string
+	}{
+		{"/most-viewed/uk", http.StatusOK, []string{"a", "b", "c"}},
+		{"/most-viewed/uk?sort=byline", http.StatusOK, []string{"b", "c", "a"}},
+		{"/most-viewed/uk?sort=-byline", http.StatusOK, []string{"a", "c", "b"}},
+		// sorted before the list is paged
+		{"/most-viewed/uk?sort=byline&limit=2", http.StatusOK, []string{"b", "c"}},
+		{"/most-viewed/uk?sort=title", http.StatusBadRequest, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			w := serve(h, tt.target)
+			if w.Code != tt.status {
+				t.Fatalf("got %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			if got := decodeTrails(t, w).urls(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithRank(t *testing.T) {
+	stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"response":{"status":"ok","mostViewed":[
+			{"id":"a","type":"article","fields":{"byline":"Dan Writer"}},
+			{"id":"b","type":"article","fields":{"byline":"Cai Writer","thumbnail":"https://i.guim.co.uk/b.jpg"}},
+			{"id":"c","type":"article","fields":{"byline":"Ben Writer","thumbnail":"https://i.guim.co.uk/c.jpg"}},
+			{"id":"d","type":"article","fields":{"byline":"Amy Writer","thumbnail":"https://i.guim.co.uk/d.jpg"}}
+		]}}`))
+	})
+	cfg := testConfig(t, stub.URL)
+	h := mostViewedHandler(testCAPI(cfg), testCache(t, cfg), cfg)
+
+	// ranks are places in the filtered list, before it's sorted or paged
+	tests := []struct {
+		target string
+		want   map[string]int
+	}{
+		{"/most-viewed/uk", map[string]int{"a": 0, "b": 0, "c": 0, "d": 0}},
+		{"/most-viewed/uk?with-rank=true", map[string]int{"a": 1, "b": 2, "c": 3, "d": 4}},
+		{"/most-viewed/uk?with-rank=true&has-image=true", map[string]int{"b": 1, "c": 2, "d": 3}},
+		{"/most-viewed/uk?with-rank=true&sort=byline", map[string]int{"d": 4, "c": 3, "b": 2, "a": 1}},
+		{"/most-viewed/uk?with-rank=true&limit=2&page=2", map[string]int{"c": 3, "d": 4}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			w := serve(h, tt.target)
+			if w.Code != http.StatusOK {
+				t.Fatalf("got %d, want 200: %s", w.Code, w.Body)
+			}
+
+			var il struct {
+				Trails []map[string]interface{} `json:"trails"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &il); err != nil {
+				t.Fatal(err)
+			}
+
+			got := map[string]int{}
+			for _, trail := range il.Trails {
+				rank, _ := trail["rank"].(float64)
+				got[trail["url"].(string)] = int(rank)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got ranks %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,211 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/guardian/onward/cache"
+	"github.com/guardian/onward/config"
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+)
+
+// rateLimitExempt are the routes clients aren't limited on: operational and
+// admin ones, which are either cheap or already protected
+var rateLimitExempt = []string{"/admin/", "/debug/", "/healthz", "/healthcheck", "/readyz", "/ready", "/version", "/metrics"}
+
+// rateLimiter keeps a token bucket per client. Buckets hold up to burst
+// tokens, refilled at perMinute a minute, and each request takes one.
+type rateLimiter interface {
+	// take takes a token from key's bucket, reporting whether there was one
+	// and, if not, how long until there will be
+	take(ctx context.Context, key string, perMinute, burst int) (bool, time.Duration, error)
+
+	// close stops the limiter, releasing what it holds
+	close()
+}
+
+// clientLimiter is the rate limiter clients' requests take tokens from, nil when
+// they aren't limited
+var clientLimiter rateLimiter
+
+var (
+	_ rateLimiter = &memoryLimiter{}
+	_ rateLimiter = redisLimiter{}
+)
+
+// newRateLimiter builds the limiter backend named by cfg.RateLimitBackend
+func newRateLimiter(cfg config.Config) (rateLimiter, error) {
+	switch cfg.RateLimitBackend {
+	case "memory":
+		return &memoryLimiter{buckets: cache.NewMemory(cfg.CacheCleanupInterval, nil)}, nil
+	case "redis":
+		opts, err := redis.ParseURL(cfg.RedisURL)
+		if err != nil {
+			return nil, errors.Wrap(err, "Invalid Redis URL")
+		}
+
+		return redisLimiter{client: redis.NewClient(opts)}, nil
+	default:
+		return nil, fmt.Errorf("unknown rate limit backend %q", cfg.RateLimitBackend)
+	}
+}
+
+// rateLimit limits each client to cfg.RateLimit requests a minute, or its
+// entry in cfg.RateLimitClients, answering the rest with a 429. Clients on
+// cfg.RateLimitClients are told apart by cfg.RateLimitHeader, and everyone
+// else by IP. If the limiter fails, requests are let through rather than
+// refused.
+func rateLimit(cfg config.Config, limiter rateLimiter, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, prefix := range rateLimitExempt {
+			if strings.HasPrefix(r.URL.Path, prefix) {
+				h.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		key, perMinute := rateLimitClient(r, cfg)
+
+		ok, retryAfter, err := limiter.take(r.Context(), key, perMinute, cfg.RateLimitBurst)
+		if err != nil {
+			log.Printf("Rate limiter failed, letting %s through: %s", key, err)
+			ok = true
+		}
+
+		if !ok {
+			rateLimitedTotal.Inc()
+			writeError(w, &HTTPError{Status: http.StatusTooManyRequests, Message: "Too many requests", RetryAfter: retryAfter})
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// rateLimitClient is the bucket key for a request and its limit per minute.
+// Only keys on cfg.RateLimitClients get their own bucket, so a client can't
+// escape its IP's by sending a new key with each request.
+func rateLimitClient(r *http.Request, cfg config.Config) (string, int) {
+	if cfg.RateLimitHeader != "" {
+		client := r.Header.Get(cfg.RateLimitHeader)
+		if perMinute, ok := cfg.RateLimitClients[client]; ok && client != "" {
+			return "key:" + client, perMinute
+		}
+	}
+
+	return "ip:" + clientIP(r, cfg.TrustedProxies), cfg.RateLimit
+}
+
+// bucket is a client's token bucket at a moment
+type bucket struct {
+	tokens float64
+	at     time.Time
+}
+
+// refilled is the bucket topped up for the time since it was last seen,
+// never beyond burst
+func (b bucket) refilled(now time.Time, perMinute, burst int) bucket {
+	b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.at).Minutes()*float64(perMinute))
+	b.at = now
+	return b
+}
+
+// memoryLimiter keeps buckets in process, so each instance limits clients
+// separately. A bucket expires once it would have refilled, at most a refill
+// window after it was last taken from, as a missing one is treated as full.
+type memoryLimiter struct {
+	mu      sync.Mutex
+	buckets cache.Cache
+}
+
+func (m *memoryLimiter) take(ctx context.Context, key string, perMinute, burst int) (bool, time.Duration, error) {
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b := bucket{tokens: float64(burst), at: now}
+	if cached, found := m.buckets.Get(key); found {
+		b = cached.(bucket).refilled(now, perMinute, burst)
+	}
+
+	ok := b.tokens >= 1
+	if ok {
+		b.tokens--
+	}
+
+	m.buckets.Set(key, b, now.Add(refillTime(float64(burst)-b.tokens, perMinute)))
+
+	if ok {
+		return true, 0, nil
+	}
+	return false, refillTime(1-b.tokens, perMinute), nil
+}
+
+func (m *memoryLimiter) close() {
+	m.buckets.Close()
+}
+
+// refillTime is how long a bucket takes to gain tokens at perMinute
+func refillTime(tokens float64, perMinute int) time.Duration {
+	return time.Duration(tokens / float64(perMinute) * float64(time.Minute))
+}
+
+// redisTakeScript is memoryLimiter.take done atomically in Redis, so the
+// instances sharing it share each client's bucket. KEYS[1] is the bucket,
+// ARGV the time in milliseconds, the rate per minute and the burst. It
+// returns 1 or 0 for whether there was a token, and the milliseconds until
+// there is one.
+var redisTakeScript = redis.NewScript(`
+local now, perMinute, burst = tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[3])
+local perMs = perMinute / 60000
+
+local state = redis.call("HMGET", KEYS[1], "tokens", "at")
+local tokens, at = tonumber(state[1]), tonumber(state[2])
+if tokens == nil then
+  tokens, at = burst, now
+end
+tokens = math.min(burst, tokens + math.max(0, now - at) * perMs)
+
+local ok, wait = 0, math.ceil((1 - tokens) / perMs)
+if tokens >= 1 then
+  ok, wait, tokens = 1, 0, tokens - 1
+end
+
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "at", now)
+redis.call("PEXPIRE", KEYS[1], math.ceil((burst - tokens) / perMs) + 1000)
+return {ok, wait}
+`)
+
+// redisLimiterPrefix keeps buckets apart from cached entries, so listing or
+// flushing the cache leaves them alone
+const redisLimiterPrefix = "onward-ratelimit:"
+
+// redisLimiter keeps buckets in Redis, shared between instances
+type redisLimiter struct {
+	client *redis.Client
+}
+
+func (rl redisLimiter) take(ctx context.Context, key string, perMinute, burst int) (bool, time.Duration, error) {
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+
+	result, err := redisTakeScript.Run(ctx, rl.client, []string{redisLimiterPrefix + key}, now, perMinute, burst).Int64Slice()
+	if err != nil {
+		return false, 0, errors.Wrap(err, "Redis rate limit script failed")
+	}
+
+	return result[0] == 1, time.Duration(result[1]) * time.Millisecond, nil
+}
+
+func (rl redisLimiter) close() {
+	if err := rl.client.Close(); err != nil {
+		log.Printf("Unable to close the rate limiter's Redis client, %s", err)
+	}
+}
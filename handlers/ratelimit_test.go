@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestRateLimit(t *testing.T) {
+	tests := []struct {
+		name string
+		// key is the X-Client-Key sent with the nth request after the IP's
+		// bucket is empty
+		key  func(n int) string
+		want int
+	}{
+		{name: "no key", key: func(int) string { return "" }, want: 0},
+		{name: "an unlisted key", key: func(int) string { return "made-up" }, want: 0},
+		{name: "a new unlisted key each request", key: func(n int) string { return "spoofed-" + strconv.Itoa(n) }, want: 0},
+		{name: "a listed key", key: func(int) string { return "apps" }, want: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig(t, "http://capi.invalid")
+			cfg.RateLimit = 1
+			cfg.RateLimitBurst = 2
+			cfg.RateLimitClients = map[string]int{"apps": 1}
+
+			limiter, err := newRateLimiter(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer limiter.close()
+
+			h := rateLimit(cfg, limiter, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			get := func(key string) int {
+				r := httptest.NewRequest(http.MethodGet, "/most-viewed/uk", nil)
+				if key != "" {
+					r.Header.Set(cfg.RateLimitHeader, key)
+				}
+
+				w := httptest.NewRecorder()
+				h.ServeHTTP(w, r)
+				return w.Code
+			}
+
+			for n := 0; n < cfg.RateLimitBurst; n++ {
+				if code := get(""); code != http.StatusOK {
+					t.Fatalf("request %d got %d, want %d", n, code, http.StatusOK)
+				}
+			}
+
+			allowed := 0
+			for n := 0; n < 2; n++ {
+				if get(tt.key(n)) == http.StatusOK {
+					allowed++
+				}
+			}
+			if allowed != tt.want {
+				t.Errorf("%d of 2 requests allowed once the IP's limit was reached, want %d", allowed, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,37 @@
+//go:build debug
+// +build debug
+
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/guardian/onward/capi"
+	"github.com/guardian/onward/config"
+)
+
+// rawHandler serves CAPI's response for a most-viewed query exactly as CAPI
+// sent it, bypassing the cache, for comparing with the mapped ItemList. The
+// API key only ever goes in the outbound URL, never the body.
+func rawHandler(api capi.Client, cfg config.Config) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := query{
+			Path:       normalizePath(strings.TrimPrefix(r.URL.Path, "/raw/")),
+			MostViewed: r.URL.Query().Get("most-viewed") != "false",
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.RequestBudget)
+		defer cancel()
+
+		body, err := capi.Fetch(ctx, api, q.Path, q.params())
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	}
+}
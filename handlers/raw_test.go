@@ -0,0 +1,45 @@
+//go:build debug
+
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRawHandler(t *testing.T) {
+	// unmapped fields, odd spacing and all
+	const body = `{"response": {"status":"ok","userTier":"internal","mostViewed":[{"id":"a","type":"article","webTitle":"A","apiUrl":"https://content.guardianapis.com/a"}]}}`
+	var key string
+	stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		key = r.URL.Query().Get("api-key")
+		w.Write([]byte(body))
+	})
+	cfg := testConfig(t, stub.URL)
+	cfg.APIKey = "s3cret-key"
+	h := rawHandler(testCAPI(cfg), cfg)
+
+	for i := 0; i < 2; i++ {
+		w := serve(h, "/raw/uk")
+		if w.Code != http.StatusOK {
+			t.Fatalf("got %d, want 200: %s", w.Code, w.Body)
+		}
+		if got := w.Body.String(); got != body {
+			t.Errorf("got %s, want CAPI's body unchanged: %s", got, body)
+		}
+		for name, values := range w.Header() {
+			if strings.Contains(strings.Join(values, ","), cfg.APIKey) {
+				t.Errorf("the %s header shows the API key", name)
+			}
+		}
+	}
+
+	if key != cfg.APIKey {
+		t.Errorf("CAPI was sent api-key %q, want the configured key", key)
+	}
+	// it's a look at CAPI as it is now, not the cache
+	if calls := stub.requests(); calls != 2 {
+		t.Errorf("CAPI got %d requests, want 2", calls)
+	}
+}
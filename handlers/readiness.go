@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/guardian/onward/capi"
+	"github.com/guardian/onward/config"
+	"github.com/pkg/errors"
+)
+
+// healthzHandler is the liveness probe. It only says the process is serving,
+// and never touches CAPI; see readyzHandler for that.
+func healthzHandler(cfg config.Config) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(renderOptionsFor(r, cfg).json(map[string]string{"status": "ok"}))
+	}
+}
+
+// componentStatus is the result of one of the readiness checks
+type componentStatus struct {
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+// readiness is the body of a readiness response, whether ready or not
+type readiness struct {
+	Status     string                     `json:"status"`
+	Components map[string]componentStatus `json:"components"`
+}
+
+// readyzHandler reports whether the service can serve, for load balancer and
+// orchestrator readiness probes, with the status of each component checked:
+//
+//   - warmer: with the warmer on, it isn't ready until the warmer has first
+//     filled the default edition, so traffic doesn't arrive to a cold cache
+//   - cache: the cache backend answers a ping
+//   - capi: the recent CAPI success rate is at least cfg.HealthThreshold, so
+//     an instance drops out as errors climb rather than only once CAPI is
+//     down entirely, and a CAPI request made now succeeds. With
+//     cfg.ReadyMaxCAPIAge set, a CAPI call having succeeded within it will do
+//     instead, sparing CAPI a request per probe.
+//
+// The checks get their own short timeout, cfg.ReadinessTimeout, rather than
+// the request budget, so a struggling dependency fails the probe quickly
+// instead of leaving it hanging.
+func readyzHandler(api capi.Client, c Cache, cfg config.Config, gate *warmGate) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.ReadinessTimeout)
+		defer cancel()
+
+		// a probe shouldn't sit out a rate limit or retries
+		ctx = capi.WithoutRetries(ctx)
+
+		body := readiness{Status: "ok", Components: map[string]componentStatus{}}
+		report := func(component string, err error, message string) {
+			if err == nil {
+				body.Components[component] = componentStatus{Status: "ok"}
+				return
+			}
+
+			log.Printf("Not ready, %s: %s", component, err)
+			body.Status = "unavailable"
+			body.Components[component] = componentStatus{Status: "unavailable", Message: message}
+		}
+
+		if !gate.isOpen() {
+			report("warmer", errors.New("not warmed yet"), "Cache not warmed yet")
+		} else {
+			report("warmer", nil, "")
+		}
+
+		report("cache", c.Ping(ctx), "Cache unreachable")
+
+		health := api.Health()
+		switch {
+		case health.SuccessRate < cfg.HealthThreshold:
+			message := fmt.Sprintf("CAPI success rate %.2f is below %.2f", health.SuccessRate, cfg.HealthThreshold)
+			report("capi", errors.New(message), message)
+		case cfg.ReadyMaxCAPIAge > 0 && time.Since(health.LastSucceeded) <= cfg.ReadyMaxCAPIAge:
+			report("capi", nil, "")
+		default:
+			_, err := capi.Fetch(ctx, api, config.Editions[0], url.Values{"page-size": {"1"}})
+			report("capi", err, "CAPI unavailable")
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if body.Status != "ok" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		w.Write(renderOptionsFor(r, cfg).json(body))
+	}
+}
+
+// checkUpstream makes one authenticated request to CAPI, so a bad key or
+// unreachable CAPI shows up at startup rather than on the first request
+func checkUpstream(api capi.Client, cfg config.Config) error {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.RequestBudget)
+	defer cancel()
+
+	_, err := capi.Fetch(capi.WithoutRetries(ctx), api, config.Editions[0], url.Values{"page-size": {"1"}})
+	return err
+}
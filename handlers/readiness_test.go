@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/guardian/onward/capi"
+)
+
+// decodeReadiness is a readiness response's body
+func decodeReadiness(t *testing.T, body []byte) readiness {
+	t.Helper()
+
+	var got readiness
+	if err := json.Unmarshal(body, &got); err != nil {
+		t.Fatalf("%q isn't a readiness report, %s", body, err)
+	}
+	return got
+}
+
+func TestReadinessTimeout(t *testing.T) {
+	tests := []struct {
+		name   string
+		delay  time.Duration
+		status int
+		capi   string
+	}{
+		{"fast upstream", 0, http.StatusOK, "ok"},
+		{"slow upstream", 5 * time.Second, http.StatusServiceUnavailable, "unavailable"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+				select {
+				case <-time.After(tt.delay):
+					w.Write([]byte(mostViewedBody("a")))
+				case <-r.Context().Done():
+				}
+			})
+			cfg := testConfig(t, stub.URL)
+			cfg.ReadinessTimeout = 100 * time.Millisecond
+			cfg.RequestBudget = 10 * time.Second
+
+			start := time.Now()
+			w := serve(readyzHandler(testCAPI(cfg), testCache(t, cfg), cfg, nil), "/readyz")
+			elapsed := time.Since(start)
+
+			if w.Code != tt.status {
+				t.Fatalf("got %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if got := decodeReadiness(t, w.Body.Bytes()).Components["capi"].Status; got != tt.capi {
+				t.Errorf("capi is %q, want %q", got, tt.capi)
+			}
+			if elapsed > cfg.ReadinessTimeout+400*time.Millisecond {
+				t.Errorf("took %s, past the %s readiness timeout", elapsed, cfg.ReadinessTimeout)
+			}
+		})
+	}
+}
+
+func TestReadinessFollowsTheSuccessRate(t *testing.T) {
+	failing := int64(3)
+	stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt64(&failing, -1) >= 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(mostViewedBody("a")))
+	})
+	cfg := testConfig(t, stub.URL)
+	cfg.CAPIRetries = 0
+	cfg.BreakerThreshold = 0
+	cfg.HealthWindow = 4
+	cfg.HealthThreshold = 0.5
+	api := testCAPI(cfg)
+	c := testCache(t, cfg)
+	h := readyzHandler(api, c, cfg, nil)
+
+	if w := serve(h, "/readyz"); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("with CAPI failing got %d, want 503: %s", w.Code, w.Body)
+	}
+	for i := 0; i < 2; i++ {
+		capi.Fetch(context.Background(), api, "uk", nil)
+	}
+
+	// every call so far failed, the probe's own included, so it's not
+	// ready whether or not CAPI's answering now
+	w := serve(h, "/readyz")
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("with a success rate of 0 got %d, want 503: %s", w.Code, w.Body)
+	}
+	if got := decodeReadiness(t, w.Body.Bytes()).Components["capi"].Message; !strings.Contains(got, "success rate 0.00") {
+		t.Errorf("capi's message is %q, want the success rate", got)
+	}
+
+	for i := 0; i < 2; i++ {
+		capi.Fetch(context.Background(), api, "uk", nil)
+	}
+	if w := serve(h, "/readyz"); w.Code != http.StatusOK {
+		t.Errorf("with a success rate of 0.5 got %d, want 200: %s", w.Code, w.Body)
+	}
+}
+
+func TestReadinessWaitsForTheWarmer(t *testing.T) {
+	auDown := int32(1)
+	stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/au" && atomic.LoadInt32(&auDown) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(mostViewedBody("a")))
+	})
+	cfg := testConfig(t, stub.URL)
+	cfg.CAPIRetries = 0
+	cfg.BreakerThreshold = 0
+	cfg.HealthThreshold = 0
+	api := testCAPI(cfg)
+	c := testCache(t, cfg)
+	gate := &warmGate{}
+	h := readyzHandler(api, c, cfg, gate)
+
+	ready := func(when string, want bool) {
+		t.Helper()
+
+		w := serve(h, "/readyz")
+		warmer := decodeReadiness(t, w.Body.Bytes()).Components["warmer"].Status
+		if got := w.Code == http.StatusOK && warmer == "ok"; got != want {
+			t.Errorf("%s: got %d, the warmer %q, want ready to be %v", when, w.Code, warmer, want)
+		}
+	}
+
+	ready("before the first warm", false)
+
+	// not every edition was filled, so requests for au would still miss
+	warmOnce(context.Background(), api, c, cfg, &inFlight{}, gate)
+	ready("after a partial warm", false)
+
+	atomic.StoreInt32(&auDown, 0)
+	warmOnce(context.Background(), api, c, cfg, &inFlight{}, gate)
+	ready("after a full warm", true)
+
+	// it stays open through later failures
+	atomic.StoreInt32(&auDown, 1)
+	warmOnce(context.Background(), api, c, cfg, &inFlight{}, gate)
+	ready("after a later failed warm", true)
+
+	if w := serve(readyzHandler(api, c, cfg, nil), "/readyz"); w.Code != http.StatusOK {
+		t.Errorf("without a warmer got %d, want 200: %s", w.Code, w.Body)
+	}
+}
+
+func TestStartupCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		require bool
+		fails   bool
+		logged  string
+	}{
+		{"rejected, not required", false, false, "CAPI startup check failed, serving anyway"},
+		{"rejected, required", true, true, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Query().Get("api-key") == "" {
+					t.Errorf("the startup check sent no API key: %s", r.URL)
+				}
+				w.WriteHeader(http.StatusUnauthorized)
+			})
+
+			savedChanges := listChanges
+			listChanges = newChangeFeed()
+			defer func() { listChanges = savedChanges }()
+
+			savedWorkers := workers
+			workers = newBackground()
+			defer func() { workers = savedWorkers }()
+
+			savedCompression := compression
+			defer func() { compression = savedCompression }()
+
+			cfg := testConfig(t, stub.URL)
+			cfg.StartupCheck = true
+			cfg.RequireUpstream = tt.require
+			cfg.ShutdownTimeout = time.Second
+			logs := captureLog(t)
+
+			s, err := New(cfg, testCAPI(cfg))
+			if tt.fails {
+				if err == nil || !strings.Contains(err.Error(), "CAPI startup check failed") {
+					t.Errorf("got %v, want the startup check to fail", err)
+				}
+				workers.stop(cfg.ShutdownTimeout)
+			} else {
+				if err != nil {
+					t.Fatal(err)
+				}
+				s.Shutdown()
+				if !strings.Contains(logs.String(), tt.logged) {
+					t.Errorf("the log doesn't say %q: %s", tt.logged, logs)
+				}
+			}
+
+			if got := stub.requests(); got != 1 {
+				t.Errorf("CAPI got %d startup requests, want 1", got)
+			}
+		})
+	}
+}
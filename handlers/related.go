@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/guardian/onward/capi"
+	"github.com/guardian/onward/config"
+)
+
+// relatedHandler serves the content CAPI lists as related to an item, e.g.
+// /related/world/2024/jan/01/some-article, as the same trails as
+// most-viewed. Lists are cached per content path.
+func relatedHandler(api capi.Client, c Cache, cfg config.Config) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		path, f := splitFormat(normalizePath(strings.TrimPrefix(r.URL.Path, "/related/")))
+		if path == "" {
+			writeError(w, &HTTPError{Status: http.StatusNotFound, Message: "No content path"})
+			return
+		}
+
+		var err error
+		if f == "" {
+			if f, err = requestedFormat(r); err != nil {
+				writeError(w, err)
+				return
+			}
+		}
+
+		limit, clamped, err := requestedLimit(r, cfg.DefaultLimit, cfg.MaxLimit)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		seen, err := requestedSeen(r)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		page, err := requestedPage(r)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.RequestBudget)
+		defer cancel()
+		ctx = withCacheDirective(ctx, requestCacheDirective(r))
+		ctx, fresh := withFreshness(ctx)
+
+		items, err := cachedGet(ctx, api, query{Path: path, Related: true}, c, cfg)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		if cfg.EmptyNoContent && len(items.Response.Results) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		il := applyFilters(asItemList(items, path, cfg.RelatedHeading), r).dedupe(seen)
+
+		if il, err = applySort(il, r); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		il, pages := il.page(limit, page)
+		if page > pages {
+			writeError(w, &HTTPError{Status: http.StatusNotFound, Message: "No such page"})
+			return
+		}
+
+		setCacheControl(w, cfg, fresh)
+		opts := renderOptionsFor(r, cfg)
+
+		if limit > 0 {
+			w.Header().Set("Link", paginationLinks(r, page, pages))
+		}
+
+		if clamped {
+			w.Header().Set("X-Effective-Limit", strconv.Itoa(limit))
+		}
+
+		if f == formatNDJSON {
+			writeNDJSON(w, r, il, opts)
+			return
+		}
+
+		writeBody(w, r, contentTypes[f], renderItemList(il, f, opts))
+	}
+}
@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"github.com/guardian/onward/config"
+)
+
+// renderOptions control how JSON responses are serialised
+type renderOptions struct {
+	// SnakeCase emits snake_case field names instead of camelCase
+	SnakeCase bool
+
+	// TrailingNewline ends JSON documents with a newline, for piping to jq
+	// and the like
+	TrailingNewline bool
+
+	// Pretty indents JSON documents by two spaces, for reading in a browser or
+	// terminal
+	Pretty bool
+
+	// Wrapper, if set, nests lists under that top-level key, e.g.
+	// {"mostViewed": {...}}
+	Wrapper string
+
+	// LegacyLiveblog sends v1 isLiveBlog as a "true"/"false" string
+	LegacyLiveblog bool
+}
+
+func renderOptionsFor(r *http.Request, cfg config.Config) renderOptions {
+	return renderOptions{
+		SnakeCase:       cfg.JSONStyle == "snake",
+		TrailingNewline: cfg.TrailingNewline || r.URL.Query().Get("newline") == "true",
+		Pretty:          r.URL.Query().Get("pretty") == "true",
+		Wrapper:         cfg.JSONWrapper,
+		LegacyLiveblog:  cfg.LiveblogStyle == "string",
+	}
+}
+
+// json marshals v as a complete JSON response body
+func (opts renderOptions) json(v interface{}) []byte {
+	return opts.document(opts.marshal(v))
+}
+
+// listJSON is json for a list response, which goes under the wrapper key if
+// there is one. The key is used exactly as configured, whatever the field
+// naming style.
+func (opts renderOptions) listJSON(v interface{}) []byte {
+	body := opts.marshal(v)
+
+	if opts.Wrapper != "" {
+		body = asJSON(map[string]json.RawMessage{opts.Wrapper: body})
+	}
+
+	return opts.document(body)
+}
+
+// document finishes off a marshalled body as a response
+func (opts renderOptions) document(body []byte) []byte {
+	if opts.Pretty {
+		var indented bytes.Buffer
+		if err := json.Indent(&indented, body, "", "  "); err != nil {
+			log.Fatalf("Unable to indent JSON (should never happen), %s", err)
+		}
+		body = indented.Bytes()
+	}
+
+	if opts.TrailingNewline {
+		body = append(body, '\n')
+	}
+
+	return body
+}
+
+// marshal marshals v in the configured field naming style
+func (opts renderOptions) marshal(v interface{}) []byte {
+	if opts.LegacyLiveblog {
+		v = withLegacyLiveblog(v)
+	}
+
+	body := asJSON(v)
+
+	if opts.SnakeCase {
+		var err error
+		if body, err = snakeCaseKeys(body); err != nil {
+			log.Fatalf("Unable to rewrite JSON keys (should never happen), %s", err)
+		}
+	}
+
+	return body
+}
+
+// snakeCaseKeys rewrites every object key in a JSON document to snake_case,
+// leaving values and the order of fields untouched
+func snakeCaseKeys(in []byte) ([]byte, error) {
+	type container struct {
+		object    bool
+		count     int
+		expectKey bool
+	}
+
+	var out bytes.Buffer
+	var stack []*container
+
+	dec := json.NewDecoder(bytes.NewReader(in))
+	dec.UseNumber()
+
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return out.Bytes(), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if delim, ok := tok.(json.Delim); ok && (delim == '}' || delim == ']') {
+			stack = stack[:len(stack)-1]
+			out.WriteRune(rune(delim))
+			continue
+		}
+
+		if len(stack) > 0 {
+			top := stack[len(stack)-1]
+
+			if top.object && top.expectKey {
+				if top.count > 0 {
+					out.WriteByte(',')
+				}
+				top.count++
+				top.expectKey = false
+
+				key, _ := json.Marshal(snakeCase(tok.(string)))
+				out.Write(key)
+				out.WriteByte(':')
+				continue
+			}
+
+			if top.object {
+				top.expectKey = true
+			} else {
+				if top.count > 0 {
+					out.WriteByte(',')
+				}
+				top.count++
+			}
+		}
+
+		switch v := tok.(type) {
+		case json.Delim:
+			out.WriteRune(rune(v))
+			stack = append(stack, &container{object: v == '{', expectKey: v == '{'})
+		case json.Number:
+			out.WriteString(v.String())
+		default:
+			value, err := json.Marshal(v)
+			if err != nil {
+				return nil, err
+			}
+			out.Write(value)
+		}
+	}
+}
+
+// snakeCase converts a camelCase name, so "isLiveBlog" is "is_live_blog" and
+// "webURL" is "web_url"
+func snakeCase(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+
+	for i, r := range runes {
+		if unicode.IsUpper(r) && i > 0 {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				b.WriteByte('_')
+			}
+		}
+
+		b.WriteRune(unicode.ToLower(r))
+	}
+
+	return b.String()
+}
@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestSnakeCase(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"url", "url"},
+		{"linkText", "link_text"},
+		{"isLiveBlog", "is_live_blog"},
+		{"webURL", "web_url"},
+		{"URLPath", "url_path"},
+		{"item2Count", "item2_count"},
+		{"already_snake", "already_snake"},
+	}
+
+	for _, tt := range tests {
+		if got := snakeCase(tt.name); got != tt.want {
+			t.Errorf("snakeCase(%q) is %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestJSONStyles(t *testing.T) {
+	stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"response":{"status":"ok","mostViewed":[{"id":"a","type":"article","webTitle":"About \"linkText\"","fields":{"byline":"A Writer"}}]}}`))
+	})
+
+	tests := []struct {
+		style string
+		keys  []string
+	}{
+		{"camel", []string{"byline", "image", "isLiveBlog", "linkText", "showByline", "url"}},
+		{"snake", []string{"byline", "image", "is_live_blog", "link_text", "show_byline", "url"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.style, func(t *testing.T) {
+			cfg := testConfig(t, stub.URL)
+			cfg.JSONStyle = tt.style
+
+			w := serve(mostViewedHandler(testCAPI(cfg), testCache(t, cfg), cfg), "/most-viewed/uk")
+			if w.Code != http.StatusOK {
+				t.Fatalf("got %d, want 200: %s", w.Code, w.Body)
+			}
+
+			var il struct {
+				Trails []map[string]interface{} `json:"trails"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &il); err != nil || len(il.Trails) != 1 {
+				t.Fatalf("got %s, want one trail (%v)", w.Body, err)
+			}
+
+			var keys []string
+			for key := range il.Trails[0] {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			if !reflect.DeepEqual(keys, tt.keys) {
+				t.Errorf("trail fields are %v, want %v", keys, tt.keys)
+			}
+
+			// values are left alone, even ones that look like field names
+			if got := il.Trails[0][tt.keys[3]]; got != `About "linkText"` {
+				t.Errorf("link text is %q, want it unchanged", got)
+			}
+		})
+	}
+}
+
+func TestTrailingNewline(t *testing.T) {
+	stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(mostViewedBody("a")))
+	})
+
+	tests := []struct {
+		name    string
+		config  bool
+		target  string
+		newline bool
+	}{
+		{"default", false, "/most-viewed/uk", false},
+		{"parameter", false, "/most-viewed/uk?newline=true", true},
+		{"configured", true, "/most-viewed/uk", true},
+		{"pretty", false, "/most-viewed/uk?pretty=true&newline=true", true},
+		{"single item", false, "/most-viewed/uk/1?newline=true", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig(t, stub.URL)
+			cfg.TrailingNewline = tt.config
+
+			w := serve(mostViewedHandler(testCAPI(cfg), testCache(t, cfg), cfg), tt.target)
+			if w.Code != http.StatusOK {
+				t.Fatalf("got %d, want 200: %s", w.Code, w.Body)
+			}
+
+			body := w.Body.Bytes()
+			if got := bytes.HasSuffix(body, []byte("\n")); got != tt.newline {
+				t.Errorf("ends with a newline: %t, want %t", got, tt.newline)
+			}
+			if bytes.HasSuffix(body, []byte("\n\n")) {
+				t.Error("ends with more than one newline")
+			}
+			if !json.Valid(body) {
+				t.Errorf("%q isn't JSON", body)
+			}
+		})
+	}
+}
+
+func TestPrettyOutput(t *testing.T) {
+	stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(mostViewedBody("a", "b")))
+	})
+	cfg := testConfig(t, stub.URL)
+	h := mostViewedHandler(testCAPI(cfg), testCache(t, cfg), cfg)
+
+	tests := []struct {
+		compact string
+		pretty  string
+	}{
+		{"/most-viewed/uk", "/most-viewed/uk?pretty=true"},
+		{"/most-viewed/uk/1", "/most-viewed/uk/1?pretty=true"},
+		{"/most-viewed/uk?limit=1", "/most-viewed/uk?limit=1&pretty=true"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pretty, func(t *testing.T) {
+			compact := serve(h, tt.compact).Body.Bytes()
+			pretty := serve(h, tt.pretty).Body.Bytes()
+
+			if len(pretty) <= len(compact) {
+				t.Errorf("pretty output is %d bytes, want more than the compact %d", len(pretty), len(compact))
+			}
+			if bytes.Contains(compact, []byte("\n")) {
+				t.Errorf("compact output is indented: %s", compact)
+			}
+			if !bytes.Contains(pretty, []byte("\n  \"")) {
+				t.Errorf("pretty output isn't indented by two spaces: %s", pretty)
+			}
+
+			var indented bytes.Buffer
+			if err := json.Indent(&indented, compact, "", "  "); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(pretty, indented.Bytes()) {
+				t.Errorf("pretty output is\n%s\nwant the compact output indented\n%s", pretty, indented.Bytes())
+			}
+		})
+	}
+}
+
+func TestJSONWrapper(t *testing.T) {
+	stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(mostViewedBody("a", "b")))
+	})
+
+	tests := []struct {
+		name    string
+		wrapper string
+		style   string
+		keys    []string
+	}{
+		{"unwrapped", "", "camel", []string{"heading", "trails"}},
+		{"wrapped", "mostViewed", "camel", []string{"mostViewed"}},
+		{"wrapped, snake case", "mostViewed", "snake", []string{"mostViewed"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig(t, stub.URL)
+			cfg.JSONWrapper = tt.wrapper
+			cfg.JSONStyle = tt.style
+
+			w := serve(mostViewedHandler(testCAPI(cfg), testCache(t, cfg), cfg), "/most-viewed/uk")
+			if w.Code != http.StatusOK {
+				t.Fatalf("got %d, want 200: %s", w.Code, w.Body)
+			}
+
+			var top map[string]json.RawMessage
+			if err := json.Unmarshal(w.Body.Bytes(), &top); err != nil {
+				t.Fatal(err)
+			}
+			var keys []string
+			for key := range top {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			if !reflect.DeepEqual(keys, tt.keys) {
+				t.Fatalf("top-level keys are %v, want %v", keys, tt.keys)
+			}
+
+			list := w.Body.Bytes()
+			if tt.wrapper != "" {
+				list = top[tt.wrapper]
+			}
+			var il struct {
+				Trails []json.RawMessage `json:"trails"`
+			}
+			if err := json.Unmarshal(list, &il); err != nil || len(il.Trails) != 2 {
+				t.Errorf("got %s, want the list with two trails (%v)", list, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+)
+
+// router is the service's mux, with each route limited to its methods and
+// described for /openapi.json. Patterns are ServeMux's, so one ending in a
+// slash takes the whole subtree and its handler parses the rest.
+type router struct {
+	mux  *http.ServeMux
+	docs []routeDoc
+}
+
+// routeDoc describes a route in the OpenAPI document
+type routeDoc struct {
+	// Path is the OpenAPI path template, e.g. /most-viewed/{edition}. Its
+	// parameters are described in pathParams.
+	Path    string
+	Summary string
+
+	// Returns is what a successful response is: "list" for an ItemList,
+	// "lists" for one per edition, "json", "events", "text", "html" or "none"
+	Returns string
+
+	// Query names the query parameters it takes, from queryParams
+	Query []string
+
+	methods []string
+}
+
+func newRouter() *router {
+	rt := &router{mux: http.NewServeMux()}
+	rt.mux.HandleFunc("/", notFoundHandler)
+	return rt
+}
+
+func (rt *router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rt.mux.ServeHTTP(w, r)
+}
+
+// handle serves pattern with h, answering methods other than the given ones
+// with a 405, and documents the route as docs. GET routes serve HEAD too.
+func (rt *router) handle(pattern string, methods []string, h http.Handler, docs ...routeDoc) {
+	rt.mux.Handle(pattern, allowMethods(methods, h))
+
+	for _, doc := range docs {
+		doc.methods = methods
+		rt.docs = append(rt.docs, doc)
+	}
+}
+
+func (rt *router) handleFunc(pattern string, methods []string, h func(http.ResponseWriter, *http.Request), docs ...routeDoc) {
+	rt.handle(pattern, methods, http.HandlerFunc(h), docs...)
+}
+
+var (
+	get     = []string{http.MethodGet}
+	getPost = []string{http.MethodGet, http.MethodPost}
+	getPut  = []string{http.MethodGet, http.MethodPut}
+	post    = []string{http.MethodPost}
+	del     = []string{http.MethodDelete}
+)
+
+// allowMethods passes requests with one of methods on to h, and answers
+// anything else with a 405 and an Allow header
+func allowMethods(methods []string, h http.Handler) http.Handler {
+	allowed := map[string]bool{}
+	for _, method := range methods {
+		allowed[method] = true
+	}
+	if allowed[http.MethodGet] {
+		allowed[http.MethodHead] = true
+		methods = append(methods[:len(methods):len(methods)], http.MethodHead)
+	}
+
+	allow := strings.Join(methods, ", ")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !allowed[r.Method] {
+			w.Header().Set("Allow", allow)
+			writeError(w, &HTTPError{Status: http.StatusMethodNotAllowed, Message: "Method not allowed"})
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// serveOpenAPI serves the OpenAPI document for every route registered so
+// far, itself included, so it's registered last
+func (rt *router) serveOpenAPI() {
+	doc := routeDoc{Path: "/openapi.json", Summary: "This OpenAPI document", Returns: "json", methods: get}
+	body := asJSON(openAPIDocument(append(rt.docs, doc)))
+
+	rt.handleFunc("/openapi.json", get, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if _, err := w.Write(body); err != nil {
+			logWriteFailure(r.URL.Path, err)
+		}
+	})
+	rt.docs = append(rt.docs, doc)
+}
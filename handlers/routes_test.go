@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+func TestRouter(t *testing.T) {
+	stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(mostViewedBody("a")))
+	})
+	cfg := testConfig(t, stub.URL)
+
+	rt := newRouter()
+	rt.handleFunc("/most-viewed/", getPost, mostViewedHandler(testCAPI(cfg), testCache(t, cfg), cfg), mostViewedDocs...)
+	rt.handleFunc("/healthz", get, healthzHandler(cfg), opsDocs["/healthz"])
+
+	tests := []struct {
+		method string
+		target string
+		status int
+		allow  string
+		code   string
+	}{
+		{method: "GET", target: "/most-viewed/uk", status: http.StatusOK},
+		{method: "HEAD", target: "/most-viewed/uk", status: http.StatusOK},
+		{method: "POST", target: "/most-viewed/uk", status: http.StatusOK},
+		{method: "DELETE", target: "/most-viewed/uk", status: http.StatusMethodNotAllowed, allow: "GET, POST, HEAD"},
+		{method: "POST", target: "/healthz", status: http.StatusMethodNotAllowed, allow: "GET, HEAD"},
+		{method: "GET", target: "/most-viewed/xx", status: http.StatusNotFound, code: codeUnknownEdition},
+		{method: "GET", target: "/search?q=brexit", status: http.StatusNotFound},
+		{method: "GET", target: "/", status: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.target, func(t *testing.T) {
+			before := stub.requests()
+			w := httptest.NewRecorder()
+			rt.ServeHTTP(w, httptest.NewRequest(tt.method, tt.target, nil))
+
+			if w.Code != tt.status {
+				t.Fatalf("got %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if got := w.Header().Get("Allow"); got != tt.allow {
+				t.Errorf("Allow is %q, want %q", got, tt.allow)
+			}
+			if tt.code != "" {
+				var body errorBody
+				if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.Code != tt.code {
+					t.Errorf("got %s, want code %s", w.Body, tt.code)
+				}
+			}
+			if tt.status != http.StatusOK && stub.requests() != before {
+				t.Error("a refused request reached CAPI")
+			}
+		})
+	}
+}
+
+func TestOpenAPIDocument(t *testing.T) {
+	cfg := testConfig(t, "http://capi.invalid")
+
+	rt := newRouter()
+	rt.handleFunc("/most-viewed/", getPost, mostViewedHandler(testCAPI(cfg), testCache(t, cfg), cfg), mostViewedDocs...)
+	rt.handleFunc("/healthz", get, healthzHandler(cfg), opsDocs["/healthz"])
+	rt.serveOpenAPI()
+
+	w := httptest.NewRecorder()
+	rt.ServeHTTP(w, httptest.NewRequest("GET", "/openapi.json", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d, want 200: %s", w.Code, w.Body)
+	}
+
+	var doc struct {
+		OpenAPI    string                                `json:"openapi"`
+		Paths      map[string]map[string]json.RawMessage `json:"paths"`
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]json.RawMessage `json:"properties"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	if doc.OpenAPI != "3.0.3" {
+		t.Errorf("openapi is %q, want 3.0.3", doc.OpenAPI)
+	}
+
+	// HEAD is served wherever GET is, but not documented separately
+	routes := map[string][]string{
+		"/most-viewed/{edition}":           {"get", "post"},
+		"/most-viewed/{edition}/{section}": {"get", "post"},
+		"/healthz":                         {"get"},
+		"/openapi.json":                    {"get"},
+	}
+	for path, methods := range routes {
+		operations, ok := doc.Paths[path]
+		if !ok {
+			t.Errorf("%s isn't documented", path)
+			continue
+		}
+		if len(operations) != len(methods) {
+			t.Errorf("%s documents %d methods, want %v", path, len(operations), methods)
+		}
+		for _, method := range methods {
+			if _, ok := operations[method]; !ok {
+				t.Errorf("%s %s isn't documented", method, path)
+			}
+		}
+	}
+
+	for _, property := range []string{"heading", "trails"} {
+		if _, ok := doc.Components.Schemas["ItemList"].Properties[property]; !ok {
+			t.Errorf("the ItemList schema has no %s", property)
+		}
+	}
+	if _, ok := doc.Components.Schemas["Error"]; !ok {
+		t.Error("there's no Error schema")
+	}
+}
+
+func TestHEAD(t *testing.T) {
+	stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(mostViewedBody("a")))
+	})
+	cfg := testConfig(t, stub.URL)
+
+	rt := newRouter()
+	rt.handleFunc("/most-viewed/", getPost, mostViewedHandler(testCAPI(cfg), testCache(t, cfg), cfg), mostViewedDocs...)
+	rt.handleFunc("/healthz", get, healthzHandler(cfg), opsDocs["/healthz"])
+	rt.handleFunc("/version", get, versionHandler(cfg), opsDocs["/version"])
+	rt.handle("/metrics", get, promhttp.Handler(), opsDocs["/metrics"])
+	srv := httptest.NewServer(rt)
+	defer srv.Close()
+
+	for _, path := range []string{"/most-viewed/uk", "/healthz", "/version", "/metrics"} {
+		t.Run(path, func(t *testing.T) {
+			get, err := http.Get(srv.URL + path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			get.Body.Close()
+
+			head, err := http.Head(srv.URL + path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, err := io.ReadAll(head.Body)
+			head.Body.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if head.StatusCode != http.StatusOK || get.StatusCode != http.StatusOK {
+				t.Fatalf("HEAD got %d and GET %d, want 200", head.StatusCode, get.StatusCode)
+			}
+			if got, want := head.Header.Get("Content-Type"), get.Header.Get("Content-Type"); got != want || got == "" {
+				t.Errorf("HEAD's Content-Type is %q, want GET's, %q", got, want)
+			}
+			if len(body) != 0 {
+				t.Errorf("HEAD got a body, %q", body)
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/guardian/onward/config"
+)
+
+// sectionPattern matches a CAPI section ID, e.g. sport or uk-news
+var sectionPattern = regexp.MustCompile(`^[a-z0-9-]+$`)
+
+// splitSection splits an edition's section path, e.g. "uk/sport", into the
+// edition and section. CAPI serves each edition's take on a section at that
+// same path, so it's used as it is upstream.
+func splitSection(path string) (edition, section string, ok bool) {
+	parts := strings.SplitN(path, "/", 2)
+	if len(parts) != 2 || !config.IsEdition(parts[0]) || !sectionPattern.MatchString(parts[1]) {
+		return "", "", false
+	}
+
+	return parts[0], parts[1], true
+}
+
+// pathError is the 404 for a path that's neither an edition nor one of its
+// sections, telling clients when it's the edition that's wrong
+func pathError(path string) error {
+	if !config.IsEdition(strings.SplitN(path, "/", 2)[0]) {
+		return &HTTPError{Status: http.StatusNotFound, Message: "Unknown edition", Code: codeUnknownEdition}
+	}
+
+	return &HTTPError{Status: http.StatusNotFound, Message: "Not found"}
+}
+
+// isEditionSection reports whether path is an edition's section
+func isEditionSection(path string) bool {
+	_, _, ok := splitSection(path)
+	return ok
+}
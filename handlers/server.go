@@ -0,0 +1,226 @@
+// Package handlers is the service's HTTP and gRPC API: the most-viewed,
+// most-commented and related lists, served through the cache from CAPI and
+// the other upstreams, along with the operational and admin endpoints.
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+
+	"github.com/guardian/onward/capi"
+	"github.com/guardian/onward/config"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/grpc"
+)
+
+// Server is the service: its HTTP server, the gRPC one if cfg.GRPCAddr is
+// set, and the cache and background work behind both
+type Server struct {
+	http *http.Server
+	grpc *grpc.Server
+	c    Cache
+	cfg  config.Config
+
+	// shutdownTracing flushes the spans still batched up to export
+	shutdownTracing func(context.Context) error
+}
+
+// New sets the service up to serve with cfg, fetching from CAPI with api. It
+// opens everything cfg points at (the cache, GeoIP database, last-good store
+// and overrides), starts the background work, and starts serving gRPC if
+// that's configured; HTTP waits for ListenAndServe.
+func New(cfg config.Config, api capi.Client) (*Server, error) {
+	editionSnapshots.resize(cfg.SnapshotRetention)
+	compression.configure(cfg.Compress, cfg.CompressMinBytes, cfg.CompressCacheBytes, cfg.CacheTTL)
+	images.configure(cfg.ImageResizerURL, cfg.ImageSalt, cfg.ImageWidths, cfg.ImageQuality)
+	if err := openGeoIP(cfg.GeoIPDB); err != nil {
+		return nil, err
+	}
+	if err := openLastGood(cfg); err != nil {
+		return nil, err
+	}
+	shutdownTracing, err := setupTracing(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	features.refresh(context.Background(), envFlags{})
+	workers.run(func(ctx context.Context) { features.watch(ctx, envFlags{}, cfg.FeatureRefresh) })
+	if err := openOverrides(cfg); err != nil {
+		return nil, err
+	}
+	if err := overrides.reload(context.Background(), api, cfg); err != nil {
+		log.Printf("Unable to load overrides, %s", err)
+	}
+	workers.run(func(ctx context.Context) { overrides.watch(ctx, api, cfg) })
+
+	if cfg.StartupCheck {
+		if err := checkUpstream(api, cfg); err != nil {
+			if cfg.RequireUpstream {
+				return nil, errors.Wrap(err, "CAPI startup check failed")
+			}
+			log.Printf("CAPI startup check failed, serving anyway: %s", err)
+		} else {
+			log.Printf("CAPI startup check passed")
+		}
+	}
+
+	c, err := newCache(cfg)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to create cache")
+	}
+
+	load := &inFlight{}
+
+	var gate *warmGate
+	if cfg.WarmInterval > 0 {
+		gate = &warmGate{}
+		workers.run(func(ctx context.Context) { warm(ctx, api, c, cfg, load, gate) })
+	}
+
+	// a mux of our own, so nothing a package registers on the default one
+	// (net/http/pprof, for one) is served by accident. Lists can be POSTed
+	// the trails already seen.
+	rt := newRouter()
+	rt.handleFunc("/most-viewed/", getPost, mostViewedHandler(api, c, cfg), mostViewedDocs...)
+	rt.handleFunc("/most-viewed/all", get, allEditionsHandler(api, c, cfg), allEditionsDocs...)
+	rt.handleFunc("/v2/most-viewed/", getPost, mostViewedHandler(api, c, cfg), mostViewedV2Docs...)
+	rt.handleFunc("/most-commented/", getPost, mostCommentedHandler(api, c, cfg), mostCommentedDocs...)
+	rt.handleFunc("/related/", getPost, relatedHandler(api, c, cfg), relatedDocs...)
+	rt.handleFunc("/graphql", getPost, graphqlHandler(api, c, cfg), graphqlDocs...)
+	rt.handleFunc("/summary", get, summaryHandler(api, c, cfg), summaryDocs...)
+	rt.handleFunc("/healthz", get, healthzHandler(cfg), opsDocs["/healthz"])
+	rt.handleFunc("/healthcheck", get, healthzHandler(cfg), opsDocs["/healthcheck"])
+	rt.handleFunc("/readyz", get, readyzHandler(api, c, cfg, gate), opsDocs["/readyz"])
+	rt.handleFunc("/ready", get, readyzHandler(api, c, cfg, gate), opsDocs["/ready"])
+	rt.handleFunc("/version", get, versionHandler(cfg), opsDocs["/version"])
+	rt.handle("/metrics", get, promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{EnableOpenMetrics: true})), opsDocs["/metrics"])
+
+	if cfg.AdminEnabled() {
+		rt.handleFunc("/admin/purge/", post, purgeHandler(c), adminDocs["/admin/purge/"]...)
+		rt.handleFunc("/admin/cache", get, cacheListHandler(c, cfg), adminDocs["/admin/cache"]...)
+		rt.handleFunc("/admin/cache/", del, cacheDeleteHandler(c), adminDocs["/admin/cache/"]...)
+		rt.handleFunc("/admin/cache/flush", post, cacheFlushHandler(c), adminDocs["/admin/cache/flush"]...)
+		rt.handleFunc("/admin/overrides", getPut, overridesHandler(api, cfg), adminDocs["/admin/overrides"]...)
+	}
+
+	if cfg.Debug {
+		registerDebugRoutes(rt, api, c, cfg)
+	}
+
+	if len(cfg.ProxyPaths) > 0 {
+		rt.handleFunc("/capi/", get, proxyHandler(api, c, cfg), routeDoc{Path: "/capi/{path}", Summary: "A CAPI response, for the paths in -proxy-paths", Returns: "json"})
+	}
+
+	rt.serveOpenAPI()
+
+	var handler http.Handler = rt
+	if cfg.AdminEnabled() {
+		handler = requireAdmin(cfg, handler)
+	}
+
+	if cfg.RateLimit > 0 {
+		clientLimiter, err = newRateLimiter(cfg)
+		if err != nil {
+			return nil, errors.Wrap(err, "Unable to create rate limiter")
+		}
+
+		handler = rateLimit(cfg, clientLimiter, handler)
+	}
+
+	if len(cfg.CORSOrigins) > 0 {
+		handler = cors(cfg.CORSOrigins, cfg.CORSMaxAge, handler)
+	}
+
+	if cfg.SlowLogThreshold > 0 {
+		handler = slowLog(cfg.SlowLogThreshold, cfg.TrustedProxies, handler)
+	}
+
+	if cfg.AccessLog {
+		handler = accessLog(cfg.TrustedProxies, handler)
+	}
+
+	handler = withRequestID(traceRequests(trackTimings(handler)))
+	handler = load.track(observeLatency(handler))
+	if cfg.H2C {
+		handler = withH2C(handler)
+	}
+
+	s := &Server{
+		http: &http.Server{
+			Addr:              cfg.Addr,
+			Handler:           handler,
+			ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+			ReadTimeout:       cfg.ReadTimeout,
+			WriteTimeout:      cfg.WriteTimeout,
+			IdleTimeout:       cfg.IdleTimeout,
+		},
+		c:               c,
+		cfg:             cfg,
+		shutdownTracing: shutdownTracing,
+	}
+
+	if cfg.GRPCAddr != "" {
+		if s.grpc, err = serveGRPC(api, c, cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	s.http.RegisterOnShutdown(listChanges.close)
+	return s, nil
+}
+
+// withH2C serves HTTP/2 over cleartext (h2c) as well as HTTP/1, for clients
+// behind a proxy that terminates TLS
+func withH2C(handler http.Handler) http.Handler {
+	return h2c.NewHandler(handler, &http2.Server{})
+}
+
+// ListenAndServe serves HTTP on cfg.Addr. Like http.Server's, it always
+// returns an error, http.ErrServerClosed once Shutdown has been called.
+func (s *Server) ListenAndServe() error {
+	return s.http.ListenAndServe()
+}
+
+// Shutdown stops the server, as shutdown does, then flushes the spans from
+// the last requests
+func (s *Server) Shutdown() {
+	shutdown(s.http, s.grpc, s.c, s.cfg)
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.ShutdownTimeout)
+	defer cancel()
+	if err := s.shutdownTracing(ctx); err != nil {
+		log.Printf("Unable to flush traces at shutdown, %s", err)
+	}
+}
+
+// shutdown stops accepting requests, lets those in flight finish (gRPC calls
+// too, if it's serving them), stops the background work and closes the
+// caches and the rate limiter. Each wait is bounded by cfg.ShutdownTimeout.
+func shutdown(srv *http.Server, grpcSrv *grpc.Server, c Cache, cfg config.Config) {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("Requests still in flight at shutdown, %s", err)
+	}
+
+	if grpcSrv != nil {
+		stopGRPC(ctx, grpcSrv)
+	}
+
+	if !workers.stop(cfg.ShutdownTimeout) {
+		log.Printf("Background work still running at shutdown")
+	}
+
+	c.Close()
+	compression.close()
+	if clientLimiter != nil {
+		clientLimiter.close()
+	}
+}
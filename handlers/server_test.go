@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/net/http2"
+)
+
+func TestH2C(t *testing.T) {
+	var proto string
+	h := func(w http.ResponseWriter, r *http.Request) {
+		proto = r.Proto
+		w.Write([]byte("ok"))
+	}
+
+	// HTTP/2 with prior knowledge, without TLS
+	client := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	}}
+
+	srv := httptest.NewServer(withH2C(http.HandlerFunc(h)))
+	defer srv.Close()
+
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.ProtoMajor != 2 || proto != "HTTP/2.0" {
+		t.Errorf("served over %s, seen as %s, want HTTP/2.0", resp.Proto, proto)
+	}
+
+	// HTTP/1 still works
+	resp, err = http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.ProtoMajor != 1 || proto != "HTTP/1.1" {
+		t.Errorf("served over %s, seen as %s, want HTTP/1.1", resp.Proto, proto)
+	}
+
+	// and without h2c, HTTP/2 without TLS is refused
+	plain := httptest.NewServer(http.HandlerFunc(h))
+	defer plain.Close()
+	if resp, err := client.Get(plain.URL); err == nil {
+		resp.Body.Close()
+		t.Errorf("got %s without h2c, want the request refused", resp.Proto)
+	}
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// timings records where a request spent its time, and how the cache served
+// it
+type timings struct {
+	mu       sync.Mutex
+	cache    time.Duration
+	upstream time.Duration
+
+	// lookup is the worst cache lookup result: "miss", "stale", "hit", or
+	// empty if the request didn't use the cache
+	lookup string
+}
+
+type timingsKey struct{}
+
+// timingsFrom returns the request's timings, or nil if they aren't tracked
+func timingsFrom(ctx context.Context) *timings {
+	t, _ := ctx.Value(timingsKey{}).(*timings)
+	return t
+}
+
+func (t *timings) addCache(since time.Time) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	t.cache += time.Since(since)
+	t.mu.Unlock()
+}
+
+// lookupRank orders cache lookup results from best to worst
+var lookupRank = map[string]int{"": 0, "hit": 1, "stale": 2, "miss": 3}
+
+// noteLookup records a cache lookup result, keeping the worst seen
+func (t *timings) noteLookup(result string) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	if lookupRank[result] > lookupRank[t.lookup] {
+		t.lookup = result
+	}
+	t.mu.Unlock()
+}
+
+func (t *timings) addUpstream(since time.Time) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	t.upstream += time.Since(since)
+	t.mu.Unlock()
+}
+
+// trackTimings records each request's timings, for the slow and access logs
+func trackTimings(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), timingsKey{}, &timings{})))
+	})
+}
+
+// slowLog logs requests that take longer than threshold, with a breakdown of
+// time spent on the cache and upstream. Faster requests and streams aren't
+// logged. It needs trackTimings further out.
+func slowLog(threshold time.Duration, trusted []*net.IPNet, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isStream(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		t := timingsFrom(r.Context())
+		start := time.Now()
+
+		h.ServeHTTP(w, r)
+
+		total := time.Since(start)
+		if total < threshold {
+			return
+		}
+
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		log.Printf("Slow request %s %s from %s (request %s): total=%s cache=%s upstream=%s", r.Method, r.URL.Path, clientIP(r, trusted), requestIDFrom(r.Context()), total, t.cache, t.upstream)
+	})
+}
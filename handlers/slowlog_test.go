@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSlowLog(t *testing.T) {
+	tests := []struct {
+		name   string
+		delay  time.Duration
+		logged bool
+	}{
+		{"slow", 60 * time.Millisecond, true},
+		{"fast", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(tt.delay)
+				w.Write([]byte(mostViewedBody("a")))
+			})
+			cfg := testConfig(t, stub.URL)
+			h := trackTimings(slowLog(30*time.Millisecond, nil, http.HandlerFunc(mostViewedHandler(testCAPI(cfg), testCache(t, cfg), cfg))))
+
+			logged := captureLog(t)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest("GET", "/most-viewed/uk", nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("got %d, want 200: %s", w.Code, w.Body)
+			}
+
+			line := ""
+			for _, l := range strings.Split(logged.String(), "\n") {
+				if strings.Contains(l, "Slow request") {
+					line = l
+				}
+			}
+			if (line != "") != tt.logged {
+				t.Fatalf("logged %q, want a slow request logged: %t", logged, tt.logged)
+			}
+			if !tt.logged {
+				return
+			}
+
+			for _, want := range []string{"GET /most-viewed/uk", "total=", "cache=", "upstream="} {
+				if !strings.Contains(line, want) {
+					t.Errorf("%q doesn't show %q", line, want)
+				}
+			}
+			if strings.Contains(line, "upstream=0s") {
+				t.Errorf("%q doesn't count the time waiting on CAPI", line)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"sync"
+	"time"
+
+	"github.com/guardian/onward/capi"
+)
+
+// snapshot is the trail URLs of a list cached for an edition
+type snapshot struct {
+	CachedAt time.Time `json:"cachedAt"`
+	URLs     []string  `json:"urls"`
+}
+
+// snapshots keeps the trail URLs of the last few lists cached for each
+// edition, so changes between fills can be inspected. Only URLs are kept, so
+// memory is bounded by the retention times the list length.
+type snapshots struct {
+	mu       sync.Mutex
+	size     int
+	editions map[string][]snapshot
+}
+
+// editionSnapshots are the recently cached lists, sized by New from
+// cfg.SnapshotRetention
+var editionSnapshots = newSnapshots(2)
+
+func newSnapshots(size int) *snapshots {
+	return &snapshots{size: size, editions: map[string][]snapshot{}}
+}
+
+// resize empties the snapshots and makes them keep the last size lists per
+// edition
+func (s *snapshots) resize(size int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.size = size
+	s.editions = map[string][]snapshot{}
+}
+
+// record notes a newly cached list for an edition, dropping the oldest once
+// there are more than the retention. It reports whether the list's trails or
+// their order differ from the last one recorded.
+func (s *snapshots) record(edition string, items []capi.Item) bool {
+	urls := make([]string, len(items))
+	for i, item := range items {
+		urls[i] = item.ID
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	previous := s.editions[edition]
+	changed := len(previous) == 0 || !equalStrings(previous[len(previous)-1].URLs, urls)
+
+	kept := append(previous, snapshot{CachedAt: time.Now(), URLs: urls})
+	if len(kept) > s.size {
+		kept = append([]snapshot(nil), kept[len(kept)-s.size:]...)
+	}
+	s.editions[edition] = kept
+
+	return changed
+}
+
+// history is an edition's retained snapshots, newest first
+func (s *snapshots) history(edition string) []snapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kept := s.editions[edition]
+	newestFirst := make([]snapshot, len(kept))
+	for i, snap := range kept {
+		newestFirst[len(kept)-1-i] = snap
+	}
+
+	return newestFirst
+}
+
+// diff lists the URLs added to and removed from an edition's list since the
+// fill back fills before the latest, and whether that fill is still retained
+func (s *snapshots) diff(edition string, back int) (added, removed []string, ok bool) {
+	history := s.history(edition)
+	if back < 1 || back >= len(history) {
+		return nil, nil, false
+	}
+
+	current, previous := history[0].URLs, history[back].URLs
+	return missingFrom(previous, current), missingFrom(current, previous), true
+}
+
+// missingFrom is the URLs in b that aren't in a, in b's order
+func missingFrom(a, b []string) []string {
+	in := map[string]bool{}
+	for _, url := range a {
+		in[url] = true
+	}
+
+	missing := []string{}
+	for _, url := range b {
+		if !in[url] {
+			missing = append(missing, url)
+		}
+	}
+
+	return missing
+}
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+)
+
+// snapshotURLs are each snapshot's URLs, in the order given
+func snapshotURLs(history []snapshot) [][]string {
+	urls := [][]string{}
+	for _, snap := range history {
+		urls = append(urls, snap.URLs)
+	}
+	return urls
+}
+
+func TestSnapshotRetention(t *testing.T) {
+	s := newSnapshots(3)
+
+	for _, ids := range [][]string{{"a"}, {"b"}, {"c"}, {"d"}, {"e"}} {
+		if !s.record("uk", capiItems(ids...)) {
+			t.Errorf("recording %v wasn't a change", ids)
+		}
+	}
+
+	// the two oldest have been dropped
+	want := [][]string{{"e"}, {"d"}, {"c"}}
+	if got := snapshotURLs(s.history("uk")); !reflect.DeepEqual(got, want) {
+		t.Errorf("uk's snapshots are %v, want %v", got, want)
+	}
+
+	if s.record("uk", capiItems("e")) {
+		t.Error("recording the same list again was a change")
+	}
+	if !s.record("uk", capiItems("f", "e")) || !s.record("uk", capiItems("e", "f")) {
+		t.Error("recording new trails or a new order wasn't a change")
+	}
+
+	// each edition has its own
+	s.record("us", capiItems("x"))
+	if got := snapshotURLs(s.history("us")); !reflect.DeepEqual(got, [][]string{{"x"}}) {
+		t.Errorf("us's snapshots are %v, want its own", got)
+	}
+	if got := len(s.history("uk")); got != 3 {
+		t.Errorf("uk has %d snapshots, want 3", got)
+	}
+
+	s.resize(1)
+	if got := snapshotURLs(s.history("uk")); len(got) != 0 {
+		t.Errorf("after resizing uk has snapshots %v, want none", got)
+	}
+	s.record("uk", capiItems("g"))
+	s.record("uk", capiItems("h"))
+	if got := snapshotURLs(s.history("uk")); !reflect.DeepEqual(got, [][]string{{"h"}}) {
+		t.Errorf("uk's snapshots are %v, want only the last", got)
+	}
+}
@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/guardian/onward/capi"
+	"github.com/guardian/onward/config"
+)
+
+// listChanges tells streams when an edition's cached list has changed
+var listChanges = newChangeFeed()
+
+// changeFeed passes each edition's newly cached list to its subscribers. A
+// subscriber that falls behind only ever has the latest list waiting, not a
+// backlog.
+type changeFeed struct {
+	mu     sync.Mutex
+	closed bool
+	subs   map[string]map[chan capi.Response]bool
+}
+
+func newChangeFeed() *changeFeed {
+	return &changeFeed{subs: map[string]map[chan capi.Response]bool{}}
+}
+
+// subscribe returns a channel of the edition's changed lists, and a function
+// to stop receiving them. The channel is closed when the feed is.
+func (f *changeFeed) subscribe(edition string) (<-chan capi.Response, func()) {
+	ch := make(chan capi.Response, 1)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		close(ch)
+		return ch, func() {}
+	}
+
+	if f.subs[edition] == nil {
+		f.subs[edition] = map[chan capi.Response]bool{}
+	}
+	f.subs[edition][ch] = true
+
+	return ch, func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		if f.subs[edition][ch] {
+			delete(f.subs[edition], ch)
+			close(ch)
+		}
+	}
+}
+
+// publish hands the edition's new list to its subscribers, replacing any
+// they've yet to take
+func (f *changeFeed) publish(edition string, items capi.Response) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for ch := range f.subs[edition] {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- items
+	}
+}
+
+// close ends every subscription, so streams finish when the server shuts
+// down rather than holding it open
+func (f *changeFeed) close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.closed = true
+	for edition, subs := range f.subs {
+		for ch := range subs {
+			close(ch)
+		}
+		delete(f.subs, edition)
+	}
+}
+
+// streamedEdition is the edition a most-viewed path, less its prefix and
+// format, streams, if it's a stream
+func streamedEdition(path string) (string, bool) {
+	edition := strings.TrimSuffix(path, "/stream")
+	return edition, edition != path && config.IsEdition(edition)
+}
+
+// isStream reports whether r is for an edition's stream. Streams last as long
+// as their clients stay, so they're kept out of request latencies, the slow
+// log and the count of requests in flight.
+func isStream(r *http.Request) bool {
+	path := strings.TrimPrefix(r.URL.Path, "/v2")
+	if !strings.HasPrefix(path, "/most-viewed/") {
+		return false
+	}
+
+	path, _ = splitFormat(normalizePath(strings.TrimPrefix(path, "/most-viewed/")))
+	_, ok := streamedEdition(path)
+	return ok
+}
+
+// streamEdition serves an edition's most-viewed list as Server-Sent Events:
+// the current list on connect, then the list again whenever a cache fill
+// changes its trails or their order. Fills are noticed by this instance
+// only, whether by the warmer, a refresh or another request's miss.
+func streamEdition(w http.ResponseWriter, r *http.Request, edition string, api capi.Client, c Cache, cfg config.Config) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, &HTTPError{Status: http.StatusInternalServerError, Message: "Streaming unsupported"})
+		return
+	}
+
+	limit, _, err := requestedLimit(r, defaultLimit(cfg, edition), cfg.MaxLimit)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// subscribed before the first fetch, so a change in between isn't missed
+	changes, unsubscribe := listChanges.subscribe(edition)
+	defer unsubscribe()
+
+	requestsTotal.WithLabelValues(edition).Inc()
+
+	ctx, cancel := context.WithTimeout(r.Context(), cfg.RequestBudget)
+	items, err := getMostViewed(ctx, api, query{Path: edition, MostViewed: true}, c, cfg)
+	cancel()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// a stream outlives the server's write timeout, so lift it for this
+	// response
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	opts := renderOptionsFor(r, cfg)
+	opts.Pretty, opts.TrailingNewline = false, false // one event, one line
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	var sent []string
+	send := func(items capi.Response) bool {
+		il := asItemList(items, edition, editionHeading(cfg, edition)).limit(limit)
+
+		urls := make([]string, len(il.Trails))
+		for i, item := range il.Trails {
+			urls[i] = item.URL
+		}
+		if sent != nil && equalStrings(urls, sent) {
+			return true
+		}
+		sent = urls
+
+		if _, err := fmt.Fprintf(w, "event: list\ndata: %s\n\n", opts.listJSON(il)); err != nil {
+			logWriteFailure(r.URL.Path, err)
+			return false
+		}
+		flusher.Flush()
+		return true
+	}
+
+	if !send(items) {
+		return
+	}
+
+	keepAlive := time.NewTicker(cfg.StreamKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case items, ok := <-changes:
+			if !ok || !send(items) {
+				return
+			}
+		case <-keepAlive.C:
+			// a comment, so idle proxies don't drop the connection
+			if _, err := fmt.Fprint(w, ":\n\n"); err != nil {
+				logWriteFailure(r.URL.Path, err)
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
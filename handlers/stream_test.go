@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIsStream(t *testing.T) {
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"/most-viewed/uk/stream", true},
+		{"/v2/most-viewed/us/stream", true},
+		{"/most-viewed/uk/stream.json", true},
+		{"/most-viewed/uk", false},
+		{"/most-viewed/uk/stream-of-consciousness", false},
+		{"/most-viewed/uk/books/stream", false},
+		{"/related/uk/stream", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := isStream(httptest.NewRequest(http.MethodGet, tt.path, nil)); got != tt.want {
+				t.Errorf("isStream(%q) = %v, want %v", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStreamsArentCountedInFlight(t *testing.T) {
+	load := &inFlight{}
+	var during int64
+	h := load.track(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		during = load.count()
+	}))
+
+	serve(h.ServeHTTP, "/most-viewed/uk/stream")
+	if during != 0 {
+		t.Errorf("a stream counted as %d requests in flight, want 0", during)
+	}
+
+	serve(h.ServeHTTP, "/most-viewed/uk")
+	if during != 1 {
+		t.Errorf("a request counted as %d in flight, want 1", during)
+	}
+}
@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/guardian/onward/capi"
+	"github.com/guardian/onward/config"
+)
+
+// editionSummary describes what's cached for an edition
+type editionSummary struct {
+	Cached    bool       `json:"cached"`
+	Count     int        `json:"count"`
+	FetchedAt *time.Time `json:"fetchedAt,omitempty"`
+}
+
+// summaryHandler reports, for every edition, how many most-viewed items are
+// cached and when they were fetched. It only reads the cache and never
+// fetches from CAPI, so it's cheap to poll.
+func summaryHandler(api capi.Client, c Cache, cfg config.Config) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		summary := map[string]editionSummary{}
+
+		for _, edition := range config.Editions {
+			entry, found := c.Get(query{Path: edition, MostViewed: true}.cacheKey())
+			if !found {
+				summary[edition] = editionSummary{}
+				continue
+			}
+
+			fetchedAt := entry.FetchedAt
+			summary[edition] = editionSummary{
+				Cached:    true,
+				Count:     len(entry.Response.Response.Results),
+				FetchedAt: &fetchedAt,
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(renderOptionsFor(r, cfg).json(summary))
+	}
+}
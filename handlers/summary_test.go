@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/guardian/onward/config"
+)
+
+func TestEmptyMostViewed(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"missing", `{"response":{"status":"ok"}}`},
+		{"null", `{"response":{"status":"ok","mostViewed":null}}`},
+		{"empty", `{"response":{"status":"ok","mostViewed":[]}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(tt.body))
+			})
+			cfg := testConfig(t, stub.URL)
+			c := testCache(t, cfg)
+			h := mostViewedHandler(testCAPI(cfg), c, cfg)
+
+			w := serve(h, "/most-viewed/uk")
+			if w.Code != http.StatusOK {
+				t.Fatalf("got %d, want 200: %s", w.Code, w.Body)
+			}
+			if !strings.Contains(w.Body.String(), `"trails":[]`) {
+				t.Errorf("got %s, want trails []", w.Body)
+			}
+
+			if w := serve(h, "/most-viewed/uk/1"); w.Code != http.StatusNotFound {
+				t.Errorf("first item got %d, want 404: %s", w.Code, w.Body)
+			}
+
+			w = serve(summaryHandler(testCAPI(cfg), c, cfg), "/summary")
+			var summary map[string]editionSummary
+			if err := json.Unmarshal(w.Body.Bytes(), &summary); err != nil {
+				t.Fatal(err)
+			}
+			if uk := summary["uk"]; !uk.Cached || uk.Count != 0 {
+				t.Errorf("summary of uk is cached %v with %d items, want cached with 0", uk.Cached, uk.Count)
+			}
+		})
+	}
+}
+
+func TestSummaryReflectsWarmedEditions(t *testing.T) {
+	stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/us" {
+			w.Write([]byte(mostViewedBody("a", "b", "c")))
+			return
+		}
+		w.Write([]byte(mostViewedBody("a")))
+	})
+	cfg := testConfig(t, stub.URL)
+	c := testCache(t, cfg)
+
+	start := time.Now()
+	if !warmOnce(context.Background(), testCAPI(cfg), c, cfg, &inFlight{}, &warmGate{}) {
+		t.Fatal("warming was skipped")
+	}
+	warmed := stub.requests()
+
+	w := serve(summaryHandler(testCAPI(cfg), c, cfg), "/summary")
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d, want 200: %s", w.Code, w.Body)
+	}
+	var summary map[string]editionSummary
+	if err := json.Unmarshal(w.Body.Bytes(), &summary); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, edition := range config.Editions {
+		want := 1
+		if edition == "us" {
+			want = 3
+		}
+		got := summary[edition]
+		if !got.Cached || got.Count != want {
+			t.Errorf("summary of %s is cached %v with %d items, want cached with %d", edition, got.Cached, got.Count, want)
+		}
+		if got.FetchedAt == nil || got.FetchedAt.Before(start.Add(-time.Second)) || got.FetchedAt.After(time.Now()) {
+			t.Errorf("%s was fetched at %v, want during the warm", edition, got.FetchedAt)
+		}
+	}
+
+	if calls := stub.requests(); calls != warmed {
+		t.Errorf("the summary made %d CAPI requests, want none", calls-warmed)
+	}
+}
+
+func TestSummaryOfAnEmptyCache(t *testing.T) {
+	stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(mostViewedBody("a")))
+	})
+	cfg := testConfig(t, stub.URL)
+
+	w := serve(summaryHandler(testCAPI(cfg), testCache(t, cfg), cfg), "/summary")
+	var summary map[string]editionSummary
+	if err := json.Unmarshal(w.Body.Bytes(), &summary); err != nil {
+		t.Fatal(err)
+	}
+	for _, edition := range config.Editions {
+		if got := summary[edition]; got.Cached || got.Count != 0 || got.FetchedAt != nil {
+			t.Errorf("summary of %s is %+v, want nothing cached", edition, got)
+		}
+	}
+	if calls := stub.requests(); calls != 0 {
+		t.Errorf("the summary made %d CAPI requests, want none", calls)
+	}
+}
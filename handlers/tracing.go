@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer starts the service's spans. Until setupTracing installs a provider
+// they aren't recorded, but incoming trace context is still passed on to CAPI.
+var tracer = otel.Tracer("github.com/guardian/onward")
+
+// setupTracing exports spans over OTLP/HTTP when the standard OpenTelemetry
+// environment asks for it: an OTEL_EXPORTER_OTLP_ENDPOINT or
+// OTEL_EXPORTER_OTLP_TRACES_ENDPOINT, or OTEL_TRACES_EXPORTER=otlp. The
+// exporter, sampler and resource are configured by the rest of the OTEL_*
+// variables. The returned function flushes and stops the exporter.
+func setupTracing(ctx context.Context) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	noop := func(context.Context) error { return nil }
+
+	exporter := os.Getenv("OTEL_TRACES_EXPORTER")
+	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") + os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+
+	switch {
+	case strings.EqualFold(os.Getenv("OTEL_SDK_DISABLED"), "true"), exporter == "none":
+		return noop, nil
+	case exporter != "" && exporter != "otlp":
+		return noop, errors.Errorf("OTEL_TRACES_EXPORTER %q is unsupported, only otlp", exporter)
+	case exporter == "" && endpoint == "":
+		return noop, nil
+	}
+
+	client, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return noop, errors.Wrap(err, "Unable to create OTLP trace exporter")
+	}
+
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override the defaults
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceNameKey.String("onward"), semconv.ServiceVersionKey.String(version)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return noop, errors.Wrap(err, "Unable to describe the trace resource")
+	}
+
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(client), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// traceRequests gives each request a server span, continuing the trace in its
+// traceparent header if it has one. Spans are named by route, not path, so
+// sections and articles don't each get their own.
+func traceRequests(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+
+		route, _ := routeLabels(r.URL.Path)
+		ctx, span := tracer.Start(ctx, r.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPMethodKey.String(r.Method),
+				semconv.HTTPTargetKey.String(r.URL.Path),
+				semconv.HTTPRouteKey.String(route),
+				attribute.String("onward.request_id", requestIDFrom(r.Context())),
+			))
+		defer span.End()
+
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(sw, r.WithContext(ctx))
+
+		span.SetAttributes(semconv.HTTPStatusCodeKey.Int(sw.status))
+		if sw.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(sw.status))
+		}
+	})
+}
+
+// traceCache is a span for a cache operation on key, to End once it's done
+func traceCache(ctx context.Context, operation, key string) trace.Span {
+	_, span := tracer.Start(ctx, "cache "+operation, trace.WithAttributes(attribute.String("onward.cache.key", key)))
+	return span
+}
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"time"
+)
+
+// Transport wraps the transport CAPI requests are sent over, so they carry
+// the request ID of the request they're made for and count towards its
+// upstream time in the slow and access logs
+func Transport(next http.RoundTripper) http.RoundTripper {
+	return requestTransport{next: next}
+}
+
+type requestTransport struct {
+	next http.RoundTripper
+}
+
+func (t requestTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	ctx := req.Context()
+
+	if id := requestIDFrom(ctx); id != "" {
+		// a RoundTripper mustn't modify the request it's given
+		req = req.Clone(ctx)
+		req.Header.Set(requestIDHeader, id)
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		timingsFrom(ctx).addUpstream(start)
+		return resp, err
+	}
+
+	// the response isn't done with until its body's been read
+	resp.Body = &timedBody{ReadCloser: resp.Body, done: func() { timingsFrom(ctx).addUpstream(start) }}
+	return resp, nil
+}
+
+// timedBody calls done when it's first closed
+type timedBody struct {
+	io.ReadCloser
+	done func()
+	once bool
+}
+
+func (b *timedBody) Close() error {
+	if !b.once {
+		b.once = true
+		b.done()
+	}
+
+	return b.ReadCloser.Close()
+}
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/guardian/onward/capi"
+	"github.com/guardian/onward/config"
+	"github.com/pkg/errors"
+)
+
+// fetchJSON decodes the JSON at target into v, for upstreams other than CAPI.
+// Failures are capi.Errors, retried like CAPI's are; service names the
+// upstream in them.
+func fetchJSON(ctx context.Context, service, target string, cfg config.Config, v interface{}) error {
+	return capi.WithRetries(ctx, cfg, func() error {
+		return fetchJSONAttempt(ctx, service, target, cfg, v)
+	})
+}
+
+func fetchJSONAttempt(ctx context.Context, service, target string, cfg config.Config, v interface{}) error {
+	if cfg.UpstreamTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.UpstreamTimeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return errors.Wrapf(err, "Unable to build %s request", service)
+	}
+
+	req.Header.Set("User-Agent", capi.UserAgent)
+	if id := requestIDFrom(ctx); id != "" {
+		req.Header.Set(requestIDHeader, id)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		// as with CAPI, the URL may carry a key
+		if urlErr, ok := err.(*url.Error); ok {
+			urlErr.URL = capi.RedactedURL(urlErr.URL)
+		}
+
+		if ctx.Err() == context.DeadlineExceeded {
+			return &capi.Error{Status: http.StatusGatewayTimeout, Message: service + " timed out", Err: err, Transient: true}
+		}
+		return capi.TransientError(err, service+" GET failed")
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return &capi.Error{
+			Status:     http.StatusTooManyRequests,
+			Message:    service + " rate limit exceeded",
+			Code:       capi.CodeUpstreamRateLimited,
+			RetryAfter: capi.ParseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+		}
+	case resp.StatusCode >= 500:
+		return capi.TransientError(fmt.Errorf("status %d", resp.StatusCode), service+" error")
+	case resp.StatusCode != http.StatusOK:
+		return capi.UpstreamError(fmt.Errorf("status %d", resp.StatusCode), "Unexpected "+service+" response")
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return capi.UpstreamError(err, "Unable to decode "+service+" response")
+	}
+
+	return nil
+}
+
+// resolveArticles looks up the CAPI items at paths with a single search,
+// keeping the paths' order and dropping any CAPI didn't return
+func resolveArticles(ctx context.Context, api capi.Client, paths []string) (capi.Response, error) {
+	if len(paths) == 0 {
+		return capi.Response{}, nil
+	}
+
+	params := query{}.params()
+	params.Set("ids", strings.Join(paths, ","))
+	params.Set("page-size", strconv.Itoa(len(paths)))
+
+	response, err := capi.Get(ctx, api, "search", params)
+	if err != nil {
+		return response, err
+	}
+
+	// CAPI orders the search its own way
+	found := map[string]capi.Item{}
+	for _, item := range response.Response.Latest {
+		found[item.ID] = item
+	}
+
+	response.Response.Results = nil
+	for _, path := range paths {
+		if item, ok := found[path]; ok {
+			response.Response.Results = append(response.Response.Results, item)
+		}
+	}
+
+	return response, nil
+}
+
+// webPath is the CAPI path of a guardian.com URL
+func webPath(webURL string) string {
+	u, err := url.Parse(webURL)
+	if err != nil {
+		return ""
+	}
+
+	return normalizePath(u.Path)
+}
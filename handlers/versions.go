@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+const v2ContentType = "application/vnd.onward.v2+json"
+
+// ItemListV2 is the v2 shape of ItemList. v1 (ItemList) is frozen; new or
+// renamed fields belong here.
+type ItemListV2 struct {
+	Heading    string      `json:"heading"`
+	Trails     []ItemV2    `json:"trails"`
+	Pagination *pagination `json:"pagination,omitempty"`
+	Edition    string      `json:"edition,omitempty"`
+}
+
+// ItemV2 is the v2 shape of Item
+type ItemV2 struct {
+	URL        string   `json:"url"`
+	Headline   string   `json:"headline"`
+	ShowByline bool     `json:"showByline"`
+	Byline     string   `json:"byline,omitempty"`
+	Image      *ImageV2 `json:"image,omitempty"`
+	IsLiveBlog bool     `json:"isLiveBlog"`
+
+	PublishedAt *time.Time `json:"publishedAt,omitempty"`
+	Rank        int        `json:"rank,omitempty"`
+}
+
+// ImageV2 is a trail image, with its crops for responsive images
+type ImageV2 struct {
+	URL   string        `json:"url"`
+	Crops []ImageCropV2 `json:"crops,omitempty"`
+}
+
+// ImageCropV2 is a trail image at one size
+type ImageCropV2 struct {
+	URL         string `json:"url"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	AspectRatio string `json:"aspectRatio,omitempty"`
+}
+
+// apiVersion returns the output version the request asks for, via a /v2
+// prefix or the v2 media type, along with the path minus any prefix. With
+// the v2 feature off everything gets v1.
+func apiVersion(r *http.Request) (int, string) {
+	v2 := features.enabled(featureV2)
+
+	if strings.HasPrefix(r.URL.Path, "/v2/") {
+		path := strings.TrimPrefix(r.URL.Path, "/v2")
+		if !v2 {
+			return 1, path
+		}
+		return 2, path
+	}
+
+	if v2 && strings.Contains(r.Header.Get("Accept"), v2ContentType) {
+		return 2, r.URL.Path
+	}
+
+	return 1, r.URL.Path
+}
+
+func (il ItemList) asV2() ItemListV2 {
+	trails := []ItemV2{}
+
+	for _, item := range il.Trails {
+		trail := ItemV2{
+			URL:         item.URL,
+			Headline:    item.LinkText,
+			ShowByline:  item.ShowByline,
+			Byline:      item.Byline,
+			IsLiveBlog:  item.IsLiveblog,
+			PublishedAt: item.PublishedAt,
+			Rank:        item.Rank,
+		}
+
+		if item.Image != "" || len(item.Crops) > 0 {
+			trail.Image = &ImageV2{URL: item.Image}
+			for _, crop := range item.Crops {
+				trail.Image.Crops = append(trail.Image.Crops, ImageCropV2(crop))
+			}
+
+			// without a thumbnail the smallest crop stands in
+			if trail.Image.URL == "" {
+				trail.Image.URL = item.Crops[0].URL
+			}
+		}
+
+		trails = append(trails, trail)
+	}
+
+	return ItemListV2{
+		Heading:    il.Heading,
+		Trails:     trails,
+		Pagination: il.Pagination,
+		Edition:    il.Edition,
+	}
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestV1AndV2FromTheSameResponse(t *testing.T) {
+	stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"response":{"status":"ok","mostViewed":[
+			{"id":"politics/live/1","type":"liveblog","webTitle":"Web title",
+			 "fields":{"headline":"Budget live","byline":"A Writer","thumbnail":"https://media.guim.co.uk/1/500.jpg"}}
+		]}}`))
+	})
+	cfg := testConfig(t, stub.URL)
+	h := mostViewedHandler(testCAPI(cfg), testCache(t, cfg), cfg)
+
+	v1 := serve(h, "/most-viewed/uk")
+	if v1.Code != http.StatusOK {
+		t.Fatalf("v1 got %d, want 200: %s", v1.Code, v1.Body)
+	}
+	if got := v1.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("v1 Content-Type is %q, want application/json", got)
+	}
+	var v1List struct {
+		Trails []map[string]interface{} `json:"trails"`
+	}
+	if err := json.Unmarshal(v1.Body.Bytes(), &v1List); err != nil || len(v1List.Trails) != 1 {
+		t.Fatalf("v1 got %s, want one trail (%v)", v1.Body, err)
+	}
+	want := map[string]interface{}{
+		"url":        "politics/live/1",
+		"linkText":   "Budget live",
+		"byline":     "A Writer",
+		"image":      "https://media.guim.co.uk/1/500.jpg",
+		"isLiveBlog": "true",
+	}
+	for name, value := range want {
+		if got := v1List.Trails[0][name]; got != value {
+			t.Errorf("v1 %s is %v, want %v", name, got, value)
+		}
+	}
+
+	// by prefix or by media type
+	for _, v2Request := range []struct{ target, accept string }{
+		{"/v2/most-viewed/uk", ""},
+		{"/most-viewed/uk", v2ContentType},
+	} {
+		r := httptest.NewRequest("GET", v2Request.target, nil)
+		r.Header.Set("Accept", v2Request.accept)
+		v2 := httptest.NewRecorder()
+		h(v2, r)
+
+		if v2.Code != http.StatusOK {
+			t.Fatalf("v2 got %d, want 200: %s", v2.Code, v2.Body)
+		}
+		if got := v2.Header().Get("Content-Type"); got != v2ContentType {
+			t.Errorf("v2 Content-Type is %q, want %s", got, v2ContentType)
+		}
+
+		var v2List ItemListV2
+		if err := json.Unmarshal(v2.Body.Bytes(), &v2List); err != nil || len(v2List.Trails) != 1 {
+			t.Fatalf("v2 got %s, want one trail (%v)", v2.Body, err)
+		}
+		trail := v2List.Trails[0]
+		if trail.URL != "politics/live/1" || trail.Headline != "Budget live" || trail.Byline != "A Writer" || !trail.IsLiveBlog {
+			t.Errorf("v2 trail is %+v", trail)
+		}
+		if trail.Image == nil || trail.Image.URL != "https://media.guim.co.uk/1/500.jpg" {
+			t.Errorf("v2 image is %+v, want the thumbnail", trail.Image)
+		}
+	}
+}
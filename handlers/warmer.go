@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/guardian/onward/capi"
+	"github.com/guardian/onward/config"
+)
+
+// inFlight counts the requests currently being served
+type inFlight struct {
+	n int64
+}
+
+// track counts the requests h is serving, other than streams
+func (f *inFlight) track(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isStream(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		atomic.AddInt64(&f.n, 1)
+		defer atomic.AddInt64(&f.n, -1)
+		h.ServeHTTP(w, r)
+	})
+}
+
+func (f *inFlight) count() int64 {
+	return atomic.LoadInt64(&f.n)
+}
+
+// warmGate records whether the warmer has filled the cache yet. A nil gate,
+// when there's no warmer, is always open.
+type warmGate struct {
+	warmed int32
+}
+
+func (g *warmGate) open() {
+	atomic.StoreInt32(&g.warmed, 1)
+}
+
+func (g *warmGate) isOpen() bool {
+	return g == nil || atomic.LoadInt32(&g.warmed) == 1
+}
+
+// warm fills the cached editions straight away, then refreshes them every
+// cfg.WarmInterval until ctx is done. The gate opens once a fill has cached
+// every edition, so an instance isn't ready until no edition will miss.
+func warm(ctx context.Context, api capi.Client, c Cache, cfg config.Config, load *inFlight, gate *warmGate) {
+	warmOnce(ctx, api, c, cfg, load, gate)
+
+	ticker := time.NewTicker(cfg.WarmInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			warmOnce(ctx, api, c, cfg, load, gate)
+		}
+	}
+}
+
+// warmOnce refreshes the editions' lists from cfg.MostViewedSource, as
+// requests without a ?source= get them, cfg.FanOutWorkers at a time,
+// unless the service is already busier than cfg.WarmMaxInFlight, in which
+// case the cycle is skipped and the work left to the next one. It reports
+// whether the refresh ran. Failed fetches are logged and the remaining
+// editions still refreshed.
+func warmOnce(ctx context.Context, api capi.Client, c Cache, cfg config.Config, load *inFlight, gate *warmGate) bool {
+	if cfg.WarmMaxInFlight > 0 && load.count() >= cfg.WarmMaxInFlight {
+		log.Printf("Skipping cache warm, %d requests in flight", load.count())
+		return false
+	}
+
+	_, errs := fetchEditions(config.Editions, cfg.FanOutWorkers, func(edition string) (capi.Response, error) {
+		q, err := mostViewedQuery(edition, "", true, cfg.MostViewedSource)
+		if err != nil {
+			return capi.Response{}, err
+		}
+
+		items, err := warmFetch(ctx, api, q, c, cfg)
+		if err != nil {
+			return items, err
+		}
+
+		cacheSet(ctx, c, q, items, cfg)
+		return items, nil
+	})
+
+	warmed := true
+	for i, err := range errs {
+		if err != nil {
+			log.Printf("Unable to warm %s, %s", config.Editions[i], err)
+			warmed = false
+		}
+	}
+
+	if warmed {
+		gate.open()
+	}
+
+	return true
+}
+
+// warmFetch refetches q with its own timeout, so a hanging CAPI can't stall
+// the warmer. It shares a fetch with requests missing q at the same time.
+func warmFetch(ctx context.Context, api capi.Client, q query, c Cache, cfg config.Config) (capi.Response, error) {
+	ctx, cancel := context.WithTimeout(ctx, cfg.WarmTimeout)
+	defer cancel()
+
+	entry, found := c.Get(q.cacheKey())
+	return fetches.do(ctx, q.cacheKey(), cfg.CoalesceWindow, cfg.WarmTimeout, func(ctx context.Context) (capi.Response, error) {
+		return refetch(ctx, api, q, entry, found, cfg)
+	})
+}
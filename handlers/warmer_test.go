@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/guardian/onward/config"
+)
+
+func TestWarmOnceFollowsTheSource(t *testing.T) {
+	tests := []struct {
+		source string
+		query  func(edition string) query
+	}{
+		{config.SourceCAPI, func(edition string) query { return query{Path: edition, MostViewed: true} }},
+		{config.SourceOphan, func(edition string) query { return query{Path: edition, Ophan: true} }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(mostViewedBody("a")))
+			})
+			ophan := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(`[]`))
+			})
+			cfg := testConfig(t, stub.URL)
+			cfg.MostViewedSource = tt.source
+			cfg.OphanURL = ophan.URL
+			cfg.OphanAPIKey = "test"
+			c := newRecordingCache()
+
+			if !warmOnce(context.Background(), testCAPI(cfg), c.cache(), cfg, &inFlight{}, &warmGate{}) {
+				t.Fatal("warming was skipped")
+			}
+
+			for _, edition := range config.Editions {
+				if _, found := c.entry(tt.query(edition).cacheKey()); !found {
+					t.Errorf("%s wasn't warmed under the key its requests use", edition)
+				}
+			}
+		})
+	}
+}
+
+func TestWarmOnceSkipsUnderLoad(t *testing.T) {
+	stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(mostViewedBody("a")))
+	})
+	cfg := testConfig(t, stub.URL)
+	cfg.WarmMaxInFlight = 2
+	c := newRecordingCache()
+	gate := &warmGate{}
+
+	if warmOnce(context.Background(), testCAPI(cfg), c.cache(), cfg, &inFlight{n: 2}, gate) {
+		t.Error("warmed with as many requests in flight as -warm-max-in-flight")
+	}
+	if calls := stub.requests(); calls != 0 {
+		t.Errorf("CAPI got %d requests, want none", calls)
+	}
+	if made := c.made(); len(made) != 0 {
+		t.Errorf("the cache got %v, want nothing", made)
+	}
+	if gate.isOpen() {
+		t.Error("the gate opened without a warm")
+	}
+
+	if !warmOnce(context.Background(), testCAPI(cfg), c.cache(), cfg, &inFlight{n: 1}, gate) {
+		t.Error("skipped warming with fewer requests in flight than -warm-max-in-flight")
+	}
+}
+
+func TestWarmCarriesOnAfterATimeout(t *testing.T) {
+	var ukRequests int64
+	stub := newStubCAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/uk" && atomic.AddInt64(&ukRequests, 1) == 1 {
+			// the first fetch of uk hangs until it's given up on
+			<-r.Context().Done()
+			return
+		}
+		w.Write([]byte(mostViewedBody("a")))
+	})
+	cfg := testConfig(t, stub.URL)
+	cfg.CAPIRetries = 0
+	cfg.WarmTimeout = 50 * time.Millisecond
+	cfg.WarmInterval = 20 * time.Millisecond
+	c := newRecordingCache()
+	gate := &warmGate{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		warm(ctx, testCAPI(cfg), c.cache(), cfg, &inFlight{}, gate)
+	}()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !gate.isOpen() && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if !gate.isOpen() {
+		t.Error("the warmer didn't fill every edition after uk timed out")
+	}
+	if n := atomic.LoadInt64(&ukRequests); n < 2 {
+		t.Errorf("uk was fetched %d times, want another try after the timeout", n)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the warmer didn't stop once cancelled")
+	}
+}
@@ -1,154 +1,83 @@
 package main
 
 import (
-	"encoding/json"
+	"flag"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"net/http"
-	"strings"
-	"time"
+	"os"
+	"os/signal"
+	"syscall"
 
-	"github.com/patrickmn/go-cache"
-	"github.com/pkg/errors"
+	"github.com/guardian/onward/capi"
+	"github.com/guardian/onward/config"
+	"github.com/guardian/onward/handlers"
 )
 
-// ItemList is the collection of items
-type ItemList struct {
-	Heading string `json:"heading"`
-	Trails  []Item `json:"trails"`
-}
-
-// Item is the basic article data model
-type Item struct {
-	URL        string `json:"url"`
-	LinkText   string `json:"linkText"`
-	ShowByline bool   `json:"showByline"`
-	Byline     string `json:"byline"`
-	Image      string `json:"image"`
-	IsLiveblog bool   `json:"isLiveBlog"`
-}
-
-// CAPIItem is the CAPI iten model
-type CAPIItem struct {
-	ID       string `json:"id"`
-	WebTitle string `json:"webTitle"`
-	Fields   struct {
-		Byline string `json:"byline"`
-	} `json:"fields"`
-}
-
-// CAPIResponse is the main CAPI response model
-type CAPIResponse struct {
-	Response struct {
-		Results []CAPIItem `json:"mostViewed"`
-	} `json:"response"`
-}
-
 func main() {
-	c := cache.New(5*time.Minute, 10*time.Minute)
-
-	http.HandleFunc("/most-viewed/", mostViewedHandler(c))
-	log.Fatal(http.ListenAndServe(":8080", nil))
-}
-
-func mostViewedHandler(c *cache.Cache) func(w http.ResponseWriter, r *http.Request) {
-	return func(w http.ResponseWriter, r *http.Request) {
-		var items CAPIResponse
-		var err error
-
-		path := strings.TrimPrefix(r.URL.Path, "/most-viewed/")
-
-		switch path {
-		case "uk", "us", "au":
-			items, err = cachedGet(path, c)
-		default:
-			items, err = capiGet(path)
-		}
-
-		if err != nil {
-			errorResponse(w, err)
-			return
-		}
-
-		respJSON := items.asItemList().asJSON()
-		w.Header().Set("Content-Type", "application/json")
-		w.Write(respJSON)
-		return
-	}
-}
-
-func cachedGet(path string, c *cache.Cache) (CAPIResponse, error) {
-	if items, found := c.Get(path); found {
-		return items.(CAPIResponse), nil
+	if len(os.Args) > 1 && os.Args[1] == "fetch" {
+		os.Exit(runFetch(os.Args[2:], os.Stdout, os.Stderr))
 	}
 
-	// get from CAPI, set cache and return
-	items, err := capiGet(path)
+	var cfg config.Config
+	config.Register(flag.CommandLine, &cfg)
+	configPath := flag.String("config", "", "YAML or JSON file of settings, keyed by flag name")
+	validateOnly := flag.Bool("validate", false, "check the configuration and exit without serving")
+	flag.Parse()
 
-	if err != nil {
-		return items, errors.Wrap(err, "CAPI GET failed")
+	if err := config.ApplySources(flag.CommandLine, *configPath); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
 
-	c.Set(path, items, cache.DefaultExpiration)
-	return items, nil
-}
-
-func capiGet(path string) (CAPIResponse, error) {
-	var response CAPIResponse
-	APIKey := "test"
-
-	url := fmt.Sprintf("https://content.guardianapis.com/%s?show-most-viewed=true&api-key=%s&show-fields=byline", path, APIKey)
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 
-	resp, err := http.Get(url)
-	if err != nil {
-		return response, errors.Wrap(err, "GET failed")
+	if *validateOnly {
+		fmt.Println("Configuration OK")
+		return
 	}
-	defer resp.Body.Close()
 
-	body, err := ioutil.ReadAll(resp.Body)
+	api, err := newCAPIClient(cfg)
 	if err != nil {
-		return response, errors.Wrap(err, "Unable to read response body")
+		log.Fatal(err)
 	}
 
-	err = json.Unmarshal(body, &response) // TODO fixme
+	srv, err := handlers.New(cfg, api)
 	if err != nil {
-		return response, errors.Wrap(err, "Unable to unmarshal response body")
+		log.Fatal(err)
 	}
 
-	return response, err
-}
-
-func (resp CAPIResponse) asItemList() ItemList {
-	var items []Item
+	done := make(chan struct{})
+	go shutdownOnSignal(srv, done)
 
-	for _, capiItem := range resp.Response.Results {
-		item := Item{
-			URL:        capiItem.ID,
-			LinkText:   capiItem.WebTitle, // TODO think there's a linkText field too?
-			Byline:     capiItem.Fields.Byline,
-			IsLiveblog: false, // TODO implement
-		}
-
-		items = append(items, item)
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
 	}
 
-	return ItemList{
-		Heading: "Placeholder heading",
-		Trails:  items,
-	}
+	<-done
 }
 
-func (il ItemList) asJSON() []byte {
-	respJSON, err := json.Marshal(il)
+// newCAPIClient is the CAPI client the server and fetch use, over a
+// transport that passes requests' IDs on and counts their upstream time
+func newCAPIClient(cfg config.Config) (capi.Client, error) {
+	transport, err := capi.NewTransport(cfg)
 	if err != nil {
-		log.Fatalf("Unable to marshal item list (should never happen), %s", err)
+		return nil, err
 	}
 
-	return respJSON
+	return capi.New(cfg, capi.NewHTTPClient(cfg, handlers.Transport(transport))), nil
 }
 
-func errorResponse(w http.ResponseWriter, err error) {
-	log.Printf("%s", err)
-	w.WriteHeader(http.StatusInternalServerError)
+// shutdownOnSignal waits for SIGINT or SIGTERM, then shuts down, closing done
+// once it's finished
+func shutdownOnSignal(srv *handlers.Server, done chan<- struct{}) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	log.Printf("Received %s, shutting down", <-signals)
+
+	srv.Shutdown()
+	close(done)
 }
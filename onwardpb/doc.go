@@ -0,0 +1,5 @@
+// Package onwardpb is the generated protobuf and gRPC code for the onward
+// service's gRPC API, from onward.proto.
+package onwardpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative onward.proto
@@ -0,0 +1,153 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: onward.proto
+
+// The onward-journey lists, for server-side renderers that would rather call
+// over gRPC than fetch JSON. Messages mirror the HTTP API's ItemList and Item.
+
+package onwardpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	OnwardService_GetMostViewed_FullMethodName = "/guardian.onward.v1.OnwardService/GetMostViewed"
+	OnwardService_GetRelated_FullMethodName    = "/guardian.onward.v1.OnwardService/GetRelated"
+)
+
+// OnwardServiceClient is the client API for OnwardService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type OnwardServiceClient interface {
+	// GetMostViewed is an edition's most-viewed list, or one of its sections'
+	GetMostViewed(ctx context.Context, in *GetMostViewedRequest, opts ...grpc.CallOption) (*ItemList, error)
+	// GetRelated is the content related to an article
+	GetRelated(ctx context.Context, in *GetRelatedRequest, opts ...grpc.CallOption) (*ItemList, error)
+}
+
+type onwardServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewOnwardServiceClient(cc grpc.ClientConnInterface) OnwardServiceClient {
+	return &onwardServiceClient{cc}
+}
+
+func (c *onwardServiceClient) GetMostViewed(ctx context.Context, in *GetMostViewedRequest, opts ...grpc.CallOption) (*ItemList, error) {
+	out := new(ItemList)
+	err := c.cc.Invoke(ctx, OnwardService_GetMostViewed_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *onwardServiceClient) GetRelated(ctx context.Context, in *GetRelatedRequest, opts ...grpc.CallOption) (*ItemList, error) {
+	out := new(ItemList)
+	err := c.cc.Invoke(ctx, OnwardService_GetRelated_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// OnwardServiceServer is the server API for OnwardService service.
+// All implementations must embed UnimplementedOnwardServiceServer
+// for forward compatibility
+type OnwardServiceServer interface {
+	// GetMostViewed is an edition's most-viewed list, or one of its sections'
+	GetMostViewed(context.Context, *GetMostViewedRequest) (*ItemList, error)
+	// GetRelated is the content related to an article
+	GetRelated(context.Context, *GetRelatedRequest) (*ItemList, error)
+	mustEmbedUnimplementedOnwardServiceServer()
+}
+
+// UnimplementedOnwardServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedOnwardServiceServer struct {
+}
+
+func (UnimplementedOnwardServiceServer) GetMostViewed(context.Context, *GetMostViewedRequest) (*ItemList, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetMostViewed not implemented")
+}
+func (UnimplementedOnwardServiceServer) GetRelated(context.Context, *GetRelatedRequest) (*ItemList, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetRelated not implemented")
+}
+func (UnimplementedOnwardServiceServer) mustEmbedUnimplementedOnwardServiceServer() {}
+
+// UnsafeOnwardServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to OnwardServiceServer will
+// result in compilation errors.
+type UnsafeOnwardServiceServer interface {
+	mustEmbedUnimplementedOnwardServiceServer()
+}
+
+func RegisterOnwardServiceServer(s grpc.ServiceRegistrar, srv OnwardServiceServer) {
+	s.RegisterService(&OnwardService_ServiceDesc, srv)
+}
+
+func _OnwardService_GetMostViewed_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetMostViewedRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(OnwardServiceServer).GetMostViewed(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: OnwardService_GetMostViewed_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(OnwardServiceServer).GetMostViewed(ctx, req.(*GetMostViewedRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _OnwardService_GetRelated_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetRelatedRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(OnwardServiceServer).GetRelated(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: OnwardService_GetRelated_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(OnwardServiceServer).GetRelated(ctx, req.(*GetRelatedRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// OnwardService_ServiceDesc is the grpc.ServiceDesc for OnwardService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var OnwardService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "guardian.onward.v1.OnwardService",
+	HandlerType: (*OnwardServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetMostViewed",
+			Handler:    _OnwardService_GetMostViewed_Handler,
+		},
+		{
+			MethodName: "GetRelated",
+			Handler:    _OnwardService_GetRelated_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "onward.proto",
+}